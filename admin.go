@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+const minAdminTokenLength = 32
+
+// adminTokenFromEnv reads ADMIN_TOKEN, the bearer token the /admin
+// endpoints require. Without it they are not served at all.
+func adminTokenFromEnv() (string, error) {
+	token := os.Getenv("ADMIN_TOKEN")
+	if token != "" && len(token) < minAdminTokenLength {
+		return "", fmt.Errorf("ADMIN_TOKEN must be at least %d characters", minAdminTokenLength)
+	}
+	return token, nil
+}
+
+// adminToken is empty, leaving /admin unmounted, if ADMIN_TOKEN is
+// invalid; startup validation refuses to start in that case.
+var adminToken = func() string {
+	token, err := adminTokenFromEnv()
+	if err != nil {
+		return ""
+	}
+	return token
+}()
+
+// requireAdminToken rejects requests whose Authorization header is not
+// "Bearer <token>". The comparison takes the same time whatever the
+// header holds, so the token cannot be guessed a byte at a time.
+func requireAdminToken(token string) gin.HandlerFunc {
+	want := []byte("Bearer " + token)
+	return func(c *gin.Context) {
+		got := []byte(c.GetHeader("Authorization"))
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="admin"`)
+			respond(c, http.StatusUnauthorized, ErrorResponse{
+				Error:   "unauthorized",
+				Message: "Admin endpoints require the ADMIN_TOKEN as a bearer token",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func adminStatus() string {
+	if adminToken == "" {
+		return "disabled"
+	}
+	return "token"
+}
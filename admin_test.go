@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAdminTokenFromEnv(t *testing.T) {
+	tests := []struct {
+		token   string
+		wantErr bool
+	}{
+		{"", false},
+		{testAdminToken, false},
+		{testAdminToken[:minAdminTokenLength-1], true},
+	}
+	for _, tt := range tests {
+		t.Setenv("ADMIN_TOKEN", tt.token)
+		token, err := adminTokenFromEnv()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ADMIN_TOKEN=%q: error = %v, want error %v", tt.token, err, tt.wantErr)
+			continue
+		}
+		if err == nil && token != tt.token {
+			t.Errorf("ADMIN_TOKEN=%q: token = %q", tt.token, token)
+		}
+	}
+}
+
+func TestRequireAdminToken(t *testing.T) {
+	saved := adminToken
+	defer func() { adminToken = saved }()
+	adminToken = testAdminToken
+	r := newRouter()
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantCode      int
+	}{
+		{"missing", "", http.StatusUnauthorized},
+		{"short", "Bearer " + testAdminToken[:8], http.StatusUnauthorized},
+		{"wrong", "Bearer " + "fedcba9876543210fedcba9876543210", http.StatusUnauthorized},
+		{"no scheme", testAdminToken, http.StatusUnauthorized},
+		{"longer", "Bearer " + testAdminToken + "0", http.StatusUnauthorized},
+		{"correct", "Bearer " + testAdminToken, http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := do(t, r, http.MethodGet, "/admin/stats.json", "", "Authorization", tt.authorization)
+		if w.Code != tt.wantCode {
+			t.Errorf("%s token: status %d, want %d: %s", tt.name, w.Code, tt.wantCode, w.Body)
+		}
+		if tt.wantCode == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s token: no WWW-Authenticate challenge", tt.name)
+		}
+	}
+}
+
+func TestAdminRoutesAbsentWithoutToken(t *testing.T) {
+	saved := adminToken
+	defer func() { adminToken = saved }()
+	adminToken = ""
+	r := newRouter()
+
+	for _, path := range []string{"/admin/stats.json", "/admin/limits", "/admin/config"} {
+		if w := do(t, r, http.MethodGet, path, "", "Authorization", "Bearer "); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s: status %d, want 404 with ADMIN_TOKEN unset", path, w.Code)
+		}
+	}
+	for _, route := range r.Routes() {
+		if strings.HasPrefix(route.Path, "/admin/") {
+			t.Errorf("%s %s is mounted with ADMIN_TOKEN unset", route.Method, route.Path)
+		}
+	}
+}
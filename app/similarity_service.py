@@ -1,26 +1,88 @@
 from sentence_transformers import SentenceTransformer, util
+import hashlib
+import importlib
 import json
+import platform
+import re
+import struct
 import sys
+import threading
 import logging
-from typing import Dict, Any
+from concurrent.futures import ThreadPoolExecutor
+from typing import Dict, Any, List
 
 logging.basicConfig(level = logging.INFO, format = '%(asctime)s - %(levelname)s - %(message)s')
 logger = logging.getLogger(__name__)
 
-class SimilarityService:
-    def __init__(self, model_name: str = 'sentence-transformers/all-MiniLM-L6-v2'):
+def library_versions() -> Dict[str, str]:
+    versions = {}
+    for name in ('sentence_transformers', 'transformers', 'torch', 'numpy'):
         try:
-            logger.info(f"Loading model: {model_name}")
-            self.model = SentenceTransformer(model_name)
-            logger.info("Model loaded successfully")
-        except Exception as e:
-            logger.error(f"Failed to load model: {e}")
-            raise
+            versions[name] = importlib.import_module(name).__version__
+        except (ImportError, AttributeError):
+            continue
+    return versions
+
+DEFAULT_MODEL = 'sentence-transformers/all-MiniLM-L6-v2'
+POOLING_STRATEGIES = ('mean', 'cls', 'max')
+# Per-request parameters the service honours, reported by info so the API
+# can reject the ones it does not.
+MODEL_PARAMETERS = ('pooling', 'normalize', 'max_seq_length')
+
+class SimilarityService:
+    def __init__(self, model_name: str = DEFAULT_MODEL, pooling: str = None,
+                 normalize: bool = False, max_seq_length: int = 0, model=None):
+        self.model_name = model_name
+        self.pooling = pooling
+        self.normalize = normalize
+        self.max_seq_length = max_seq_length
+        self.model = model if model is not None else load_model(model_name)
+
+    # Shortens a sentence to max_seq_length tokens, special tokens included.
+    # The model's own limit is an attribute shared by every caller, so a
+    # per-request limit truncates the text instead.
+    def truncate(self, sentence: str) -> str:
+        if not self.max_seq_length:
+            return sentence
+        tokenizer = self.model.tokenizer
+        limit = max(1, self.max_seq_length - tokenizer.num_special_tokens_to_add())
+        tokens = tokenizer.tokenize(sentence)
+        if len(tokens) <= limit:
+            return sentence
+        return tokenizer.convert_tokens_to_string(tokens[:limit])
+
+    # Encodes with the model's own pooling, or pools its token embeddings with
+    # the requested strategy. Pooled embeddings skip the model's normalization
+    # step unless normalize is set, which does not affect cosine similarity.
+    def encode(self, sentences, convert_to_tensor: bool = True):
+        single = isinstance(sentences, str)
+        sentences = self.truncate(sentences) if single else [self.truncate(s) for s in sentences]
+        if not self.pooling:
+            if self.normalize:
+                return self.model.encode(sentences, convert_to_tensor=convert_to_tensor, normalize_embeddings=True)
+            return self.model.encode(sentences, convert_to_tensor=convert_to_tensor)
+
+        import torch
+        token_embeddings = self.model.encode([sentences] if single else sentences, output_value='token_embeddings')
+        pooled = []
+        for tokens in token_embeddings:
+            if self.pooling == 'cls':
+                pooled.append(tokens[0])
+            elif self.pooling == 'max':
+                pooled.append(tokens.max(dim=0).values)
+            else:
+                pooled.append(tokens.mean(dim=0))
+        embeddings = torch.stack(pooled)
+        if self.normalize:
+            embeddings = torch.nn.functional.normalize(embeddings, p=2, dim=1)
+        if single:
+            embeddings = embeddings[0]
+        return embeddings if convert_to_tensor else embeddings.cpu().numpy()
 
     def calculate_similarity(self, sentence1: str, sentence2: str) -> float:
         try:
-            embedding1 = self.model.encode(sentence1, convert_to_tensor=True)
-            embedding2 = self.model.encode(sentence2, convert_to_tensor=True)
+            embedding1 = self.encode(sentence1)
+            embedding2 = self.encode(sentence2)
             cosine_score = util.cos_sim(embedding1, embedding2)
             similarity = float(cosine_score.item())
             similarity = max(0.0, min(1.0, similarity))
@@ -31,13 +93,127 @@ class SimilarityService:
             logger.error(f"Error calculating similarity: {e}")
             raise
 
+    def info(self) -> Dict[str, Any]:
+        return {
+            "model": self.model_name,
+            "max_seq_length": int(getattr(self.model, 'max_seq_length', 0) or 0),
+            "embedding_dimension": int(self.model.get_sentence_embedding_dimension() or 0),
+            "libraries": library_versions(),
+            "runtime": f"python {platform.python_version()}",
+            "parameters": list(MODEL_PARAMETERS),
+        }
+
+    def model_sha256(self) -> str:
+        digest = hashlib.sha256()
+        for name, tensor in sorted(self.model.state_dict().items()):
+            digest.update(name.encode('utf-8'))
+            digest.update(tensor.detach().cpu().numpy().tobytes())
+        return digest.hexdigest()
+
+    def calculate_similarity_with_audit(self, sentence1: str, sentence2: str) -> Dict[str, Any]:
+        try:
+            import numpy
+
+            embeddings = self.encode([sentence1, sentence2])
+            raw_cosine = float(util.cos_sim(embeddings[0], embeddings[1]).item())
+            similarity = max(0.0, min(1.0, raw_cosine))
+
+            tokenizer = self.model.tokenizer
+            max_seq_length = self.max_seq_length or int(self.model.max_seq_length)
+            inputs = []
+            for sentence, embedding in zip([sentence1, sentence2], embeddings):
+                token_count = len(tokenizer(sentence, add_special_tokens=True)['input_ids'])
+                vector = numpy.asarray(embedding.detach().cpu().numpy(), dtype=numpy.float32)
+                inputs.append({
+                    "token_count": token_count,
+                    "truncated": token_count > max_seq_length,
+                    "embedding_sha256": hashlib.sha256(vector.tobytes()).hexdigest(),
+                })
+
+            audit = {
+                "model": self.model_name,
+                "model_sha256": self.model_sha256(),
+                "tokenizer": type(tokenizer).__name__,
+                "max_seq_length": max_seq_length,
+                "libraries": library_versions(),
+                "preprocessing": ["python:strip", f"python:truncate_to_{max_seq_length}_tokens"]
+                                 + ([f"python:{self.pooling}_pooling"] if self.pooling else [])
+                                 + (["python:normalize"] if self.normalize else []),
+                "inputs": inputs,
+                "raw_cosine": round(raw_cosine, 6),
+            }
+            logger.info(f"Calculated audited similarity: {similarity:.4f}")
+            return {"similarity": similarity, "audit": audit}
+
+        except Exception as e:
+            logger.error(f"Error calculating audited similarity: {e}")
+            raise
+
+    def calculate_matrix(self, sentences1: List[str], sentences2: List[str]) -> List[List[float]]:
+        try:
+            embeddings1 = self.encode(sentences1)
+            embeddings2 = self.encode(sentences2)
+            scores = util.cos_sim(embeddings1, embeddings2).tolist()
+            matrix = [[max(0.0, min(1.0, float(score))) for score in row] for row in scores]
+            logger.info(f"Calculated {len(sentences1)}x{len(sentences2)} similarity matrix")
+            return matrix
+
+        except Exception as e:
+            logger.error(f"Error calculating similarity matrix: {e}")
+            raise
+
+    def embed(self, sentences: List[str]) -> List[List[float]]:
+        try:
+            embeddings = self.encode(sentences, convert_to_tensor=False)
+            logger.info(f"Embedded {len(sentences)} sentences")
+            return [[float(value) for value in embedding] for embedding in embeddings]
+
+        except Exception as e:
+            logger.error(f"Error embedding sentences: {e}")
+            raise
+
+def process_embed_request(service: SimilarityService, request_data: Dict[str, Any]) -> Dict[str, Any]:
+    sentences = [s.strip() for s in request_data.get('sentences') or []]
+
+    if not sentences or not all(sentences):
+        return {"error": "sentences must be a non-empty list of non-empty sentences"}
+
+    return {
+        "embeddings": service.embed(sentences)
+    }
+
+def process_matrix_request(service: SimilarityService, request_data: Dict[str, Any]) -> Dict[str, Any]:
+    sentences1 = [s.strip() for s in request_data.get('sentences1') or []]
+    sentences2 = [s.strip() for s in request_data.get('sentences2') or []]
+
+    if not sentences1 or not sentences2 or not all(sentences1) or not all(sentences2):
+        return {"error": "sentences1 and sentences2 must be non-empty lists of non-empty sentences"}
+    matrix = service.calculate_matrix(sentences1, sentences2)
+
+    return {
+        "matrix": [[round(score, 6) for score in row] for row in matrix]
+    }
+
 def process_request(service: SimilarityService, request_data: Dict[str, Any]) -> Dict[str, Any]:
     try:
+        if request_data.get('info'):
+            return {"info": service.info()}
+        if 'sentences' in request_data:
+            return process_embed_request(service, request_data)
+        if 'sentences1' in request_data or 'sentences2' in request_data:
+            return process_matrix_request(service, request_data)
+
         sentence1 = request_data.get('sentence1', '').strip()
         sentence2 = request_data.get('sentence2', '').strip()
         
         if not sentence1 or not sentence2:
             return {"error": "Both sentence1 and sentence2 must be provided and non-empty"}
+        if request_data.get('audit'):
+            result = service.calculate_similarity_with_audit(sentence1, sentence2)
+            return {
+                "similarity": round(result["similarity"], 6),
+                "audit": result["audit"]
+            }
         similarity = service.calculate_similarity(sentence1, sentence2)
         
         return {
@@ -48,18 +224,131 @@ def process_request(service: SimilarityService, request_data: Dict[str, Any]) ->
         logger.error(f"Error processing request: {e}")
         return {"error": f"Processing failed: {str(e)}"}
 
+def load_model(model_name: str):
+    try:
+        logger.info(f"Loading model: {model_name}")
+        model = SentenceTransformer(model_name)
+        logger.info("Model loaded successfully")
+        return model
+    except Exception as e:
+        logger.error(f"Failed to load model: {e}")
+        raise
+
+def handle_request(request_data: Any, load_service) -> Dict[str, Any]:
+    if not isinstance(request_data, dict):
+        request_data = {}
+    pooling = request_data.get('pooling') or None
+    if pooling is not None and pooling not in POOLING_STRATEGIES:
+        return {"error": f"pooling must be one of {', '.join(POOLING_STRATEGIES)}"}
+    normalize = request_data.get('normalize', False)
+    if not isinstance(normalize, bool):
+        return {"error": "normalize must be a boolean"}
+    max_seq_length = request_data.get('max_seq_length') or 0
+    if not isinstance(max_seq_length, int) or isinstance(max_seq_length, bool) or max_seq_length < 0:
+        return {"error": "max_seq_length must be a non-negative integer"}
+    service = load_service(request_data.get('model') or DEFAULT_MODEL, pooling, normalize, max_seq_length)
+    return process_request(service, request_data)
+
+# Persistent mode (--serve) keeps the process and its loaded models alive
+# across requests. Every frame on stdin and stdout is a 4-byte big-endian
+# length followed by a JSON document. Requests carry an "id" that is echoed
+# on the response; responses are written as they complete, so they may
+# arrive out of order. Every request gets a response, so the caller never
+# waits out its timeout: a frame that cannot be parsed, or one that arrives
+# while SERVE_MAX_QUEUED requests are already waiting, is answered with an
+# error at once.
+FRAME_HEADER = struct.Struct('>I')
+SERVE_WORKERS = 4
+SERVE_MAX_QUEUED = 64
+FRAME_ID = re.compile(rb'"id"\s*:\s*(\d+)')
+
+def frame_id(payload: bytes) -> Any:
+    """Recovers the request ID from a frame that is not valid JSON."""
+    match = FRAME_ID.search(payload)
+    return int(match.group(1)) if match else None
+
+def serve():
+    stdin, stdout = sys.stdin.buffer, sys.stdout.buffer
+    # Anything a library prints would corrupt the frame stream.
+    sys.stdout = sys.stderr
+    # Only the loaded models are kept. A service differs from another on the
+    # same model only in how it encodes, so each request gets its own
+    # around the shared copy rather than one being kept per combination of
+    # options.
+    models = {}
+    models_lock = threading.Lock()
+    write_lock = threading.Lock()
+
+    def load_service(model_name: str, pooling: str, normalize: bool, max_seq_length: int) -> SimilarityService:
+        with models_lock:
+            if model_name not in models:
+                models[model_name] = load_model(model_name)
+            model = models[model_name]
+        return SimilarityService(model_name, pooling, normalize, max_seq_length, model)
+
+    def reply(request_id: Any, response: Dict[str, Any]):
+        response["id"] = request_id
+        payload = json.dumps(response).encode('utf-8')
+        with write_lock:
+            stdout.write(FRAME_HEADER.pack(len(payload)) + payload)
+            stdout.flush()
+
+    # Held by each request from when it is read until it is answered,
+    # bounding the executor's queue.
+    slots = threading.BoundedSemaphore(SERVE_WORKERS + SERVE_MAX_QUEUED)
+
+    def answer(request_id: Any, request_data: Any):
+        try:
+            response = handle_request(request_data, load_service)
+        except Exception as e:
+            logger.error(f"Unexpected error: {e}")
+            response = {"error": f"Service error: {str(e)}"}
+        finally:
+            slots.release()
+        reply(request_id, response)
+
+    with ThreadPoolExecutor(max_workers=SERVE_WORKERS) as pool:
+        while True:
+            header = stdin.read(FRAME_HEADER.size)
+            if len(header) < FRAME_HEADER.size:
+                break
+            (size,) = FRAME_HEADER.unpack(header)
+            payload = stdin.read(size)
+            if len(payload) < size:
+                logger.error("Truncated frame on stdin")
+                break
+            try:
+                request_data = json.loads(payload)
+            except (json.JSONDecodeError, UnicodeDecodeError) as e:
+                logger.error(f"Invalid JSON frame: {e}")
+                reply(frame_id(payload), {"error": f"Invalid JSON frame: {str(e)}"})
+                continue
+            if not isinstance(request_data, dict):
+                reply(None, {"error": "Request frame must be a JSON object"})
+                continue
+            request_id = request_data.pop('id', None)
+            if not slots.acquire(blocking=False):
+                reply(request_id, {"error": f"Service busy: {SERVE_MAX_QUEUED} requests already queued"})
+                continue
+            pool.submit(answer, request_id, request_data)
+
 def main():
+    if '--serve' in sys.argv[1:]:
+        serve()
+        return
+
     try:
-        service = SimilarityService()
         input_data = sys.stdin.read().strip()
         if not input_data:
             response = {"error": "No input data received"}
         else:
             try:
                 request_data = json.loads(input_data)
-                response = process_request(service, request_data)
             except json.JSONDecodeError as e:
+                request_data = None
                 response = {"error": f"Invalid JSON input: {str(e)}"}
+            if request_data is not None:
+                response = handle_request(request_data, SimilarityService)
         
         print(json.dumps(response))
         
@@ -70,4 +359,4 @@ def main():
         sys.exit(1)
 
 if __name__ == "__main__":
-    main()
\ No newline at end of file
+    main()
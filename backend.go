@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"text-similarity-api/similarity"
+)
+
+// Backends selectable with SIMILARITY_BACKEND.
+const (
+	backendPython = "python"
+	backendNative = "native"
+	backendRemote = "remote"
+)
+
+// Python subprocess protocols selectable with PYTHON_PROTOCOL. The
+// persistent protocol keeps one process with the model loaded; oneshot
+// starts a process per call.
+const (
+	pythonProtocolPersistent = "persistent"
+	pythonProtocolOneShot    = "oneshot"
+)
+
+func pythonProtocolFromEnv() (string, error) {
+	switch protocol := os.Getenv("PYTHON_PROTOCOL"); protocol {
+	case "":
+		return pythonProtocolPersistent, nil
+	case pythonProtocolPersistent, pythonProtocolOneShot:
+		return protocol, nil
+	default:
+		return "", fmt.Errorf("PYTHON_PROTOCOL %q must be persistent or oneshot", protocol)
+	}
+}
+
+// pythonWorkersFromEnv returns PYTHON_WORKERS, the number of persistent
+// Python processes, which defaults to one.
+func pythonWorkersFromEnv() (int, error) {
+	raw := os.Getenv("PYTHON_WORKERS")
+	if raw == "" {
+		return 1, nil
+	}
+	workers, err := strconv.Atoi(raw)
+	if err != nil || workers < 1 {
+		return 0, fmt.Errorf("PYTHON_WORKERS %q must be a positive integer", raw)
+	}
+	return workers, nil
+}
+
+// pythonTimeoutFromEnv returns PYTHON_TIMEOUT, how long one call to the
+// Python service may take, which defaults to 30s.
+func pythonTimeoutFromEnv() (time.Duration, error) {
+	raw := os.Getenv("PYTHON_TIMEOUT")
+	if raw == "" {
+		return similarity.DefaultPythonTimeout, nil
+	}
+	timeout, err := time.ParseDuration(raw)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("PYTHON_TIMEOUT %q must be a positive duration such as 30s", raw)
+	}
+	return timeout, nil
+}
+
+func pythonProtocol() string {
+	if pythonBackend.Persistent {
+		return pythonProtocolPersistent
+	}
+	return pythonProtocolOneShot
+}
+
+func newPythonBackend() *similarity.PythonBackend {
+	backend := similarity.NewPythonBackend()
+	protocol, err := pythonProtocolFromEnv()
+	backend.Persistent = err != nil || protocol == pythonProtocolPersistent
+	backend.Workers, _ = pythonWorkersFromEnv()
+	backend.LogStderr = func(pid int, line string) {
+		slog.Info("Python service stderr", "pid", pid, "line", logRedactor.Redact(line))
+	}
+	if executable := os.Getenv("PYTHON_EXECUTABLE"); executable != "" {
+		backend.Executable = executable
+	}
+	if script := os.Getenv("PYTHON_SCRIPT"); script != "" {
+		backend.Script = script
+	}
+	if timeout, err := pythonTimeoutFromEnv(); err == nil {
+		backend.Timeout = timeout
+	}
+	return backend
+}
+
+// backendKind defaults to the Python model, except on Lambda where a
+// subprocess that loads the model on every call is not viable.
+func backendKind() string {
+	if kind := os.Getenv("SIMILARITY_BACKEND"); kind != "" {
+		return kind
+	}
+	if runningInLambda() {
+		return backendNative
+	}
+	return backendPython
+}
+
+func backendFromEnv() (similarity.Backend, error) {
+	switch kind := backendKind(); kind {
+	case backendPython:
+		return pythonBackend, nil
+	case backendNative:
+		return similarity.NewNativeBackend(), nil
+	case backendRemote:
+		raw := os.Getenv("REMOTE_MODEL_URL")
+		if parsed, err := url.Parse(raw); raw == "" || err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return nil, fmt.Errorf("REMOTE_MODEL_URL %q must be an http or https URL", raw)
+		}
+		return similarity.NewRemoteBackend(raw), nil
+	default:
+		return nil, fmt.Errorf("SIMILARITY_BACKEND %q must be one of python, native, remote", kind)
+	}
+}
+
+// Scoring methods a request can ask for besides the lexical ones.
+const (
+	methodModel = "model"
+	fallbackOff = "off"
+)
+
+// fallbackMethodFromEnv returns FALLBACK_METHOD, the lexical method that
+// answers pair scores when the backend fails, or "off". It defaults to
+// tfidf.
+func fallbackMethodFromEnv() (string, error) {
+	switch method := os.Getenv("FALLBACK_METHOD"); method {
+	case "":
+		return similarity.MethodTFIDF, nil
+	case fallbackOff, similarity.MethodTFIDF, similarity.MethodJaccard, similarity.MethodLevenshtein:
+		return method, nil
+	default:
+		return "", fmt.Errorf("FALLBACK_METHOD %q must be one of tfidf, jaccard, levenshtein, off", method)
+	}
+}
+
+// fallbackMethod is the configured FALLBACK_METHOD, or "off" if it is
+// invalid; startup validation refuses to start in that case.
+var fallbackMethod = func() string {
+	method, err := fallbackMethodFromEnv()
+	if err != nil {
+		return fallbackOff
+	}
+	return method
+}()
+
+// scorerOptions configures the shared scorer from the environment.
+func scorerOptions() []similarity.Option {
+	opts := []similarity.Option{similarity.WithBackend(selectedBackend()), similarity.WithCoalescing()}
+	if cache := activeScoreCache(); cache != nil {
+		opts = append(opts, similarity.WithCache(cache))
+	}
+	hooks := faultHooks{injector: faultInjector}
+	if backendHooks != nil {
+		hooks.next = backendHooks
+	}
+	opts = append(opts, similarity.WithHooks(hooks))
+	if requestTracer != nil {
+		opts = append(opts, similarity.WithTracer(requestTracer))
+	}
+	if fallbackMethod != fallbackOff {
+		opts = append(opts, similarity.WithFallback(&similarity.LexicalBackend{Method: fallbackMethod}))
+	}
+	return opts
+}
+
+// selectedBackend falls back to the Python backend if the environment is
+// invalid; startup validation reports the error and refuses to start in
+// that case.
+func selectedBackend() similarity.Backend {
+	backend, err := backendFromEnv()
+	if err != nil {
+		return pythonBackend
+	}
+	return backend
+}
+
+// validateCallOptions checks opts and, when it sets model parameters,
+// checks them against what the backend declares it supports. If the
+// backend cannot be asked, the call is left to fail or to ignore them as
+// before.
+func validateCallOptions(ctx context.Context, opts similarity.CallOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	if len(opts.Parameters()) == 0 {
+		return nil
+	}
+	info, err := backendInfo(ctx)
+	if err != nil {
+		return nil
+	}
+	return opts.Supported(info)
+}
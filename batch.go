@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+
+	"text-similarity-api/similarity"
+)
+
+// batchConcurrency bounds the backend calls one batch request has in
+// flight, so a large batch cannot starve single-pair requests.
+const batchConcurrency = 4
+
+type SentencePair struct {
+	Sentence1 string `json:"sentence1"`
+	Sentence2 string `json:"sentence2"`
+}
+
+type BatchInput struct {
+	Pairs        []SentencePair `json:"pairs" binding:"required,min=1"`
+	Pooling      string         `json:"pooling,omitempty"`
+	Normalize    bool           `json:"normalize,omitempty"`
+	MaxSeqLength int            `json:"max_seq_length,omitempty"`
+	Entities     []string       `json:"entities,omitempty"`
+	Method       string         `json:"method,omitempty"`
+}
+
+// BatchResult is the outcome of one pair. Exactly one of Similarity and
+// Error is meaningful; a pair that fails does not fail the others.
+type BatchResult struct {
+	Index       int            `json:"index"`
+	Sentence1   string         `json:"sentence1"`
+	Sentence2   string         `json:"sentence2"`
+	Similarity  *float64       `json:"similarity,omitempty"`
+	Prefiltered bool           `json:"prefiltered,omitempty"`
+	Coalesced   bool           `json:"coalesced,omitempty"`
+	Cached      bool           `json:"cached,omitempty"`
+	Method      string         `json:"method,omitempty"`
+	Fallback    bool           `json:"fallback,omitempty"`
+	Error       *ErrorResponse `json:"error,omitempty"`
+}
+
+type BatchResponse struct {
+	Results     []BatchResult `json:"results"`
+	Succeeded   int           `json:"succeeded"`
+	Failed      int           `json:"failed"`
+	ProcessedAt string        `json:"processed_at"`
+}
+
+// pairScore is a score from scorePair and how it was produced. Method is
+// set when a lexical method produced the score, and Fallback when that
+// was because the backend failed.
+type pairScore struct {
+	Score       float64
+	Prefiltered bool
+	Coalesced   bool
+	Cached      bool
+	Method      string
+	Fallback    bool
+}
+
+// validateMethod rejects a scoring method other than the model or a
+// lexical one.
+func validateMethod(method string) error {
+	switch method {
+	case "", methodModel, similarity.MethodTFIDF, similarity.MethodJaccard, similarity.MethodLevenshtein:
+		return nil
+	}
+	return fmt.Errorf("method %q must be one of model, tfidf, jaccard, levenshtein", method)
+}
+
+// scorePair scores a pair the way /api/v1/similarity does without audit or
+// a mode. A lexical method is computed directly. Otherwise the prefilter
+// decides the pair if it can, and the model scores the rest, feeding
+// estimate calibration and the coalescing counter.
+func scorePair(ctx context.Context, callOptions similarity.CallOptions, method, a, b string) (pairScore, error) {
+	if method != "" && method != methodModel {
+		score, err := similarity.LexicalScore(ctx, method, a, b)
+		return pairScore{Score: score, Method: method}, err
+	}
+	if estimate, ok := prefilterEstimate(a, b); ok {
+		return pairScore{Score: estimate, Prefiltered: true}, nil
+	}
+	result, err := scorer.ScoreDetailed(ctx, a, b)
+	if err != nil {
+		return pairScore{}, err
+	}
+	if result.Coalesced {
+		coalescedRequests.Add(1)
+	}
+	// Only calibrate on the primary model's scores in its default
+	// configuration, which is what estimates are compared against, and
+	// count each computed score once.
+	if !result.FellBack && !result.Coalesced && !result.Cached && callOptions.Pooling == "" && callOptions.MaxSeqLength == 0 && len(callOptions.Entities) == 0 {
+		calibration.Observe(a, b, result.Score)
+	}
+	scored := pairScore{Score: result.Score, Coalesced: result.Coalesced, Cached: result.Cached}
+	if result.FellBack {
+		scored.Method, scored.Fallback = fallbackMethod, true
+	}
+	return scored, nil
+}
+
+// validatePair trims the pair in place and returns the reason it cannot be
+// scored, if any.
+func validatePair(pair *SentencePair, l Limits) *ErrorResponse {
+	pair.Sentence1 = strings.TrimSpace(pair.Sentence1)
+	pair.Sentence2 = strings.TrimSpace(pair.Sentence2)
+	if pair.Sentence1 == "" || pair.Sentence2 == "" {
+		return &ErrorResponse{Error: "empty_sentences", Message: "Both sentences must be non-empty"}
+	}
+	for _, text := range []string{pair.Sentence1, pair.Sentence2} {
+		if length := utf8.RuneCountInString(text); length > l.MaxSentenceLength {
+			return &ErrorResponse{
+				Error:   "validation_error",
+				Message: fmt.Sprintf("sentences are limited to %d characters, got one with %d", l.MaxSentenceLength, length),
+			}
+		}
+	}
+	return nil
+}
+
+// validateBatch checks a batch of at most maxPairs pairs and returns its
+// call options. It responds with 400 and returns false if the batch as a
+// whole is invalid; invalid pairs are reported per pair later.
+func validateBatch(c *gin.Context, input *BatchInput, maxPairs int) (similarity.CallOptions, bool) {
+	lim := limitsFor(c)
+	if len(input.Pairs) > maxPairs {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: fmt.Sprintf("At most %d pairs are allowed per batch, got %d", maxPairs, len(input.Pairs)),
+		})
+		return similarity.CallOptions{}, false
+	}
+	if len(input.Entities) > lim.MaxEntities {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: fmt.Sprintf("At most %d entities are allowed", lim.MaxEntities),
+		})
+		return similarity.CallOptions{}, false
+	}
+	if err := validateMethod(input.Method); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: err.Error(),
+		})
+		return similarity.CallOptions{}, false
+	}
+	callOptions := similarity.CallOptions{Pooling: input.Pooling, Normalize: input.Normalize, MaxSeqLength: input.MaxSeqLength, Entities: input.Entities}
+	if err := validateCallOptions(c.Request.Context(), callOptions); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: err.Error(),
+		})
+		return similarity.CallOptions{}, false
+	}
+	return callOptions, true
+}
+
+// scoreBatchPair scores a valid pair into result. A panic is counted
+// against site and, like a backend error, fails only this pair. The error
+// is returned for the caller to log.
+func scoreBatchPair(ctx context.Context, callOptions similarity.CallOptions, method, site string, result *BatchResult) error {
+	var scored pairScore
+	err := func() (err error) {
+		defer recoverItem(site, &err)
+		scored, err = scorePair(ctx, callOptions, method, result.Sentence1, result.Sentence2)
+		return err
+	}()
+	if err != nil {
+		result.Error = &ErrorResponse{
+			Error:     "internal_error",
+			Message:   "Failed to process similarity calculation",
+			Retryable: retryableBackendError(err),
+		}
+		return err
+	}
+	result.Similarity = &scored.Score
+	result.Prefiltered, result.Coalesced = scored.Prefiltered, scored.Coalesced
+	result.Method, result.Fallback = scored.Method, scored.Fallback
+	result.Cached = scored.Cached
+	return nil
+}
+
+// handleSimilarityBatch scores many pairs in one request. Invalid pairs
+// and backend failures are reported per pair; only a malformed request as
+// a whole is rejected.
+func handleSimilarityBatch(c *gin.Context) {
+	var input BatchInput
+	if err := bindInput(c, &input); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "Invalid input format: " + err.Error(),
+		})
+		return
+	}
+	lim := limitsFor(c)
+	callOptions, ok := validateBatch(c, &input, lim.MaxBatchPairs)
+	if !ok {
+		return
+	}
+	ctx := similarity.WithCallOptions(c.Request.Context(), callOptions)
+
+	results := make([]BatchResult, len(input.Pairs))
+	slots := make(chan struct{}, batchConcurrency)
+	var wg sync.WaitGroup
+	for i := range input.Pairs {
+		pair := &input.Pairs[i]
+		invalid := validatePair(pair, lim)
+		results[i] = BatchResult{Index: i, Sentence1: pair.Sentence1, Sentence2: pair.Sentence2, Error: invalid}
+		if invalid != nil {
+			continue
+		}
+		noteInputs(c, pair.Sentence1, pair.Sentence2)
+
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(result *BatchResult) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if err := scoreBatchPair(ctx, callOptions, input.Method, panicSiteBatch, result); err != nil {
+				logBackendError(c, err, result.Sentence1, result.Sentence2)
+			}
+		}(&results[i])
+	}
+	wg.Wait()
+
+	response := BatchResponse{
+		Results:     results,
+		ProcessedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	for _, result := range results {
+		if result.Error != nil {
+			response.Failed++
+		} else {
+			response.Succeeded++
+		}
+	}
+	respond(c, http.StatusOK, response)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/ugorji/go/codec"
+)
+
+// mimeCBOR is the content type of CBOR (RFC 8949) bodies. The scoring
+// endpoints read CBOR requests sent with this Content-Type and answer in
+// CBOR when it is preferred in Accept, using the same field names as JSON.
+const mimeCBOR = "application/cbor"
+
+var cborHandle = &codec.CborHandle{}
+
+type cborBinding struct{}
+
+func (cborBinding) Name() string {
+	return "cbor"
+}
+
+func (b cborBinding) Bind(req *http.Request, obj interface{}) error {
+	if err := codec.NewDecoder(req.Body, cborHandle).Decode(obj); err != nil {
+		return err
+	}
+	return binding.Validator.ValidateStruct(obj)
+}
+
+func (b cborBinding) BindBody(body []byte, obj interface{}) error {
+	if err := codec.NewDecoderBytes(body, cborHandle).Decode(obj); err != nil {
+		return err
+	}
+	return binding.Validator.ValidateStruct(obj)
+}
+
+type cborRender struct {
+	Data interface{}
+}
+
+func (r cborRender) Render(w http.ResponseWriter) error {
+	r.WriteContentType(w)
+	var body bytes.Buffer
+	if err := codec.NewEncoder(&body, cborHandle).Encode(r.Data); err != nil {
+		return err
+	}
+	_, err := w.Write(body.Bytes())
+	return err
+}
+
+func (r cborRender) WriteContentType(w http.ResponseWriter) {
+	if header := w.Header(); len(header["Content-Type"]) == 0 {
+		header["Content-Type"] = []string{mimeCBOR}
+	}
+}
+
+// bindInput binds the request body as CBOR or JSON depending on its
+// Content-Type.
+func bindInput(c *gin.Context, obj interface{}) error {
+	if c.ContentType() == mimeCBOR {
+		return c.ShouldBindWith(obj, cborBinding{})
+	}
+	return c.ShouldBindJSON(obj)
+}
+
+// respond writes obj as CBOR if the client prefers it and as JSON
+// otherwise, including when there is no Accept header. An ErrorResponse
+// gets the request's ID and says whether it is worth retrying.
+func respond(c *gin.Context, code int, obj interface{}) {
+	if errResp, ok := obj.(ErrorResponse); ok {
+		if errResp.RequestID == "" {
+			errResp.RequestID = requestID(c)
+		}
+		obj = markRetryable(c, code, errResp)
+	}
+	if c.NegotiateFormat(binding.MIMEJSON, mimeCBOR) == mimeCBOR {
+		c.Render(code, cborRender{Data: obj})
+		return
+	}
+	c.JSON(code, obj)
+}
+
+// jsonFieldValues maps the JSON names of a struct's top-level fields to
+// their values.
+func jsonFieldValues(v interface{}) map[string]interface{} {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+	values := make(map[string]interface{}, value.NumField())
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		values[name] = value.Field(i).Interface()
+	}
+	return values
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ugorji/go/codec"
+)
+
+func encodeCBOR(t *testing.T, v interface{}) string {
+	t.Helper()
+	var body []byte
+	if err := codec.NewEncoderBytes(&body, cborHandle).Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestCBORNegotiation(t *testing.T) {
+	r := newRouter()
+	body := `{"sentence1": "a b c", "sentence2": "b c d", "method": "jaccard"}`
+	tests := []struct {
+		accept   string
+		wantCBOR bool
+	}{
+		{"", false},
+		{"application/json", false},
+		{"*/*", false},
+		{"application/cbor", true},
+		{"application/cbor, application/json", true},
+		{"application/json, application/cbor", false},
+		{"text/html", false},
+	}
+	for _, tt := range tests {
+		w := do(t, r, http.MethodPost, "/api/v1/similarity", body, "Accept", tt.accept)
+		if w.Code != http.StatusOK {
+			t.Errorf("Accept %q: status %d: %s", tt.accept, w.Code, w.Body)
+			continue
+		}
+		var resp SimilarityResponse
+		var err error
+		if tt.wantCBOR {
+			err = codec.NewDecoderBytes(w.Body.Bytes(), cborHandle).Decode(&resp)
+		} else {
+			err = json.Unmarshal(w.Body.Bytes(), &resp)
+		}
+		contentType := w.Header().Get("Content-Type")
+		if gotCBOR := contentType == mimeCBOR; gotCBOR != tt.wantCBOR || err != nil {
+			t.Errorf("Accept %q: Content-Type %q, decode error %v; want CBOR %v", tt.accept, contentType, err, tt.wantCBOR)
+			continue
+		}
+		if resp.Sentence1 != "a b c" || resp.Similarity != 0.5 {
+			t.Errorf("Accept %q: response = %+v", tt.accept, resp)
+		}
+	}
+}
+
+func TestCBORRequest(t *testing.T) {
+	r := newRouter()
+	body := encodeCBOR(t, map[string]interface{}{
+		"sentence1": "a b c",
+		"sentence2": "b c d",
+		"method":    "jaccard",
+	})
+	w := do(t, r, http.MethodPost, "/api/v1/similarity", body, "Content-Type", mimeCBOR, "Accept", mimeCBOR)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var resp SimilarityResponse
+	if err := codec.NewDecoderBytes(w.Body.Bytes(), cborHandle).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Sentence2 != "b c d" || resp.Similarity != 0.5 {
+		t.Errorf("response = %+v", resp)
+	}
+
+	// A CBOR request may still ask for JSON back.
+	w = do(t, r, http.MethodPost, "/api/v1/similarity", body, "Content-Type", mimeCBOR)
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Errorf("CBOR request without Accept: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestCBORErrors(t *testing.T) {
+	r := newRouter()
+	tests := []struct {
+		name, body string
+	}{
+		{"malformed", "\xff\x00"},
+		{"JSON sent as CBOR", `{"sentence1": "a", "sentence2": "b"}`},
+		{"missing sentence", encodeCBOR(t, map[string]interface{}{"sentence1": "a"})},
+	}
+	for _, tt := range tests {
+		w := do(t, r, http.MethodPost, "/api/v1/similarity", tt.body, "Content-Type", mimeCBOR, "Accept", mimeCBOR)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", tt.name, w.Code)
+			continue
+		}
+		var resp ErrorResponse
+		if err := codec.NewDecoderBytes(w.Body.Bytes(), cborHandle).Decode(&resp); err != nil || resp.Error == "" || resp.RequestID == "" {
+			t.Errorf("%s: error response %+v (%v), want a CBOR error with a request ID", tt.name, resp, err)
+		}
+		if strings.Contains(w.Header().Get("Content-Type"), "json") {
+			t.Errorf("%s: Content-Type %q, want CBOR", tt.name, w.Header().Get("Content-Type"))
+		}
+	}
+}
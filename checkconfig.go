@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"text-similarity-api/config"
+	"text-similarity-api/similarity"
+)
+
+type ConfigCheck struct {
+	Name   string
+	OK     bool
+	Detail string
+}
+
+// validateConfig runs the checks that are cheap enough to perform on every
+// startup: environment values and the presence of the Python backend.
+func validateConfig() []ConfigCheck {
+	var checks []ConfigCheck
+
+	if err := config.Err(); err != nil {
+		checks = append(checks, ConfigCheck{"CONFIG_FILE", false, err.Error()})
+	} else if config.File() == "" {
+		checks = append(checks, ConfigCheck{"CONFIG_FILE", true, "not set, using the environment only"})
+	} else {
+		checks = append(checks, ConfigCheck{"CONFIG_FILE", true, fmt.Sprintf("%s (%d settings from the file)", config.File(), config.FileValues())})
+	}
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		checks = append(checks, ConfigCheck{"PORT", true, "not set, using default 8080"})
+	} else if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		checks = append(checks, ConfigCheck{"PORT", false, fmt.Sprintf("%q is not a valid TCP port", port)})
+	} else {
+		checks = append(checks, ConfigCheck{"PORT", true, port})
+	}
+
+	switch mode := os.Getenv("GIN_MODE"); mode {
+	case "":
+		checks = append(checks, ConfigCheck{"GIN_MODE", true, "not set, using release"})
+	case gin.DebugMode, gin.ReleaseMode, gin.TestMode:
+		checks = append(checks, ConfigCheck{"GIN_MODE", true, mode})
+	default:
+		checks = append(checks, ConfigCheck{"GIN_MODE", false, fmt.Sprintf("%q must be one of debug, release, test", mode)})
+	}
+
+	if _, err := newRedactorFromEnv(); err != nil {
+		checks = append(checks, ConfigCheck{"LOG_REDACTION", false, err.Error()})
+	} else {
+		checks = append(checks, ConfigCheck{"LOG_REDACTION", true, logRedactor.mode})
+	}
+
+	if token, err := adminTokenFromEnv(); err != nil {
+		checks = append(checks, ConfigCheck{"ADMIN_TOKEN", false, err.Error()})
+	} else if token == "" {
+		checks = append(checks, ConfigCheck{"ADMIN_TOKEN", true, "not set, /admin endpoints are disabled"})
+	} else {
+		checks = append(checks, ConfigCheck{"ADMIN_TOKEN", true, "set"})
+	}
+
+	if origins, err := corsOriginsFromEnv(); err != nil {
+		checks = append(checks, ConfigCheck{"CORS_ALLOWED_ORIGINS", false, err.Error()})
+	} else {
+		checks = append(checks, ConfigCheck{"CORS_ALLOWED_ORIGINS", true, strings.Join(origins, ", ")})
+	}
+
+	if grace, err := shutdownGracePeriodFromEnv(); err != nil {
+		checks = append(checks, ConfigCheck{"SHUTDOWN_GRACE_PERIOD", false, err.Error()})
+	} else {
+		checks = append(checks, ConfigCheck{"SHUTDOWN_GRACE_PERIOD", true, grace.String()})
+	}
+
+	if config, err := logConfigFromEnv(); err != nil {
+		checks = append(checks, ConfigCheck{"LOG_FORMAT", false, err.Error()})
+	} else {
+		checks = append(checks, ConfigCheck{"LOG_FORMAT", true, fmt.Sprintf("%s at level %s", config.Format, strings.ToLower(config.Level.String()))})
+	}
+
+	if defs, err := sloDefinitionsFromEnv(); err != nil {
+		checks = append(checks, ConfigCheck{"SLO_DEFINITIONS", false, err.Error()})
+	} else {
+		checks = append(checks, ConfigCheck{"SLO_DEFINITIONS", true, fmt.Sprintf("%d SLOs", len(defs))})
+	}
+
+	if p, err := prefilterFromEnv(); err != nil {
+		checks = append(checks, ConfigCheck{"PREFILTER", false, err.Error()})
+	} else if p == nil {
+		checks = append(checks, ConfigCheck{"PREFILTER", true, "off"})
+	} else {
+		checks = append(checks, ConfigCheck{"PREFILTER", true, fmt.Sprintf("on (length ratio %g, char overlap %g, simhash distance %d)", p.MinLengthRatio, p.MinCharOverlap, p.MaxSimHashDistance)})
+	}
+
+	if d, err := deferralFromEnv(); err != nil {
+		checks = append(checks, ConfigCheck{"DEFERRAL", false, err.Error()})
+	} else if d == nil {
+		checks = append(checks, ConfigCheck{"DEFERRAL", true, "off"})
+	} else {
+		checks = append(checks, ConfigCheck{"DEFERRAL", true, fmt.Sprintf("on (%d in flight, %d pending)", d.MaxInFlight, d.MaxPending)})
+	}
+
+	switch policy := inputQualityPolicy(); policy {
+	case qualityPolicyScore, qualityPolicyWarn, qualityPolicyReject:
+		checks = append(checks, ConfigCheck{"INPUT_QUALITY_POLICY", true, policy})
+	default:
+		checks = append(checks, ConfigCheck{"INPUT_QUALITY_POLICY", false, fmt.Sprintf("%q must be one of score, warn, reject", policy)})
+	}
+
+	if config, err := scoreCacheFromEnv(); err != nil {
+		checks = append(checks, ConfigCheck{"SCORE_CACHE", false, err.Error()})
+	} else if config.Size == 0 {
+		checks = append(checks, ConfigCheck{"SCORE_CACHE", true, "off"})
+	} else if config.RedisURL != "" {
+		if redis, err := similarity.NewRedisCache(config.RedisURL); err != nil {
+			checks = append(checks, ConfigCheck{"SCORE_CACHE", false, err.Error()})
+		} else {
+			checks = append(checks, ConfigCheck{"SCORE_CACHE", true, fmt.Sprintf("redis at %s, ttl %s", redis.Addr(), config.TTL)})
+		}
+	} else {
+		checks = append(checks, ConfigCheck{"SCORE_CACHE", true, fmt.Sprintf("%d entries, ttl %s", config.Size, config.TTL)})
+	}
+
+	if cards, err := modelCardsFromEnv(); err != nil {
+		checks = append(checks, ConfigCheck{"MODEL_CARDS", false, err.Error()})
+	} else {
+		checks = append(checks, ConfigCheck{"MODEL_CARDS", true, fmt.Sprintf("%d model cards", len(cards))})
+	}
+
+	if config, err := resultConfigFromEnv(); err != nil {
+		checks = append(checks, ConfigCheck{"RESULT_PERSISTENCE", false, err.Error()})
+	} else if config.Retention == 0 {
+		checks = append(checks, ConfigCheck{"RESULT_PERSISTENCE", true, "off"})
+	} else {
+		checks = append(checks, ConfigCheck{"RESULT_PERSISTENCE", true, fmt.Sprintf("retention %s, %d per client, access %s", config.Retention, config.MaxPerClient, config.Access)})
+	}
+
+	rateLimit, err := rateLimitFromEnv()
+	if err != nil {
+		checks = append(checks, ConfigCheck{"RATE_LIMIT", false, err.Error()})
+	} else if rateLimit == nil {
+		checks = append(checks, ConfigCheck{"RATE_LIMIT", true, "off"})
+	} else {
+		detail := fmt.Sprintf("on (%g/s, burst %d, daily quota %d)", rateLimit.RPS, rateLimit.Burst, rateLimit.DailyQuota)
+		if rateLimit.KeyHeader != "" {
+			detail += fmt.Sprintf(", %d keys in %s", len(rateLimit.Keys), rateLimit.KeyHeader)
+		}
+		checks = append(checks, ConfigCheck{"RATE_LIMIT", true, detail})
+	}
+	if proxies, err := trustedProxiesFromEnv(); err != nil {
+		checks = append(checks, ConfigCheck{"TRUSTED_PROXIES", false, err.Error()})
+	} else if proxies == nil && rateLimit != nil {
+		checks = append(checks, ConfigCheck{"TRUSTED_PROXIES", false, "must be set with RATE_LIMIT=on: list the proxies in front of the server, or set none if clients connect directly"})
+	} else if proxies == nil {
+		checks = append(checks, ConfigCheck{"TRUSTED_PROXIES", true, "not set, trusting no proxy"})
+	} else if len(proxies) == 0 {
+		checks = append(checks, ConfigCheck{"TRUSTED_PROXIES", true, "none, clients connect directly"})
+	} else {
+		checks = append(checks, ConfigCheck{"TRUSTED_PROXIES", true, strings.Join(proxies, ", ")})
+	}
+
+	if config, err := hooksFromEnv(); err != nil {
+		checks = append(checks, ConfigCheck{"HOOKS", false, err.Error()})
+	} else if config == nil {
+		checks = append(checks, ConfigCheck{"HOOKS", true, "off"})
+	} else {
+		checks = append(checks, ConfigCheck{"HOOKS", true, fmt.Sprintf("before %q, after %q, timeout %s, on failure %s", config.Before, config.After, config.Timeout, config.Policy)})
+	}
+
+	if config, err := tracingFromEnv(); err != nil {
+		checks = append(checks, ConfigCheck{"TRACING", false, err.Error()})
+	} else if config == nil {
+		checks = append(checks, ConfigCheck{"TRACING", true, "off"})
+	} else {
+		checks = append(checks, ConfigCheck{"TRACING", true, fmt.Sprintf("OTLP to %s as %s", config.Endpoint, config.ServiceName)})
+	}
+
+	if config, err := probeFromEnv(); err != nil {
+		checks = append(checks, ConfigCheck{"PROBE", false, err.Error()})
+	} else if config == nil {
+		checks = append(checks, ConfigCheck{"PROBE", true, "off"})
+	} else {
+		target := config.Target
+		if target == "" {
+			target = "self"
+		}
+		checks = append(checks, ConfigCheck{"PROBE", true, fmt.Sprintf("%d cases against %s every %s, alert after %d failed rounds", len(config.Cases), target, config.Interval, config.AlertAfter)})
+	}
+
+	if config, err := jobsFromEnv(); err != nil {
+		checks = append(checks, ConfigCheck{"JOBS", false, err.Error()})
+	} else {
+		checks = append(checks, ConfigCheck{"JOBS", true, fmt.Sprintf("%d workers, %d queued, retention %s", config.Workers, config.MaxQueued, config.Retention)})
+	}
+
+	if driver, _, err := corpusDatabaseFromEnv(); err != nil {
+		checks = append(checks, ConfigCheck{"CORPUS_DATABASE", false, err.Error()})
+	} else if driver == "" {
+		checks = append(checks, ConfigCheck{"CORPUS_DATABASE", true, "not set, corpora are kept in memory only"})
+	} else {
+		checks = append(checks, ConfigCheck{"CORPUS_DATABASE", true, driver})
+	}
+
+	if method, err := fallbackMethodFromEnv(); err != nil {
+		checks = append(checks, ConfigCheck{"FALLBACK_METHOD", false, err.Error()})
+	} else {
+		checks = append(checks, ConfigCheck{"FALLBACK_METHOD", true, method})
+	}
+
+	if _, err := backendFromEnv(); err != nil {
+		checks = append(checks, ConfigCheck{"SIMILARITY_BACKEND", false, err.Error()})
+		return checks
+	}
+	checks = append(checks, ConfigCheck{"SIMILARITY_BACKEND", true, backendKind()})
+	if backendKind() != backendPython {
+		return checks
+	}
+
+	if protocol, err := pythonProtocolFromEnv(); err != nil {
+		checks = append(checks, ConfigCheck{"PYTHON_PROTOCOL", false, err.Error()})
+	} else {
+		checks = append(checks, ConfigCheck{"PYTHON_PROTOCOL", true, protocol})
+	}
+	if workers, err := pythonWorkersFromEnv(); err != nil {
+		checks = append(checks, ConfigCheck{"PYTHON_WORKERS", false, err.Error()})
+	} else {
+		checks = append(checks, ConfigCheck{"PYTHON_WORKERS", true, strconv.Itoa(workers)})
+	}
+
+	if timeout, err := pythonTimeoutFromEnv(); err != nil {
+		checks = append(checks, ConfigCheck{"PYTHON_TIMEOUT", false, err.Error()})
+	} else {
+		checks = append(checks, ConfigCheck{"PYTHON_TIMEOUT", true, timeout.String()})
+	}
+
+	if path, err := exec.LookPath(pythonBackend.Executable); err != nil {
+		checks = append(checks, ConfigCheck{"python", false, fmt.Sprintf("%s not found on PATH", pythonBackend.Executable)})
+	} else {
+		checks = append(checks, ConfigCheck{"python", true, path})
+	}
+
+	if info, err := os.Stat(pythonBackend.Script); err != nil {
+		checks = append(checks, ConfigCheck{"python_script", false, err.Error()})
+	} else if info.IsDir() {
+		checks = append(checks, ConfigCheck{"python_script", false, pythonBackend.Script + " is a directory"})
+	} else {
+		checks = append(checks, ConfigCheck{"python_script", true, pythonBackend.Script})
+	}
+
+	return checks
+}
+
+// checkBackend runs a real similarity request through the configured
+// backend, which for the Python service loads the model and so proves the
+// whole scoring path works.
+func checkBackend() ConfigCheck {
+	start := time.Now()
+	result, err := scorer.ScoreDetailed(context.Background(),
+		"The configuration check is running.",
+		"A configuration check is in progress.",
+	)
+	if err != nil {
+		return ConfigCheck{"backend", false, err.Error()}
+	}
+	if result.FellBack {
+		return ConfigCheck{"backend", false, fmt.Sprintf("backend failed and the %s fallback answered", fallbackMethod)}
+	}
+	return ConfigCheck{"backend", true, fmt.Sprintf("model responded in %s (score %.4f)", time.Since(start).Round(time.Millisecond), result.Score)}
+}
+
+// storeCheckTimeout bounds each store connectivity check.
+const storeCheckTimeout = 5 * time.Second
+
+// checkStores connects to the external stores the configuration names, the
+// score cache's Redis and the corpus database, and reports each one that
+// does not answer.
+func checkStores() []ConfigCheck {
+	var checks []ConfigCheck
+
+	if config, err := scoreCacheFromEnv(); err == nil && config.Size > 0 && config.RedisURL != "" {
+		if redis, err := similarity.NewRedisCache(config.RedisURL); err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), storeCheckTimeout)
+			if err := redis.Ping(ctx); err != nil {
+				checks = append(checks, ConfigCheck{"redis", false, fmt.Sprintf("PING %s failed: %v", redis.Addr(), err)})
+			} else {
+				checks = append(checks, ConfigCheck{"redis", true, "PING " + redis.Addr() + " answered"})
+			}
+			cancel()
+		}
+	}
+
+	if driver, dsn, err := corpusDatabaseFromEnv(); err == nil && driver != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), storeCheckTimeout)
+		db, err := sql.Open(driver, dsn)
+		if err == nil {
+			err = db.PingContext(ctx)
+			db.Close()
+		}
+		if err != nil {
+			checks = append(checks, ConfigCheck{"corpus_database", false, fmt.Sprintf("%s did not answer: %v", driver, err)})
+		} else {
+			checks = append(checks, ConfigCheck{"corpus_database", true, driver + " answered"})
+		}
+		cancel()
+	}
+
+	return checks
+}
+
+func checksPassed(checks []ConfigCheck) bool {
+	for _, check := range checks {
+		if !check.OK {
+			return false
+		}
+	}
+	return true
+}
+
+func writeConfigReport(w io.Writer, checks []ConfigCheck) {
+	for _, check := range checks {
+		status := "OK  "
+		if !check.OK {
+			status = "FAIL"
+		}
+		fmt.Fprintf(w, "[%s] %-20s %s\n", status, check.Name, check.Detail)
+	}
+}
+
+// runCheckConfig validates the configuration, the stores and the model
+// backend, prints a report and returns the process exit code.
+func runCheckConfig(w io.Writer) int {
+	checks := validateConfig()
+	if checksPassed(checks) {
+		checks = append(checks, checkStores()...)
+		checks = append(checks, checkBackend())
+	} else {
+		checks = append(checks, ConfigCheck{"backend", false, "skipped because configuration is invalid"})
+	}
+
+	writeConfigReport(w, checks)
+	if !checksPassed(checks) {
+		fmt.Fprintln(w, "Configuration check failed")
+		return 1
+	}
+	fmt.Fprintln(w, "Configuration check passed")
+	return 0
+}
@@ -0,0 +1,313 @@
+// Package config loads the server's settings from an optional YAML or
+// TOML file named by CONFIG_FILE. Every setting in the file is also an
+// environment variable of the same name, and a variable set in the
+// environment overrides the file:
+//
+//	port: 8080
+//	python:
+//	  script: app/similarity_service.py
+//	  timeout: 30s
+//	cors_allowed_origins: [https://app.example.com]
+//
+// Keys are the variable names in lower case. Nested tables are joined to
+// their parent with an underscore, so python.timeout sets PYTHON_TIMEOUT,
+// and lists become comma-separated values.
+//
+// The file is read when the package is initialised. Go initialises
+// imported packages first, so this happens before the main package builds
+// anything from the environment, and every setting reads file values
+// through os.Getenv without knowing the file exists. A file that cannot
+// be loaded changes nothing; Err reports why so that startup validation
+// can refuse to start.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Setting is a configuration value the server reads. Secret values are
+// never shown in full.
+type Setting struct {
+	Name   string
+	Secret bool
+}
+
+// Settings lists every setting that may appear in a configuration file,
+// sorted by name.
+var Settings = []Setting{
+	{Name: "ADMIN_TOKEN", Secret: true},
+	{Name: "CORPUS_DATABASE", Secret: true},
+	{Name: "CORS_ALLOWED_ORIGINS"},
+	{Name: "DEFERRAL"},
+	{Name: "DEFERRAL_MAX_IN_FLIGHT"},
+	{Name: "DEFERRAL_MAX_PENDING"},
+	{Name: "FALLBACK_METHOD"},
+	{Name: "GIN_MODE"},
+	{Name: "HOOK_AFTER"},
+	{Name: "HOOK_BEFORE"},
+	{Name: "HOOK_FAILURE_POLICY"},
+	{Name: "HOOK_TIMEOUT"},
+	{Name: "INPUT_QUALITY_POLICY"},
+	{Name: "JOB_MAX_QUEUED"},
+	{Name: "JOB_RETENTION"},
+	{Name: "JOB_WORKERS"},
+	{Name: "LOG_FORMAT"},
+	{Name: "LOG_LEVEL"},
+	{Name: "LOG_REDACTION"},
+	{Name: "LOG_REDACTION_REGEX"},
+	{Name: "LOG_REDACTION_TRUNCATE"},
+	{Name: "MODEL_CARDS"},
+	{Name: "OTEL_EXPORTER_OTLP_ENDPOINT"},
+	{Name: "OTEL_EXPORTER_OTLP_HEADERS", Secret: true},
+	{Name: "OTEL_EXPORTER_OTLP_PROTOCOL"},
+	{Name: "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"},
+	{Name: "OTEL_SERVICE_NAME"},
+	{Name: "OTEL_TRACES_EXPORTER"},
+	{Name: "PORT"},
+	{Name: "PREFILTER"},
+	{Name: "PREFILTER_MAX_SIMHASH_DISTANCE"},
+	{Name: "PREFILTER_MIN_CHAR_OVERLAP"},
+	{Name: "PREFILTER_MIN_LENGTH_RATIO"},
+	{Name: "PROBE"},
+	{Name: "PROBE_ALERT_AFTER"},
+	{Name: "PROBE_CASES"},
+	{Name: "PROBE_INTERVAL"},
+	{Name: "PROBE_TARGET"},
+	{Name: "PROBE_TIMEOUT"},
+	{Name: "PROBE_WEBHOOK", Secret: true},
+	{Name: "PYTHON_EXECUTABLE"},
+	{Name: "PYTHON_PROTOCOL"},
+	{Name: "PYTHON_SCRIPT"},
+	{Name: "PYTHON_TIMEOUT"},
+	{Name: "PYTHON_WORKERS"},
+	{Name: "RATE_LIMIT"},
+	{Name: "RATE_LIMIT_BURST"},
+	{Name: "RATE_LIMIT_DAILY_QUOTA"},
+	{Name: "RATE_LIMIT_KEY_HEADER"},
+	{Name: "RATE_LIMIT_KEYS", Secret: true},
+	{Name: "RATE_LIMIT_RPS"},
+	{Name: "REMOTE_MODEL_URL"},
+	{Name: "RESULT_ACCESS"},
+	{Name: "RESULT_BASE_URL"},
+	{Name: "RESULT_MAX_PER_CLIENT"},
+	{Name: "RESULT_RETENTION"},
+	{Name: "RESULT_SIGNING_KEY", Secret: true},
+	{Name: "SCORE_CACHE_REDIS_URL"},
+	{Name: "SCORE_CACHE_SIZE"},
+	{Name: "SCORE_CACHE_TTL"},
+	{Name: "SHUTDOWN_GRACE_PERIOD"},
+	{Name: "SIMILARITY_BACKEND"},
+	{Name: "SLO_DEFINITIONS"},
+	{Name: "TRUSTED_PROXIES"},
+}
+
+// Sources of an effective value.
+const (
+	SourceEnv  = "env"
+	SourceFile = "file"
+)
+
+// Value is a setting's effective value and where it came from.
+type Value struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+var (
+	file     string
+	fromFile = map[string]bool{}
+	loadErr  error
+)
+
+func init() {
+	apply(os.Getenv("CONFIG_FILE"))
+}
+
+// apply loads path, if set, into the environment, leaving alone any
+// variable the environment already sets.
+func apply(path string) {
+	file, fromFile, loadErr = path, map[string]bool{}, nil
+	if file == "" {
+		return
+	}
+	values, err := Load(file)
+	if err != nil {
+		loadErr = err
+		return
+	}
+	for name, value := range values {
+		if _, set := os.LookupEnv(name); set {
+			continue
+		}
+		os.Setenv(name, value)
+		fromFile[name] = true
+	}
+}
+
+// File returns the configuration file in use, or "" if CONFIG_FILE is
+// not set.
+func File() string {
+	return file
+}
+
+// Err returns why the configuration file could not be loaded, or nil.
+func Err() error {
+	return loadErr
+}
+
+// FileValues returns how many settings the file supplied that the
+// environment did not override.
+func FileValues() int {
+	return len(fromFile)
+}
+
+// Load reads a configuration file and returns its settings by variable
+// name. The format follows the extension: .yaml, .yml or .toml. Keys that
+// name no known setting are an error, so that a misspelt key is not
+// silently ignored.
+func Load(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tree := map[string]interface{}{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		if err := decoder.Decode(&tree); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	case ".toml":
+		if err := toml.Unmarshal(data, &tree); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("%s: unsupported format %q; use .yaml, .yml or .toml", path, ext)
+	}
+
+	values := map[string]string{}
+	if err := flatten("", tree, values); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for name := range values {
+		if _, ok := lookup(name); !ok {
+			return nil, fmt.Errorf("%s: unknown setting %q", path, strings.ToLower(name))
+		}
+	}
+	return values, nil
+}
+
+// flatten turns nested tables into variable names and scalar values into
+// strings, the form the environment holds them in.
+func flatten(prefix string, tree map[string]interface{}, values map[string]string) error {
+	for key, value := range tree {
+		name := strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+		if prefix != "" {
+			name = prefix + "_" + name
+		}
+		if table, ok := value.(map[string]interface{}); ok {
+			if err := flatten(name, table, values); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, dup := values[name]; dup {
+			return fmt.Errorf("setting %q is given twice", strings.ToLower(name))
+		}
+		var formatted string
+		if list, ok := value.([]interface{}); ok {
+			items := make([]string, len(list))
+			for i, item := range list {
+				s, err := scalar(name, item)
+				if err != nil {
+					return err
+				}
+				items[i] = s
+			}
+			formatted = strings.Join(items, ",")
+		} else {
+			s, err := scalar(name, value)
+			if err != nil {
+				return err
+			}
+			formatted = s
+		}
+		values[name] = formatted
+	}
+	return nil
+}
+
+func scalar(name string, value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case nil:
+		return "", fmt.Errorf("setting %q has no value", strings.ToLower(name))
+	default:
+		return "", fmt.Errorf("setting %q must be a string, number, boolean or list of them", strings.ToLower(name))
+	}
+}
+
+func lookup(name string) (Setting, bool) {
+	for _, setting := range Settings {
+		if setting.Name == name {
+			return setting, true
+		}
+	}
+	return Setting{}, false
+}
+
+// Effective returns every known setting that is set, from the file or
+// the environment, sorted by name and with secrets redacted. Settings
+// left at their defaults are not listed; startup validation reports
+// those.
+func Effective() []Value {
+	var values []Value
+	for _, setting := range Settings {
+		raw, set := os.LookupEnv(setting.Name)
+		if !set {
+			continue
+		}
+		source := SourceEnv
+		if fromFile[setting.Name] {
+			source = SourceFile
+		}
+		values = append(values, Value{Name: setting.Name, Value: Redact(setting.Name, raw), Source: source})
+	}
+	return values
+}
+
+// urlCredentials matches the user info of a URL, such as the password in
+// a Redis URL.
+var urlCredentials = regexp.MustCompile(`://[^/@\s]+@`)
+
+// Redact hides a setting's value if it is secret and removes credentials
+// embedded in URLs otherwise.
+func Redact(name, value string) string {
+	if setting, ok := lookup(name); ok && setting.Secret && value != "" {
+		return "REDACTED"
+	}
+	return urlCredentials.ReplaceAllString(value, "://REDACTED@")
+}
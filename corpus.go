@@ -0,0 +1,788 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"text-similarity-api/similarity"
+)
+
+const (
+	defaultCorpusName   = "default"
+	defaultSearchTopK   = 10
+	maxSearchTopK       = 1000
+	maxCorpora          = 100
+	defaultDocumentPage = 100
+	maxDocumentPage     = 1000
+)
+
+// validCorpusName keeps corpus names usable in URLs and log lines.
+var validCorpusName = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// CorpusDocument is a stored sentence with its embedding. Metadata is
+// returned with search matches so callers can link back to the source.
+type CorpusDocument struct {
+	ID        string
+	Text      string
+	Metadata  map[string]string
+	Embedding []float64
+	AddedAt   time.Time
+	// seq orders documents in storage, where deletes leave gaps; a
+	// replaced document keeps its place.
+	seq int64
+}
+
+// Corpus is a named set of documents searched by embedding similarity.
+// Every embedding has the dimension of the first one added.
+type Corpus struct {
+	Name      string
+	Documents []CorpusDocument
+	index     map[string]int
+	nextSeq   int64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (c *Corpus) dimension() int {
+	if len(c.Documents) == 0 {
+		return 0
+	}
+	return len(c.Documents[0].Embedding)
+}
+
+// CorpusStorage persists corpora so they survive a restart. The in-memory
+// CorpusStore stays the search index: every change is written to the
+// storage before it is applied in memory, and the storage is only read
+// when it is attached.
+type CorpusStorage interface {
+	// Load returns every stored corpus with its documents in the order
+	// they were first added.
+	Load(ctx context.Context) ([]*Corpus, error)
+	// Put creates corpus if it is not stored yet, records its UpdatedAt
+	// and inserts docs, replacing stored documents with the same IDs.
+	Put(ctx context.Context, corpus *Corpus, docs []CorpusDocument) error
+	DeleteDocument(ctx context.Context, corpus, id string) error
+	DeleteCorpus(ctx context.Context, name string) error
+	Close() error
+}
+
+// CorpusStore holds the corpora in memory. Documents are only replaced,
+// never modified in place, so search can read them under a read lock
+// while embeddings for an upload are computed outside any lock. Writes to
+// the storage, if one is attached, happen under the write lock so that
+// memory and storage apply changes in the same order.
+type CorpusStore struct {
+	mu      sync.RWMutex
+	corpora map[string]*Corpus
+	storage CorpusStorage
+}
+
+var corpusStore = NewCorpusStore()
+
+func NewCorpusStore() *CorpusStore {
+	return &CorpusStore{corpora: make(map[string]*Corpus)}
+}
+
+// Attach loads the corpora held by storage, replacing those in memory,
+// and writes every later change through to it.
+func (s *CorpusStore) Attach(ctx context.Context, storage CorpusStorage) error {
+	corpora, err := storage.Load(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.corpora = make(map[string]*Corpus, len(corpora))
+	for _, corpus := range corpora {
+		corpus.index = make(map[string]int, len(corpus.Documents))
+		for i, doc := range corpus.Documents {
+			corpus.index[doc.ID] = i
+			if doc.seq >= corpus.nextSeq {
+				corpus.nextSeq = doc.seq + 1
+			}
+		}
+		s.corpora[corpus.Name] = corpus
+	}
+	s.storage = storage
+	return nil
+}
+
+// Close closes the attached storage, if any.
+func (s *CorpusStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.storage == nil {
+		return nil
+	}
+	err := s.storage.Close()
+	s.storage = nil
+	return err
+}
+
+// Persistent reports whether corpora are written to a storage.
+func (s *CorpusStore) Persistent() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.storage != nil
+}
+
+// Errors returned by CorpusStore.Add.
+var (
+	errTooManyCorpora  = fmt.Errorf("at most %d corpora can exist at once", maxCorpora)
+	errCorpusDimension = errors.New("the embeddings do not match the corpus's dimension; the model has changed since it was built")
+)
+
+// errCorpusFull means an upload would take a corpus past the
+// max_corpus_documents limit.
+type errCorpusFull struct{ limit int }
+
+func (e errCorpusFull) Error() string {
+	return fmt.Sprintf("a corpus holds at most %d documents", e.limit)
+}
+
+// errCorpusStorage wraps a failure of the attached storage; the change
+// was not applied.
+type errCorpusStorage struct{ err error }
+
+func (e errCorpusStorage) Error() string { return "corpus storage: " + e.err.Error() }
+
+func (e errCorpusStorage) Unwrap() error { return e.err }
+
+// Add inserts documents into the named corpus, creating it if needed. A
+// document whose ID is already present replaces the stored one. It
+// returns how many documents were replaced and the corpus's new size.
+func (s *CorpusStore) Add(ctx context.Context, name string, docs []CorpusDocument, maxDocuments int) (replaced, size int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	corpus, ok := s.corpora[name]
+	if !ok {
+		if len(s.corpora) >= maxCorpora {
+			return 0, 0, errTooManyCorpora
+		}
+		now := time.Now()
+		corpus = &Corpus{Name: name, index: map[string]int{}, CreatedAt: now}
+	}
+	dimension := corpus.dimension()
+	added := 0
+	for _, doc := range docs {
+		if dimension != 0 && len(doc.Embedding) != dimension {
+			return 0, 0, errCorpusDimension
+		}
+		if _, ok := corpus.index[doc.ID]; ok {
+			replaced++
+		} else {
+			added++
+		}
+	}
+	if len(corpus.Documents)+added > maxDocuments {
+		return 0, 0, errCorpusFull{maxDocuments}
+	}
+
+	docs = append([]CorpusDocument(nil), docs...)
+	seq := corpus.nextSeq
+	for i := range docs {
+		if j, ok := corpus.index[docs[i].ID]; ok {
+			docs[i].seq = corpus.Documents[j].seq
+		} else {
+			docs[i].seq = seq
+			seq++
+		}
+	}
+	updated := *corpus
+	updated.UpdatedAt = time.Now()
+	if s.storage != nil {
+		if err := s.storage.Put(ctx, &updated, docs); err != nil {
+			return 0, 0, errCorpusStorage{err}
+		}
+	}
+
+	documents := append([]CorpusDocument(nil), corpus.Documents...)
+	for _, doc := range docs {
+		if i, ok := corpus.index[doc.ID]; ok {
+			documents[i] = doc
+			continue
+		}
+		corpus.index[doc.ID] = len(documents)
+		documents = append(documents, doc)
+	}
+	corpus.Documents = documents
+	corpus.nextSeq = seq
+	corpus.UpdatedAt = updated.UpdatedAt
+	s.corpora[name] = corpus
+	return replaced, len(documents), nil
+}
+
+// Get returns a copy of the corpus that shares its documents.
+func (s *CorpusStore) Get(name string) (*Corpus, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	corpus, ok := s.corpora[name]
+	if !ok {
+		return nil, false
+	}
+	snapshot := *corpus
+	return &snapshot, true
+}
+
+// Document returns one document of the named corpus.
+func (s *CorpusStore) Document(name, id string) (CorpusDocument, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	corpus, ok := s.corpora[name]
+	if !ok {
+		return CorpusDocument{}, false
+	}
+	i, ok := corpus.index[id]
+	if !ok {
+		return CorpusDocument{}, false
+	}
+	return corpus.Documents[i], true
+}
+
+func (s *CorpusStore) List() []*Corpus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	corpora := make([]*Corpus, 0, len(s.corpora))
+	for _, corpus := range s.corpora {
+		snapshot := *corpus
+		corpora = append(corpora, &snapshot)
+	}
+	sort.Slice(corpora, func(i, j int) bool { return corpora[i].Name < corpora[j].Name })
+	return corpora
+}
+
+func (s *CorpusStore) Delete(ctx context.Context, name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.corpora[name]; !ok {
+		return false, nil
+	}
+	if s.storage != nil {
+		if err := s.storage.DeleteCorpus(ctx, name); err != nil {
+			return false, errCorpusStorage{err}
+		}
+	}
+	delete(s.corpora, name)
+	return true, nil
+}
+
+// DeleteDocument removes one document. The corpus remains, even when it is
+// left empty.
+func (s *CorpusStore) DeleteDocument(ctx context.Context, name, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	corpus, ok := s.corpora[name]
+	if !ok {
+		return false, nil
+	}
+	i, ok := corpus.index[id]
+	if !ok {
+		return false, nil
+	}
+	if s.storage != nil {
+		if err := s.storage.DeleteDocument(ctx, name, id); err != nil {
+			return false, errCorpusStorage{err}
+		}
+	}
+	documents := make([]CorpusDocument, 0, len(corpus.Documents)-1)
+	documents = append(documents, corpus.Documents[:i]...)
+	documents = append(documents, corpus.Documents[i+1:]...)
+	corpus.index = make(map[string]int, len(documents))
+	for j, doc := range documents {
+		corpus.index[doc.ID] = j
+	}
+	corpus.Documents = documents
+	corpus.UpdatedAt = time.Now()
+	return true, nil
+}
+
+func newDocumentID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+type CorpusDocumentInput struct {
+	ID       string            `json:"id"`
+	Text     string            `json:"text"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+type CorpusUploadInput struct {
+	Corpus    string                `json:"corpus"`
+	Documents []CorpusDocumentInput `json:"documents"`
+	// Sentences is a shorthand for documents that need neither an ID nor
+	// metadata.
+	Sentences []string `json:"sentences"`
+}
+
+type CorpusUploadResponse struct {
+	Corpus   string   `json:"corpus"`
+	Added    int      `json:"added"`
+	Replaced int      `json:"replaced"`
+	Size     int      `json:"size"`
+	IDs      []string `json:"ids"`
+}
+
+type CorpusInfo struct {
+	Corpus    string `json:"corpus"`
+	Size      int    `json:"size"`
+	Dimension int    `json:"dimension"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+type CorpusDocumentInfo struct {
+	ID       string            `json:"id"`
+	Text     string            `json:"text"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	AddedAt  string            `json:"added_at"`
+}
+
+type CorpusDocumentsResponse struct {
+	Corpus    string               `json:"corpus"`
+	Documents []CorpusDocumentInfo `json:"documents"`
+	Total     int                  `json:"total"`
+	Offset    int                  `json:"offset"`
+}
+
+type SearchInput struct {
+	Corpus        string   `json:"corpus"`
+	Query         string   `json:"query" binding:"required"`
+	TopK          int      `json:"top_k"`
+	MinSimilarity *float64 `json:"min_similarity"`
+}
+
+type SearchMatch struct {
+	ID         string            `json:"id"`
+	Text       string            `json:"text"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	Similarity float64           `json:"similarity"`
+}
+
+type SearchResponse struct {
+	Corpus      string        `json:"corpus"`
+	Query       string        `json:"query"`
+	Matches     []SearchMatch `json:"matches"`
+	Searched    int           `json:"searched"`
+	ProcessedAt string        `json:"processed_at"`
+}
+
+func corpusInfo(corpus *Corpus) CorpusInfo {
+	return CorpusInfo{
+		Corpus:    corpus.Name,
+		Size:      len(corpus.Documents),
+		Dimension: corpus.dimension(),
+		CreatedAt: corpus.CreatedAt.UTC().Format(time.RFC3339),
+		UpdatedAt: corpus.UpdatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// corpusName returns the requested corpus name, or the default one, and
+// responds with 400 if it is not a valid name.
+func corpusName(c *gin.Context, name string) (string, bool) {
+	if name == "" {
+		return defaultCorpusName, true
+	}
+	if !validCorpusName.MatchString(name) {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "corpus names are 1 to 64 letters, digits, dots, dashes or underscores",
+		})
+		return "", false
+	}
+	return name, true
+}
+
+func corpusNotFound(c *gin.Context) {
+	respond(c, http.StatusNotFound, ErrorResponse{
+		Error:   "corpus_not_found",
+		Message: "Corpus does not exist; upload documents with POST /api/v1/corpus",
+	})
+}
+
+// handleUploadCorpus embeds the documents and adds them to a corpus.
+func handleUploadCorpus(c *gin.Context) {
+	var input CorpusUploadInput
+	if err := bindInput(c, &input); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "Invalid input format: " + err.Error(),
+		})
+		return
+	}
+	name, ok := corpusName(c, input.Corpus)
+	if !ok {
+		return
+	}
+	for _, sentence := range input.Sentences {
+		input.Documents = append(input.Documents, CorpusDocumentInput{Text: sentence})
+	}
+	lim := limitsFor(c)
+	if len(input.Documents) == 0 {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "documents or sentences must list at least one document",
+		})
+		return
+	}
+	if len(input.Documents) > lim.MaxEmbeddingSentences {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: fmt.Sprintf("At most %d documents can be uploaded per request, got %d", lim.MaxEmbeddingSentences, len(input.Documents)),
+		})
+		return
+	}
+
+	docs, replaced, size, ok := addDocuments(c, name, input.Documents)
+	if !ok {
+		return
+	}
+	ids := make([]string, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.ID
+	}
+	respond(c, http.StatusCreated, CorpusUploadResponse{
+		Corpus:   name,
+		Added:    len(docs) - replaced,
+		Replaced: replaced,
+		Size:     size,
+		IDs:      ids,
+	})
+}
+
+// addDocuments validates and embeds the documents, which get random IDs
+// where they have none, and adds them to the named corpus. It responds
+// with the error and returns false if they cannot be added.
+func addDocuments(c *gin.Context, name string, inputs []CorpusDocumentInput) (docs []CorpusDocument, replaced, size int, ok bool) {
+	lim := limitsFor(c)
+	texts := make([]string, len(inputs))
+	seen := make(map[string]bool, len(inputs))
+	for i, doc := range inputs {
+		texts[i] = strings.TrimSpace(doc.Text)
+		if texts[i] == "" {
+			respond(c, http.StatusBadRequest, ErrorResponse{
+				Error:   "empty_sentences",
+				Message: "All documents must have non-empty text",
+			})
+			return nil, 0, 0, false
+		}
+		if doc.ID == "" {
+			continue
+		}
+		if len(doc.ID) > 128 || seen[doc.ID] {
+			respond(c, http.StatusBadRequest, ErrorResponse{
+				Error:   "validation_error",
+				Message: fmt.Sprintf("document IDs must be unique within a request and at most 128 bytes; %q is not", doc.ID),
+			})
+			return nil, 0, 0, false
+		}
+		seen[doc.ID] = true
+	}
+	if !checkSentenceLengths(c, lim, texts...) {
+		return nil, 0, 0, false
+	}
+
+	embeddings, err := scorer.Embed(c.Request.Context(), texts)
+	if err != nil {
+		logBackendError(c, err, texts...)
+		respond(c, http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to embed corpus documents",
+		})
+		return nil, 0, 0, false
+	}
+
+	now := time.Now()
+	docs = make([]CorpusDocument, len(inputs))
+	for i, doc := range inputs {
+		id := doc.ID
+		if id == "" {
+			if id, err = newDocumentID(); err != nil {
+				respond(c, http.StatusInternalServerError, ErrorResponse{
+					Error:   "internal_error",
+					Message: "Failed to create document IDs",
+				})
+				return nil, 0, 0, false
+			}
+		}
+		docs[i] = CorpusDocument{ID: id, Text: texts[i], Metadata: doc.Metadata, Embedding: embeddings[i], AddedAt: now}
+	}
+
+	replaced, size, err = corpusStore.Add(c.Request.Context(), name, docs, lim.MaxCorpusDocuments)
+	var full errCorpusFull
+	var storage errCorpusStorage
+	switch {
+	case err == nil:
+		return docs, replaced, size, true
+	case errors.As(err, &full):
+		respond(c, http.StatusRequestEntityTooLarge, ErrorResponse{
+			Error:   "corpus_full",
+			Message: err.Error(),
+		})
+	case errors.Is(err, errTooManyCorpora):
+		respond(c, http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "too_many_corpora",
+			Message: err.Error(),
+		})
+	case errors.As(err, &storage):
+		corpusStorageFailed(c, err)
+	default:
+		respond(c, http.StatusConflict, ErrorResponse{
+			Error:   "corpus_conflict",
+			Message: err.Error(),
+		})
+	}
+	return nil, 0, 0, false
+}
+
+// corpusStorageFailed logs a failed write to the corpus database and
+// responds with 503; the change was not applied.
+func corpusStorageFailed(c *gin.Context, err error) {
+	slog.Error("Corpus storage failed", "request_id", requestID(c), "error", err)
+	respond(c, http.StatusServiceUnavailable, ErrorResponse{
+		Error:   "corpus_storage_unavailable",
+		Message: "The corpus database could not be written; the change was not applied",
+	})
+}
+
+func handleListCorpora(c *gin.Context) {
+	infos := []CorpusInfo{}
+	for _, corpus := range corpusStore.List() {
+		infos = append(infos, corpusInfo(corpus))
+	}
+	respond(c, http.StatusOK, gin.H{"corpora": infos})
+}
+
+func handleGetCorpus(c *gin.Context) {
+	corpus, ok := corpusStore.Get(c.Param("name"))
+	if !ok {
+		corpusNotFound(c)
+		return
+	}
+	respond(c, http.StatusOK, corpusInfo(corpus))
+}
+
+func handleDeleteCorpus(c *gin.Context) {
+	deleted, err := corpusStore.Delete(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		corpusStorageFailed(c, err)
+		return
+	}
+	if !deleted {
+		corpusNotFound(c)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func documentInfo(doc CorpusDocument) CorpusDocumentInfo {
+	return CorpusDocumentInfo{
+		ID:       doc.ID,
+		Text:     doc.Text,
+		Metadata: doc.Metadata,
+		AddedAt:  doc.AddedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+func documentNotFound(c *gin.Context) {
+	respond(c, http.StatusNotFound, ErrorResponse{
+		Error:   "document_not_found",
+		Message: "Document does not exist in this corpus",
+	})
+}
+
+// handleListDocuments pages through a corpus's documents in the order
+// they were added, without their embeddings.
+func handleListDocuments(c *gin.Context) {
+	corpus, ok := corpusStore.Get(c.Param("name"))
+	if !ok {
+		corpusNotFound(c)
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "offset must be a non-negative integer",
+		})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultDocumentPage)))
+	if err != nil || limit < 1 || limit > maxDocumentPage {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: fmt.Sprintf("limit must be between 1 and %d", maxDocumentPage),
+		})
+		return
+	}
+
+	docs := []CorpusDocumentInfo{}
+	for i := offset; i < len(corpus.Documents) && len(docs) < limit; i++ {
+		docs = append(docs, documentInfo(corpus.Documents[i]))
+	}
+	respond(c, http.StatusOK, CorpusDocumentsResponse{
+		Corpus:    corpus.Name,
+		Documents: docs,
+		Total:     len(corpus.Documents),
+		Offset:    offset,
+	})
+}
+
+func handleGetDocument(c *gin.Context) {
+	doc, ok := corpusStore.Document(c.Param("name"), c.Param("id"))
+	if !ok {
+		documentNotFound(c)
+		return
+	}
+	respond(c, http.StatusOK, documentInfo(doc))
+}
+
+// handlePutDocument embeds the text and stores it under the document ID
+// in the URL, creating the corpus if needed.
+func handlePutDocument(c *gin.Context) {
+	var input CorpusDocumentInput
+	if err := bindInput(c, &input); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "Invalid input format: " + err.Error(),
+		})
+		return
+	}
+	name, ok := corpusName(c, c.Param("name"))
+	if !ok {
+		return
+	}
+	if input.ID != "" && input.ID != c.Param("id") {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "id in the body does not match the URL",
+		})
+		return
+	}
+	input.ID = c.Param("id")
+
+	docs, replaced, _, ok := addDocuments(c, name, []CorpusDocumentInput{input})
+	if !ok {
+		return
+	}
+	status := http.StatusCreated
+	if replaced > 0 {
+		status = http.StatusOK
+	}
+	respond(c, status, documentInfo(docs[0]))
+}
+
+func handleDeleteDocument(c *gin.Context) {
+	deleted, err := corpusStore.DeleteDocument(c.Request.Context(), c.Param("name"), c.Param("id"))
+	if err != nil {
+		corpusStorageFailed(c, err)
+		return
+	}
+	if !deleted {
+		documentNotFound(c)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// handleSearch embeds the query and returns the corpus documents most
+// similar to it. Every document is compared, so latency grows with the
+// corpus, but only one model call is made per search.
+func handleSearch(c *gin.Context) {
+	var input SearchInput
+	if err := bindInput(c, &input); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "Invalid input format: " + err.Error(),
+		})
+		return
+	}
+	name, ok := corpusName(c, input.Corpus)
+	if !ok {
+		return
+	}
+	input.Query = strings.TrimSpace(input.Query)
+	if input.Query == "" {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "empty_sentences",
+			Message: "query must be non-empty",
+		})
+		return
+	}
+	topK := input.TopK
+	if topK == 0 {
+		topK = defaultSearchTopK
+	}
+	if topK < 1 || topK > maxSearchTopK {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: fmt.Sprintf("top_k must be between 1 and %d", maxSearchTopK),
+		})
+		return
+	}
+	if !checkSentenceLengths(c, limitsFor(c), input.Query) {
+		return
+	}
+
+	corpus, ok := corpusStore.Get(name)
+	if !ok {
+		corpusNotFound(c)
+		return
+	}
+
+	embeddings, err := scorer.Embed(c.Request.Context(), []string{input.Query})
+	if err != nil {
+		logBackendError(c, err, input.Query)
+		respond(c, http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to embed the query",
+		})
+		return
+	}
+	if dimension := corpus.dimension(); dimension != 0 && len(embeddings[0]) != dimension {
+		respond(c, http.StatusConflict, ErrorResponse{
+			Error:   "corpus_conflict",
+			Message: errCorpusDimension.Error(),
+		})
+		return
+	}
+
+	matches := make([]SearchMatch, 0, len(corpus.Documents))
+	for _, doc := range corpus.Documents {
+		score := similarity.Cosine(embeddings[0], doc.Embedding)
+		if input.MinSimilarity != nil && score < *input.MinSimilarity {
+			continue
+		}
+		matches = append(matches, SearchMatch{ID: doc.ID, Text: doc.Text, Metadata: doc.Metadata, Similarity: score})
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Similarity > matches[j].Similarity
+	})
+	if topK < len(matches) {
+		matches = matches[:topK]
+	}
+
+	respond(c, http.StatusOK, SearchResponse{
+		Corpus:      name,
+		Query:       input.Query,
+		Matches:     matches,
+		Searched:    len(corpus.Documents),
+		ProcessedAt: time.Now().UTC().Format(time.RFC3339),
+	})
+}
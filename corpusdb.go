@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+
+	"text-similarity-api/config"
+)
+
+// database/sql drivers for CORPUS_DATABASE.
+const (
+	corpusDriverSQLite   = "sqlite"
+	corpusDriverPostgres = "postgres"
+)
+
+// corpusDatabaseFromEnv returns the driver and data source name for
+// CORPUS_DATABASE. A postgres:// URL selects Postgres and anything else is
+// the path of a SQLite file. Unset, corpora live in memory only.
+func corpusDatabaseFromEnv() (driver, dsn string, err error) {
+	raw := strings.TrimSpace(os.Getenv("CORPUS_DATABASE"))
+	switch {
+	case raw == "":
+		return "", "", nil
+	case strings.HasPrefix(raw, "postgres://"), strings.HasPrefix(raw, "postgresql://"):
+		if u, err := url.Parse(raw); err != nil || u.Host == "" {
+			return "", "", fmt.Errorf("CORPUS_DATABASE %q is not a valid Postgres URL", config.Redact("CORPUS_DATABASE", raw))
+		}
+		return corpusDriverPostgres, raw, nil
+	case strings.Contains(raw, "://"):
+		return "", "", fmt.Errorf("CORPUS_DATABASE %q must be a SQLite file path or a postgres:// URL", config.Redact("CORPUS_DATABASE", raw))
+	default:
+		// The busy timeout covers another process holding the file.
+		return corpusDriverSQLite, "file:" + raw + "?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)", nil
+	}
+}
+
+// corpusDatabaseStatus names the corpus database engine for /version.
+func corpusDatabaseStatus() string {
+	driver, _, err := corpusDatabaseFromEnv()
+	switch {
+	case err != nil:
+		return "invalid"
+	case driver == "":
+		return "memory"
+	}
+	return driver
+}
+
+// attachCorpusDatabase opens and migrates the database named by
+// CORPUS_DATABASE and loads its corpora. Startup validation has already
+// refused an invalid value.
+func attachCorpusDatabase(ctx context.Context) error {
+	driver, dsn, err := corpusDatabaseFromEnv()
+	if err != nil || driver == "" {
+		return err
+	}
+	storage, err := openSQLCorpusStorage(ctx, driver, dsn)
+	if err != nil {
+		return err
+	}
+	if err := corpusStore.Attach(ctx, storage); err != nil {
+		storage.Close()
+		return err
+	}
+	return nil
+}
+
+// corpusMigrations create and evolve the corpus schema. Each is applied
+// once, in order, and its version recorded in corpus_schema_migrations.
+// Append new migrations; never edit one that has shipped.
+var corpusMigrations = []struct {
+	sqlite, postgres string
+}{
+	{
+		sqlite: `
+CREATE TABLE corpora (
+	name       TEXT PRIMARY KEY,
+	created_at BIGINT NOT NULL,
+	updated_at BIGINT NOT NULL
+);
+CREATE TABLE corpus_documents (
+	corpus    TEXT NOT NULL REFERENCES corpora(name) ON DELETE CASCADE,
+	id        TEXT NOT NULL,
+	seq       BIGINT NOT NULL,
+	text      TEXT NOT NULL,
+	metadata  TEXT,
+	embedding BLOB NOT NULL,
+	added_at  BIGINT NOT NULL,
+	PRIMARY KEY (corpus, id)
+);`,
+		postgres: `
+CREATE TABLE corpora (
+	name       TEXT PRIMARY KEY,
+	created_at BIGINT NOT NULL,
+	updated_at BIGINT NOT NULL
+);
+CREATE TABLE corpus_documents (
+	corpus    TEXT NOT NULL REFERENCES corpora(name) ON DELETE CASCADE,
+	id        TEXT NOT NULL,
+	seq       BIGINT NOT NULL,
+	text      TEXT NOT NULL,
+	metadata  TEXT,
+	embedding BYTEA NOT NULL,
+	added_at  BIGINT NOT NULL,
+	PRIMARY KEY (corpus, id)
+);`,
+	},
+}
+
+// sqlCorpusStorage keeps corpora in SQLite or Postgres. Times are stored
+// as Unix nanoseconds and embeddings as little-endian float64s, so both
+// databases hold exactly what was in memory.
+type sqlCorpusStorage struct {
+	db     *sql.DB
+	driver string
+}
+
+func openSQLCorpusStorage(ctx context.Context, driver, dsn string) (*sqlCorpusStorage, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == corpusDriverSQLite {
+		// SQLite allows a single writer.
+		db.SetMaxOpenConns(1)
+	}
+	s := &sqlCorpusStorage{db: db, driver: driver}
+	if err := s.migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// query adapts a query written with Postgres's $1 placeholders to the
+// driver; SQLite numbers its placeholders ?1.
+func (s *sqlCorpusStorage) query(q string) string {
+	if s.driver == corpusDriverSQLite {
+		return strings.ReplaceAll(q, "$", "?")
+	}
+	return q
+}
+
+// corpusMigrationLock is the Postgres advisory lock that keeps replicas
+// starting together from applying the same migration twice.
+const corpusMigrationLock = 0x636f72707573
+
+func (s *sqlCorpusStorage) migrate(ctx context.Context) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if s.driver == corpusDriverPostgres {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, corpusMigrationLock); err != nil {
+			return fmt.Errorf("locking the schema for migration: %w", err)
+		}
+		defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, corpusMigrationLock)
+	}
+
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS corpus_schema_migrations (version INTEGER PRIMARY KEY, applied_at BIGINT NOT NULL)`); err != nil {
+		return fmt.Errorf("creating the migrations table: %w", err)
+	}
+	var version int
+	if err := conn.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM corpus_schema_migrations`).Scan(&version); err != nil {
+		return fmt.Errorf("reading the schema version: %w", err)
+	}
+	if version > len(corpusMigrations) {
+		return fmt.Errorf("the corpus database is at schema version %d, newer than this server's %d", version, len(corpusMigrations))
+	}
+	for i := version; i < len(corpusMigrations); i++ {
+		migration := corpusMigrations[i].postgres
+		if s.driver == corpusDriverSQLite {
+			migration = corpusMigrations[i].sqlite
+		}
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if err := applyMigration(ctx, tx, migration, s.query(`INSERT INTO corpus_schema_migrations (version, applied_at) VALUES ($1, $2)`), i+1); err != nil {
+			return fmt.Errorf("applying corpus schema migration %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration runs one migration in tx and records its version, and
+// commits only if both succeed.
+func applyMigration(ctx context.Context, tx *sql.Tx, migration, record string, version int) error {
+	if _, err := tx.ExecContext(ctx, migration); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, version, time.Now().UnixNano()); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlCorpusStorage) inTx(ctx context.Context, fn func(*sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlCorpusStorage) Load(ctx context.Context) ([]*Corpus, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT name, created_at, updated_at FROM corpora ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	var corpora []*Corpus
+	byName := map[string]*Corpus{}
+	for rows.Next() {
+		var corpus Corpus
+		var created, updated int64
+		if err := rows.Scan(&corpus.Name, &created, &updated); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		corpus.CreatedAt, corpus.UpdatedAt = time.Unix(0, created), time.Unix(0, updated)
+		corpora = append(corpora, &corpus)
+		byName[corpus.Name] = &corpus
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.QueryContext(ctx, `SELECT corpus, id, seq, text, metadata, embedding, added_at FROM corpus_documents ORDER BY corpus, seq`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var doc CorpusDocument
+		var metadata sql.NullString
+		var embedding []byte
+		var added int64
+		if err := rows.Scan(&name, &doc.ID, &doc.seq, &doc.Text, &metadata, &embedding, &added); err != nil {
+			return nil, err
+		}
+		if metadata.Valid {
+			if err := json.Unmarshal([]byte(metadata.String), &doc.Metadata); err != nil {
+				return nil, fmt.Errorf("document %s/%s: metadata: %w", name, doc.ID, err)
+			}
+		}
+		if doc.Embedding, err = decodeEmbedding(embedding); err != nil {
+			return nil, fmt.Errorf("document %s/%s: %w", name, doc.ID, err)
+		}
+		doc.AddedAt = time.Unix(0, added)
+		if corpus := byName[name]; corpus != nil {
+			corpus.Documents = append(corpus.Documents, doc)
+		}
+	}
+	return corpora, rows.Err()
+}
+
+func (s *sqlCorpusStorage) Put(ctx context.Context, corpus *Corpus, docs []CorpusDocument) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, s.query(`INSERT INTO corpora (name, created_at, updated_at) VALUES ($1, $2, $3)
+ON CONFLICT (name) DO UPDATE SET updated_at = excluded.updated_at`),
+			corpus.Name, corpus.CreatedAt.UnixNano(), corpus.UpdatedAt.UnixNano()); err != nil {
+			return err
+		}
+		stmt, err := tx.PrepareContext(ctx, s.query(`INSERT INTO corpus_documents (corpus, id, seq, text, metadata, embedding, added_at) VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (corpus, id) DO UPDATE SET text = excluded.text, metadata = excluded.metadata, embedding = excluded.embedding, added_at = excluded.added_at`))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, doc := range docs {
+			var metadata sql.NullString
+			if len(doc.Metadata) > 0 {
+				encoded, err := json.Marshal(doc.Metadata)
+				if err != nil {
+					return err
+				}
+				metadata = sql.NullString{String: string(encoded), Valid: true}
+			}
+			if _, err := stmt.ExecContext(ctx, corpus.Name, doc.ID, doc.seq, doc.Text, metadata, encodeEmbedding(doc.Embedding), doc.AddedAt.UnixNano()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqlCorpusStorage) DeleteDocument(ctx context.Context, corpus, id string) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, s.query(`DELETE FROM corpus_documents WHERE corpus = $1 AND id = $2`), corpus, id); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, s.query(`UPDATE corpora SET updated_at = $1 WHERE name = $2`), time.Now().UnixNano(), corpus)
+		return err
+	})
+}
+
+func (s *sqlCorpusStorage) DeleteCorpus(ctx context.Context, name string) error {
+	// Documents go with the corpus through ON DELETE CASCADE.
+	_, err := s.db.ExecContext(ctx, s.query(`DELETE FROM corpora WHERE name = $1`), name)
+	return err
+}
+
+func (s *sqlCorpusStorage) Close() error {
+	return s.db.Close()
+}
+
+func encodeEmbedding(embedding []float64) []byte {
+	b := make([]byte, 8*len(embedding))
+	for i, value := range embedding {
+		binary.LittleEndian.PutUint64(b[8*i:], math.Float64bits(value))
+	}
+	return b
+}
+
+func decodeEmbedding(b []byte) ([]float64, error) {
+	if len(b)%8 != 0 {
+		return nil, fmt.Errorf("embedding of %d bytes is not a whole number of float64s", len(b))
+	}
+	embedding := make([]float64, len(b)/8)
+	for i := range embedding {
+		embedding[i] = math.Float64frombits(binary.LittleEndian.Uint64(b[8*i:]))
+	}
+	return embedding, nil
+}
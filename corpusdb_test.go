@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openTestCorpusDatabase(t *testing.T, path string) *sqlCorpusStorage {
+	t.Helper()
+	t.Setenv("CORPUS_DATABASE", path)
+	driver, dsn, err := corpusDatabaseFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage, err := openSQLCorpusStorage(context.Background(), driver, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storage
+}
+
+func TestCorpusDatabaseFromEnv(t *testing.T) {
+	tests := []struct {
+		value      string
+		wantDriver string
+		wantErr    bool
+	}{
+		{"", "", false},
+		{"  ", "", false},
+		{"/var/lib/corpora.db", corpusDriverSQLite, false},
+		{"postgres://user:pass@db:5432/corpora", corpusDriverPostgres, false},
+		{"postgresql://db/corpora", corpusDriverPostgres, false},
+		{"postgres:///corpora", "", true},
+		{"mysql://db/corpora", "", true},
+	}
+	for _, tt := range tests {
+		t.Setenv("CORPUS_DATABASE", tt.value)
+		driver, _, err := corpusDatabaseFromEnv()
+		if (err != nil) != tt.wantErr || driver != tt.wantDriver {
+			t.Errorf("CORPUS_DATABASE=%q: got %q, %v; want %q, error %v", tt.value, driver, err, tt.wantDriver, tt.wantErr)
+		}
+	}
+}
+
+func TestCorpusMigrationsIdempotent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "corpora.db")
+	for run := 1; run <= 2; run++ {
+		storage := openTestCorpusDatabase(t, path)
+		var versions int
+		if err := storage.db.QueryRow(`SELECT COUNT(*) FROM corpus_schema_migrations`).Scan(&versions); err != nil {
+			t.Fatal(err)
+		}
+		if versions != len(corpusMigrations) {
+			t.Errorf("run %d: %d migrations recorded, want %d", run, versions, len(corpusMigrations))
+		}
+		storage.Close()
+	}
+}
+
+func TestCorpusMigrationsRefuseNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "corpora.db")
+	storage := openTestCorpusDatabase(t, path)
+	if _, err := storage.db.Exec(`INSERT INTO corpus_schema_migrations (version, applied_at) VALUES (?, ?)`, len(corpusMigrations)+1, time.Now().UnixNano()); err != nil {
+		t.Fatal(err)
+	}
+	storage.Close()
+
+	_, dsn, _ := corpusDatabaseFromEnv()
+	if _, err := openSQLCorpusStorage(context.Background(), corpusDriverSQLite, dsn); err == nil || !strings.Contains(err.Error(), "newer than this server's") {
+		t.Errorf("error = %v, want the newer schema to be refused", err)
+	}
+}
+
+func TestSQLCorpusStorageRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "corpora.db")
+	storage := openTestCorpusDatabase(t, path)
+	defer storage.Close()
+
+	now := time.Unix(0, time.Now().UnixNano())
+	corpus := &Corpus{Name: "faq", CreatedAt: now, UpdatedAt: now}
+	docs := []CorpusDocument{
+		{ID: "a", Text: "first", Metadata: map[string]string{"lang": "en"}, Embedding: []float64{0.5, -1}, AddedAt: now, seq: 0},
+		{ID: "b", Text: "second", Embedding: []float64{1, 0}, AddedAt: now, seq: 1},
+	}
+	if err := storage.Put(ctx, corpus, docs); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.DeleteDocument(ctx, "faq", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := storage.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 1 || loaded[0].Name != "faq" || !loaded[0].CreatedAt.Equal(now) {
+		t.Fatalf("loaded %+v, want the faq corpus", loaded)
+	}
+	if got := loaded[0].Documents; len(got) != 1 || got[0].ID != "b" || !reflect.DeepEqual(got[0].Embedding, docs[1].Embedding) {
+		t.Errorf("documents = %+v, want only b", got)
+	}
+
+	if err := storage.DeleteCorpus(ctx, "faq"); err != nil {
+		t.Fatal(err)
+	}
+	var remaining int
+	if err := storage.db.QueryRow(`SELECT COUNT(*) FROM corpus_documents`).Scan(&remaining); err != nil {
+		t.Fatal(err)
+	}
+	if remaining != 0 {
+		t.Errorf("%d documents left after deleting their corpus, want 0", remaining)
+	}
+}
+
+func TestEmbeddingEncoding(t *testing.T) {
+	tests := [][]float64{
+		nil,
+		{0},
+		{1.5, -2.25, 1e-300},
+	}
+	for _, embedding := range tests {
+		got, err := decodeEmbedding(encodeEmbedding(embedding))
+		if err != nil || len(got) != len(embedding) {
+			t.Errorf("round trip of %v = %v, %v", embedding, got, err)
+			continue
+		}
+		for i := range got {
+			if got[i] != embedding[i] {
+				t.Errorf("round trip of %v = %v", embedding, got)
+				break
+			}
+		}
+	}
+	if _, err := decodeEmbedding(make([]byte, 7)); err == nil {
+		t.Error("decodeEmbedding accepted 7 bytes")
+	}
+}
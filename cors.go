@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const corsAnyOrigin = "*"
+
+// corsOriginsFromEnv reads CORS_ALLOWED_ORIGINS, a comma-separated list of
+// origins such as https://app.example.com that browsers may call the API
+// from. It defaults to "*", any origin.
+func corsOriginsFromEnv() ([]string, error) {
+	raw := os.Getenv("CORS_ALLOWED_ORIGINS")
+	if raw == "" {
+		return []string{corsAnyOrigin}, nil
+	}
+	var origins []string
+	for _, origin := range strings.Split(raw, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == corsAnyOrigin {
+			origins = append(origins, origin)
+			continue
+		}
+		parsed, err := url.Parse(origin)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" ||
+			parsed.User != nil || (parsed.Path != "" && parsed.Path != "/") || parsed.RawQuery != "" {
+			return nil, fmt.Errorf("CORS_ALLOWED_ORIGINS entry %q must be * or an origin such as https://app.example.com", origin)
+		}
+		origins = append(origins, parsed.Scheme+"://"+parsed.Host)
+	}
+	return origins, nil
+}
+
+// corsOrigins allows no cross-origin callers if the environment is
+// invalid; startup validation refuses to start in that case.
+var corsOrigins = func() []string {
+	origins, err := corsOriginsFromEnv()
+	if err != nil {
+		return nil
+	}
+	return origins
+}()
+
+// cors answers preflight requests and lets browsers on the allowed
+// origins read responses. With a list of origins the matching one is
+// echoed back, since the header holds a single origin.
+func cors(origins []string) gin.HandlerFunc {
+	allowed := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		allowed[strings.ToLower(origin)] = true
+	}
+	return func(c *gin.Context) {
+		if allowed[corsAnyOrigin] {
+			c.Header("Access-Control-Allow-Origin", corsAnyOrigin)
+		} else {
+			c.Writer.Header().Add("Vary", "Origin")
+			if origin := c.GetHeader("Origin"); allowed[strings.ToLower(origin)] {
+				c.Header("Access-Control-Allow-Origin", origin)
+			}
+		}
+		c.Header("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Fault-Injection, Prefer, X-Request-ID, traceparent")
+		c.Header("Access-Control-Expose-Headers", "X-Request-ID")
+		if c.Request.Method == http.MethodOptions {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	}
+}
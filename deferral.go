@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultDeferralMaxInFlight = 8
+	defaultDeferralMaxPending  = 1000
+	// deferralResultTTL is how long a computed result waits to be redeemed.
+	deferralResultTTL = 10 * time.Minute
+	// defaultDeferralLatency seeds the ready-time estimate until a request
+	// has been measured.
+	defaultDeferralLatency = 200 * time.Millisecond
+)
+
+type DeferralConfig struct {
+	MaxInFlight int
+	MaxPending  int
+}
+
+// deferralFromEnv returns the configuration for DEFERRAL=on and its
+// DEFERRAL_* limits, or nil when deferral is off.
+func deferralFromEnv() (*DeferralConfig, error) {
+	switch mode := os.Getenv("DEFERRAL"); mode {
+	case "", "off":
+		return nil, nil
+	case "on":
+	default:
+		return nil, fmt.Errorf("DEFERRAL %q must be on or off", mode)
+	}
+
+	config := &DeferralConfig{
+		MaxInFlight: defaultDeferralMaxInFlight,
+		MaxPending:  defaultDeferralMaxPending,
+	}
+	for name, target := range map[string]*int{
+		"DEFERRAL_MAX_IN_FLIGHT": &config.MaxInFlight,
+		"DEFERRAL_MAX_PENDING":   &config.MaxPending,
+	} {
+		if raw := os.Getenv(name); raw != "" {
+			value, err := strconv.Atoi(raw)
+			if err != nil || value < 1 {
+				return nil, fmt.Errorf("%s %q must be a positive integer", name, raw)
+			}
+			*target = value
+		}
+	}
+	return config, nil
+}
+
+// deferredResult is a response computed after its request was deferred.
+type deferredResult struct {
+	ReadyAt     time.Time
+	Done        bool
+	CompletedAt time.Time
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// DeferralQueue caps how many scoring requests run at once. Past the cap,
+// a request that sent "Prefer: respond-async" gets 202 and a token to
+// redeem later, and is computed once a slot frees; other requests wait for
+// a slot as before.
+type DeferralQueue struct {
+	config  *DeferralConfig
+	slots   chan struct{}
+	handler http.Handler
+
+	// ctx is the base of the replays' contexts, cancelled by Drain once
+	// its deadline passes; replays tracks the ones still running.
+	ctx     context.Context
+	cancel  context.CancelFunc
+	replays sync.WaitGroup
+
+	mu      sync.Mutex
+	results map[string]*deferredResult
+	pending int
+	latency time.Duration
+	// draining is set by Drain, after which nothing more is deferred.
+	draining bool
+}
+
+func NewDeferralQueue(config *DeferralConfig) *DeferralQueue {
+	q := &DeferralQueue{
+		config:  config,
+		results: make(map[string]*deferredResult),
+		latency: defaultDeferralLatency,
+	}
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+	if config != nil {
+		q.slots = make(chan struct{}, config.MaxInFlight)
+	}
+	return q
+}
+
+var deferrals = NewDeferralQueue(deferralConfig())
+
+// deferralConfig is nil, and nothing is deferred or capped, if deferral is
+// off or misconfigured; startup validation refuses to start in the latter
+// case.
+func deferralConfig() *DeferralConfig {
+	config, _ := deferralFromEnv()
+	return config
+}
+
+// deferredReplayKey marks the context of a deferred request being
+// computed, which already holds a slot.
+type deferredReplayKey struct{}
+
+// isDeferredReplay reports whether c is a deferred request being computed.
+// It was counted when it was deferred, so rate limiting, statistics and
+// SLOs skip it.
+func isDeferredReplay(c *gin.Context) bool {
+	return c.Request.Context().Value(deferredReplayKey{}) != nil
+}
+
+func (q *DeferralQueue) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if q.config == nil || isDeferredReplay(c) {
+			c.Next()
+			return
+		}
+
+		select {
+		case q.slots <- struct{}{}:
+		default:
+			if prefersAsync(c.Request) {
+				q.deferRequest(c)
+				return
+			}
+			select {
+			case q.slots <- struct{}{}:
+			case <-c.Request.Context().Done():
+				c.Abort()
+				return
+			}
+		}
+		defer func() { <-q.slots }()
+
+		start := time.Now()
+		c.Next()
+		q.observe(time.Since(start))
+	}
+}
+
+func prefersAsync(req *http.Request) bool {
+	for _, value := range req.Header.Values("Prefer") {
+		for _, preference := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(preference), "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// observe folds one request duration into the moving average used for
+// ready-time estimates.
+func (q *DeferralQueue) observe(d time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.latency = (q.latency*7 + d) / 8
+}
+
+func (q *DeferralQueue) deferRequest(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "Failed to read request body",
+		})
+		c.Abort()
+		return
+	}
+	token, err := newSessionID()
+	if err != nil {
+		respond(c, http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to create deferral token",
+		})
+		c.Abort()
+		return
+	}
+
+	q.mu.Lock()
+	if q.draining {
+		q.mu.Unlock()
+		respond(c, http.StatusServiceUnavailable, ErrorResponse{
+			Error:     "shutting_down",
+			Message:   "The server is shutting down and no longer defers requests",
+			Retryable: true,
+		})
+		c.Abort()
+		return
+	}
+	if q.pending >= q.config.MaxPending {
+		readyAt := time.Now().Add(q.estimate(q.pending))
+		q.mu.Unlock()
+		c.Header("Retry-After", strconv.Itoa(q.retryAfterSeconds(readyAt)))
+		respond(c, http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "overloaded",
+			Message: "The server is at capacity and its deferral queue is full",
+		})
+		c.Abort()
+		return
+	}
+	result := &deferredResult{ReadyAt: time.Now().Add(q.estimate(q.pending))}
+	q.pending++
+	q.results[token] = result
+	// Added under q.mu, so Drain cannot start waiting before it.
+	q.replays.Add(1)
+	q.mu.Unlock()
+
+	replay, _ := http.NewRequestWithContext(
+		context.WithValue(q.ctx, deferredReplayKey{}, true),
+		c.Request.Method, c.Request.URL.RequestURI(), bytes.NewReader(body))
+	replay.Header = c.Request.Header.Clone()
+	replay.Header.Del("Prefer")
+	go q.compute(token, replay)
+
+	c.Header("Location", "/api/v1/deferred/"+token)
+	q.respondPending(c, token, result)
+	c.Abort()
+}
+
+// estimate is how long until a request with ahead requests queued in
+// front of it gets a slot and completes. Callers hold q.mu.
+func (q *DeferralQueue) estimate(ahead int) time.Duration {
+	rounds := ahead/q.config.MaxInFlight + 1
+	return time.Duration(rounds) * q.latency
+}
+
+func (q *DeferralQueue) retryAfterSeconds(readyAt time.Time) int {
+	seconds := int(time.Until(readyAt).Seconds() + 0.999)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+func (q *DeferralQueue) compute(token string, req *http.Request) {
+	defer q.replays.Done()
+	status, contentType, body := q.replay(req)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending--
+	if result, ok := q.results[token]; ok {
+		result.Done = true
+		result.CompletedAt = time.Now()
+		result.Status = status
+		result.ContentType = contentType
+		result.Body = body
+	}
+}
+
+// replay runs req once it gets a slot and returns its response. A replay
+// that Drain cancels before it gets a slot fails as shutting_down.
+func (q *DeferralQueue) replay(req *http.Request) (status int, contentType string, body []byte) {
+	select {
+	case q.slots <- struct{}{}:
+	case <-req.Context().Done():
+		body, _ = json.Marshal(ErrorResponse{
+			Error:     "shutting_down",
+			Message:   "The server shut down before this request was computed",
+			Retryable: true,
+		})
+		return http.StatusServiceUnavailable, "application/json; charset=utf-8", body
+	}
+	start := time.Now()
+	recorder := httptest.NewRecorder()
+	// Handler panics are answered by the router's recovery; this catches
+	// the middleware in front of it, so the slot is released and the token
+	// still completes.
+	err := func() (err error) {
+		defer recoverItem(panicSiteDeferral, &err)
+		q.handler.ServeHTTP(recorder, req)
+		return nil
+	}()
+	<-q.slots
+	q.observe(time.Since(start))
+	if err != nil {
+		body, _ = json.Marshal(ErrorResponse{
+			Error:   "internal_error",
+			Message: "The server hit an unexpected error",
+		})
+		return http.StatusInternalServerError, "application/json; charset=utf-8", body
+	}
+	return recorder.Code, recorder.Header().Get("Content-Type"), recorder.Body.Bytes()
+}
+
+// Drain waits for the deferred requests still being computed, for use at
+// shutdown once the server has stopped taking requests. Requests are no
+// longer deferred. When ctx ends, the replays left are cancelled, and
+// Drain returns once they have stopped.
+func (q *DeferralQueue) Drain(ctx context.Context) error {
+	q.mu.Lock()
+	q.draining = true
+	q.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		q.replays.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+type DeferralResponse struct {
+	Token             string `json:"token"`
+	Status            string `json:"status"`
+	ReadyAt           string `json:"ready_at"`
+	RetryAfterSeconds int    `json:"retry_after_seconds"`
+	Redeem            string `json:"redeem"`
+}
+
+func (q *DeferralQueue) respondPending(c *gin.Context, token string, result *deferredResult) {
+	readyAt := result.ReadyAt
+	if time.Now().After(readyAt) {
+		q.mu.Lock()
+		readyAt = time.Now().Add(q.latency)
+		q.mu.Unlock()
+	}
+	retryAfter := q.retryAfterSeconds(readyAt)
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	respond(c, http.StatusAccepted, DeferralResponse{
+		Token:             token,
+		Status:            "pending",
+		ReadyAt:           readyAt.UTC().Format(time.RFC3339),
+		RetryAfterSeconds: retryAfter,
+		Redeem:            "GET /api/v1/deferred/" + token,
+	})
+}
+
+// StartJanitor removes results nobody redeemed once a minute.
+func (q *DeferralQueue) StartJanitor() {
+	go func() {
+		for range time.Tick(time.Minute) {
+			q.sweep()
+		}
+	}()
+}
+
+func (q *DeferralQueue) sweep() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for token, result := range q.results {
+		if result.Done && time.Since(result.CompletedAt) > deferralResultTTL {
+			delete(q.results, token)
+		}
+	}
+}
+
+// handleRedeemDeferral returns the result of a deferred request once it
+// has been computed, with the status it would have had, or another 202
+// while it is still pending. A result can be fetched again until it
+// expires.
+func handleRedeemDeferral(c *gin.Context) {
+	token := c.Param("token")
+	deferrals.mu.Lock()
+	result, ok := deferrals.results[token]
+	var snapshot deferredResult
+	if ok {
+		snapshot = *result
+	}
+	deferrals.mu.Unlock()
+
+	if !ok {
+		respond(c, http.StatusNotFound, ErrorResponse{
+			Error:   "deferral_not_found",
+			Message: "Deferral token does not exist or its result has expired",
+		})
+		return
+	}
+	if !snapshot.Done {
+		deferrals.respondPending(c, token, &snapshot)
+		return
+	}
+	c.Data(snapshot.Status, snapshot.ContentType, snapshot.Body)
+}
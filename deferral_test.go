@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// deferralTestRouter serves POST /work behind q with statistics and an SLO
+// on the route. A request to /work holds its slot until it receives from
+// release, or its context ends. started receives a value as each one
+// begins.
+func deferralTestRouter(q *DeferralQueue, stats *RollingStats, slos *SLOTracker) (r *gin.Engine, started chan struct{}, release chan struct{}) {
+	started, release = make(chan struct{}, 8), make(chan struct{})
+	r = gin.New()
+	r.Use(statsMiddleware(stats), sloMiddleware(slos), q.Middleware())
+	r.POST("/work", func(c *gin.Context) {
+		started <- struct{}{}
+		select {
+		case <-release:
+			c.JSON(http.StatusOK, gin.H{"done": true})
+		case <-c.Request.Context().Done():
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "cancelled"})
+		}
+	})
+	q.handler = r
+	return r, started, release
+}
+
+// deferredToken fills q's only slot with a request and defers a second
+// one, returning its token and a channel that closes when the first
+// request has finished.
+func deferredToken(t *testing.T, r http.Handler, started chan struct{}) (token string, first chan struct{}) {
+	t.Helper()
+	first = make(chan struct{})
+	go func() {
+		defer close(first)
+		do(t, r, http.MethodPost, "/work", `{}`)
+	}()
+	<-started
+	w := do(t, r, http.MethodPost, "/work", `{}`, "Prefer", "respond-async")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("deferred request: status %d, want 202: %s", w.Code, w.Body)
+	}
+	var resp DeferralResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Token, first
+}
+
+func deferredResultFor(q *DeferralQueue, token string) deferredResult {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return *q.results[token]
+}
+
+func TestDeferralReplayNotCounted(t *testing.T) {
+	q := NewDeferralQueue(&DeferralConfig{MaxInFlight: 1, MaxPending: 10})
+	stats := NewRollingStats()
+	slos := NewSLOTracker([]SLODefinition{{Name: "work", Route: "/work", LatencyMs: 60000, Target: 0.5, WindowMinutes: 5}})
+	r, started, release := deferralTestRouter(q, stats, slos)
+
+	token, first := deferredToken(t, r, started)
+	close(release)
+	<-first
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := q.Drain(ctx); err != nil {
+		t.Fatalf("Drain = %v, want the replay to finish", err)
+	}
+
+	result := deferredResultFor(q, token)
+	if !result.Done || result.Status != http.StatusOK || !strings.Contains(string(result.Body), "done") {
+		t.Errorf("deferred result = %+v, want the handler's 200", result)
+	}
+	// The first request and the 202; the replay is not counted again.
+	if total := stats.Snapshot().TotalRequests; total != 2 {
+		t.Errorf("stats counted %d requests, want 2", total)
+	}
+	if report := slos.Report(); report[0].Requests != 2 {
+		t.Errorf("SLO counted %d requests, want 2", report[0].Requests)
+	}
+}
+
+func TestDeferralDrainCancels(t *testing.T) {
+	q := NewDeferralQueue(&DeferralConfig{MaxInFlight: 1, MaxPending: 10})
+	r, started, release := deferralTestRouter(q, NewRollingStats(), NewSLOTracker(nil))
+	defer close(release)
+
+	token, _ := deferredToken(t, r, started)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := q.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Drain = %v, want the deadline to pass with the replay waiting", err)
+	}
+	// Drain returned, so the replay has already given up its wait for the
+	// slot.
+	result := deferredResultFor(q, token)
+	if !result.Done || result.Status != http.StatusServiceUnavailable || !strings.Contains(string(result.Body), "shutting_down") {
+		t.Errorf("deferred result = %d %s, want 503 shutting_down", result.Status, result.Body)
+	}
+
+	w := do(t, r, http.MethodPost, "/work", `{}`, "Prefer", "respond-async")
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "shutting_down") {
+		t.Errorf("request deferred while draining: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestDeferralDrainCancelsRunningReplay(t *testing.T) {
+	q := NewDeferralQueue(&DeferralConfig{MaxInFlight: 1, MaxPending: 10})
+	r, started, release := deferralTestRouter(q, NewRollingStats(), NewSLOTracker(nil))
+	defer close(release)
+
+	token, first := deferredToken(t, r, started)
+	// Finish the first request alone, so the replay takes the slot and
+	// blocks in the handler.
+	release <- struct{}{}
+	<-first
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := q.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Drain = %v, want the deadline to pass with the replay running", err)
+	}
+	if result := deferredResultFor(q, token); !result.Done || !strings.Contains(string(result.Body), "cancelled") {
+		t.Errorf("deferred result = %d %s, want the handler to see its context cancelled", result.Status, result.Body)
+	}
+}
+
+func TestPrefersAsync(t *testing.T) {
+	tests := []struct {
+		prefer []string
+		want   bool
+	}{
+		{nil, false},
+		{[]string{"respond-async"}, true},
+		{[]string{"wait=10, Respond-Async"}, true},
+		{[]string{"return=minimal", "respond-async"}, true},
+		{[]string{"respond-asynchronously"}, false},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodPost, "/", nil)
+		for _, value := range tt.prefer {
+			req.Header.Add("Prefer", value)
+		}
+		if got := prefersAsync(req); got != tt.want {
+			t.Errorf("Prefer %q: prefersAsync = %v, want %v", tt.prefer, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"text-similarity-api/similarity"
+)
+
+type EmbeddingsInput struct {
+	Sentences    []string `json:"sentences" binding:"required,min=1"`
+	Pooling      string   `json:"pooling,omitempty"`
+	Normalize    bool     `json:"normalize,omitempty"`
+	MaxSeqLength int      `json:"max_seq_length,omitempty"`
+	Entities     []string `json:"entities,omitempty"`
+}
+
+type EmbeddingsResponse struct {
+	// Embeddings holds one vector per sentence, in request order.
+	Embeddings  [][]float64 `json:"embeddings"`
+	Dimension   int         `json:"dimension"`
+	ProcessedAt string      `json:"processed_at"`
+}
+
+// handleEmbeddings returns the backend's embedding of each sentence, so
+// clients can store vectors and compare them themselves.
+func handleEmbeddings(c *gin.Context) {
+	var input EmbeddingsInput
+	if err := bindInput(c, &input); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "Invalid input format: " + err.Error(),
+		})
+		return
+	}
+	for i, sentence := range input.Sentences {
+		if input.Sentences[i] = strings.TrimSpace(sentence); input.Sentences[i] == "" {
+			respond(c, http.StatusBadRequest, ErrorResponse{
+				Error:   "empty_sentences",
+				Message: "sentences must be non-empty",
+			})
+			return
+		}
+	}
+
+	lim := limitsFor(c)
+	if len(input.Sentences) > lim.MaxEmbeddingSentences {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: fmt.Sprintf("At most %d sentences can be embedded per request, got %d", lim.MaxEmbeddingSentences, len(input.Sentences)),
+		})
+		return
+	}
+	if !checkSentenceLengths(c, lim, input.Sentences...) {
+		return
+	}
+	if len(input.Entities) > lim.MaxEntities {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: fmt.Sprintf("At most %d entities are allowed", lim.MaxEntities),
+		})
+		return
+	}
+	callOptions := similarity.CallOptions{Pooling: input.Pooling, Normalize: input.Normalize, MaxSeqLength: input.MaxSeqLength, Entities: input.Entities}
+	if err := validateCallOptions(c.Request.Context(), callOptions); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	embeddings, err := scorer.Embed(similarity.WithCallOptions(c.Request.Context(), callOptions), input.Sentences)
+	if err != nil {
+		logBackendError(c, err, input.Sentences...)
+		respond(c, http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to compute embeddings",
+		})
+		return
+	}
+
+	respond(c, http.StatusOK, EmbeddingsResponse{
+		Embeddings:  embeddings,
+		Dimension:   len(embeddings[0]),
+		ProcessedAt: time.Now().UTC().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"text-similarity-api/similarity"
+)
+
+const maxExplainSpans = 5
+
+type ExplainInput struct {
+	Sentence1 string `json:"sentence1" binding:"required"`
+	Sentence2 string `json:"sentence2" binding:"required"`
+	// Target is the sentence that gets perturbed, sentence1 by default.
+	Target string `json:"target"`
+	Unit   string `json:"unit"`
+	// Spans asks for the most similar passages of the two sentences.
+	Spans bool `json:"spans"`
+	// Persist stores the explanation and returns a share link.
+	Persist bool `json:"persist"`
+}
+
+type ExplainResponse struct {
+	Sentence1  string               `json:"sentence1"`
+	Sentence2  string               `json:"sentence2"`
+	Target     string               `json:"target"`
+	Unit       string               `json:"unit"`
+	Similarity float64              `json:"similarity"`
+	Removals   []similarity.Removal `json:"removals"`
+	// Spans pairs passages of sentence1 with their most similar passage of
+	// sentence2, with code point offsets into the echoed sentences.
+	Spans       []similarity.SpanMatch `json:"spans,omitempty"`
+	ProcessedAt string                 `json:"processed_at"`
+	Result      *PersistedResult       `json:"result,omitempty"`
+}
+
+func handleExplainSimilarity(c *gin.Context) {
+	var input ExplainInput
+	if err := bindInput(c, &input); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "Invalid input format: " + err.Error(),
+		})
+		return
+	}
+
+	input.Sentence1 = strings.TrimSpace(input.Sentence1)
+	input.Sentence2 = strings.TrimSpace(input.Sentence2)
+	if input.Sentence1 == "" || input.Sentence2 == "" {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "empty_sentences",
+			Message: "Both sentences must be non-empty",
+		})
+		return
+	}
+	lim := limitsFor(c)
+	if !checkSentenceLengths(c, lim, input.Sentence1, input.Sentence2) {
+		return
+	}
+
+	if input.Target == "" {
+		input.Target = "sentence1"
+	}
+	text, other := input.Sentence1, input.Sentence2
+	switch input.Target {
+	case "sentence1":
+	case "sentence2":
+		text, other = other, text
+	default:
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "target must be sentence1 or sentence2",
+		})
+		return
+	}
+
+	if input.Unit == "" {
+		input.Unit = similarity.UnitToken
+	}
+	units, err := similarity.ExplainUnits(text, input.Unit)
+	if err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "unit must be token or phrase",
+		})
+		return
+	}
+	if len(units) > lim.MaxExplainUnits {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: fmt.Sprintf("%s has %d %ss, the limit is %d", input.Target, len(units), input.Unit, lim.MaxExplainUnits),
+		})
+		return
+	}
+
+	explanation, err := scorer.Explain(c.Request.Context(), text, other, input.Unit)
+	if err != nil {
+		logBackendError(c, err, input.Sentence1, input.Sentence2)
+		respond(c, http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to explain similarity",
+		})
+		return
+	}
+
+	var spans []similarity.SpanMatch
+	if input.Spans {
+		spans, err = scorer.MatchSpans(c.Request.Context(), input.Sentence1, input.Sentence2, maxExplainSpans)
+		if err != nil {
+			logBackendError(c, err, input.Sentence1, input.Sentence2)
+			respond(c, http.StatusInternalServerError, ErrorResponse{
+				Error:   "internal_error",
+				Message: "Failed to match spans",
+			})
+			return
+		}
+	}
+
+	response := ExplainResponse{
+		Sentence1:   input.Sentence1,
+		Sentence2:   input.Sentence2,
+		Target:      input.Target,
+		Unit:        input.Unit,
+		Similarity:  explanation.Score,
+		Removals:    explanation.Removals,
+		Spans:       spans,
+		ProcessedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	if input.Persist {
+		var ok bool
+		if response.Result, ok = persistResult(c, StoredResult{
+			Sentence1:  input.Sentence1,
+			Sentence2:  input.Sentence2,
+			Similarity: explanation.Score,
+			Unit:       input.Unit,
+			Removals:   explanation.Removals,
+			Spans:      spans,
+		}); !ok {
+			return
+		}
+	}
+	respond(c, http.StatusOK, response)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"text-similarity-api/similarity"
+)
+
+const maxBackendFailures = 50
+
+// BackendFailure is the admin-visible record of a request that failed
+// because the backend process died or timed out.
+type BackendFailure struct {
+	At          string `json:"at"`
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	Class       string `json:"class"`
+	PID         int    `json:"pid,omitempty"`
+	ExitCode    *int   `json:"exit_code,omitempty"`
+	Signal      string `json:"signal,omitempty"`
+	MaxRSSBytes int64  `json:"max_rss_bytes,omitempty"`
+	Stderr      string `json:"stderr,omitempty"`
+	Error       string `json:"error"`
+}
+
+// BackendFailureLog keeps the most recent backend failures and a running
+// count per class.
+type BackendFailureLog struct {
+	mu     sync.Mutex
+	recent []BackendFailure
+	counts map[string]int64
+}
+
+var backendFailures = &BackendFailureLog{
+	counts: map[string]int64{
+		similarity.FailureOOM:     0,
+		similarity.FailureCrash:   0,
+		similarity.FailureTimeout: 0,
+	},
+}
+
+// Record notes err against the request if it is a process failure or a
+// timeout. Other errors, such as the service rejecting an input, are not
+// failures of the worker and are ignored. Sentences are redacted from the
+// error and stderr like they are from logs.
+func (l *BackendFailureLog) Record(c *gin.Context, err error, sentences ...string) {
+	l.record(c.Request.Method, c.FullPath(), err, sentences...)
+}
+
+// record notes err against the route it was made for, which for work done
+// outside a request, such as a job, is the route that submitted it.
+func (l *BackendFailureLog) record(method, path string, err error, sentences ...string) {
+	failure := BackendFailure{
+		At:     time.Now().UTC().Format(time.RFC3339),
+		Method: method,
+		Path:   path,
+		Error:  logRedactor.Redact(err.Error(), sentences...),
+	}
+	var process *similarity.ProcessFailure
+	switch {
+	case errors.As(err, &process):
+		exitCode := process.ExitCode
+		failure.Class = process.Class
+		failure.PID = process.PID
+		failure.ExitCode = &exitCode
+		failure.Signal = process.Signal
+		failure.MaxRSSBytes = process.MaxRSSBytes
+		failure.Stderr = logRedactor.Redact(process.Stderr, sentences...)
+		failure.Error = logRedactor.Redact(process.Err.Error(), sentences...)
+	case errors.Is(err, context.DeadlineExceeded):
+		failure.Class = similarity.FailureTimeout
+	default:
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.counts[failure.Class]++
+	l.recent = append(l.recent, failure)
+	if len(l.recent) > maxBackendFailures {
+		l.recent = l.recent[len(l.recent)-maxBackendFailures:]
+	}
+}
+
+func (l *BackendFailureLog) Counts() map[string]int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	counts := make(map[string]int64, len(l.counts))
+	for class, count := range l.counts {
+		counts[class] = count
+	}
+	return counts
+}
+
+// logBackendError logs a failed backend call and records it if the
+// backend process died or timed out. The request's failed spans are
+// redacted with the same sentences.
+func logBackendError(c *gin.Context, err error, sentences ...string) {
+	c.Set(backendErrorKey, err)
+	heldSpansFrom(c.Request.Context()).redact(sentences...)
+	slog.Error("Error calling Python service", "request_id", requestID(c), "error", logRedactor.Redact(err.Error(), sentences...))
+	backendFailures.Record(c, err, sentences...)
+}
+
+// Report lists recent failures, newest first, with the counts per class
+// since startup.
+func (l *BackendFailureLog) Report() gin.H {
+	l.mu.Lock()
+	recent := make([]BackendFailure, len(l.recent))
+	for i, failure := range l.recent {
+		recent[len(recent)-1-i] = failure
+	}
+	l.mu.Unlock()
+
+	return gin.H{
+		"counts": l.Counts(),
+		"recent": recent,
+	}
+}
+
+func handleBackendFailures(c *gin.Context) {
+	c.JSON(http.StatusOK, backendFailures.Report())
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"text-similarity-api/similarity"
+)
+
+const (
+	faultRequestHeader  = "X-Fault-Injection"
+	faultResponseHeader = "X-Fault-Injected"
+	maxFaultLatencyMs   = 60000
+)
+
+// FaultConfig controls resilience-testing fault injection. Percentages are
+// in the range 0-100. Unless RequireHeader is turned off, only requests
+// that opt in with `X-Fault-Injection: on` are affected, so a live instance
+// can be tested without disturbing normal traffic.
+type FaultConfig struct {
+	Enabled          bool    `json:"enabled"`
+	RequireHeader    bool    `json:"require_header"`
+	LatencyPercent   float64 `json:"latency_percent"`
+	LatencyMs        int     `json:"latency_ms"`
+	ErrorPercent     float64 `json:"error_percent"`
+	MalformedPercent float64 `json:"malformed_percent"`
+	// BackendErrorPercent and BackendTimeoutPercent fail calls to the
+	// model backend as a crash or a timeout would, so the fallback and
+	// the retryable error paths run as they do for a real failure.
+	BackendErrorPercent   float64 `json:"backend_error_percent"`
+	BackendTimeoutPercent float64 `json:"backend_timeout_percent"`
+}
+
+type FaultCounters struct {
+	Latency        int64 `json:"latency"`
+	Error          int64 `json:"error"`
+	Malformed      int64 `json:"malformed"`
+	BackendError   int64 `json:"backend_error"`
+	BackendTimeout int64 `json:"backend_timeout"`
+}
+
+// errInjectedFault is the cause of every injected backend failure.
+var errInjectedFault = errors.New("injected fault")
+
+// faultContextKey marks the context of a request that faults may affect.
+type faultContextKey struct{}
+
+type FaultInjector struct {
+	mu       sync.RWMutex
+	config   FaultConfig
+	counters FaultCounters
+}
+
+var faultInjector = &FaultInjector{config: FaultConfig{RequireHeader: true}}
+
+func (f FaultConfig) validate() error {
+	for name, percent := range map[string]float64{
+		"latency_percent":         f.LatencyPercent,
+		"error_percent":           f.ErrorPercent,
+		"malformed_percent":       f.MalformedPercent,
+		"backend_error_percent":   f.BackendErrorPercent,
+		"backend_timeout_percent": f.BackendTimeoutPercent,
+	} {
+		if percent < 0 || percent > 100 {
+			return fmt.Errorf("%s must be between 0 and 100", name)
+		}
+	}
+	if f.ErrorPercent+f.MalformedPercent > 100 {
+		return fmt.Errorf("error_percent and malformed_percent must not add up to more than 100")
+	}
+	if f.BackendErrorPercent+f.BackendTimeoutPercent > 100 {
+		return fmt.Errorf("backend_error_percent and backend_timeout_percent must not add up to more than 100")
+	}
+	if f.LatencyMs < 0 || f.LatencyMs > maxFaultLatencyMs {
+		return fmt.Errorf("latency_ms must be between 0 and %d", maxFaultLatencyMs)
+	}
+	return nil
+}
+
+func (f *FaultInjector) Config() (FaultConfig, FaultCounters) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.config, f.counters
+}
+
+func (f *FaultInjector) SetConfig(config FaultConfig) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.config = config
+}
+
+func (f *FaultInjector) count(counter *int64) {
+	f.mu.Lock()
+	*counter++
+	f.mu.Unlock()
+}
+
+// Middleware injects the configured faults before the handler runs. Every
+// affected response carries an X-Fault-Injected header naming the faults.
+// It also marks the request's context, so backend faults apply to the
+// backend calls it makes.
+func (f *FaultInjector) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		config, _ := f.Config()
+		if !config.Enabled {
+			c.Next()
+			return
+		}
+		if config.RequireHeader && !strings.EqualFold(c.GetHeader(faultRequestHeader), "on") {
+			c.Next()
+			return
+		}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), faultContextKey{}, true))
+
+		var injected []string
+		if config.LatencyMs > 0 && rand.Float64()*100 < config.LatencyPercent {
+			f.count(&f.counters.Latency)
+			injected = append(injected, "latency")
+			c.Header(faultResponseHeader, strings.Join(injected, ","))
+			select {
+			case <-time.After(time.Duration(config.LatencyMs) * time.Millisecond):
+			case <-c.Request.Context().Done():
+				c.Abort()
+				return
+			}
+		}
+
+		roll := rand.Float64() * 100
+		switch {
+		case roll < config.ErrorPercent:
+			f.count(&f.counters.Error)
+			injected = append(injected, "error")
+			c.Header(faultResponseHeader, strings.Join(injected, ","))
+			respond(c, http.StatusInternalServerError, ErrorResponse{
+				Error:     "internal_error",
+				Message:   "Failed to process similarity calculation",
+				Retryable: true,
+			})
+			c.Abort()
+			return
+		case roll < config.ErrorPercent+config.MalformedPercent:
+			f.count(&f.counters.Malformed)
+			injected = append(injected, "malformed")
+			c.Header(faultResponseHeader, strings.Join(injected, ","))
+			c.Data(http.StatusOK, "application/json; charset=utf-8", []byte(`{"similarity": 0.`))
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// backendFault returns the failure to inject into a backend call made with
+// ctx, or nil. Only calls for requests the middleware marked are affected.
+func (f *FaultInjector) backendFault(ctx context.Context) error {
+	if ctx.Value(faultContextKey{}) == nil {
+		return nil
+	}
+	config, _ := f.Config()
+	if !config.Enabled {
+		return nil
+	}
+	roll := rand.Float64() * 100
+	switch {
+	case roll < config.BackendErrorPercent:
+		f.count(&f.counters.BackendError)
+		return &similarity.ProcessFailure{Class: similarity.FailureCrash, ExitCode: -1, Err: errInjectedFault}
+	case roll < config.BackendErrorPercent+config.BackendTimeoutPercent:
+		f.count(&f.counters.BackendTimeout)
+		return &similarity.ProcessFailure{Class: similarity.FailureTimeout, ExitCode: -1, Err: fmt.Errorf("%w: %w", errInjectedFault, context.DeadlineExceeded)}
+	}
+	return nil
+}
+
+// faultHooks are the scorer's hooks. They inject backend faults before a
+// call reaches the backend, like a failed before hook, and then run the
+// configured backend hooks, if any.
+type faultHooks struct {
+	injector *FaultInjector
+	next     similarity.Hooks
+}
+
+func (h faultHooks) Before(ctx context.Context) error {
+	if err := h.injector.backendFault(ctx); err != nil {
+		return err
+	}
+	if h.next == nil {
+		return nil
+	}
+	return h.next.Before(ctx)
+}
+
+func (h faultHooks) After(ctx context.Context, callErr error) error {
+	if h.next == nil {
+		return nil
+	}
+	return h.next.After(ctx, callErr)
+}
+
+func handleGetFaults(c *gin.Context) {
+	config, counters := faultInjector.Config()
+	c.JSON(http.StatusOK, gin.H{
+		"config":   config,
+		"injected": counters,
+	})
+}
+
+func handlePutFaults(c *gin.Context) {
+	config := FaultConfig{RequireHeader: true}
+	if err := c.ShouldBindJSON(&config); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "Invalid input format: " + err.Error(),
+		})
+		return
+	}
+	if err := config.validate(); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	faultInjector.SetConfig(config)
+	handleGetFaults(c)
+}
+
+func handleDeleteFaults(c *gin.Context) {
+	faultInjector.SetConfig(FaultConfig{RequireHeader: true})
+	handleGetFaults(c)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// FieldList is the set of response fields a caller asked for. It decodes
+// from either a comma-separated string or a JSON array of strings.
+type FieldList []string
+
+func (f *FieldList) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		*f = list
+		return nil
+	}
+	var joined string
+	if err := json.Unmarshal(data, &joined); err != nil {
+		return fmt.Errorf("fields must be a string or an array of strings")
+	}
+	*f = parseFieldList(joined)
+	return nil
+}
+
+func parseFieldList(joined string) FieldList {
+	var list FieldList
+	for _, field := range strings.Split(joined, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			list = append(list, field)
+		}
+	}
+	return list
+}
+
+// requestedFields merges the `fields` query parameter with the body value.
+func requestedFields(c *gin.Context, body FieldList) FieldList {
+	fields := append(FieldList{}, body...)
+	if query := c.Query("fields"); query != "" {
+		fields = append(fields, parseFieldList(query)...)
+	}
+	return fields
+}
+
+// similarityResponseFields are the fields a /api/v1/similarity caller may
+// select, read once from SimilarityResponse's JSON tags.
+var similarityResponseFields = responseFieldNames(SimilarityResponse{})
+
+// responseFieldNames returns the top-level JSON field names of a response
+// type, including those omitted when empty.
+func responseFieldNames(response interface{}) map[string]bool {
+	names := make(map[string]bool)
+	for name := range jsonFieldValues(response) {
+		names[name] = true
+	}
+	return names
+}
+
+// checkFields rejects requested fields the response does not have, so
+// typos don't silently produce empty payloads. Handlers call it before
+// doing any work, so an invalid list costs no backend call.
+func checkFields(c *gin.Context, fields FieldList, known map[string]bool) bool {
+	var unknown []string
+	for _, field := range fields {
+		if !known[field] {
+			unknown = append(unknown, field)
+		}
+	}
+	if len(unknown) > 0 {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "Unknown response fields: " + strings.Join(unknown, ", "),
+		})
+		return false
+	}
+	return true
+}
+
+// respondWithFields writes response as JSON or CBOR, trimmed to the requested
+// top-level fields when any were asked for. The fields must have passed
+// checkFields; one left out of the response because it is empty stays out.
+func respondWithFields(c *gin.Context, status int, response interface{}, fields FieldList) {
+	if len(fields) == 0 {
+		respond(c, status, response)
+		return
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		respond(c, http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to encode response",
+		})
+		return
+	}
+	var full map[string]json.RawMessage
+	if err := json.Unmarshal(data, &full); err != nil {
+		respond(c, status, response)
+		return
+	}
+
+	trimmed := make(map[string]json.RawMessage, len(fields))
+	for _, field := range fields {
+		if value, ok := full[field]; ok {
+			trimmed[field] = value
+		}
+	}
+	if c.NegotiateFormat(binding.MIMEJSON, mimeCBOR) == mimeCBOR {
+		// Raw JSON would go out as CBOR byte strings, so send the typed
+		// field values instead.
+		values := jsonFieldValues(response)
+		selected := make(map[string]interface{}, len(trimmed))
+		for field := range trimmed {
+			selected[field] = values[field]
+		}
+		respond(c, status, selected)
+		return
+	}
+	c.JSON(status, trimmed)
+}
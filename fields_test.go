@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/ugorji/go/codec"
+)
+
+func TestFieldListUnmarshal(t *testing.T) {
+	tests := []struct {
+		json    string
+		want    FieldList
+		wantErr bool
+	}{
+		{`"similarity"`, FieldList{"similarity"}, false},
+		{`" similarity, processed_at ,,"`, FieldList{"similarity", "processed_at"}, false},
+		{`["similarity", "sentence1"]`, FieldList{"similarity", "sentence1"}, false},
+		{`""`, nil, false},
+		{`3`, nil, true},
+		{`[1]`, nil, true},
+	}
+	for _, tt := range tests {
+		var got FieldList
+		err := json.Unmarshal([]byte(tt.json), &got)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.json, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: fields = %q, want %q", tt.json, got, tt.want)
+		}
+	}
+}
+
+func TestSimilarityResponseFields(t *testing.T) {
+	for _, field := range []string{"sentence1", "similarity", "processed_at", "audit", "warnings"} {
+		if !similarityResponseFields[field] {
+			t.Errorf("%s is not a selectable field", field)
+		}
+	}
+}
+
+func TestFieldSelection(t *testing.T) {
+	r := newRouter()
+	const pair = `"sentence1": "a b c", "sentence2": "b c d", "method": "jaccard"`
+	tests := []struct {
+		name, path, body string
+		wantCode         int
+		wantFields       []string
+	}{
+		{"no selection", "/api/v1/similarity", `{` + pair + `}`, http.StatusOK, []string{"method", "processed_at", "sentence1", "sentence2", "similarity"}},
+		{"body string", "/api/v1/similarity", `{` + pair + `, "fields": "similarity"}`, http.StatusOK, []string{"similarity"}},
+		{"body array", "/api/v1/similarity", `{` + pair + `, "fields": ["similarity", "sentence1"]}`, http.StatusOK, []string{"sentence1", "similarity"}},
+		{"query", "/api/v1/similarity?fields=processed_at", `{` + pair + `}`, http.StatusOK, []string{"processed_at"}},
+		{"query and body", "/api/v1/similarity?fields=sentence2", `{` + pair + `, "fields": "similarity"}`, http.StatusOK, []string{"sentence2", "similarity"}},
+		{"empty field left out", "/api/v1/similarity?fields=similarity,audit", `{` + pair + `}`, http.StatusOK, []string{"similarity"}},
+		{"unknown field", "/api/v1/similarity?fields=similarty", `{` + pair + `}`, http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		w := do(t, r, http.MethodPost, tt.path, tt.body)
+		if w.Code != tt.wantCode {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.wantCode, w.Body)
+			continue
+		}
+		if tt.wantFields == nil {
+			continue
+		}
+		var resp map[string]json.RawMessage
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var got []string
+		for field := range resp {
+			got = append(got, field)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.wantFields) {
+			t.Errorf("%s: fields %v, want %v", tt.name, got, tt.wantFields)
+		}
+	}
+}
+
+func TestFieldSelectionCBOR(t *testing.T) {
+	r := newRouter()
+	w := do(t, r, http.MethodPost, "/api/v1/similarity?fields=similarity,sentence1",
+		`{"sentence1": "a b c", "sentence2": "b c d", "method": "jaccard"}`, "Accept", mimeCBOR)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != mimeCBOR {
+		t.Fatalf("status %d, Content-Type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	var resp map[string]interface{}
+	if err := codec.NewDecoderBytes(w.Body.Bytes(), cborHandle).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	// The score must arrive as a number, not as the bytes of its JSON.
+	if len(resp) != 2 || resp["similarity"] != 0.5 || resp["sentence1"] != "a b c" {
+		t.Errorf("response = %#v, want similarity 0.5 and sentence1 only", resp)
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"text-similarity-api/similarity"
+)
+
+const (
+	defaultLSHBands             = 32
+	defaultNearDuplicateJaccard = 0.8
+	maxNumHashes                = 1024
+)
+
+type HashTextsInput struct {
+	Texts       []string `json:"texts" binding:"required,min=1"`
+	ShingleSize int      `json:"shingle_size"`
+	NumHashes   int      `json:"num_hashes"`
+}
+
+type SimHashResult struct {
+	Text    string `json:"text"`
+	SimHash string `json:"simhash"`
+}
+
+type MinHashResult struct {
+	Text      string   `json:"text"`
+	Signature []uint32 `json:"signature"`
+}
+
+type HashCompareInput struct {
+	Sentence1   string   `json:"sentence1"`
+	Sentence2   string   `json:"sentence2"`
+	SimHash1    string   `json:"simhash1"`
+	SimHash2    string   `json:"simhash2"`
+	MinHash1    []uint32 `json:"minhash1"`
+	MinHash2    []uint32 `json:"minhash2"`
+	ShingleSize int      `json:"shingle_size"`
+	NumHashes   int      `json:"num_hashes"`
+}
+
+type HashCompareResponse struct {
+	HammingDistance   *int     `json:"hamming_distance,omitempty"`
+	SimHashSimilarity *float64 `json:"simhash_similarity,omitempty"`
+	JaccardEstimate   *float64 `json:"jaccard_estimate,omitempty"`
+	ProcessedAt       string   `json:"processed_at"`
+}
+
+type NearDuplicateDocument struct {
+	ID   string `json:"id" binding:"required"`
+	Text string `json:"text" binding:"required"`
+}
+
+type NearDuplicateInput struct {
+	Documents []NearDuplicateDocument `json:"documents" binding:"required,min=2,dive"`
+	// Threshold is a pointer so that an explicit 0, which reports every
+	// candidate pair, is told apart from leaving it out.
+	Threshold   *float64 `json:"threshold"`
+	ShingleSize int      `json:"shingle_size"`
+	NumHashes   int      `json:"num_hashes"`
+	Bands       int      `json:"bands"`
+}
+
+type NearDuplicatePair struct {
+	ID1     string  `json:"id1"`
+	ID2     string  `json:"id2"`
+	Jaccard float64 `json:"jaccard"`
+}
+
+type NearDuplicateResponse struct {
+	Pairs       []NearDuplicatePair `json:"pairs"`
+	Groups      [][]string          `json:"groups"`
+	Candidates  int                 `json:"candidates"`
+	ProcessedAt string              `json:"processed_at"`
+}
+
+func hashParams(shingleSize, numHashes int) (int, int, error) {
+	if shingleSize == 0 {
+		shingleSize = similarity.DefaultShingleSize
+	}
+	if numHashes == 0 {
+		numHashes = similarity.DefaultNumHashes
+	}
+	if shingleSize < 1 {
+		return 0, 0, fmt.Errorf("shingle_size must be positive")
+	}
+	if numHashes < 1 || numHashes > maxNumHashes {
+		return 0, 0, fmt.Errorf("num_hashes must be between 1 and %d", maxNumHashes)
+	}
+	return shingleSize, numHashes, nil
+}
+
+// checkHashTexts responds with 400 and returns false if there are more
+// texts than the limit allows, or any is too long or has no words. Texts
+// without words would all hash alike and match each other. kind names the
+// texts in errors.
+func checkHashTexts(c *gin.Context, kind string, texts []string) bool {
+	lim := limitsFor(c)
+	if len(texts) > lim.MaxHashTexts {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: fmt.Sprintf("At most %d %ss are allowed per request, got %d", lim.MaxHashTexts, kind, len(texts)),
+		})
+		return false
+	}
+	if !checkSentenceLengths(c, lim, texts...) {
+		return false
+	}
+	for i, text := range texts {
+		if !similarity.HasWords(text) {
+			respond(c, http.StatusBadRequest, ErrorResponse{
+				Error:   "empty_sentences",
+				Message: fmt.Sprintf("Every %s must have words to compare; %s %d has none", kind, kind, i),
+			})
+			return false
+		}
+	}
+	return true
+}
+
+func handleSimHash(c *gin.Context) {
+	var input HashTextsInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "Invalid input format: " + err.Error(),
+		})
+		return
+	}
+	if !checkHashTexts(c, "text", input.Texts) {
+		return
+	}
+
+	results := make([]SimHashResult, len(input.Texts))
+	for i, text := range input.Texts {
+		results[i] = SimHashResult{
+			Text:    text,
+			SimHash: fmt.Sprintf("%016x", similarity.SimHash(text)),
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"results":      results,
+		"processed_at": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+func handleMinHash(c *gin.Context) {
+	var input HashTextsInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "Invalid input format: " + err.Error(),
+		})
+		return
+	}
+
+	shingleSize, numHashes, err := hashParams(input.ShingleSize, input.NumHashes)
+	if err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: err.Error(),
+		})
+		return
+	}
+	if !checkHashTexts(c, "text", input.Texts) {
+		return
+	}
+
+	results := make([]MinHashResult, len(input.Texts))
+	for i, text := range input.Texts {
+		results[i] = MinHashResult{
+			Text:      text,
+			Signature: similarity.MinHash(text, shingleSize, numHashes),
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"results":      results,
+		"shingle_size": shingleSize,
+		"num_hashes":   numHashes,
+		"processed_at": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+func handleHashCompare(c *gin.Context) {
+	var input HashCompareInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "Invalid input format: " + err.Error(),
+		})
+		return
+	}
+
+	shingleSize, numHashes, err := hashParams(input.ShingleSize, input.NumHashes)
+	if err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if !checkSentenceLengths(c, limitsFor(c), input.Sentence1, input.Sentence2) {
+		return
+	}
+
+	response := HashCompareResponse{}
+	hasTexts := similarity.HasWords(input.Sentence1) && similarity.HasWords(input.Sentence2)
+
+	var sim1, sim2 uint64
+	hasSimHash := false
+	switch {
+	case input.SimHash1 != "" || input.SimHash2 != "":
+		sim1, err = strconv.ParseUint(input.SimHash1, 16, 64)
+		if err == nil {
+			sim2, err = strconv.ParseUint(input.SimHash2, 16, 64)
+		}
+		if err != nil {
+			respond(c, http.StatusBadRequest, ErrorResponse{
+				Error:   "validation_error",
+				Message: "simhash1 and simhash2 must both be 64-bit hex strings",
+			})
+			return
+		}
+		hasSimHash = true
+	case hasTexts:
+		sim1, sim2 = similarity.SimHash(input.Sentence1), similarity.SimHash(input.Sentence2)
+		hasSimHash = true
+	}
+	if hasSimHash {
+		distance := similarity.HammingDistance(sim1, sim2)
+		simhashSimilarity := 1 - float64(distance)/64
+		response.HammingDistance = &distance
+		response.SimHashSimilarity = &simhashSimilarity
+	}
+
+	var min1, min2 []uint32
+	switch {
+	case len(input.MinHash1) > 0 || len(input.MinHash2) > 0:
+		min1, min2 = input.MinHash1, input.MinHash2
+	case hasTexts:
+		min1 = similarity.MinHash(input.Sentence1, shingleSize, numHashes)
+		min2 = similarity.MinHash(input.Sentence2, shingleSize, numHashes)
+	}
+	if min1 != nil || min2 != nil {
+		jaccard, err := similarity.JaccardEstimate(min1, min2)
+		if err != nil {
+			respond(c, http.StatusBadRequest, ErrorResponse{
+				Error:   "validation_error",
+				Message: err.Error(),
+			})
+			return
+		}
+		response.JaccardEstimate = &jaccard
+	}
+
+	if response.HammingDistance == nil && response.JaccardEstimate == nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "Provide sentence1/sentence2, simhash1/simhash2 or minhash1/minhash2",
+		})
+		return
+	}
+
+	response.ProcessedAt = time.Now().UTC().Format(time.RFC3339)
+	c.JSON(http.StatusOK, response)
+}
+
+func handleNearDuplicates(c *gin.Context) {
+	var input NearDuplicateInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "Invalid input format: " + err.Error(),
+		})
+		return
+	}
+
+	shingleSize, numHashes, err := hashParams(input.ShingleSize, input.NumHashes)
+	if err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: err.Error(),
+		})
+		return
+	}
+	bands := input.Bands
+	if bands == 0 {
+		bands = defaultLSHBands
+		if numHashes%bands != 0 {
+			bands = 1
+		}
+	}
+	if bands < 1 || numHashes%bands != 0 {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "bands must be a positive divisor of num_hashes",
+		})
+		return
+	}
+	threshold := defaultNearDuplicateJaccard
+	if input.Threshold != nil {
+		threshold = *input.Threshold
+	}
+	if threshold < 0 || threshold > 1 {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "threshold must be between 0.0 and 1.0",
+		})
+		return
+	}
+
+	texts := make([]string, len(input.Documents))
+	for i, doc := range input.Documents {
+		texts[i] = doc.Text
+	}
+	if !checkHashTexts(c, "document", texts) {
+		return
+	}
+	seen := make(map[string]bool, len(input.Documents))
+	for _, doc := range input.Documents {
+		if seen[doc.ID] {
+			respond(c, http.StatusBadRequest, ErrorResponse{
+				Error:   "validation_error",
+				Message: fmt.Sprintf("Document ID %q appears more than once", doc.ID),
+			})
+			return
+		}
+		seen[doc.ID] = true
+	}
+	duplicates, indexGroups, candidates := similarity.NearDuplicates(texts, threshold, shingleSize, numHashes, bands)
+
+	pairs := make([]NearDuplicatePair, len(duplicates))
+	for i, pair := range duplicates {
+		pairs[i] = NearDuplicatePair{
+			ID1:     input.Documents[pair.A].ID,
+			ID2:     input.Documents[pair.B].ID,
+			Jaccard: pair.Jaccard,
+		}
+	}
+	groups := make([][]string, len(indexGroups))
+	for i, group := range indexGroups {
+		for _, index := range group {
+			groups[i] = append(groups[i], input.Documents[index].ID)
+		}
+	}
+
+	c.JSON(http.StatusOK, NearDuplicateResponse{
+		Pairs:       pairs,
+		Groups:      groups,
+		Candidates:  candidates,
+		ProcessedAt: time.Now().UTC().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestNearDuplicatesThreshold(t *testing.T) {
+	r := newRouter()
+	documents := `"documents": [{"id": "a", "text": "a b c d e f g h"}, {"id": "b", "text": "a b c d e f g x"}]`
+	tests := []struct {
+		name      string
+		threshold string
+		wantCode  int
+		wantPairs int
+	}{
+		{"default", "", http.StatusOK, 0},
+		{"explicit zero", `, "threshold": 0`, http.StatusOK, 1},
+		{"explicit one", `, "threshold": 1`, http.StatusOK, 0},
+		{"negative", `, "threshold": -0.1`, http.StatusBadRequest, 0},
+		{"above one", `, "threshold": 1.5`, http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		w := do(t, r, http.MethodPost, "/api/v1/near-duplicates", "{"+documents+tt.threshold+"}")
+		if w.Code != tt.wantCode {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.wantCode, w.Body)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var resp NearDuplicateResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(resp.Pairs) != tt.wantPairs {
+			t.Errorf("%s: %d pairs, want %d", tt.name, len(resp.Pairs), tt.wantPairs)
+		}
+	}
+}
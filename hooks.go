@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultHookTimeout = 5 * time.Second
+	hookPolicyFail     = "fail"
+	hookPolicyIgnore   = "ignore"
+)
+
+// HookConfig runs a command or calls a URL before and after each backend
+// call. A command is split on spaces and run without a shell.
+type HookConfig struct {
+	Before  string
+	After   string
+	Timeout time.Duration
+	// Policy decides what a failed hook does: fail the backend call, or
+	// only be logged.
+	Policy string
+}
+
+// hooksFromEnv returns the configuration in HOOK_BEFORE, HOOK_AFTER,
+// HOOK_TIMEOUT and HOOK_FAILURE_POLICY, or nil when neither hook is set.
+func hooksFromEnv() (*HookConfig, error) {
+	config := &HookConfig{
+		Before:  strings.TrimSpace(os.Getenv("HOOK_BEFORE")),
+		After:   strings.TrimSpace(os.Getenv("HOOK_AFTER")),
+		Timeout: defaultHookTimeout,
+		Policy:  hookPolicyFail,
+	}
+	if raw := os.Getenv("HOOK_TIMEOUT"); raw != "" {
+		timeout, err := time.ParseDuration(raw)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("HOOK_TIMEOUT %q must be a positive duration such as 2s", raw)
+		}
+		config.Timeout = timeout
+	}
+	switch policy := os.Getenv("HOOK_FAILURE_POLICY"); policy {
+	case "":
+	case hookPolicyFail, hookPolicyIgnore:
+		config.Policy = policy
+	default:
+		return nil, fmt.Errorf("HOOK_FAILURE_POLICY %q must be fail or ignore", policy)
+	}
+	for name, hook := range map[string]string{"HOOK_BEFORE": config.Before, "HOOK_AFTER": config.After} {
+		if err := checkHook(hook); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	if config.Before == "" && config.After == "" {
+		return nil, nil
+	}
+	return config, nil
+}
+
+func isHookURL(hook string) bool {
+	return strings.HasPrefix(hook, "http://") || strings.HasPrefix(hook, "https://")
+}
+
+func checkHook(hook string) error {
+	switch {
+	case hook == "":
+		return nil
+	case isHookURL(hook):
+		if u, err := url.Parse(hook); err != nil || u.Host == "" {
+			return fmt.Errorf("%q is not a valid URL", hook)
+		}
+	default:
+		if _, err := exec.LookPath(strings.Fields(hook)[0]); err != nil {
+			return fmt.Errorf("%s not found", strings.Fields(hook)[0])
+		}
+	}
+	return nil
+}
+
+// commandHooks implements similarity.Hooks with HookConfig. It counts
+// failed hook runs, whatever the policy made of them.
+type commandHooks struct {
+	config   *HookConfig
+	client   *http.Client
+	failures atomic.Int64
+}
+
+// hookEvent is what a URL hook receives as JSON and a command hook as
+// HOOK_PHASE and HOOK_ERROR in its environment.
+type hookEvent struct {
+	Phase string `json:"phase"`
+	Error string `json:"error,omitempty"`
+}
+
+func newCommandHooks(config *HookConfig) *commandHooks {
+	return &commandHooks{config: config, client: &http.Client{}}
+}
+
+// backendHooks is nil when no hook is configured or the configuration is
+// invalid; startup validation refuses to start in the latter case.
+var backendHooks = func() *commandHooks {
+	config, err := hooksFromEnv()
+	if err != nil || config == nil {
+		return nil
+	}
+	return newCommandHooks(config)
+}()
+
+func (h *commandHooks) Before(ctx context.Context) error {
+	return h.run(ctx, h.config.Before, hookEvent{Phase: "before"})
+}
+
+func (h *commandHooks) After(ctx context.Context, callErr error) error {
+	event := hookEvent{Phase: "after"}
+	if callErr != nil {
+		event.Error = callErr.Error()
+	}
+	return h.run(ctx, h.config.After, event)
+}
+
+func (h *commandHooks) run(ctx context.Context, hook string, event hookEvent) error {
+	if hook == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, h.config.Timeout)
+	defer cancel()
+	var err error
+	if isHookURL(hook) {
+		err = h.post(ctx, hook, event)
+	} else {
+		err = h.exec(ctx, hook, event)
+	}
+	if err == nil {
+		return nil
+	}
+	h.failures.Add(1)
+	slog.Warn("Backend hook failed", "phase", event.Phase, "error", err)
+	if h.config.Policy == hookPolicyIgnore {
+		return nil
+	}
+	return fmt.Errorf("%s hook: %w", event.Phase, err)
+}
+
+func (h *commandHooks) exec(ctx context.Context, hook string, event hookEvent) error {
+	fields := strings.Fields(hook)
+	cmd := exec.CommandContext(ctx, fields[0], fields[1:]...)
+	cmd.Env = append(os.Environ(), "HOOK_PHASE="+event.Phase, "HOOK_ERROR="+event.Error)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("timed out after %s", h.config.Timeout)
+		}
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (h *commandHooks) post(ctx context.Context, hook string, event hookEvent) error {
+	body, _ := json.Marshal(event)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", hook, resp.Status)
+	}
+	return nil
+}
+
+func hookFailures() int64 {
+	if backendHooks == nil {
+		return 0
+	}
+	return backendHooks.failures.Load()
+}
+
+func hooksStatus() string {
+	if backendHooks == nil {
+		return "disabled"
+	}
+	return "enabled (" + backendHooks.config.Policy + ")"
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+
+	"text-similarity-api/similarity"
+)
+
+const (
+	defaultJobWorkers   = 2
+	defaultJobMaxQueued = 20
+	defaultJobRetention = time.Hour
+)
+
+// Job statuses. A completed job may still have failed pairs; a failed job
+// stopped before scoring them all.
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobCompleted = "completed"
+	jobFailed    = "failed"
+)
+
+type JobConfig struct {
+	// Workers is the number of jobs run at once. Each scores its pairs
+	// batchConcurrency at a time, like a batch request.
+	Workers   int
+	MaxQueued int
+	// Retention is how long a finished job and its results are kept.
+	Retention time.Duration
+}
+
+// jobsFromEnv returns JOB_WORKERS, JOB_MAX_QUEUED and JOB_RETENTION.
+func jobsFromEnv() (JobConfig, error) {
+	config := JobConfig{
+		Workers:   defaultJobWorkers,
+		MaxQueued: defaultJobMaxQueued,
+		Retention: defaultJobRetention,
+	}
+	for name, target := range map[string]*int{
+		"JOB_WORKERS":    &config.Workers,
+		"JOB_MAX_QUEUED": &config.MaxQueued,
+	} {
+		if raw := os.Getenv(name); raw != "" {
+			value, err := strconv.Atoi(raw)
+			if err != nil || value < 1 {
+				return JobConfig{}, fmt.Errorf("%s %q must be a positive integer", name, raw)
+			}
+			*target = value
+		}
+	}
+	if raw := os.Getenv("JOB_RETENTION"); raw != "" {
+		retention, err := time.ParseDuration(raw)
+		if err != nil || retention <= 0 {
+			return JobConfig{}, fmt.Errorf("JOB_RETENTION %q must be a positive duration such as 1h", raw)
+		}
+		config.Retention = retention
+	}
+	return config, nil
+}
+
+// Job is a batch of pairs scored in the background. Results are written
+// by the job's worker and read only once the job has finished.
+type Job struct {
+	ID          string
+	Total       int
+	input       BatchInput
+	callOptions similarity.CallOptions
+	// client is the submitter's clientKey and limits are its limits when
+	// the job was submitted.
+	client string
+	limits Limits
+
+	// Status and the times are guarded by the queue's lock.
+	Status     string
+	Error      string
+	CreatedAt  time.Time
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Results    []BatchResult
+
+	completed atomic.Int64
+	failed    atomic.Int64
+}
+
+// JobQueue runs jobs on a fixed pool of workers. Jobs wait in a bounded
+// queue; when it is full, new jobs are refused rather than held.
+type JobQueue struct {
+	config JobConfig
+	queue  chan *Job
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+}
+
+func NewJobQueue(config JobConfig) *JobQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &JobQueue{
+		config: config,
+		queue:  make(chan *Job, config.MaxQueued),
+		jobs:   make(map[string]*Job),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+var jobQueue = NewJobQueue(jobConfig())
+
+// jobConfig falls back to the defaults if the JOB_* settings are invalid;
+// startup validation refuses to start in that case.
+func jobConfig() JobConfig {
+	config, err := jobsFromEnv()
+	if err != nil {
+		return JobConfig{Workers: defaultJobWorkers, MaxQueued: defaultJobMaxQueued, Retention: defaultJobRetention}
+	}
+	return config
+}
+
+// Start launches the workers and the janitor that removes expired jobs.
+func (q *JobQueue) Start() {
+	for i := 0; i < q.config.Workers; i++ {
+		q.workers.Add(1)
+		go q.work()
+	}
+	go func() {
+		for range time.Tick(time.Minute) {
+			q.sweep()
+		}
+	}()
+}
+
+// Stop cancels the running jobs, which fail with the pairs they have not
+// scored, and waits for the workers to exit or ctx to end. Queued jobs are
+// not started and fail at once.
+func (q *JobQueue) Stop(ctx context.Context) error {
+	q.cancel()
+	for drained := false; !drained; {
+		select {
+		case job := <-q.queue:
+			q.abandon(job)
+		default:
+			drained = true
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *JobQueue) work() {
+	defer q.workers.Done()
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case job := <-q.queue:
+			if q.ctx.Err() != nil {
+				q.abandon(job)
+				return
+			}
+			q.run(job)
+		}
+	}
+}
+
+// abandon fails a queued job that will not be started.
+func (q *JobQueue) abandon(job *Job) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job.Status = jobFailed
+	job.Error = "the server shut down before the job started"
+	job.FinishedAt = time.Now()
+	job.input.Pairs = nil
+}
+
+// Submit queues a job, or returns false if the queue is full.
+// Errors from Submit.
+var (
+	errJobQueueFull = errors.New("job queue is full")
+	errTooManyJobs  = errors.New("client has too many jobs")
+)
+
+// Submit queues job. It fails with errTooManyJobs when the job's client
+// already has its max_concurrent_jobs queued or running, and with
+// errJobQueueFull when the queue is full.
+func (q *JobQueue) Submit(job *Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if max := job.limits.MaxConcurrentJobs; max > 0 && q.active(job.client) >= max {
+		return errTooManyJobs
+	}
+	select {
+	case q.queue <- job:
+		q.jobs[job.ID] = job
+		return nil
+	default:
+		return errJobQueueFull
+	}
+}
+
+// active counts client's jobs that have not finished. q.mu must be held.
+func (q *JobQueue) active(client string) int {
+	n := 0
+	for _, job := range q.jobs {
+		if job.client == client && (job.Status == jobQueued || job.Status == jobRunning) {
+			n++
+		}
+	}
+	return n
+}
+
+func (q *JobQueue) run(job *Job) {
+	q.mu.Lock()
+	job.Status = jobRunning
+	job.StartedAt = time.Now()
+	q.mu.Unlock()
+
+	lim := job.limits
+	ctx := similarity.WithCallOptions(q.ctx, job.callOptions)
+	results := make([]BatchResult, len(job.input.Pairs))
+	slots := make(chan struct{}, batchConcurrency)
+	var wg sync.WaitGroup
+	for i := range job.input.Pairs {
+		pair := &job.input.Pairs[i]
+		if q.ctx.Err() != nil {
+			results[i] = BatchResult{Index: i, Sentence1: pair.Sentence1, Sentence2: pair.Sentence2, Error: &ErrorResponse{
+				Error:     "shutting_down",
+				Message:   "The server shut down before this pair was scored",
+				Retryable: true,
+			}}
+			job.completed.Add(1)
+			job.failed.Add(1)
+			continue
+		}
+		invalid := validatePair(pair, lim)
+		results[i] = BatchResult{Index: i, Sentence1: pair.Sentence1, Sentence2: pair.Sentence2, Error: invalid}
+		if invalid != nil {
+			job.completed.Add(1)
+			job.failed.Add(1)
+			continue
+		}
+
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(result *BatchResult) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			defer job.completed.Add(1)
+			pairCtx, held := withHeldSpans(ctx)
+			defer held.end(result.Sentence1, result.Sentence2)
+			if err := scoreBatchPair(pairCtx, job.callOptions, job.input.Method, panicSiteJob, result); err != nil {
+				job.failed.Add(1)
+				if q.ctx.Err() != nil {
+					return
+				}
+				slog.Error("Error calling Python service", "job_id", job.ID, "error", logRedactor.Redact(err.Error(), result.Sentence1, result.Sentence2))
+				backendFailures.record(http.MethodPost, "/api/v1/jobs", err, result.Sentence1, result.Sentence2)
+			}
+		}(&results[i])
+	}
+	wg.Wait()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job.Results = results
+	job.FinishedAt = time.Now()
+	job.Status = jobCompleted
+	if q.ctx.Err() != nil {
+		job.Status = jobFailed
+		job.Error = "the server shut down before the job finished"
+	}
+	job.input.Pairs = nil
+}
+
+func (q *JobQueue) sweep() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for id, job := range q.jobs {
+		if !job.FinishedAt.IsZero() && time.Since(job.FinishedAt) > q.config.Retention {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+// Counts returns the number of jobs in each status.
+func (q *JobQueue) Counts() map[string]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	counts := map[string]int{jobQueued: 0, jobRunning: 0, jobCompleted: 0, jobFailed: 0}
+	for _, job := range q.jobs {
+		counts[job.Status]++
+	}
+	return counts
+}
+
+type JobResponse struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Completed counts the pairs scored or failed so far, out of Total,
+	// and Failed those of them that failed.
+	Total      int           `json:"total"`
+	Completed  int           `json:"completed"`
+	Failed     int           `json:"failed"`
+	Progress   float64       `json:"progress"`
+	CreatedAt  string        `json:"created_at"`
+	StartedAt  string        `json:"started_at,omitempty"`
+	FinishedAt string        `json:"finished_at,omitempty"`
+	ExpiresAt  string        `json:"expires_at,omitempty"`
+	Results    []BatchResult `json:"results,omitempty"`
+}
+
+// response describes job; results are included once it has finished.
+func (q *JobQueue) response(job *Job) JobResponse {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	response := JobResponse{
+		ID:        job.ID,
+		Status:    job.Status,
+		Error:     job.Error,
+		Total:     job.Total,
+		Completed: int(job.completed.Load()),
+		Failed:    int(job.failed.Load()),
+		CreatedAt: job.CreatedAt.UTC().Format(time.RFC3339),
+		Results:   job.Results,
+	}
+	if job.Total > 0 {
+		response.Progress = float64(response.Completed) / float64(job.Total)
+	}
+	if !job.StartedAt.IsZero() {
+		response.StartedAt = job.StartedAt.UTC().Format(time.RFC3339)
+	}
+	if !job.FinishedAt.IsZero() {
+		response.FinishedAt = job.FinishedAt.UTC().Format(time.RFC3339)
+		response.ExpiresAt = job.FinishedAt.Add(q.config.Retention).UTC().Format(time.RFC3339)
+	}
+	return response
+}
+
+func (q *JobQueue) Get(id string) (*Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	return job, ok
+}
+
+// handleSubmitJob queues a batch too large to score within one request
+// and returns its ID at once. The body is that of /api/v1/similarity/batch,
+// bounded by max_job_pairs instead of max_batch_pairs and by
+// max_job_characters in all.
+func handleSubmitJob(c *gin.Context) {
+	var input BatchInput
+	if err := bindInput(c, &input); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "Invalid input format: " + err.Error(),
+		})
+		return
+	}
+	lim := limitsFor(c)
+	callOptions, ok := validateBatch(c, &input, lim.MaxJobPairs)
+	if !ok {
+		return
+	}
+	characters := 0
+	for _, pair := range input.Pairs {
+		characters += utf8.RuneCountInString(pair.Sentence1) + utf8.RuneCountInString(pair.Sentence2)
+	}
+	if characters > lim.MaxJobCharacters {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: fmt.Sprintf("Jobs are limited to %d characters in all, got %d", lim.MaxJobCharacters, characters),
+		})
+		return
+	}
+	id, err := newSessionID()
+	if err != nil {
+		respond(c, http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to create job ID",
+		})
+		return
+	}
+
+	job := &Job{
+		ID:          id,
+		Total:       len(input.Pairs),
+		input:       input,
+		callOptions: callOptions,
+		client:      rateLimiter.clientKey(c),
+		limits:      lim,
+		Status:      jobQueued,
+		CreatedAt:   time.Now(),
+	}
+	switch err := jobQueue.Submit(job); err {
+	case errTooManyJobs:
+		respond(c, http.StatusTooManyRequests, ErrorResponse{
+			Error:   "too_many_jobs",
+			Message: fmt.Sprintf("At most %d of your jobs may be queued or running at once; retry once one has finished", lim.MaxConcurrentJobs),
+		})
+		return
+	case errJobQueueFull:
+		respond(c, http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "overloaded",
+			Message: fmt.Sprintf("%d jobs are already queued; retry once some have started", jobQueue.config.MaxQueued),
+		})
+		return
+	}
+	c.Header("Location", "/api/v1/jobs/"+id)
+	respond(c, http.StatusAccepted, jobQueue.response(job))
+}
+
+// handleGetJob reports a job's progress, and its results once it has
+// finished, until it expires.
+func handleGetJob(c *gin.Context) {
+	job, ok := jobQueue.Get(c.Param("id"))
+	if !ok {
+		respond(c, http.StatusNotFound, ErrorResponse{
+			Error:   "job_not_found",
+			Message: "Job does not exist or its results have expired",
+		})
+		return
+	}
+	respond(c, http.StatusOK, jobQueue.response(job))
+}
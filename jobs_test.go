@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testJob(id string, pairs ...SentencePair) *Job {
+	return &Job{ID: id, Total: len(pairs), input: BatchInput{Pairs: pairs, Method: "jaccard"}, limits: defaultLimits, Status: jobQueued, CreatedAt: time.Now()}
+}
+
+func TestJobQueueFull(t *testing.T) {
+	q := NewJobQueue(JobConfig{Workers: 1, MaxQueued: 2, Retention: time.Hour})
+	for i, want := range []error{nil, nil, errJobQueueFull} {
+		job := testJob(string(rune('a' + i)))
+		job.client = "ip:192.0.2." + strconv.Itoa(i)
+		if got := q.Submit(job); got != want {
+			t.Errorf("submit %d = %v, want %v", i, got, want)
+		}
+	}
+	if _, ok := q.Get("c"); ok {
+		t.Error("a refused job is listed")
+	}
+
+	saved := jobQueue
+	defer func() { jobQueue = saved }()
+	jobQueue = q
+	w := do(t, newRouter(), http.MethodPost, "/api/v1/jobs", `{"pairs": [{"sentence1": "a", "sentence2": "b"}], "method": "jaccard"}`)
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "overloaded") {
+		t.Errorf("submit to a full queue: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestJobQueueRuns(t *testing.T) {
+	q := NewJobQueue(JobConfig{Workers: 1, MaxQueued: 1, Retention: time.Hour})
+	q.Start()
+	defer q.Stop(context.Background())
+	job := testJob("a", SentencePair{Sentence1: "a b", Sentence2: "b c"}, SentencePair{Sentence1: "", Sentence2: "x"})
+	q.Submit(job)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if resp := q.response(job); resp.FinishedAt != "" {
+			if resp.Status != jobCompleted || resp.Completed != 2 || resp.Failed != 1 || len(resp.Results) != 2 {
+				t.Errorf("finished job = %+v, want completed with 2 pairs, 1 failed", resp)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the job did not finish")
+		}
+	}
+}
+
+func TestJobQueueStop(t *testing.T) {
+	q := NewJobQueue(JobConfig{Workers: 1, MaxQueued: 2, Retention: time.Hour})
+	queued := testJob("queued", SentencePair{Sentence1: "a", Sentence2: "b"})
+	q.Submit(queued)
+	if err := q.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if resp := q.response(queued); resp.Status != jobFailed || resp.FinishedAt == "" || resp.Error == "" {
+		t.Errorf("queued job after Stop = %+v, want failed", resp)
+	}
+
+	// A job that runs after the queue was cancelled fails every pair
+	// with a retryable error.
+	running := testJob("running", SentencePair{Sentence1: "a", Sentence2: "b"}, SentencePair{Sentence1: "c", Sentence2: "d"})
+	q.run(running)
+	resp := q.response(running)
+	if resp.Status != jobFailed || resp.Completed != 2 || resp.Failed != 2 {
+		t.Errorf("cancelled job = %+v, want failed with both pairs failed", resp)
+	}
+	for _, result := range resp.Results {
+		if result.Error == nil || result.Error.Error != "shutting_down" || !result.Error.Retryable {
+			t.Errorf("pair %d error = %+v, want a retryable shutting_down", result.Index, result.Error)
+		}
+	}
+}
+
+func TestJobQueueSweep(t *testing.T) {
+	q := NewJobQueue(JobConfig{Workers: 1, MaxQueued: 3, Retention: time.Hour})
+	expired, recent, unfinished := testJob("expired"), testJob("recent"), testJob("unfinished")
+	expired.FinishedAt = time.Now().Add(-2 * time.Hour)
+	recent.FinishedAt = time.Now().Add(-time.Minute)
+	for _, job := range []*Job{expired, recent, unfinished} {
+		q.Submit(job)
+	}
+	q.sweep()
+	for id, want := range map[string]bool{"expired": false, "recent": true, "unfinished": true} {
+		if _, ok := q.Get(id); ok != want {
+			t.Errorf("job %s kept = %v, want %v", id, ok, want)
+		}
+	}
+}
+
+func TestSubmitJobLimits(t *testing.T) {
+	saved, savedLimits := jobQueue, limits.Get()
+	defer func() { jobQueue = saved; limits.Set(savedLimits) }()
+	jobQueue = NewJobQueue(JobConfig{Workers: 1, MaxQueued: 10, Retention: time.Hour})
+	lim := defaultLimits
+	lim.MaxJobPairs, lim.MaxJobCharacters, lim.MaxRequestBytes = 2, 10, 200
+	limits.Set(lim)
+	r := newRouter()
+
+	tests := []struct {
+		name  string
+		pairs string
+		want  int
+	}{
+		{"within limits", `{"sentence1": "abc", "sentence2": "def"}`, http.StatusAccepted},
+		{"too many pairs", `{"sentence1": "a", "sentence2": "b"}, {"sentence1": "a", "sentence2": "b"}, {"sentence1": "a", "sentence2": "b"}`, http.StatusBadRequest},
+		{"too many characters", `{"sentence1": "abcdef", "sentence2": "ghijkl"}`, http.StatusBadRequest},
+		{"body too large", `{"sentence1": "` + strings.Repeat("a", 300) + `", "sentence2": "b"}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		w := do(t, r, http.MethodPost, "/api/v1/jobs", `{"method": "jaccard", "pairs": [`+tt.pairs+`]}`)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.want, w.Body)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/gin-gonic/gin"
+)
+
+func runningInLambda() bool {
+	return os.Getenv("AWS_LAMBDA_RUNTIME_API") != ""
+}
+
+// startLambda serves API Gateway events through the same router as the
+// HTTP server. Both REST API (payload 1.0) and HTTP API (payload 2.0)
+// events are accepted; the payload's version field tells them apart.
+func startLambda(r *gin.Engine) {
+	lambda.Start(func(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+		var probe struct {
+			Version string `json:"version"`
+		}
+		if err := json.Unmarshal(payload, &probe); err != nil {
+			return nil, err
+		}
+		if probe.Version == "2.0" {
+			var event events.APIGatewayV2HTTPRequest
+			if err := json.Unmarshal(payload, &event); err != nil {
+				return nil, err
+			}
+			return serveHTTPAPIEvent(ctx, r, event)
+		}
+		var event events.APIGatewayProxyRequest
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, err
+		}
+		return serveRESTAPIEvent(ctx, r, event)
+	})
+}
+
+func eventBody(body string, base64Encoded bool) (string, error) {
+	if !base64Encoded {
+		return body, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(body)
+	return string(decoded), err
+}
+
+// serveEvent runs one request through the router and returns the recorded
+// response, base64-encoding bodies that are not valid UTF-8.
+func serveEvent(req *http.Request, r *gin.Engine) (int, map[string]string, string, bool) {
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+
+	headers := make(map[string]string, len(recorder.Header()))
+	for name, values := range recorder.Header() {
+		headers[name] = strings.Join(values, ",")
+	}
+	body := recorder.Body.Bytes()
+	if utf8.Valid(body) {
+		return recorder.Code, headers, string(body), false
+	}
+	return recorder.Code, headers, base64.StdEncoding.EncodeToString(body), true
+}
+
+func serveRESTAPIEvent(ctx context.Context, r *gin.Engine, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	body, err := eventBody(event.Body, event.IsBase64Encoded)
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest}, nil
+	}
+	query := url.Values{}
+	for name, values := range event.MultiValueQueryStringParameters {
+		query[name] = values
+	}
+	for name, value := range event.QueryStringParameters {
+		if _, ok := query[name]; !ok {
+			query.Set(name, value)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, event.HTTPMethod, (&url.URL{Path: event.Path, RawQuery: query.Encode()}).String(), strings.NewReader(body))
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest}, nil
+	}
+	for name, value := range event.Headers {
+		req.Header.Set(name, value)
+	}
+	for name, values := range event.MultiValueHeaders {
+		req.Header[http.CanonicalHeaderKey(name)] = values
+	}
+	req.RemoteAddr = event.RequestContext.Identity.SourceIP + ":0"
+
+	status, headers, responseBody, encoded := serveEvent(req, r)
+	return events.APIGatewayProxyResponse{
+		StatusCode:      status,
+		Headers:         headers,
+		Body:            responseBody,
+		IsBase64Encoded: encoded,
+	}, nil
+}
+
+func serveHTTPAPIEvent(ctx context.Context, r *gin.Engine, event events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	body, err := eventBody(event.Body, event.IsBase64Encoded)
+	if err != nil {
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusBadRequest}, nil
+	}
+
+	target := event.RawPath
+	if event.RawQueryString != "" {
+		target += "?" + event.RawQueryString
+	}
+	req, err := http.NewRequestWithContext(ctx, event.RequestContext.HTTP.Method, target, strings.NewReader(body))
+	if err != nil {
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusBadRequest}, nil
+	}
+	for name, value := range event.Headers {
+		req.Header.Set(name, value)
+	}
+	if len(event.Cookies) > 0 {
+		req.Header.Set("Cookie", strings.Join(event.Cookies, "; "))
+	}
+	req.RemoteAddr = event.RequestContext.HTTP.SourceIP + ":0"
+
+	status, headers, responseBody, encoded := serveEvent(req, r)
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode:      status,
+		Headers:         headers,
+		Body:            responseBody,
+		IsBase64Encoded: encoded,
+	}, nil
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limits are the request size limits the API enforces. Operators change
+// them at runtime through /admin/limits, for everyone or for one API key,
+// and clients read their own from /api/v1/limits, so they can split work
+// before hitting a 400.
+type Limits struct {
+	// MaxSentenceLength is in characters and applies to every sentence or
+	// segment scored on its own.
+	MaxSentenceLength int `json:"max_sentence_length"`
+	// MaxMatrixCells bounds rows x columns of the score matrix behind the
+	// matrix, transcript, summary and novelty endpoints.
+	MaxMatrixCells        int `json:"max_matrix_cells"`
+	MaxTranscriptSegments int `json:"max_transcript_segments"`
+	MaxSessionSentences   int `json:"max_session_sentences"`
+	MaxExplainUnits       int `json:"max_explain_units"`
+	MaxSummarySentences   int `json:"max_summary_sentences"`
+	MaxSourceSentences    int `json:"max_source_sentences"`
+	MaxEntities           int `json:"max_entities"`
+	MaxBatchPairs         int `json:"max_batch_pairs"`
+	// MaxHashTexts bounds the texts of a SimHash or MinHash request and
+	// the documents of a near-duplicate request.
+	MaxHashTexts          int `json:"max_hash_texts"`
+	MaxEmbeddingSentences int `json:"max_embedding_sentences"`
+	// MaxCorpusDocuments bounds each search corpus; uploads are bounded
+	// by MaxEmbeddingSentences.
+	MaxCorpusDocuments int `json:"max_corpus_documents"`
+	// MaxJobPairs bounds each async job, which is not subject to
+	// MaxBatchPairs, and MaxJobCharacters the characters of all its
+	// sentences together.
+	MaxJobPairs      int `json:"max_job_pairs"`
+	MaxJobCharacters int `json:"max_job_characters"`
+	// MaxConcurrentJobs bounds the jobs one client has queued or running,
+	// telling clients apart as rate limiting does.
+	MaxConcurrentJobs int `json:"max_concurrent_jobs"`
+	// MaxRequestBytes bounds every request body, before it is decoded.
+	MaxRequestBytes int `json:"max_request_bytes"`
+}
+
+var defaultLimits = Limits{
+	MaxSentenceLength:     10000,
+	MaxMatrixCells:        400000,
+	MaxTranscriptSegments: 500,
+	MaxSessionSentences:   10000,
+	MaxExplainUnits:       128,
+	MaxSummarySentences:   200,
+	MaxSourceSentences:    2000,
+	MaxEntities:           100,
+	MaxBatchPairs:         1000,
+	MaxHashTexts:          1000,
+	MaxEmbeddingSentences: 1000,
+	MaxCorpusDocuments:    100000,
+	MaxJobPairs:           10000,
+	MaxJobCharacters:      2000000,
+	MaxConcurrentJobs:     5,
+	MaxRequestBytes:       16 << 20,
+}
+
+func (l Limits) validate() error {
+	for name, value := range map[string]int{
+		"max_sentence_length":     l.MaxSentenceLength,
+		"max_matrix_cells":        l.MaxMatrixCells,
+		"max_transcript_segments": l.MaxTranscriptSegments,
+		"max_session_sentences":   l.MaxSessionSentences,
+		"max_explain_units":       l.MaxExplainUnits,
+		"max_summary_sentences":   l.MaxSummarySentences,
+		"max_source_sentences":    l.MaxSourceSentences,
+		"max_entities":            l.MaxEntities,
+		"max_batch_pairs":         l.MaxBatchPairs,
+		"max_hash_texts":          l.MaxHashTexts,
+		"max_embedding_sentences": l.MaxEmbeddingSentences,
+		"max_corpus_documents":    l.MaxCorpusDocuments,
+		"max_job_pairs":           l.MaxJobPairs,
+		"max_job_characters":      l.MaxJobCharacters,
+		"max_concurrent_jobs":     l.MaxConcurrentJobs,
+		"max_request_bytes":       l.MaxRequestBytes,
+	} {
+		if value < 1 {
+			return fmt.Errorf("%s must be at least 1", name)
+		}
+	}
+	return nil
+}
+
+// LimitsStore holds the global limits and the overrides for single API
+// keys, keyed by apiKeyDigest.
+type LimitsStore struct {
+	mu        sync.RWMutex
+	limits    Limits
+	overrides map[string]Limits
+}
+
+var limits = &LimitsStore{limits: defaultLimits, overrides: make(map[string]Limits)}
+
+func (s *LimitsStore) Get() Limits {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.limits
+}
+
+func (s *LimitsStore) Set(l Limits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limits = l
+}
+
+// For returns the limits for the API key with digest, which are its
+// override if it has one and the global limits otherwise.
+func (s *LimitsStore) For(digest string) Limits {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if l, ok := s.overrides[digest]; ok {
+		return l
+	}
+	return s.limits
+}
+
+func (s *LimitsStore) SetOverride(digest string, l Limits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.overrides[digest] = l
+}
+
+// DeleteOverride reports whether digest had an override.
+func (s *LimitsStore) DeleteOverride(digest string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.overrides[digest]
+	delete(s.overrides, digest)
+	return ok
+}
+
+func (s *LimitsStore) Overrides() map[string]Limits {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	overrides := make(map[string]Limits, len(s.overrides))
+	for digest, l := range s.overrides {
+		overrides[digest] = l
+	}
+	return overrides
+}
+
+// limitsFor returns the limits that apply to the request: its API key's
+// override, if it carries a configured key that has one, and the global
+// limits otherwise.
+func limitsFor(c *gin.Context) Limits {
+	if digest, ok := rateLimiter.apiKey(c); ok {
+		return limits.For(digest)
+	}
+	return limits.Get()
+}
+
+// checkSentenceLengths responds with 400 and returns false if any text is
+// longer than the sentence length limit. The texts are noted for the slow
+// log, since every scoring handler passes its inputs through here.
+func checkSentenceLengths(c *gin.Context, l Limits, texts ...string) bool {
+	noteInputs(c, texts...)
+	for _, text := range texts {
+		if length := utf8.RuneCountInString(text); length > l.MaxSentenceLength {
+			respond(c, http.StatusBadRequest, ErrorResponse{
+				Error:   "validation_error",
+				Message: fmt.Sprintf("sentences are limited to %d characters, got one with %d", l.MaxSentenceLength, length),
+			})
+			return false
+		}
+	}
+	return true
+}
+
+// limitRequestBody reads the body up front, bounded by max_request_bytes,
+// and answers 413 if it is larger; handlers then decode it from memory.
+func limitRequestBody() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Body == nil || c.Request.Body == http.NoBody {
+			c.Next()
+			return
+		}
+		limit := int64(limitsFor(c).MaxRequestBytes)
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, limit))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respond(c, http.StatusRequestEntityTooLarge, ErrorResponse{
+				Error:   "request_too_large",
+				Message: fmt.Sprintf("Request bodies are limited to %d bytes", limit),
+			})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			respond(c, http.StatusBadRequest, ErrorResponse{
+				Error:   "validation_error",
+				Message: "Failed to read the request body",
+			})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Next()
+	}
+}
+
+func handleGetLimits(c *gin.Context) {
+	c.JSON(http.StatusOK, limitsFor(c))
+}
+
+func handleAdminGetLimits(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"limits":    limits.Get(),
+		"defaults":  defaultLimits,
+		"overrides": limits.Overrides(),
+	})
+}
+
+// bindLimits decodes the limits in the request body over base, so fields
+// left out keep base's values, and validates them. It responds with 400
+// and returns false if they are invalid.
+func bindLimits(c *gin.Context, base Limits) (Limits, bool) {
+	l := base
+	if err := c.ShouldBindJSON(&l); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "Invalid input format: " + err.Error(),
+		})
+		return Limits{}, false
+	}
+	if err := l.validate(); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: err.Error(),
+		})
+		return Limits{}, false
+	}
+	return l, true
+}
+
+// handlePutLimits replaces the limits; fields left out of the body revert
+// to their defaults.
+func handlePutLimits(c *gin.Context) {
+	l, ok := bindLimits(c, defaultLimits)
+	if !ok {
+		return
+	}
+	limits.Set(l)
+	handleAdminGetLimits(c)
+}
+
+func handleDeleteLimits(c *gin.Context) {
+	limits.Set(defaultLimits)
+	handleAdminGetLimits(c)
+}
+
+// keyDigestParam returns the :digest path parameter, the hex SHA-256 of
+// an API key. It responds with 400 and returns false if it is not one.
+func keyDigestParam(c *gin.Context) (string, bool) {
+	digest := strings.ToLower(c.Param("digest"))
+	if _, err := hex.DecodeString(digest); err != nil || len(digest) != 2*sha256.Size {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "The key must be given as the hex SHA-256 of the API key",
+		})
+		return "", false
+	}
+	return digest, true
+}
+
+// handlePutKeyLimits overrides the limits for one API key. An override is
+// a complete set of limits: fields left out of the body are copied from
+// the global limits as they are now.
+func handlePutKeyLimits(c *gin.Context) {
+	digest, ok := keyDigestParam(c)
+	if !ok {
+		return
+	}
+	l, ok := bindLimits(c, limits.Get())
+	if !ok {
+		return
+	}
+	limits.SetOverride(digest, l)
+	handleAdminGetLimits(c)
+}
+
+// handleDeleteKeyLimits puts an API key back on the global limits.
+func handleDeleteKeyLimits(c *gin.Context) {
+	digest, ok := keyDigestParam(c)
+	if !ok {
+		return
+	}
+	if !limits.DeleteOverride(digest) {
+		respond(c, http.StatusNotFound, ErrorResponse{
+			Error:   "override_not_found",
+			Message: "The key has no limits of its own",
+		})
+		return
+	}
+	handleAdminGetLimits(c)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAdminToken = "0123456789abcdef0123456789abcdef"
+
+// withKeyedLimits swaps in an empty limits store and a rate limiter that
+// knows alice-key and bob-key, with the admin endpoints mounted, until
+// the returned function is called.
+func withKeyedLimits(t *testing.T) (restore func()) {
+	t.Helper()
+	t.Setenv("RATE_LIMIT", "on")
+	t.Setenv("TRUSTED_PROXIES", "none")
+	t.Setenv("RATE_LIMIT_KEY_HEADER", "X-API-Key")
+	t.Setenv("RATE_LIMIT_KEYS", "alice-key,bob-key")
+	config, err := rateLimitFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedLimiter, savedLimits, savedToken := rateLimiter, limits, adminToken
+	rateLimiter = NewRateLimiter(config)
+	limits = &LimitsStore{limits: defaultLimits, overrides: make(map[string]Limits)}
+	adminToken = testAdminToken
+	return func() { rateLimiter, limits, adminToken = savedLimiter, savedLimits, savedToken }
+}
+
+func TestLimitsValidate(t *testing.T) {
+	if err := defaultLimits.validate(); err != nil {
+		t.Errorf("default limits: %v", err)
+	}
+	l := defaultLimits
+	l.MaxConcurrentJobs = 0
+	if err := l.validate(); err == nil || !strings.Contains(err.Error(), "max_concurrent_jobs") {
+		t.Errorf("error = %v, want max_concurrent_jobs rejected", err)
+	}
+}
+
+func TestLimitsStoreOverrides(t *testing.T) {
+	s := &LimitsStore{limits: defaultLimits, overrides: make(map[string]Limits)}
+	alice, bob := apiKeyDigest("alice-key"), apiKeyDigest("bob-key")
+	override := defaultLimits
+	override.MaxBatchPairs = 5000
+	s.SetOverride(alice, override)
+
+	global := defaultLimits
+	global.MaxBatchPairs = 10
+	s.Set(global)
+	if got := s.For(alice).MaxBatchPairs; got != 5000 {
+		t.Errorf("overridden key: max_batch_pairs = %d, want 5000", got)
+	}
+	if got := s.For(bob).MaxBatchPairs; got != 10 {
+		t.Errorf("other key: max_batch_pairs = %d, want the global 10", got)
+	}
+	if got := s.Overrides(); len(got) != 1 || got[alice].MaxBatchPairs != 5000 {
+		t.Errorf("overrides = %v", got)
+	}
+	if !s.DeleteOverride(alice) || s.DeleteOverride(alice) {
+		t.Error("DeleteOverride should report the override only the first time")
+	}
+	if got := s.For(alice).MaxBatchPairs; got != 10 {
+		t.Errorf("after delete: max_batch_pairs = %d, want the global 10", got)
+	}
+}
+
+func TestKeyLimitsEndpoints(t *testing.T) {
+	defer withKeyedLimits(t)()
+	r := newRouter()
+	alice := apiKeyDigest("alice-key")
+	auth := "Bearer " + testAdminToken
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		wantCode int
+	}{
+		{"override", http.MethodPut, "/admin/limits/keys/" + alice, `{"max_batch_pairs": 2, "max_concurrent_jobs": 1}`, http.StatusOK},
+		{"upper-case digest", http.MethodPut, "/admin/limits/keys/" + strings.ToUpper(alice), `{"max_batch_pairs": 2, "max_concurrent_jobs": 1}`, http.StatusOK},
+		{"raw key", http.MethodPut, "/admin/limits/keys/alice-key", `{}`, http.StatusBadRequest},
+		{"short digest", http.MethodPut, "/admin/limits/keys/" + alice[:16], `{}`, http.StatusBadRequest},
+		{"invalid limit", http.MethodPut, "/admin/limits/keys/" + alice, `{"max_batch_pairs": 0}`, http.StatusBadRequest},
+		{"delete unknown", http.MethodDelete, "/admin/limits/keys/" + apiKeyDigest("bob-key"), "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := do(t, r, tt.method, tt.path, tt.body, "Authorization", auth); w.Code != tt.wantCode {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.wantCode, w.Body)
+		}
+	}
+
+	// Fields left out of an override come from the global limits.
+	got := limits.For(alice)
+	if got.MaxBatchPairs != 2 || got.MaxConcurrentJobs != 1 || got.MaxSentenceLength != defaultLimits.MaxSentenceLength {
+		t.Errorf("override = %+v", got)
+	}
+
+	reads := []struct {
+		key       string
+		wantPairs int
+	}{
+		{"alice-key", 2},
+		{"bob-key", defaultLimits.MaxBatchPairs},
+		{"made-up", defaultLimits.MaxBatchPairs},
+		{"", defaultLimits.MaxBatchPairs},
+	}
+	for _, tt := range reads {
+		w := do(t, r, http.MethodGet, "/api/v1/limits", "", "X-API-Key", tt.key)
+		var l Limits
+		if err := json.Unmarshal(w.Body.Bytes(), &l); err != nil || l.MaxBatchPairs != tt.wantPairs {
+			t.Errorf("key %q: /api/v1/limits = %s, want max_batch_pairs %d", tt.key, w.Body, tt.wantPairs)
+		}
+	}
+
+	batch := `{"pairs": [{"sentence1": "a", "sentence2": "b"}, {"sentence1": "c", "sentence2": "d"}, {"sentence1": "e", "sentence2": "f"}], "method": "jaccard"}`
+	if w := do(t, r, http.MethodPost, "/api/v1/similarity/batch", batch, "X-API-Key", "alice-key"); w.Code != http.StatusBadRequest {
+		t.Errorf("batch over the key's limit: status %d, want 400", w.Code)
+	}
+	if w := do(t, r, http.MethodPost, "/api/v1/similarity/batch", batch, "X-API-Key", "bob-key"); w.Code != http.StatusOK {
+		t.Errorf("batch under the global limit: status %d, want 200: %s", w.Code, w.Body)
+	}
+
+	w := do(t, r, http.MethodGet, "/admin/limits", "", "Authorization", auth)
+	var report struct {
+		Overrides map[string]Limits `json:"overrides"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || report.Overrides[alice].MaxBatchPairs != 2 {
+		t.Errorf("/admin/limits = %s, want alice's override listed", w.Body)
+	}
+	if w := do(t, r, http.MethodDelete, "/admin/limits/keys/"+alice, "", "Authorization", auth); w.Code != http.StatusOK {
+		t.Errorf("delete: status %d, want 200", w.Code)
+	}
+	if got := limits.For(alice).MaxBatchPairs; got != defaultLimits.MaxBatchPairs {
+		t.Errorf("after delete: max_batch_pairs = %d, want the global limit", got)
+	}
+}
+
+func TestMaxConcurrentJobs(t *testing.T) {
+	q := NewJobQueue(JobConfig{Workers: 1, MaxQueued: 10, Retention: time.Hour})
+	lim := defaultLimits
+	lim.MaxConcurrentJobs = 2
+	submit := func(id, client string) error {
+		job := testJob(id)
+		job.client, job.limits = client, lim
+		return q.Submit(job)
+	}
+	tests := []struct {
+		id, client string
+		want       error
+	}{
+		{"a1", "key:alice", nil},
+		{"a2", "key:alice", nil},
+		{"a3", "key:alice", errTooManyJobs},
+		{"b1", "key:bob", nil},
+	}
+	for _, tt := range tests {
+		if got := submit(tt.id, tt.client); got != tt.want {
+			t.Errorf("submit %s for %s = %v, want %v", tt.id, tt.client, got, tt.want)
+		}
+	}
+
+	// A finished job no longer counts.
+	a1, _ := q.Get("a1")
+	q.mu.Lock()
+	a1.Status = jobCompleted
+	q.mu.Unlock()
+	if err := submit("a4", "key:alice"); err != nil {
+		t.Errorf("submit after a job finished = %v, want nil", err)
+	}
+}
+
+func TestMaxConcurrentJobsEndpoint(t *testing.T) {
+	defer withKeyedLimits(t)()
+	saved := jobQueue
+	defer func() { jobQueue = saved }()
+	jobQueue = NewJobQueue(JobConfig{Workers: 1, MaxQueued: 10, Retention: time.Hour})
+	r := newRouter()
+	override := defaultLimits
+	override.MaxConcurrentJobs = 1
+	limits.SetOverride(apiKeyDigest("alice-key"), override)
+
+	job := `{"pairs": [{"sentence1": "a", "sentence2": "b"}], "method": "jaccard"}`
+	tests := []struct {
+		key      string
+		wantCode int
+	}{
+		{"alice-key", http.StatusAccepted},
+		{"alice-key", http.StatusTooManyRequests},
+		{"bob-key", http.StatusAccepted},
+	}
+	for i, tt := range tests {
+		w := do(t, r, http.MethodPost, "/api/v1/jobs", job, "X-API-Key", tt.key)
+		if w.Code != tt.wantCode {
+			t.Errorf("job %d for %s: status %d, want %d: %s", i, tt.key, w.Code, tt.wantCode, w.Body)
+		}
+		if tt.wantCode == http.StatusTooManyRequests && !strings.Contains(w.Body.String(), "too_many_jobs") {
+			t.Errorf("job %d: body %s, want too_many_jobs", i, w.Body)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"text-similarity-api/similarity"
+)
+
+// Log formats, selected with LOG_FORMAT.
+const (
+	logFormatJSON = "json"
+	logFormatText = "text"
+)
+
+// backendTimingsKey holds the request's *similarity.Timings, set by the
+// slow log middleware, so the request log can split latency.
+const backendTimingsKey = "backend_timings"
+
+// LogConfig selects how the server and request logs are written.
+type LogConfig struct {
+	// Format is json, one object per line, or text, key=value pairs that
+	// are easier to read during local development.
+	Format string
+	Level  slog.Level
+}
+
+// logConfigFromEnv reads LOG_FORMAT (default json) and LOG_LEVEL (debug,
+// info, warn or error; default info).
+func logConfigFromEnv() (LogConfig, error) {
+	config := LogConfig{Format: logFormatJSON, Level: slog.LevelInfo}
+	switch format := os.Getenv("LOG_FORMAT"); format {
+	case "":
+	case logFormatJSON, logFormatText:
+		config.Format = format
+	default:
+		return config, fmt.Errorf("LOG_FORMAT %q must be json or text", format)
+	}
+	if raw := os.Getenv("LOG_LEVEL"); raw != "" {
+		if err := config.Level.UnmarshalText([]byte(raw)); err != nil {
+			return config, fmt.Errorf("LOG_LEVEL %q must be one of debug, info, warn, error", raw)
+		}
+	}
+	return config, nil
+}
+
+// logConfig falls back to JSON at info level if the environment is
+// invalid; startup validation refuses to start in that case.
+var logConfig = func() LogConfig {
+	config, err := logConfigFromEnv()
+	if err != nil {
+		return LogConfig{Format: logFormatJSON, Level: slog.LevelInfo}
+	}
+	return config
+}()
+
+// setupLogging sends structured logs to w. Output of the standard log
+// package, including the similarity library's, becomes info records.
+func setupLogging(w io.Writer) {
+	options := &slog.HandlerOptions{Level: logConfig.Level}
+	var handler slog.Handler = slog.NewJSONHandler(w, options)
+	if logConfig.Format == logFormatText {
+		handler = slog.NewTextHandler(w, options)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// requestLogger logs one record per request: errors for 5xx responses,
+// warnings for 4xx and info otherwise. Paths and errors go through the
+// log redactor.
+func requestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		ctx := c.Request.Context()
+		if !slog.Default().Enabled(ctx, level) {
+			return
+		}
+		attrs := []slog.Attr{
+			slog.String("request_id", requestID(c)),
+			slog.String("method", c.Request.Method),
+			slog.String("path", logRedactor.Redact(c.Request.URL.Path)),
+			slog.String("route", c.FullPath()),
+			slog.Int("status", status),
+			slog.Float64("latency_ms", milliseconds(time.Since(start))),
+			slog.String("client_ip", c.ClientIP()),
+			slog.String("user_agent", c.Request.UserAgent()),
+		}
+		if value, ok := c.Get(backendTimingsKey); ok {
+			if timings := value.(*similarity.Timings).Snapshot(); timings.BackendCalls > 0 {
+				attrs = append(attrs,
+					slog.Float64("backend_ms", milliseconds(timings.Backend)),
+					slog.Float64("queue_ms", milliseconds(timings.Queue)),
+					slog.Int("backend_calls", timings.BackendCalls),
+				)
+			}
+		}
+		if errs := c.Errors.ByType(gin.ErrorTypePrivate).String(); errs != "" {
+			attrs = append(attrs, slog.String("error", logRedactor.Redact(strings.TrimSpace(errs))))
+		}
+		slog.LogAttrs(ctx, level, "request", attrs...)
+	}
+}
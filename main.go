@@ -1,238 +1,835 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
 	"strings"
 	"time"
+
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
+
+	"text-similarity-api/similarity"
 )
 
 type SentenceInput struct {
-	Sentence1 string `json:"sentence1" binding:"required" validate:"min=1"`
-	Sentence2 string `json:"sentence2" binding:"required" validate:"min=1"`
+	Sentence1    string    `json:"sentence1" binding:"required" validate:"min=1"`
+	Sentence2    string    `json:"sentence2" binding:"required" validate:"min=1"`
+	Fields       FieldList `json:"fields,omitempty"`
+	Audit        bool      `json:"audit,omitempty"`
+	Mode         string    `json:"mode,omitempty"`
+	Templates    []string  `json:"templates,omitempty"`
+	Pooling      string    `json:"pooling,omitempty"`
+	Normalize    bool      `json:"normalize,omitempty"`
+	MaxSeqLength int       `json:"max_seq_length,omitempty"`
+	Entities     []string  `json:"entities,omitempty"`
+	Estimate     bool      `json:"estimate,omitempty"`
+	Method       string    `json:"method,omitempty"`
+	Persist      bool      `json:"persist,omitempty"`
 }
 
 type SimilarityResponse struct {
-	Sentence1  string  `json:"sentence1"`
-	Sentence2  string  `json:"sentence2"`
-	Similarity float64 `json:"similarity"`
-	ProcessedAt string `json:"processed_at"`
+	Sentence1    string                   `json:"sentence1"`
+	Sentence2    string                   `json:"sentence2"`
+	Similarity   float64                  `json:"similarity"`
+	ProcessedAt  string                   `json:"processed_at"`
+	Audit        *similarity.AuditBundle  `json:"audit,omitempty"`
+	Warnings     []ResponseWarning        `json:"warnings,omitempty"`
+	TemplateDiff *similarity.TemplateDiff `json:"template_diff,omitempty"`
+	// Markup holds the text mode markup scored.
+	Markup *MarkupText `json:"markup,omitempty"`
+	// Prefiltered marks an estimated score decided without the model.
+	Prefiltered bool `json:"prefiltered,omitempty"`
+	// Coalesced marks a score shared with an identical concurrent request.
+	Coalesced bool `json:"coalesced,omitempty"`
+	// Estimate bounds the model's score when it was skipped on request.
+	Estimate *similarity.Bounds `json:"estimate,omitempty"`
+	// Method names the lexical method that produced the score, if the
+	// model did not; Fallback is set when that was because the backend
+	// failed.
+	Method   string `json:"method,omitempty"`
+	Fallback bool   `json:"fallback,omitempty"`
+	// Cached is set when the score came from the score cache.
+	Cached bool `json:"cached,omitempty"`
+	// Result is the share link of a result stored on request.
+	Result *PersistedResult `json:"result,omitempty"`
+}
+
+// MarkupText is what is left of each sentence once Markdown and HTML are
+// stripped.
+type MarkupText struct {
+	Text1 string `json:"text1"`
+	Text2 string `json:"text2"`
 }
 
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Error   string `json:"error"`
 	Message string `json:"message"`
+	// RequestID identifies the request in logs and traces; quote it when
+	// reporting a problem.
+	RequestID string `json:"request_id,omitempty"`
+	// Retryable tells clients whether repeating the same request may
+	// succeed; if so, Retry-After says how many seconds to wait first.
+	Retryable bool `json:"retryable"`
 }
 
-type PythonRequest struct {
-	Sentence1 string `json:"sentence1"`
-	Sentence2 string `json:"sentence2"`
-}
+const serviceVersion = "2.0.0"
 
-type PythonResponse struct {
-	Similarity float64 `json:"similarity"`
-	Error string `json:"error, omitempty"`
-}
+// modeTemplateDiff scores only the content that differs between the two
+// sentences once shared boilerplate and request templates are removed.
+const modeTemplateDiff = "template_diff"
+
+// modeMarkup scores the text of Markdown or HTML documents without their
+// markup.
+const modeMarkup = "markup"
 
-var validate *validator.validate
+var validate *validator.Validate
+
+var (
+	pythonBackend = newPythonBackend()
+	scorer        = similarity.New(scorerOptions()...)
+	// calibration learns estimate bounds from the scores the model returns.
+	calibration = similarity.NewCalibration()
+)
 
 func init() {
 	validate = validator.New()
 }
 
 func main() {
+	checkConfig := flag.Bool("check-config", false, "validate configuration and backend connectivity, then exit")
+	flag.Parse()
+
+	if *checkConfig {
+		os.Exit(runCheckConfig(os.Stdout))
+	}
+	captureLogs()
+
+	if checks := validateConfig(); !checksPassed(checks) {
+		writeConfigReport(os.Stderr, checks)
+		log.Fatal("Invalid configuration, refusing to start")
+	}
+
 	if os.Getenv("GIN_MODE") == "" {
 		gin.SetMode(gin.ReleaseMode)
 	}
-	r := gin.Default()
+	r := newRouter()
 
-	r.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type")
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
-		c.Next()
-	})
+	if err := attachCorpusDatabase(context.Background()); err != nil {
+		log.Fatal("Failed to open the corpus database: ", err)
+	}
 
-	r.Use(gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
-		return fmt.Sprintf("%s - [%s] \"%s %s %s %d %s \"%s\" %s\"\n",
-			param.ClientIP,
-			param.TimeStamp.Format(time.RFC1123),
-			param.Method,
-			param.Path,
-			param.Request.Proto,
-			param.StatusCode,
-			param.Latency,
-			param.Request.UserAgent(),
-			param.ErrorMessage,
-		)
-	}))
-
-	r.use(gin.Recovery())
+	sessionStore.StartJanitor()
+	resultStore.StartJanitor()
+	rateLimiter.StartJanitor()
+	deferrals.StartJanitor()
+	jobQueue.Start()
+	if prober != nil {
+		prober.Start()
+	}
+	logStartupBanner()
+
+	if runningInLambda() {
+		log.Printf("Running as an AWS Lambda handler")
+		startLambda(r)
+		return
+	}
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	log.Printf("Starting Text Similarity API server on port %s", port)
+	log.Printf("Endpoints available:")
+	log.Printf("  GET  /           - API information")
+	log.Printf("  GET  /health     - Health check")
+	log.Printf("  GET  /version    - Build and backend versions")
+	log.Printf("  GET  /docs       - API documentation")
+	if adminToken != "" {
+		log.Printf("  GET  /admin/stats.json - Rolling request statistics")
+		log.Printf("  PUT  /admin/faults - Configure fault injection")
+		log.Printf("  POST /admin/selftest - Run the internal self-test suite")
+		log.Printf("  GET  /admin/slo  - SLO compliance and error budgets")
+		log.Printf("  GET  /admin/backend/failures - Backend crash, OOM and timeout diagnostics")
+		log.Printf("  GET  /admin/support-bundle - Diagnostics archive for bug reports")
+		log.Printf("  GET  /admin/slowlog - Slowest requests with input fingerprints")
+		log.Printf("  GET  /admin/cache - Score cache statistics")
+		log.Printf("  DELETE /admin/results/:id - Revoke a shared result")
+		log.Printf("  GET  /admin/usage - Requests per client today")
+		log.Printf("  PUT  /admin/limits - Change request size limits")
+		log.Printf("  PUT  /admin/limits/keys/:digest - Override the limits for one API key")
+		log.Printf("  GET  /admin/config - Effective configuration, secrets redacted")
+		log.Printf("  GET  /admin/probe - Synthetic probe results")
+	} else {
+		log.Printf("  /admin endpoints are disabled; set ADMIN_TOKEN to enable them")
+	}
+	log.Printf("  GET  /api/v1/limits - Your current request size limits")
+	log.Printf("  GET  /api/v1/models/*name - Model license and card metadata")
+	log.Printf("  GET  /api/v1/results/:id - Shared result from a signed link")
+	log.Printf("  GET  /api/v1/usage - Your requests today and rate limits")
+	log.Printf("  GET  /api/v1/deferred/:token - Redeem a deferred request")
+	log.Printf("  POST /api/v1/similarity - Calculate similarity")
+	log.Printf("  POST /api/v1/similarity/batch - Score many pairs at once")
+	log.Printf("  POST /api/v1/jobs - Queue a large batch as an async job")
+	log.Printf("  GET  /api/v1/jobs/:id - Job progress and results")
+	log.Printf("  POST /api/v1/similarity/matrix - N x M similarity matrix")
+	log.Printf("  POST /api/v1/embeddings - Raw embedding vectors")
+	log.Printf("  POST /api/v1/embeddings/project - 2D/3D projection with clusters")
+	log.Printf("  POST /api/v1/hash/simhash - SimHash fingerprints")
+	log.Printf("  POST /api/v1/hash/minhash - MinHash signatures")
+	log.Printf("  POST /api/v1/hash/compare - Hamming/Jaccard comparison")
+	log.Printf("  POST /api/v1/near-duplicates - LSH near-duplicate detection")
+	log.Printf("  POST /api/v1/similarity/transcripts - Transcript segment alignment")
+	log.Printf("  POST /api/v1/similarity/explain - Counterfactual token importance")
+	log.Printf("  POST /api/v1/similarity/summary - Summary faithfulness coverage")
+	log.Printf("  POST /api/v1/corpus - Add documents to a search corpus")
+	log.Printf("  PUT  /api/v1/corpus/:name/documents/:id - Add or replace one corpus document")
+	log.Printf("  POST /api/v1/search - Top-K corpus documents for a query")
+	log.Printf("  POST /api/v1/sessions - Open a comparison session")
+	log.Printf("  POST /api/v1/sessions/:id/query - Query a session")
+	log.Printf("  POST /api/v1/sessions/:id/suggestions - Similar previous queries")
+	log.Printf("  POST /api/v1/sessions/:id/novelty - Novelty against the session")
+
+	if err := serve(r, ":"+port); err != nil {
+		log.Fatal("Failed to start server:", err)
+	}
+}
+
+// newRouter builds the engine with every middleware and route, shared
+// by the HTTP server and the Lambda adapter.
+func newRouter() *gin.Engine {
+	r := gin.New()
+	// gin trusts X-Forwarded-For from every peer unless told otherwise,
+	// and a nil list tells it to trust none.
+	proxies, err := trustedProxiesFromEnv()
+	if err == nil {
+		err = r.SetTrustedProxies(proxies)
+	}
+	if err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES: ", err)
+	}
+
+	r.Use(cors(corsOrigins))
+
+	r.Use(requestTracing())
+
+	r.Use(requestLogger())
+
+	r.Use(gin.CustomRecoveryWithWriter(io.Discard, recoverPanic))
+	r.NoRoute(handleNoRoute)
+	r.Use(statsMiddleware(requestStats))
+	r.Use(sloMiddleware(sloTracker))
+	r.Use(limitRequestBody())
 
 	r.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H {
-			"status": "healthy",
+		c.JSON(http.StatusOK, gin.H{
+			"status":    "healthy",
 			"timestamp": time.Now().UTC().Format(time.RFC3339),
-			"service": "text-similarity-api",
+			"service":   "text-similarity-api",
 		})
 	})
 
+	r.GET("/version", handleVersion)
+
 	r.GET("/", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H {
+		c.JSON(http.StatusOK, gin.H{
 			"message": "Welcome to the Text Similarity API (Go + Python)",
-			"version": "2.0.0",
-			"endpoints": map[string]string {
-				"similarity": "POST /api/v1/similarity",
-				"health" : "GET /health",
-				"docs" : "GET /docs",
+			"version": serviceVersion,
+			"endpoints": map[string]string{
+				"similarity":          "POST /api/v1/similarity",
+				"similarity_batch":    "POST /api/v1/similarity/batch",
+				"jobs":                "POST /api/v1/jobs",
+				"similarity_matrix":   "POST /api/v1/similarity/matrix",
+				"embeddings":          "POST /api/v1/embeddings",
+				"embeddings_project":  "POST /api/v1/embeddings/project",
+				"simhash":             "POST /api/v1/hash/simhash",
+				"minhash":             "POST /api/v1/hash/minhash",
+				"hash_compare":        "POST /api/v1/hash/compare",
+				"near_duplicates":     "POST /api/v1/near-duplicates",
+				"transcripts":         "POST /api/v1/similarity/transcripts",
+				"explain":             "POST /api/v1/similarity/explain",
+				"summary":             "POST /api/v1/similarity/summary",
+				"corpus":              "POST /api/v1/corpus",
+				"search":              "POST /api/v1/search",
+				"sessions":            "POST /api/v1/sessions",
+				"session_query":       "POST /api/v1/sessions/:id/query",
+				"session_suggestions": "POST /api/v1/sessions/:id/suggestions",
+				"session_novelty":     "POST /api/v1/sessions/:id/novelty",
+				"limits":              "GET /api/v1/limits",
+				"model_card":          "GET /api/v1/models/*name",
+				"result":              "GET /api/v1/results/:id",
+				"usage":               "GET /api/v1/usage",
+				"deferred":            "GET /api/v1/deferred/:token",
+				"health":              "GET /health",
+				"version":             "GET /version",
+				"stats":               "GET /admin/stats.json",
+				"docs":                "GET /docs",
 			},
 		})
 	})
 
 	r.GET("/docs", func(c *gin.Context) {
-		docs := map[string]interface{} {
-			"title" : "Text Similarity API",
-			"description" : "An API to compute semantic similarity between sentences using Go & Python",
-			"version" : "1.0.0",
-			"endpoints": map[string]interface{} {
-				"/api/v1/similarity": map[string]interface{} {
-					"method": "POST",
+		docs := map[string]interface{}{
+			"title":       "Text Similarity API",
+			"description": "An API to compute semantic similarity between sentences using Go & Python",
+			"version":     "1.0.0",
+			"endpoints": map[string]interface{}{
+				"/api/v1/similarity": map[string]interface{}{
+					"method":      "POST",
 					"description": "Calculate semantic similarity between two sentences",
-					"request_body": map[string]interface{} {
-						"sentence1": "string (required) - First sentence to compare",
-						"sentence2": "string (required) - Second sentence to compare",
-					},
-					"response": map[string]interface{} {
-						"sentence1": "string - Echo of first sentence",
-						"sentence2": "string - Echo of second sentence",
-						"similarity": "float - Similarity score (0.0 to 1.0)",
+					"request_body": map[string]interface{}{
+						"sentence1":      "string (required) - First sentence to compare",
+						"sentence2":      "string (required) - Second sentence to compare",
+						"fields":         "string or array (optional) - Response fields to return, also accepted as ?fields=similarity,processed_at",
+						"mode":           "string (optional) - \"template_diff\" scores only the content left after stripping shared boilerplate; \"markup\" scores the text of Markdown or HTML, keeping link text and image alt text",
+						"templates":      "array of strings (optional) - Templates to strip in template_diff mode, {{name}} marks a placeholder",
+						"pooling":        "string (optional) - Pool token embeddings with \"mean\", \"cls\" or \"max\" instead of the model's own pooling",
+						"normalize":      "bool (optional) - Scale embeddings to unit length; cosine scores are unchanged",
+						"max_seq_length": "int (optional) - Truncate inputs to this many tokens, at most the model's limit",
+						"entities":       "array of strings (optional) - Product names or codes the native backend must match verbatim (case-sensitive, not split on punctuation)",
+						"audit":          "bool (optional) - Include a reproducibility bundle (model hash, library versions, preprocessing, truncation, embedding checksums)",
+						"estimate":       "bool (optional) - Skip the model and return lexical bounds on its score with a confidence",
+						"method":         "string (optional) - \"tfidf\", \"jaccard\" or \"levenshtein\" scores in pure Go without the model; default \"model\"",
+						"persist":        "bool (optional) - Store the result and return a signed, expiring share link; needs RESULT_RETENTION",
+					},
+					"response": map[string]interface{}{
+						"sentence1":    "string - Echo of first sentence",
+						"sentence2":    "string - Echo of second sentence",
+						"similarity":   "float - Similarity score (0.0 to 1.0)",
 						"processed_at": "string - ISO timestamp of processing",
+						"warnings":     "array (optional) - low_information_input warnings for URL, emoji, numeric or boilerplate inputs",
+						"prefiltered":  "bool (optional) - true when the score is a cheap estimate and the model was skipped (PREFILTER=on)",
+						"coalesced":    "bool (optional) - true when the score was shared with an identical request computed at the same time",
+						"estimate":     "object (optional) - lower, upper, confidence and samples of the bounds when estimate was requested",
+						"method":       "string (optional) - Lexical method that produced the score when the model did not",
+						"fallback":     "bool (optional) - true when the backend failed and FALLBACK_METHOD produced the score",
+						"cached":       "bool (optional) - present and true only when the score came from the score cache (in memory or Redis)",
+						"result":       "object (optional) - id, url and expires_at of the stored result when persist was requested",
 					},
-					"example_request": map[string]string {
+					"example_request": map[string]string{
 						"sentence1": "AI is transforming the world.",
 						"sentence2": "Artificial intelligence is changing society.",
 					},
 				},
+				"/version": map[string]interface{}{
+					"method":      "GET",
+					"description": "Build version, commit, build date, Go version, enabled features and the backend's model and library versions",
+				},
+				"/api/v1/hash/simhash": map[string]interface{}{
+					"method":      "POST",
+					"description": "Compute 64-bit SimHash fingerprints natively in Go",
+					"request_body": map[string]interface{}{
+						"texts": "array of strings (required) - Texts to fingerprint, at most max_hash_texts",
+					},
+				},
+				"/api/v1/hash/minhash": map[string]interface{}{
+					"method":      "POST",
+					"description": "Compute MinHash signatures over word shingles",
+					"request_body": map[string]interface{}{
+						"texts":        "array of strings (required) - Texts to sign, at most max_hash_texts",
+						"shingle_size": "int (optional, default 3) - Words per shingle",
+						"num_hashes":   "int (optional, default 128) - Signature length",
+					},
+				},
+				"/api/v1/hash/compare": map[string]interface{}{
+					"method":      "POST",
+					"description": "Hamming distance between SimHashes and Jaccard estimate between MinHash signatures",
+					"request_body": map[string]interface{}{
+						"sentence1": "string - First text (or use simhash1/minhash1)",
+						"sentence2": "string - Second text (or use simhash2/minhash2)",
+						"simhash1":  "string - Precomputed hex SimHash",
+						"simhash2":  "string - Precomputed hex SimHash",
+						"minhash1":  "array of uint32 - Precomputed MinHash signature",
+						"minhash2":  "array of uint32 - Precomputed MinHash signature",
+					},
+				},
+				"/api/v1/near-duplicates": map[string]interface{}{
+					"method":      "POST",
+					"description": "LSH-based near-duplicate detection over MinHash signatures",
+					"request_body": map[string]interface{}{
+						"documents":  "array of {id, text} (required) - Documents to deduplicate, with unique IDs, at most max_hash_texts",
+						"threshold":  "float (optional, default 0.8) - Minimum estimated Jaccard, 0.0 to 1.0; 0 reports every candidate pair",
+						"num_hashes": "int (optional, default 128) - Signature length",
+						"bands":      "int (optional, default 32) - LSH bands, must divide num_hashes",
+					},
+				},
+				"/api/v1/similarity/batch": map[string]interface{}{
+					"method":      "POST",
+					"description": "Score many sentence pairs in one request, with per-pair errors",
+					"request_body": map[string]interface{}{
+						"pairs":          "array (required) - [{sentence1, sentence2}], at most max_batch_pairs",
+						"pooling":        "string (optional) - Pooling strategy applied to every pair",
+						"normalize":      "bool (optional) - As for /api/v1/similarity",
+						"max_seq_length": "int (optional) - As for /api/v1/similarity",
+						"entities":       "array of strings (optional) - Entities applied to every pair",
+						"method":         "string (optional) - Scoring method applied to every pair",
+					},
+					"response": map[string]interface{}{
+						"results":   "array - {index, sentence1, sentence2, and similarity or error} per pair, in request order",
+						"succeeded": "int - Pairs scored",
+						"failed":    "int - Pairs with an error",
+					},
+				},
+				"/api/v1/similarity/matrix": map[string]interface{}{
+					"method":      "POST",
+					"description": "Score every sentence of one list against every sentence of another, embedding each sentence once",
+					"request_body": map[string]interface{}{
+						"sentences1":     "array of strings (required) - Rows of the matrix",
+						"sentences2":     "array of strings (required) - Columns of the matrix",
+						"pooling":        "string (optional) - Pooling strategy for both lists",
+						"normalize":      "bool (optional) - As for /api/v1/similarity",
+						"max_seq_length": "int (optional) - As for /api/v1/similarity",
+						"entities":       "array of strings (optional) - Entities for the native backend",
+					},
+					"response": map[string]interface{}{
+						"matrix":  "array of arrays - matrix[i][j] is the similarity of sentences1[i] and sentences2[j]",
+						"rows":    "int - Length of sentences1",
+						"columns": "int - Length of sentences2",
+					},
+				},
+				"/api/v1/embeddings": map[string]interface{}{
+					"method":      "POST",
+					"description": "Raw embedding vectors for a list of sentences",
+					"request_body": map[string]interface{}{
+						"sentences":      "array of strings (required) - Sentences to embed, at most max_embedding_sentences",
+						"pooling":        "string (optional) - Pooling strategy, as for /api/v1/similarity",
+						"normalize":      "bool (optional) - Return unit-length vectors",
+						"max_seq_length": "int (optional) - As for /api/v1/similarity",
+						"entities":       "array of strings (optional) - Entities for the native backend",
+					},
+					"response": map[string]interface{}{
+						"embeddings": "array of arrays - One vector per sentence, in request order",
+						"dimension":  "int - Length of each vector",
+					},
+				},
+				"/api/v1/embeddings/project": map[string]interface{}{
+					"method":      "POST",
+					"description": "Project sentences or embeddings to 2D or 3D with PCA and label k-means clusters",
+					"request_body": map[string]interface{}{
+						"sentences":  "array of strings - Sentences to embed and project (or embeddings)",
+						"embeddings": "array of arrays - Precomputed vectors to project (or sentences)",
+						"dimensions": "int (optional, default 2) - 2 or 3",
+						"method":     "string (optional, default \"pca\") - Projection method",
+						"clusters":   "int (optional) - Number of k-means clusters, default about sqrt(n/2)",
+					},
+					"response": map[string]interface{}{
+						"points":             "array - {index, sentence, coordinates, cluster} per input",
+						"explained_variance": "array - Share of variance captured by each output dimension",
+						"clusters":           "int - Number of clusters used",
+					},
+				},
+				"/api/v1/similarity/transcripts": map[string]interface{}{
+					"method":      "POST",
+					"description": "Segment-level similarity alignment between two timestamped transcripts",
+					"request_body": map[string]interface{}{
+						"transcript1": "object (required) - {segments: [{start, end, speaker, text}]}",
+						"transcript2": "object (required) - {segments: [{start, end, speaker, text}]}",
+						"threshold":   "float (optional, default 0.75) - Minimum similarity for a segment match",
+					},
+					"response": map[string]interface{}{
+						"alignment":       "array - Order-preserving segment matches",
+						"repeated_points": "array - Best match in transcript2 for each segment of transcript1",
+						"coverage1":       "float - Fraction of transcript1 segments with a match",
+						"coverage2":       "float - Fraction of transcript2 segments with a match",
+					},
+				},
+				"/api/v1/similarity/explain": map[string]interface{}{
+					"method":      "POST",
+					"description": "Counterfactual explanation: rank the tokens or phrases of one sentence by how much removing each changes the score",
+					"request_body": map[string]interface{}{
+						"sentence1": "string (required) - First sentence",
+						"sentence2": "string (required) - Second sentence",
+						"target":    "string (optional, default sentence1) - Sentence to perturb",
+						"unit":      "string (optional, default token) - Remove one \"token\" or \"phrase\" at a time",
+						"spans":     "bool (optional) - Also return the most similar passages of the two sentences with character offsets",
+						"persist":   "bool (optional) - Store the explanation and return a signed, expiring share link; needs RESULT_RETENTION",
+					},
+					"response": map[string]interface{}{
+						"similarity": "float - Score of the unmodified pair",
+						"removals":   "array - {index, text, score, delta} per unit, largest absolute delta first; positive delta means the unit supports the match",
+						"spans":      "array (optional) - {span1, span2, similarity}, each span {start, end, text} with code point offsets",
+						"result":     "object (optional) - id, url and expires_at of the stored explanation when persist was requested",
+					},
+				},
+				"/api/v1/similarity/summary": map[string]interface{}{
+					"method":      "POST",
+					"description": "Faithfulness-style coverage of a summary by its source documents",
+					"request_body": map[string]interface{}{
+						"summary":   "string (required) - Summary to check",
+						"sources":   "array of strings (required) - Source documents",
+						"threshold": "float (optional, default 0.6) - Minimum similarity for a summary sentence to count as supported",
+					},
+					"response": map[string]interface{}{
+						"faithfulness":    "float - Fraction of summary sentences supported by a source sentence",
+						"mean_support":    "float - Mean best source similarity over summary sentences",
+						"source_coverage": "float - Fraction of source sentences that support the summary",
+						"sentences":       "array - Per summary sentence: support score, supported flag and best source sentence",
+					},
+				},
+				"/api/v1/corpus": map[string]interface{}{
+					"method":      "POST",
+					"description": "Embed documents and add them to a named corpus stored server-side; a document with an existing ID replaces it. GET lists corpora, GET and DELETE /api/v1/corpus/:name read or remove one. GET /api/v1/corpus/:name/documents pages through documents; GET, PUT and DELETE /api/v1/corpus/:name/documents/:id read, add or replace, and remove one",
+					"request_body": map[string]interface{}{
+						"corpus":    "string (optional, default \"default\") - Corpus name",
+						"documents": "array (optional) - Documents, each {\"id\", \"text\", \"metadata\"}; id and metadata are optional",
+						"sentences": "array of strings (optional) - Documents without IDs or metadata",
+					},
+				},
+				"/api/v1/search": map[string]interface{}{
+					"method":      "POST",
+					"description": "Rank a corpus's documents against a query sentence",
+					"request_body": map[string]interface{}{
+						"corpus":         "string (optional, default \"default\") - Corpus name",
+						"query":          "string (required) - Query sentence",
+						"top_k":          "int (optional, default 10) - Number of matches to return, up to 1000",
+						"min_similarity": "float (optional) - Leave out documents scoring below this",
+					},
+					"response": map[string]interface{}{
+						"matches":  "array - Documents with id, text, metadata and similarity, best first",
+						"searched": "int - Documents compared",
+					},
+				},
+				"/api/v1/sessions": map[string]interface{}{
+					"method":      "POST",
+					"description": "Open a session with a context set of sentences embedded once and cached server-side",
+					"request_body": map[string]interface{}{
+						"sentences":   "array of strings (required) - Context sentences",
+						"ttl_seconds": "int (optional, default 900) - Idle expiry",
+						"pooling":     "string (optional) - Pooling strategy for the session's sentences and queries (mean, cls, max)",
+					},
+				},
+				"/api/v1/sessions/:id/query": map[string]interface{}{
+					"method":      "POST",
+					"description": "Rank the session's context sentences against a query sentence",
+					"request_body": map[string]interface{}{
+						"sentence": "string (required) - Query sentence",
+						"top_k":    "int (optional, default 5) - Number of matches to return",
+					},
+				},
+				"/api/v1/sessions/:id/suggestions": map[string]interface{}{
+					"method":      "POST",
+					"description": "Rank the session's previous queries against a new sentence, with the best match each one got",
+					"request_body": map[string]interface{}{
+						"sentence": "string (required) - New sentence",
+						"top_k":    "int (optional, default 5) - Number of previous queries to return",
+					},
+				},
+				"/api/v1/sessions/:id/novelty": map[string]interface{}{
+					"method":      "POST",
+					"description": "Score how novel each sentence is against the session's sentences: 1 minus the similarity to the nearest one",
+					"request_body": map[string]interface{}{
+						"sentences": "array of strings (required) - Sentences to score",
+					},
+				},
+				"/api/v1/jobs": map[string]interface{}{
+					"method":      "POST",
+					"description": "Queue a batch too large for one request and return 202 with its job ID at once; poll GET /api/v1/jobs/:id for progress, and for the results once finished",
+					"request_body": map[string]interface{}{
+						"pairs":          "array (required) - [{sentence1, sentence2}], at most max_job_pairs and max_job_characters in all",
+						"pooling":        "string (optional) - As for /api/v1/similarity/batch",
+						"normalize":      "bool (optional) - As for /api/v1/similarity/batch",
+						"max_seq_length": "int (optional) - As for /api/v1/similarity/batch",
+						"entities":       "array of strings (optional) - As for /api/v1/similarity/batch",
+						"method":         "string (optional) - As for /api/v1/similarity/batch",
+					},
+					"response": map[string]interface{}{
+						"id":         "string - Job ID",
+						"status":     "string - queued, running, completed or failed",
+						"total":      "int - Pairs in the job",
+						"completed":  "int - Pairs scored or failed so far",
+						"failed":     "int - Completed pairs that failed",
+						"progress":   "float - completed / total",
+						"expires_at": "string - When a finished job's results are removed",
+						"results":    "array - Per pair, as for /api/v1/similarity/batch, once the job has finished",
+					},
+				},
+				"/api/v1/deferred/:token": map[string]interface{}{
+					"method":      "GET",
+					"description": "Result of a scoring request that was deferred with 202 because the server was at capacity (DEFERRAL=on and Prefer: respond-async); 202 again while it is still pending",
+				},
+				"/api/v1/limits": map[string]interface{}{
+					"method":      "GET",
+					"description": "The calling client's request size limits, which are its API key's override if it has one; requests over any of them are rejected with 400, and jobs past max_concurrent_jobs with 429",
+				},
+				"/api/v1/usage": map[string]interface{}{
+					"method":      "GET",
+					"description": "The calling client's requests today, its daily quota and remaining requests, and the rate limit; over the limit, other /api/v1 requests get 429 with Retry-After",
+				},
+				"/api/v1/results/:id": map[string]interface{}{
+					"method":      "GET",
+					"description": "Shared result stored with persist; needs the expires and signature query parameters of its link. HTML, or JSON with Accept: application/json",
+				},
+				"/api/v1/models/*name": map[string]interface{}{
+					"method":      "GET",
+					"description": "Model card for a model name such as sentence-transformers/all-MiniLM-L6-v2: license, languages, intended use, dimension, max tokens and eval scores",
+				},
 			},
 		}
 		c.JSON(http.StatusOK, docs)
 	})
 
-	v1 := r.Group("/api/v1") {
-		v1.POST("/similarity", handleSimilarity)
+	v1 := r.Group("/api/v1")
+	v1.Use(rateLimiter.Middleware())
+	v1.Use(slowLog.Middleware())
+	v1.Use(sizeStats.Middleware())
+	v1.Use(faultInjector.Middleware())
+	{
+		v1.POST("/similarity", deferrals.Middleware(), handleSimilarity)
+		v1.POST("/similarity/batch", deferrals.Middleware(), handleSimilarityBatch)
+		v1.POST("/jobs", handleSubmitJob)
+		v1.GET("/jobs/:id", handleGetJob)
+		v1.POST("/similarity/matrix", deferrals.Middleware(), handleSimilarityMatrix)
+		v1.POST("/embeddings", deferrals.Middleware(), handleEmbeddings)
+		v1.POST("/embeddings/project", deferrals.Middleware(), handleProjectEmbeddings)
+		v1.POST("/hash/simhash", handleSimHash)
+		v1.POST("/hash/minhash", handleMinHash)
+		v1.POST("/hash/compare", handleHashCompare)
+		v1.POST("/near-duplicates", handleNearDuplicates)
+		v1.POST("/similarity/transcripts", deferrals.Middleware(), handleTranscriptSimilarity)
+		v1.POST("/similarity/explain", deferrals.Middleware(), handleExplainSimilarity)
+		v1.POST("/similarity/summary", deferrals.Middleware(), handleSummarySimilarity)
+		v1.POST("/corpus", handleUploadCorpus)
+		v1.GET("/corpus", handleListCorpora)
+		v1.GET("/corpus/:name", handleGetCorpus)
+		v1.DELETE("/corpus/:name", handleDeleteCorpus)
+		v1.GET("/corpus/:name/documents", handleListDocuments)
+		v1.GET("/corpus/:name/documents/:id", handleGetDocument)
+		v1.PUT("/corpus/:name/documents/:id", handlePutDocument)
+		v1.DELETE("/corpus/:name/documents/:id", handleDeleteDocument)
+		v1.POST("/search", handleSearch)
+		v1.POST("/sessions", handleCreateSession)
+		v1.GET("/sessions/:id", handleGetSession)
+		v1.DELETE("/sessions/:id", handleDeleteSession)
+		v1.POST("/sessions/:id/query", handleQuerySession)
+		v1.POST("/sessions/:id/suggestions", handleSessionSuggestions)
+		v1.POST("/sessions/:id/novelty", handleSessionNovelty)
+		v1.GET("/limits", handleGetLimits)
+		v1.GET("/models/*name", handleModelCard)
+		v1.GET("/results/:id", handleGetResult)
+		v1.GET("/usage", handleUsage)
+		v1.GET("/deferred/:token", handleRedeemDeferral)
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	// Without ADMIN_TOKEN the admin endpoints are not mounted, so they
+	// cannot be reached by accident.
+	if adminToken != "" {
+		admin := r.Group("/admin", requireAdminToken(adminToken))
+		admin.GET("/stats.json", handleStatsJSON)
+		admin.GET("/faults", handleGetFaults)
+		admin.PUT("/faults", handlePutFaults)
+		admin.DELETE("/faults", handleDeleteFaults)
+		admin.POST("/selftest", handleSelfTest)
+		admin.GET("/slo", handleSLO)
+		admin.GET("/backend/failures", handleBackendFailures)
+		admin.GET("/support-bundle", handleSupportBundle)
+		admin.GET("/slowlog", handleSlowLog)
+		admin.GET("/cache", handleGetScoreCache)
+		admin.DELETE("/cache", handleDeleteScoreCache)
+		admin.DELETE("/results/:id", handleDeleteResult)
+		admin.GET("/usage", handleAdminUsage)
+		admin.GET("/limits", handleAdminGetLimits)
+		admin.PUT("/limits", handlePutLimits)
+		admin.GET("/config", handleAdminConfig)
+		admin.GET("/probe", handleProbe)
+		admin.POST("/probe", handleProbe)
+		admin.DELETE("/limits", handleDeleteLimits)
+		admin.PUT("/limits/keys/:digest", handlePutKeyLimits)
+		admin.DELETE("/limits/keys/:digest", handleDeleteKeyLimits)
 	}
 
-	log.Printf("Starting Text Similarity API server on port %s", port)
-	log.Printf("Endpoints available:")
-	log.Printf("  GET  /           - API information")
-	log.Printf("  GET  /health     - Health check")
-	log.Printf("  GET  /docs       - API documentation")
-	log.Printf("  POST /api/v1/similarity - Calculate similarity")
+	deferrals.handler = r
 
-	if err := r.Run(":" + port); err != nil {
-		log.Fatal("Failed to start server:", err)
-	}
+	return r
 }
 
 func handleSimilarity(c *gin.Context) {
 	var input SentenceInput
 
-	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse {
-			Error: "validation_error",
+	if err := bindInput(c, &input); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
 			Message: "Invalid input format: " + err.Error(),
 		})
 		return
 	}
 
 	if err := validate.Struct(input); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse {
-			Error: "validation_error",
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
 			Message: "Validation failed: " + err.Error(),
 		})
 		return
 	}
 
+	fields := requestedFields(c, input.Fields)
+	if !checkFields(c, fields, similarityResponseFields) {
+		return
+	}
+
 	input.Sentence1 = strings.TrimSpace(input.Sentence1)
 	input.Sentence2 = strings.TrimSpace(input.Sentence2)
 
 	if len(input.Sentence1) == 0 || len(input.Sentence2) == 0 {
-		c.JSON(http.StatusBadRequest, ErrorResponse {
-			Error: "empty_sentences",
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "empty_sentences",
 			Message: "Both sentences must be non-empty",
 		})
-		return 
+		return
 	}
 
-	similarity, err := callPythonService(input)
-	if err != nil {
-		log.Printf("Error calling Python service: %v", err)
-		c.JSON(http.StatusInternalServerError, ErrorResponse {
-			Error: "internal_error",
-			Message: "Failed to process similarity calculation",
-		})
+	if !checkSentenceLengths(c, limitsFor(c), input.Sentence1, input.Sentence2) {
 		return
 	}
 
-	response := SimilarityResponse {
-		Sentence1: input.Sentence1,
-		Sentence2: input.Sentence2,
-		Similarity: similarity,
-		ProcessedAt: time.Now().UTC().Format(time.RFC3339),
+	var warnings []ResponseWarning
+	if policy := inputQualityPolicy(); policy != qualityPolicyScore {
+		warnings = assessInputs(map[string]string{
+			"sentence1": input.Sentence1,
+			"sentence2": input.Sentence2,
+		}, "sentence1", "sentence2")
+		if len(warnings) > 0 && policy == qualityPolicyReject {
+			respond(c, http.StatusUnprocessableEntity, ErrorResponse{
+				Error:   "low_information_input",
+				Message: fmt.Sprintf("%s is a low-information input (%s) and cannot be scored meaningfully", warnings[0].Field, warnings[0].Reason),
+			})
+			return
+		}
 	}
-	c.JSON(http.StatusOK, response)
-}
 
-func callPythonService(input SentenceInput) (float64, error) {
-	pythonReq := PythonRequest {
-		Sentence1: input.Sentence1,
-		Sentence2: input.Sentence2,
+	var score float64
+	var audit *similarity.AuditBundle
+	var templateDiff *similarity.TemplateDiff
+	var markup *MarkupText
+	var prefiltered, coalesced bool
+	var bounds *similarity.Bounds
+	var scored pairScore
+	var err error
+	if input.Estimate && (input.Audit || input.Mode != "" || input.Persist) {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "estimate is not supported with audit, mode or persist",
+		})
+		return
 	}
-
-	reqData, err := json.Marshal(pythonReq)
-	if err != nil {
-		return 0, fmt.Errorf("Failed to Marshal request: %w", err)
+	if err := validateMethod(input.Method); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: err.Error(),
+		})
+		return
 	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 30 * time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "python3", "app/similarity_service.py")
-	cmd.Stdin = bytes.NewReader(reqData)
-
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		return 0, fmt.Errorf("python script failed: %w, stderr: %s", err, stderr.String())
+	if input.Method != "" && input.Method != methodModel && (input.Audit || input.Mode != "" || input.Estimate) {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "a lexical method is not supported with audit, mode or estimate",
+		})
+		return
+	}
+	switch input.Mode {
+	case "":
+	case modeTemplateDiff, modeMarkup:
+		if input.Audit {
+			respond(c, http.StatusBadRequest, ErrorResponse{
+				Error:   "validation_error",
+				Message: fmt.Sprintf("audit is not supported with mode %s", input.Mode),
+			})
+			return
+		}
+	default:
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: fmt.Sprintf("Unknown mode %q", input.Mode),
+		})
+		return
 	}
 
-	var pythonResp PythonResponse
-	if err := json.Unmarshal(stdout.Bytes(), &pythonResp); err != nil {
-		return 0, fmt.Errorf("failed to parse python response: %w", err)
+	if max := limitsFor(c).MaxEntities; len(input.Entities) > max {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: fmt.Sprintf("At most %d entities are allowed", max),
+		})
+		return
+	}
+	callOptions := similarity.CallOptions{Pooling: input.Pooling, Normalize: input.Normalize, MaxSeqLength: input.MaxSeqLength, Entities: input.Entities}
+	if err := validateCallOptions(c.Request.Context(), callOptions); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: err.Error(),
+		})
+		return
+	}
+	ctx := similarity.WithCallOptions(c.Request.Context(), callOptions)
+
+	if input.Mode == modeTemplateDiff {
+		var diff similarity.TemplateDiff
+		score, diff, err = scorer.ScoreTemplateDiff(ctx, input.Sentence1, input.Sentence2, input.Templates)
+		templateDiff = &diff
+	} else if input.Mode == modeMarkup {
+		// Like template_diff, two documents with no text left are
+		// identical and one with no text matches nothing.
+		markup = &MarkupText{Text1: similarity.StripMarkup(input.Sentence1), Text2: similarity.StripMarkup(input.Sentence2)}
+		switch {
+		case markup.Text1 == "" && markup.Text2 == "":
+			score = 1
+		case markup.Text1 == "" || markup.Text2 == "":
+			score = 0
+		default:
+			scored, err = scorePair(ctx, callOptions, input.Method, markup.Text1, markup.Text2)
+			score, prefiltered, coalesced = scored.Score, scored.Prefiltered, scored.Coalesced
+		}
+	} else if input.Audit {
+		score, audit, err = scorer.ScoreWithAudit(ctx, input.Sentence1, input.Sentence2)
+		if audit != nil {
+			audit.ServiceVersion = serviceVersion
+		}
+	} else if input.Estimate {
+		estimate := calibration.Bounds(input.Sentence1, input.Sentence2)
+		score, bounds = (estimate.Lower+estimate.Upper)/2, &estimate
+	} else {
+		scored, err = scorePair(ctx, callOptions, input.Method, input.Sentence1, input.Sentence2)
+		score, prefiltered, coalesced = scored.Score, scored.Prefiltered, scored.Coalesced
+	}
+	if err != nil {
+		logBackendError(c, err, input.Sentence1, input.Sentence2)
+		respond(c, http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to process similarity calculation",
+		})
+		return
 	}
 
-	if pythonResp.Error != "" {
-		return 0, fmt.Errorf("python service error: %s", pythonResp.Error)
+	response := SimilarityResponse{
+		Sentence1:    input.Sentence1,
+		Sentence2:    input.Sentence2,
+		Similarity:   score,
+		ProcessedAt:  time.Now().UTC().Format(time.RFC3339),
+		Audit:        audit,
+		Warnings:     warnings,
+		TemplateDiff: templateDiff,
+		Markup:       markup,
+		Prefiltered:  prefiltered,
+		Coalesced:    coalesced,
+		Estimate:     bounds,
+		Method:       scored.Method,
+		Fallback:     scored.Fallback,
+		Cached:       scored.Cached,
+	}
+	if input.Persist {
+		var ok bool
+		if response.Result, ok = persistResult(c, StoredResult{
+			Sentence1:  input.Sentence1,
+			Sentence2:  input.Sentence2,
+			Similarity: score,
+			Method:     scored.Method,
+		}); !ok {
+			return
+		}
 	}
-	
-	return pythonResp.Similarity, nil
+	respondWithFields(c, http.StatusOK, response, fields)
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"text-similarity-api/similarity"
+)
+
+type MatrixInput struct {
+	Sentences1   []string `json:"sentences1" binding:"required,min=1"`
+	Sentences2   []string `json:"sentences2" binding:"required,min=1"`
+	Pooling      string   `json:"pooling,omitempty"`
+	Normalize    bool     `json:"normalize,omitempty"`
+	MaxSeqLength int      `json:"max_seq_length,omitempty"`
+	Entities     []string `json:"entities,omitempty"`
+}
+
+type MatrixResponse struct {
+	// Matrix has a row per sentence of sentences1 and a column per
+	// sentence of sentences2.
+	Matrix      [][]float64 `json:"matrix"`
+	Rows        int         `json:"rows"`
+	Columns     int         `json:"columns"`
+	ProcessedAt string      `json:"processed_at"`
+}
+
+// handleSimilarityMatrix scores every sentence of one list against every
+// sentence of the other. The backend embeds each sentence once, so the
+// cost grows with N+M model passes rather than N*M.
+func handleSimilarityMatrix(c *gin.Context) {
+	var input MatrixInput
+	if err := bindInput(c, &input); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "Invalid input format: " + err.Error(),
+		})
+		return
+	}
+	for _, list := range [][]string{input.Sentences1, input.Sentences2} {
+		for i, sentence := range list {
+			if list[i] = strings.TrimSpace(sentence); list[i] == "" {
+				respond(c, http.StatusBadRequest, ErrorResponse{
+					Error:   "empty_sentences",
+					Message: "sentences must be non-empty",
+				})
+				return
+			}
+		}
+	}
+
+	lim := limitsFor(c)
+	if len(input.Sentences1)*len(input.Sentences2) > lim.MaxMatrixCells {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: fmt.Sprintf("%d sentences against %d sentences exceeds the limit of %d comparisons", len(input.Sentences1), len(input.Sentences2), lim.MaxMatrixCells),
+		})
+		return
+	}
+	if !checkSentenceLengths(c, lim, input.Sentences1...) || !checkSentenceLengths(c, lim, input.Sentences2...) {
+		return
+	}
+	if len(input.Entities) > lim.MaxEntities {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: fmt.Sprintf("At most %d entities are allowed", lim.MaxEntities),
+		})
+		return
+	}
+	callOptions := similarity.CallOptions{Pooling: input.Pooling, Normalize: input.Normalize, MaxSeqLength: input.MaxSeqLength, Entities: input.Entities}
+	if err := validateCallOptions(c.Request.Context(), callOptions); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx := similarity.WithCallOptions(c.Request.Context(), callOptions)
+	matrix, err := scorer.Matrix(ctx, input.Sentences1, input.Sentences2)
+	if err != nil {
+		logBackendError(c, err, append(input.Sentences1, input.Sentences2...)...)
+		respond(c, http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to process similarity matrix",
+		})
+		return
+	}
+
+	respond(c, http.StatusOK, MatrixResponse{
+		Matrix:      matrix,
+		Rows:        len(input.Sentences1),
+		Columns:     len(input.Sentences2),
+		ProcessedAt: time.Now().UTC().Format(time.RFC3339),
+	})
+}
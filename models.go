@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ModelCard describes a model for whoever decides whether it may be used.
+// Dimension and MaxTokens are filled from the backend handshake when the
+// card is for the model being served and leaves them unset.
+type ModelCard struct {
+	Name        string             `json:"name"`
+	License     string             `json:"license,omitempty"`
+	Languages   []string           `json:"languages,omitempty"`
+	IntendedUse string             `json:"intended_use,omitempty"`
+	Dimension   int                `json:"dimension,omitempty"`
+	MaxTokens   int                `json:"max_tokens,omitempty"`
+	EvalScores  map[string]float64 `json:"eval_scores,omitempty"`
+	// Served reports whether the backend is currently serving this model.
+	Served bool `json:"served"`
+}
+
+// defaultModelCards covers the Python service's default model, taken from
+// its published card. Evaluation scores depend on the benchmark version,
+// so they are left to MODEL_CARDS.
+var defaultModelCards = []ModelCard{
+	{
+		Name:        "sentence-transformers/all-MiniLM-L6-v2",
+		License:     "apache-2.0",
+		Languages:   []string{"en"},
+		IntendedUse: "Sentence and short paragraph embeddings for semantic search, clustering and similarity. Input longer than max_tokens word pieces is truncated.",
+		Dimension:   384,
+		MaxTokens:   256,
+	},
+}
+
+// modelCardsFromEnv reads MODEL_CARDS, a JSON array of cards. A card
+// replaces the built-in card of the same name.
+func modelCardsFromEnv() (map[string]ModelCard, error) {
+	cards := builtinModelCards()
+	raw := os.Getenv("MODEL_CARDS")
+	if raw == "" {
+		return cards, nil
+	}
+	var configured []ModelCard
+	if err := json.Unmarshal([]byte(raw), &configured); err != nil {
+		return nil, fmt.Errorf("MODEL_CARDS is not a JSON array of model cards: %v", err)
+	}
+	names := make(map[string]bool, len(configured))
+	for _, card := range configured {
+		if card.Name == "" {
+			return nil, fmt.Errorf("every model card needs a name")
+		}
+		if names[card.Name] {
+			return nil, fmt.Errorf("model card %s is defined twice", card.Name)
+		}
+		names[card.Name] = true
+		cards[card.Name] = card
+	}
+	return cards, nil
+}
+
+// modelCards falls back to the built-in cards when MODEL_CARDS is invalid;
+// startup validation refuses to start in that case.
+var modelCards = func() map[string]ModelCard {
+	cards, err := modelCardsFromEnv()
+	if err != nil {
+		return builtinModelCards()
+	}
+	return cards
+}()
+
+func builtinModelCards() map[string]ModelCard {
+	cards := make(map[string]ModelCard, len(defaultModelCards))
+	for _, card := range defaultModelCards {
+		cards[card.Name] = card
+	}
+	return cards
+}
+
+// handleModelCard serves GET /api/v1/models/*name. Model names usually
+// contain a slash, so the name is a catch-all parameter.
+func handleModelCard(c *gin.Context) {
+	name := strings.TrimPrefix(c.Param("name"), "/")
+	card, ok := modelCards[name]
+	if !ok {
+		respond(c, http.StatusNotFound, ErrorResponse{
+			Error:   "not_found",
+			Message: fmt.Sprintf("No model card for %q", name),
+		})
+		return
+	}
+	if backend, err := backendInfo(c.Request.Context()); err == nil && backend.Model == name {
+		card.Served = true
+		if card.Dimension == 0 {
+			card.Dimension = backend.EmbeddingDimension
+		}
+		if card.MaxTokens == 0 {
+			card.MaxTokens = backend.MaxSeqLength
+		}
+	}
+	respond(c, http.StatusOK, card)
+}
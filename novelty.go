@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"text-similarity-api/similarity"
+)
+
+type NoveltyInput struct {
+	Sentences []string `json:"sentences" binding:"required,min=1"`
+}
+
+type NoveltyScore struct {
+	Sentence string `json:"sentence"`
+	// Novelty is 1 minus the similarity to the closest session sentence,
+	// so 1 means nothing in the session resembles it.
+	Novelty float64      `json:"novelty"`
+	Nearest SessionMatch `json:"nearest"`
+}
+
+type NoveltyResponse struct {
+	SessionID   string         `json:"session_id"`
+	Scores      []NoveltyScore `json:"scores"`
+	ProcessedAt string         `json:"processed_at"`
+}
+
+// handleSessionNovelty scores how new each sentence is relative to the
+// session's sentences, for example to surface support issues that match
+// no known one. Like suggestions, it leaves the query history alone.
+func handleSessionNovelty(c *gin.Context) {
+	var input NoveltyInput
+	if err := bindInput(c, &input); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "Invalid input format: " + err.Error(),
+		})
+		return
+	}
+	for i, sentence := range input.Sentences {
+		if input.Sentences[i] = strings.TrimSpace(sentence); input.Sentences[i] == "" {
+			respond(c, http.StatusBadRequest, ErrorResponse{
+				Error:   "empty_sentences",
+				Message: "sentences must be non-empty",
+			})
+			return
+		}
+	}
+	lim := limitsFor(c)
+	if !checkSentenceLengths(c, lim, input.Sentences...) {
+		return
+	}
+
+	session, ok := sessionStore.Get(c.Param("id"))
+	if !ok {
+		respond(c, http.StatusNotFound, ErrorResponse{
+			Error:   "session_not_found",
+			Message: "Session does not exist or has expired",
+		})
+		return
+	}
+	if len(input.Sentences)*len(session.Sentences) > lim.MaxMatrixCells {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: fmt.Sprintf("%d sentences against a session of %d exceeds the limit of %d comparisons", len(input.Sentences), len(session.Sentences), lim.MaxMatrixCells),
+		})
+		return
+	}
+
+	embeddings, err := scorer.Embed(session.context(c.Request.Context()), input.Sentences)
+	if err != nil {
+		logBackendError(c, err, input.Sentences...)
+		respond(c, http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to process similarity calculation",
+		})
+		return
+	}
+
+	scores := make([]NoveltyScore, len(input.Sentences))
+	for i, embedding := range embeddings {
+		nearest := SessionMatch{Similarity: math.Inf(-1)}
+		for j, candidate := range session.Embeddings {
+			if score := similarity.Cosine(embedding, candidate); score > nearest.Similarity {
+				nearest = SessionMatch{Index: j, Sentence: session.Sentences[j], Similarity: score}
+			}
+		}
+		scores[i] = NoveltyScore{
+			Sentence: input.Sentences[i],
+			Novelty:  1 - math.Max(0, math.Min(1, nearest.Similarity)),
+			Nearest:  nearest,
+		}
+	}
+
+	respond(c, http.StatusOK, NoveltyResponse{
+		SessionID:   session.ID,
+		Scores:      scores,
+		ProcessedAt: time.Now().UTC().Format(time.RFC3339),
+	})
+}
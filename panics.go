@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+)
+
+// Where recovered panics happened, as counted in stats.json. Backend
+// panics are recovered and counted by the scorer itself.
+const (
+	panicSiteRequest  = "request"
+	panicSiteBatch    = "batch"
+	panicSiteDeferral = "deferral"
+	panicSiteProbe    = "probe"
+	panicSiteJob      = "job"
+	panicSiteBackend  = "backend"
+)
+
+// errPanicked marks an item whose processing panicked. Such errors are not
+// retryable: the same input would panic again.
+var errPanicked = errors.New("processing panicked")
+
+// PanicCounter counts recovered panics by site since startup.
+type PanicCounter struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+var panicCounts = &PanicCounter{counts: map[string]int64{}}
+
+func (p *PanicCounter) Add(site string) {
+	p.mu.Lock()
+	p.counts[site]++
+	p.mu.Unlock()
+}
+
+// Counts includes the scorer's recovered backend panics.
+func (p *PanicCounter) Counts() map[string]int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	counts := make(map[string]int64, len(p.counts)+1)
+	for site, n := range p.counts {
+		counts[site] = n
+	}
+	counts[panicSiteBackend] = scorer.Panics()
+	return counts
+}
+
+// recoverItem is deferred by a goroutine that processes one item of a
+// larger piece of work, such as a batch pair. A panic becomes that item's
+// error, wrapping errPanicked, and is logged with its stack and counted, so
+// the other items and the process carry on.
+func recoverItem(site string, err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	panicCounts.Add(site)
+	slog.Error("Recovered panic", "site", site, "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+	*err = fmt.Errorf("%w: %v", errPanicked, r)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"text-similarity-api/similarity"
+)
+
+const (
+	defaultPrefilterLengthRatio = 0.1
+	defaultPrefilterCharOverlap = 0.02
+)
+
+// prefilterFromEnv returns the prefilter configured with PREFILTER=on and
+// its PREFILTER_* thresholds, or nil when prefiltering is off.
+func prefilterFromEnv() (*similarity.Prefilter, error) {
+	switch mode := os.Getenv("PREFILTER"); mode {
+	case "", "off":
+		return nil, nil
+	case "on":
+	default:
+		return nil, fmt.Errorf("PREFILTER %q must be on or off", mode)
+	}
+
+	p := &similarity.Prefilter{
+		MinLengthRatio: defaultPrefilterLengthRatio,
+		MinCharOverlap: defaultPrefilterCharOverlap,
+	}
+	for name, target := range map[string]*float64{
+		"PREFILTER_MIN_LENGTH_RATIO": &p.MinLengthRatio,
+		"PREFILTER_MIN_CHAR_OVERLAP": &p.MinCharOverlap,
+	} {
+		if raw := os.Getenv(name); raw != "" {
+			value, err := strconv.ParseFloat(raw, 64)
+			if err != nil || value < 0 || value > 1 {
+				return nil, fmt.Errorf("%s %q must be between 0 and 1", name, raw)
+			}
+			*target = value
+		}
+	}
+	if raw := os.Getenv("PREFILTER_MAX_SIMHASH_DISTANCE"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 0 || value > 64 {
+			return nil, fmt.Errorf("PREFILTER_MAX_SIMHASH_DISTANCE %q must be between 0 and 64", raw)
+		}
+		p.MaxSimHashDistance = value
+	}
+	return p, nil
+}
+
+// prefilter is nil, and every pair goes to the model, if prefiltering is
+// off or misconfigured; startup validation refuses to start in the latter
+// case.
+var prefilter, _ = prefilterFromEnv()
+
+func prefilterEstimate(a, b string) (float64, bool) {
+	if prefilter == nil {
+		return 0, false
+	}
+	return prefilter.Estimate(a, b)
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"text-similarity-api/config"
+)
+
+const (
+	defaultProbeInterval   = time.Minute
+	defaultProbeTimeout    = 10 * time.Second
+	defaultProbeAlertAfter = 2
+	probeWebhookTimeout    = 10 * time.Second
+)
+
+// ProbeCase is a canned pair and the range of scores a healthy model gives
+// it. A score outside the range means the model or its preprocessing has
+// changed, even though every request still succeeds.
+type ProbeCase struct {
+	Name      string  `json:"name"`
+	Sentence1 string  `json:"sentence1"`
+	Sentence2 string  `json:"sentence2"`
+	Min       float64 `json:"min"`
+	Max       float64 `json:"max"`
+}
+
+// defaultProbeCases suit the default all-MiniLM-L6-v2 model. Deployments of
+// other models should set PROBE_CASES with ranges measured on that model.
+var defaultProbeCases = []ProbeCase{
+	{
+		Name:      "identical",
+		Sentence1: "The probe compares a sentence with itself.",
+		Sentence2: "The probe compares a sentence with itself.",
+		Min:       0.99,
+		Max:       1,
+	},
+	{
+		Name:      "paraphrase",
+		Sentence1: "AI is transforming the world.",
+		Sentence2: "Artificial intelligence is changing society.",
+		Min:       0.5,
+		Max:       1,
+	},
+	{
+		Name:      "unrelated",
+		Sentence1: "The weather is sunny today.",
+		Sentence2: "Quarterly revenue grew by ten percent.",
+		Min:       -1,
+		Max:       0.3,
+	},
+}
+
+// ProbeConfig runs the canned cases every Interval against this server's
+// scorer, or against the API at Target, and posts to Webhook once
+// AlertAfter rounds in a row have failed and again when a round passes.
+type ProbeConfig struct {
+	Interval   time.Duration
+	Timeout    time.Duration
+	Target     string
+	Webhook    string
+	AlertAfter int
+	Cases      []ProbeCase
+}
+
+// probeFromEnv returns the configuration in PROBE, PROBE_INTERVAL,
+// PROBE_TIMEOUT, PROBE_TARGET, PROBE_WEBHOOK, PROBE_ALERT_AFTER and
+// PROBE_CASES, or nil when probing is off.
+func probeFromEnv() (*ProbeConfig, error) {
+	switch mode := os.Getenv("PROBE"); mode {
+	case "", "off":
+		return nil, nil
+	case "on":
+	default:
+		return nil, fmt.Errorf("PROBE %q must be on or off", mode)
+	}
+
+	config := &ProbeConfig{
+		Interval:   defaultProbeInterval,
+		Timeout:    defaultProbeTimeout,
+		Target:     strings.TrimRight(os.Getenv("PROBE_TARGET"), "/"),
+		Webhook:    os.Getenv("PROBE_WEBHOOK"),
+		AlertAfter: defaultProbeAlertAfter,
+		Cases:      defaultProbeCases,
+	}
+	for name, target := range map[string]*time.Duration{
+		"PROBE_INTERVAL": &config.Interval,
+		"PROBE_TIMEOUT":  &config.Timeout,
+	} {
+		if raw := os.Getenv(name); raw != "" {
+			value, err := time.ParseDuration(raw)
+			if err != nil || value <= 0 {
+				return nil, fmt.Errorf("%s %q must be a positive duration such as 1m", name, raw)
+			}
+			*target = value
+		}
+	}
+	for name, raw := range map[string]string{"PROBE_TARGET": config.Target, "PROBE_WEBHOOK": config.Webhook} {
+		if raw == "" {
+			continue
+		}
+		if parsed, err := url.Parse(raw); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("%s %q must be an http or https URL", name, raw)
+		}
+	}
+	if raw := os.Getenv("PROBE_ALERT_AFTER"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 1 {
+			return nil, fmt.Errorf("PROBE_ALERT_AFTER %q must be a positive integer", raw)
+		}
+		config.AlertAfter = value
+	}
+	if raw := os.Getenv("PROBE_CASES"); raw != "" {
+		cases, err := parseProbeCases(raw)
+		if err != nil {
+			return nil, fmt.Errorf("PROBE_CASES: %v", err)
+		}
+		config.Cases = cases
+	}
+	return config, nil
+}
+
+// parseProbeCases reads a JSON array of cases. A missing min or max leaves
+// that end of the range open.
+func parseProbeCases(raw string) ([]ProbeCase, error) {
+	var specs []struct {
+		Name      string   `json:"name"`
+		Sentence1 string   `json:"sentence1"`
+		Sentence2 string   `json:"sentence2"`
+		Min       *float64 `json:"min"`
+		Max       *float64 `json:"max"`
+	}
+	if err := json.Unmarshal([]byte(raw), &specs); err != nil {
+		return nil, err
+	}
+	if len(specs) == 0 {
+		return nil, errors.New("must list at least one case")
+	}
+	cases := make([]ProbeCase, len(specs))
+	seen := map[string]bool{}
+	for i, spec := range specs {
+		c := ProbeCase{Name: spec.Name, Sentence1: spec.Sentence1, Sentence2: spec.Sentence2, Min: -1, Max: 1}
+		if spec.Min != nil {
+			c.Min = *spec.Min
+		}
+		if spec.Max != nil {
+			c.Max = *spec.Max
+		}
+		switch {
+		case c.Name == "":
+			return nil, fmt.Errorf("case %d has no name", i)
+		case seen[c.Name]:
+			return nil, fmt.Errorf("case %q is listed twice", c.Name)
+		case strings.TrimSpace(c.Sentence1) == "" || strings.TrimSpace(c.Sentence2) == "":
+			return nil, fmt.Errorf("case %q needs sentence1 and sentence2", c.Name)
+		case c.Min > c.Max:
+			return nil, fmt.Errorf("case %q has min %g above max %g", c.Name, c.Min, c.Max)
+		}
+		seen[c.Name] = true
+		cases[i] = c
+	}
+	return cases, nil
+}
+
+// ProbeResult is the outcome of one case in one round.
+type ProbeResult struct {
+	Name      string  `json:"name"`
+	OK        bool    `json:"ok"`
+	Score     float64 `json:"score"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// ProbeRound is one run of every case.
+type ProbeRound struct {
+	At      string        `json:"at"`
+	OK      bool          `json:"ok"`
+	Results []ProbeResult `json:"results"`
+}
+
+// ProbeCaseStats summarises a case since startup.
+type ProbeCaseStats struct {
+	ProbeCase
+	Runs         int64   `json:"runs"`
+	Failures     int64   `json:"failures"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+}
+
+// ProbeStatus is served as /admin/probe.
+type ProbeStatus struct {
+	Target              string           `json:"target"`
+	Interval            string           `json:"interval"`
+	Rounds              int64            `json:"rounds"`
+	FailedRounds        int64            `json:"failed_rounds"`
+	ConsecutiveFailures int              `json:"consecutive_failures"`
+	Alerting            bool             `json:"alerting"`
+	Last                *ProbeRound      `json:"last,omitempty"`
+	Cases               []ProbeCaseStats `json:"cases"`
+}
+
+// probeAlert is posted to the webhook. Status is firing when probes start
+// failing and resolved when they pass again.
+type probeAlert struct {
+	Status              string        `json:"status"`
+	Service             string        `json:"service"`
+	Target              string        `json:"target"`
+	ConsecutiveFailures int           `json:"consecutive_failures"`
+	Results             []ProbeResult `json:"results"`
+	At                  string        `json:"at"`
+}
+
+// Prober runs the canned cases in the background and keeps their results.
+type Prober struct {
+	config *ProbeConfig
+	client *http.Client
+
+	// running serialises rounds, so one started from /admin/probe does
+	// not interleave its alert decision with a scheduled one.
+	running sync.Mutex
+
+	mu          sync.Mutex
+	last        *ProbeRound
+	rounds      int64
+	failed      int64
+	consecutive int
+	// alerting is set once a firing alert has been delivered, so a
+	// webhook that was down is retried on the next failed round.
+	alerting bool
+	cases    []ProbeCaseStats
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newProber(config *ProbeConfig) *Prober {
+	p := &Prober{
+		config: config,
+		client: &http.Client{},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	for _, c := range config.Cases {
+		p.cases = append(p.cases, ProbeCaseStats{ProbeCase: c})
+	}
+	return p
+}
+
+// prober is nil when probing is off or its configuration is invalid;
+// startup validation refuses to start in the latter case.
+var prober = func() *Prober {
+	config, err := probeFromEnv()
+	if err != nil || config == nil {
+		return nil
+	}
+	return newProber(config)
+}()
+
+// Start runs a round every interval until Stop. The first round waits a
+// full interval so that it does not race the model loading at startup.
+func (p *Prober) Start() {
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(p.config.Interval)
+		defer ticker.Stop()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			<-p.stop
+			cancel()
+		}()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.Run(ctx)
+			}
+		}
+	}()
+}
+
+// Stop ends the background rounds and waits for one in progress, so that
+// probes do not restart backend workers during shutdown.
+func (p *Prober) Stop() {
+	p.stopOnce.Do(func() { close(p.stop) })
+	<-p.done
+}
+
+// Run performs one round, records it and alerts if the round changed the
+// alert state.
+func (p *Prober) Run(ctx context.Context) ProbeRound {
+	p.running.Lock()
+	defer p.running.Unlock()
+	round := ProbeRound{At: time.Now().UTC().Format(time.RFC3339), OK: true}
+	for _, c := range p.config.Cases {
+		result := p.probe(ctx, c)
+		if !result.OK {
+			round.OK = false
+		}
+		round.Results = append(round.Results, result)
+	}
+	if ctx.Err() != nil {
+		return round
+	}
+
+	p.mu.Lock()
+	p.last = &round
+	p.rounds++
+	for i, result := range round.Results {
+		stats := &p.cases[i]
+		stats.AvgLatencyMs += (result.LatencyMs - stats.AvgLatencyMs) / float64(stats.Runs+1)
+		stats.Runs++
+		if !result.OK {
+			stats.Failures++
+		}
+	}
+	var alert string
+	if round.OK {
+		p.consecutive = 0
+		if p.alerting {
+			alert = "resolved"
+		}
+	} else {
+		p.failed++
+		p.consecutive++
+		if p.consecutive >= p.config.AlertAfter && !p.alerting {
+			alert = "firing"
+		}
+	}
+	consecutive := p.consecutive
+	p.mu.Unlock()
+
+	if !round.OK {
+		slog.Warn("Synthetic probe failed", "target", p.target(), "consecutive_failures", consecutive, "results", round.Results)
+	}
+	if alert == "" {
+		return round
+	}
+	if p.config.Webhook != "" {
+		err := p.alert(ctx, probeAlert{
+			Status:              alert,
+			Service:             "text-similarity-api",
+			Target:              p.target(),
+			ConsecutiveFailures: consecutive,
+			Results:             round.Results,
+			At:                  round.At,
+		})
+		if err != nil {
+			slog.Warn("Probe alert webhook failed", "status", alert, "error", err)
+			return round
+		}
+	}
+	p.mu.Lock()
+	p.alerting = alert == "firing"
+	p.mu.Unlock()
+	return round
+}
+
+// probe scores one case as a 1x1 matrix. Matrix calls bypass the score
+// cache and the lexical fallback, either of which would hide a model that
+// has stopped scoring as expected.
+func (p *Prober) probe(ctx context.Context, c ProbeCase) ProbeResult {
+	ctx, cancel := context.WithTimeout(ctx, p.config.Timeout)
+	defer cancel()
+	start := time.Now()
+	var score float64
+	err := func() (err error) {
+		// A backend that returns a malformed matrix fails this case
+		// rather than the prober.
+		defer recoverItem(panicSiteProbe, &err)
+		if p.config.Target != "" {
+			score, err = p.probePeer(ctx, c)
+			return err
+		}
+		matrix, err := scorer.Matrix(ctx, []string{c.Sentence1}, []string{c.Sentence2})
+		if err == nil {
+			score = matrix[0][0]
+		}
+		return err
+	}()
+	result := ProbeResult{Name: c.Name, Score: score, LatencyMs: milliseconds(time.Since(start))}
+	switch {
+	case err != nil:
+		result.Error = err.Error()
+	case score < c.Min || score > c.Max:
+		result.Error = fmt.Sprintf("score %.4f is outside the expected range [%g, %g]", score, c.Min, c.Max)
+	default:
+		result.OK = true
+	}
+	return result
+}
+
+func (p *Prober) probePeer(ctx context.Context, c ProbeCase) (float64, error) {
+	body, _ := json.Marshal(MatrixInput{Sentences1: []string{c.Sentence1}, Sentences2: []string{c.Sentence2}})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.config.Target+"/api/v1/similarity/matrix", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+		return 0, fmt.Errorf("%s returned %s", p.target(), resp.Status)
+	}
+	var matrix MatrixResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&matrix); err != nil {
+		return 0, fmt.Errorf("decoding response from %s: %v", p.target(), err)
+	}
+	if len(matrix.Matrix) != 1 || len(matrix.Matrix[0]) != 1 {
+		return 0, fmt.Errorf("%s returned a %dx%d matrix for one pair", p.target(), matrix.Rows, matrix.Columns)
+	}
+	return matrix.Matrix[0][0], nil
+}
+
+func (p *Prober) alert(ctx context.Context, alert probeAlert) error {
+	ctx, cancel := context.WithTimeout(ctx, probeWebhookTimeout)
+	defer cancel()
+	body, _ := json.Marshal(alert)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.config.Webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// target names what is probed in the status, logs and alerts, without
+// any credentials in the peer's URL.
+func (p *Prober) target() string {
+	if p.config.Target == "" {
+		return "self"
+	}
+	return config.Redact("PROBE_TARGET", p.config.Target)
+}
+
+func (p *Prober) Status() ProbeStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return ProbeStatus{
+		Target:              p.target(),
+		Interval:            p.config.Interval.String(),
+		Rounds:              p.rounds,
+		FailedRounds:        p.failed,
+		ConsecutiveFailures: p.consecutive,
+		Alerting:            p.alerting,
+		Last:                p.last,
+		Cases:               append([]ProbeCaseStats(nil), p.cases...),
+	}
+}
+
+func probeStatus() string {
+	if prober == nil {
+		return "disabled"
+	}
+	if prober.config.Target == "" {
+		return "enabled (self)"
+	}
+	return "enabled (peer)"
+}
+
+func probeFailedRounds() int64 {
+	if prober == nil {
+		return 0
+	}
+	prober.mu.Lock()
+	defer prober.mu.Unlock()
+	return prober.failed
+}
+
+// handleProbe reports the prober's recent results. POST runs a round at
+// once, which is useful right after a deployment.
+func handleProbe(c *gin.Context) {
+	if prober == nil {
+		respond(c, http.StatusNotFound, ErrorResponse{
+			Error:   "probe_disabled",
+			Message: "Synthetic probing is off; set PROBE=on",
+		})
+		return
+	}
+	if c.Request.Method == http.MethodPost {
+		prober.Run(c.Request.Context())
+	}
+	c.JSON(http.StatusOK, prober.Status())
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"text-similarity-api/similarity"
+)
+
+const projectionPCA = "pca"
+
+// ProjectionInput takes either sentences, which are embedded first, or
+// embeddings the client already has.
+type ProjectionInput struct {
+	Sentences  []string    `json:"sentences,omitempty"`
+	Embeddings [][]float64 `json:"embeddings,omitempty"`
+	Dimensions int         `json:"dimensions,omitempty"`
+	Method     string      `json:"method,omitempty"`
+	// Clusters is the number of k-means clusters; 0 picks about
+	// sqrt(n/2).
+	Clusters     int      `json:"clusters,omitempty"`
+	Pooling      string   `json:"pooling,omitempty"`
+	Normalize    bool     `json:"normalize,omitempty"`
+	MaxSeqLength int      `json:"max_seq_length,omitempty"`
+	Entities     []string `json:"entities,omitempty"`
+}
+
+type ProjectedPoint struct {
+	Index       int       `json:"index"`
+	Sentence    string    `json:"sentence,omitempty"`
+	Coordinates []float64 `json:"coordinates"`
+	Cluster     int       `json:"cluster"`
+}
+
+type ProjectionResponse struct {
+	Method string           `json:"method"`
+	Points []ProjectedPoint `json:"points"`
+	// ExplainedVariance is the share of the total variance each output
+	// dimension captures.
+	ExplainedVariance []float64 `json:"explained_variance"`
+	Clusters          int       `json:"clusters"`
+	ProcessedAt       string    `json:"processed_at"`
+}
+
+// handleProjectEmbeddings projects embeddings to 2 or 3 dimensions for
+// plotting and labels each with a cluster. Clusters are found in the full
+// embedding space, not the projection, so points that land close together
+// only by projection keep their own labels.
+func handleProjectEmbeddings(c *gin.Context) {
+	var input ProjectionInput
+	if err := bindInput(c, &input); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "Invalid input format: " + err.Error(),
+		})
+		return
+	}
+	if (len(input.Sentences) == 0) == (len(input.Embeddings) == 0) {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "exactly one of sentences and embeddings is required",
+		})
+		return
+	}
+	if input.Dimensions == 0 {
+		input.Dimensions = 2
+	}
+	if input.Dimensions != 2 && input.Dimensions != 3 {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "dimensions must be 2 or 3",
+		})
+		return
+	}
+	switch input.Method {
+	case "", projectionPCA:
+		input.Method = projectionPCA
+	default:
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: fmt.Sprintf("method %q is not supported; only pca is available", input.Method),
+		})
+		return
+	}
+	if input.Clusters < 0 {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "clusters must not be negative",
+		})
+		return
+	}
+
+	lim := limitsFor(c)
+	count := len(input.Sentences) + len(input.Embeddings)
+	if count > lim.MaxEmbeddingSentences {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: fmt.Sprintf("At most %d points can be projected per request, got %d", lim.MaxEmbeddingSentences, count),
+		})
+		return
+	}
+
+	embeddings := input.Embeddings
+	if len(input.Sentences) > 0 {
+		for i, sentence := range input.Sentences {
+			if input.Sentences[i] = strings.TrimSpace(sentence); input.Sentences[i] == "" {
+				respond(c, http.StatusBadRequest, ErrorResponse{
+					Error:   "empty_sentences",
+					Message: "sentences must be non-empty",
+				})
+				return
+			}
+		}
+		if !checkSentenceLengths(c, lim, input.Sentences...) {
+			return
+		}
+		if len(input.Entities) > lim.MaxEntities {
+			respond(c, http.StatusBadRequest, ErrorResponse{
+				Error:   "validation_error",
+				Message: fmt.Sprintf("At most %d entities are allowed", lim.MaxEntities),
+			})
+			return
+		}
+		callOptions := similarity.CallOptions{Pooling: input.Pooling, Normalize: input.Normalize, MaxSeqLength: input.MaxSeqLength, Entities: input.Entities}
+		if err := validateCallOptions(c.Request.Context(), callOptions); err != nil {
+			respond(c, http.StatusBadRequest, ErrorResponse{
+				Error:   "validation_error",
+				Message: err.Error(),
+			})
+			return
+		}
+		var err error
+		embeddings, err = scorer.Embed(similarity.WithCallOptions(c.Request.Context(), callOptions), input.Sentences)
+		if err != nil {
+			logBackendError(c, err, input.Sentences...)
+			respond(c, http.StatusInternalServerError, ErrorResponse{
+				Error:   "internal_error",
+				Message: "Failed to compute embeddings",
+			})
+			return
+		}
+	}
+
+	coordinates, explained, err := similarity.PCA(embeddings, input.Dimensions)
+	if err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: err.Error(),
+		})
+		return
+	}
+	clusters := input.Clusters
+	if clusters == 0 {
+		clusters = int(math.Round(math.Sqrt(float64(len(embeddings)) / 2)))
+	}
+	clusters = max(1, min(clusters, len(embeddings)))
+	labels, err := similarity.KMeans(embeddings, clusters)
+	if err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	points := make([]ProjectedPoint, len(embeddings))
+	for i := range embeddings {
+		points[i] = ProjectedPoint{Index: i, Coordinates: coordinates[i], Cluster: labels[i]}
+		if len(input.Sentences) > 0 {
+			points[i].Sentence = input.Sentences[i]
+		}
+	}
+	respond(c, http.StatusOK, ProjectionResponse{
+		Method:            input.Method,
+		Points:            points,
+		ExplainedVariance: explained,
+		Clusters:          clusters,
+		ProcessedAt:       time.Now().UTC().Format(time.RFC3339),
+	})
+}
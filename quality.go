@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+
+	"text-similarity-api/similarity"
+)
+
+// Input quality policies, selected with INPUT_QUALITY_POLICY.
+const (
+	qualityPolicyScore  = "score"
+	qualityPolicyWarn   = "warn"
+	qualityPolicyReject = "reject"
+)
+
+type ResponseWarning struct {
+	Code   string `json:"code"`
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+func inputQualityPolicy() string {
+	if policy := os.Getenv("INPUT_QUALITY_POLICY"); policy != "" {
+		return policy
+	}
+	return qualityPolicyWarn
+}
+
+// assessInputs returns a low_information_input warning for every named
+// input that is only a URL, emoji, number or boilerplate phrase.
+func assessInputs(inputs map[string]string, order ...string) []ResponseWarning {
+	var warnings []ResponseWarning
+	for _, field := range order {
+		if reason := similarity.AssessQuality(inputs[field]); reason != similarity.QualityOK {
+			warnings = append(warnings, ResponseWarning{
+				Code:   "low_information_input",
+				Field:  field,
+				Reason: reason,
+			})
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultRateLimitRPS   = 10
+	defaultRateLimitBurst = 20
+)
+
+// RateLimitConfig is a token bucket per client, refilled at RPS up to
+// Burst, plus an optional number of requests per client per UTC day.
+type RateLimitConfig struct {
+	RPS        float64
+	Burst      int
+	DailyQuota int
+	// KeyHeader names a header that identifies clients, such as
+	// X-API-Key. Only the keys in Keys count; requests without one of
+	// them are identified by client IP, so a made-up key buys no bucket.
+	KeyHeader string
+	// Keys holds the hex SHA-256 digests of the RATE_LIMIT_KEYS.
+	Keys map[string]bool
+}
+
+// rateLimitFromEnv returns the configuration for RATE_LIMIT=on and its
+// RATE_LIMIT_* settings, or nil when rate limiting is off.
+func rateLimitFromEnv() (*RateLimitConfig, error) {
+	switch mode := os.Getenv("RATE_LIMIT"); mode {
+	case "", "off":
+		return nil, nil
+	case "on":
+	default:
+		return nil, fmt.Errorf("RATE_LIMIT %q must be on or off", mode)
+	}
+
+	config := &RateLimitConfig{
+		RPS:       defaultRateLimitRPS,
+		Burst:     defaultRateLimitBurst,
+		KeyHeader: os.Getenv("RATE_LIMIT_KEY_HEADER"),
+	}
+	if raw := os.Getenv("RATE_LIMIT_RPS"); raw != "" {
+		rps, err := strconv.ParseFloat(raw, 64)
+		if err != nil || rps <= 0 || math.IsInf(rps, 0) {
+			return nil, fmt.Errorf("RATE_LIMIT_RPS %q must be a positive number", raw)
+		}
+		config.RPS = rps
+	}
+	if raw := os.Getenv("RATE_LIMIT_BURST"); raw != "" {
+		burst, err := strconv.Atoi(raw)
+		if err != nil || burst < 1 {
+			return nil, fmt.Errorf("RATE_LIMIT_BURST %q must be a positive integer", raw)
+		}
+		config.Burst = burst
+	}
+	if raw := os.Getenv("RATE_LIMIT_DAILY_QUOTA"); raw != "" {
+		quota, err := strconv.Atoi(raw)
+		if err != nil || quota < 0 {
+			return nil, fmt.Errorf("RATE_LIMIT_DAILY_QUOTA %q must be a non-negative integer", raw)
+		}
+		config.DailyQuota = quota
+	}
+	if config.KeyHeader != "" {
+		config.Keys = make(map[string]bool)
+		for _, key := range strings.Split(os.Getenv("RATE_LIMIT_KEYS"), ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				config.Keys[apiKeyDigest(key)] = true
+			}
+		}
+		if len(config.Keys) == 0 {
+			return nil, fmt.Errorf("RATE_LIMIT_KEY_HEADER needs RATE_LIMIT_KEYS, the comma-separated keys clients may send in it")
+		}
+	}
+	return config, nil
+}
+
+func apiKeyDigest(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// trustedProxiesFromEnv reads TRUSTED_PROXIES, a comma-separated list of
+// IPs or CIDRs whose X-Forwarded-For is believed. Unset, it returns nil
+// and no proxy is trusted, so the client IP is the peer's address;
+// "none" says so explicitly and returns an empty list.
+func trustedProxiesFromEnv() ([]string, error) {
+	raw := strings.TrimSpace(os.Getenv("TRUSTED_PROXIES"))
+	switch raw {
+	case "":
+		return nil, nil
+	case "none":
+		return []string{}, nil
+	}
+	var proxies []string
+	for _, proxy := range strings.Split(raw, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				return nil, fmt.Errorf("TRUSTED_PROXIES entry %q is not an IP or CIDR", proxy)
+			}
+		}
+		proxies = append(proxies, proxy)
+	}
+	return proxies, nil
+}
+
+// maxRateLimitClients bounds the buckets and the daily counts each. A
+// client first seen once a table is full shares the overflow entry with
+// every other such client, which keeps memory bounded at the cost of
+// limiting them together.
+const (
+	maxRateLimitClients = 100000
+	overflowClient      = "overflow"
+	evictionScan        = 64
+)
+
+type tokenBucket struct {
+	tokens   float64
+	refilled time.Time
+}
+
+type dayCount struct {
+	today   int
+	limited int
+}
+
+// RateLimiter applies RateLimitConfig to each client. With a nil config
+// it lets every request through but still counts usage. Buckets are
+// forgotten once they have refilled, whatever the day; the daily counts
+// are kept apart and start over at UTC midnight.
+type RateLimiter struct {
+	config  *RateLimitConfig
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	// overflow is the bucket shared by clients that found buckets full.
+	overflow *tokenBucket
+	day      string
+	counts   map[string]*dayCount
+}
+
+func NewRateLimiter(config *RateLimitConfig) *RateLimiter {
+	return &RateLimiter{
+		config:  config,
+		buckets: make(map[string]*tokenBucket),
+		counts:  make(map[string]*dayCount),
+	}
+}
+
+// rateLimiter is off if rate limiting is off or misconfigured; startup
+// validation refuses to start in the latter case.
+var rateLimiter = NewRateLimiter(func() *RateLimitConfig {
+	config, _ := rateLimitFromEnv()
+	return config
+}())
+
+// apiKey returns the digest of the API key the request carries, if it is
+// one of the configured keys.
+func (l *RateLimiter) apiKey(c *gin.Context) (string, bool) {
+	if l.config == nil || l.config.KeyHeader == "" {
+		return "", false
+	}
+	key := c.GetHeader(l.config.KeyHeader)
+	if key == "" {
+		return "", false
+	}
+	digest := apiKeyDigest(key)
+	return digest, l.config.Keys[digest]
+}
+
+// clientKey identifies the caller. API keys are hashed so usage reports
+// never show them, and a key that is not configured is ignored.
+func (l *RateLimiter) clientKey(c *gin.Context) string {
+	if digest, ok := l.apiKey(c); ok {
+		return "key:" + digest[:16]
+	}
+	return "ip:" + c.ClientIP()
+}
+
+func utcDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+func nextUTCMidnight(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+}
+
+// count returns client's counts for the current UTC day, starting a new
+// day if needed. l.mu must be held.
+func (l *RateLimiter) count(client string, now time.Time) *dayCount {
+	if day := utcDay(now); l.day != day {
+		l.day, l.counts = day, make(map[string]*dayCount)
+	}
+	count := l.counts[client]
+	if count == nil {
+		if len(l.counts) >= maxRateLimitClients {
+			client = overflowClient
+			if count = l.counts[client]; count != nil {
+				return count
+			}
+		}
+		count = &dayCount{}
+		l.counts[client] = count
+	}
+	return count
+}
+
+// bucket returns client's token bucket, refilled up to now. When the
+// table is full it first tries to make room by dropping a bucket that
+// has refilled, looking at no more than evictionScan of them so a flood
+// of newcomers stays cheap. l.mu must be held.
+func (l *RateLimiter) bucket(client string, now time.Time) *tokenBucket {
+	bucket := l.buckets[client]
+	if bucket == nil && len(l.buckets) >= maxRateLimitClients {
+		scanned := 0
+		for other, b := range l.buckets {
+			if l.full(b, now) {
+				delete(l.buckets, other)
+				break
+			}
+			if scanned++; scanned == evictionScan {
+				break
+			}
+		}
+		if len(l.buckets) >= maxRateLimitClients {
+			if l.overflow == nil {
+				l.overflow = &tokenBucket{tokens: float64(l.config.Burst), refilled: now}
+			}
+			bucket = l.overflow
+		}
+	}
+	if bucket == nil {
+		bucket = &tokenBucket{tokens: float64(l.config.Burst), refilled: now}
+		l.buckets[client] = bucket
+	}
+	bucket.tokens = math.Min(float64(l.config.Burst), bucket.tokens+now.Sub(bucket.refilled).Seconds()*l.config.RPS)
+	bucket.refilled = now
+	return bucket
+}
+
+// full reports whether b would have refilled by now, so that forgetting
+// it changes nothing.
+func (l *RateLimiter) full(b *tokenBucket, now time.Time) bool {
+	return b.tokens+now.Sub(b.refilled).Seconds()*l.config.RPS >= float64(l.config.Burst)
+}
+
+// allow takes a token for client and counts the request against its day.
+// When the request is refused it returns how long to wait.
+func (l *RateLimiter) allow(client string, now time.Time) (bool, time.Duration, string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	count := l.count(client, now)
+	if l.config == nil {
+		count.today++
+		return true, 0, ""
+	}
+
+	bucket := l.bucket(client, now)
+	if l.config.DailyQuota > 0 && count.today >= l.config.DailyQuota {
+		count.limited++
+		return false, nextUTCMidnight(now).Sub(now), fmt.Sprintf("Daily quota of %d requests exhausted", l.config.DailyQuota)
+	}
+	if bucket.tokens < 1 {
+		count.limited++
+		wait := time.Duration((1 - bucket.tokens) / l.config.RPS * float64(time.Second))
+		return false, wait, fmt.Sprintf("Rate limit of %g requests per second exceeded", l.config.RPS)
+	}
+	bucket.tokens--
+	count.today++
+	return true, 0, ""
+}
+
+// Middleware rejects requests over the client's rate or quota with 429
+// and Retry-After. Replays of deferred requests were admitted when they
+// were first sent, and /usage stays reachable so a limited client can
+// see why.
+func (l *RateLimiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isDeferredReplay(c) || c.FullPath() == "/api/v1/usage" {
+			c.Next()
+			return
+		}
+		allowed, wait, reason := l.allow(l.clientKey(c), time.Now())
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			respond(c, http.StatusTooManyRequests, ErrorResponse{
+				Error:   "rate_limited",
+				Message: reason,
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// sweep drops buckets that have refilled, whether or not their client
+// was seen today, and yesterday's counts.
+func (l *RateLimiter) sweep() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if day := utcDay(now); l.day != day {
+		l.day, l.counts = day, make(map[string]*dayCount)
+	}
+	if l.config == nil {
+		return
+	}
+	for client, bucket := range l.buckets {
+		if l.full(bucket, now) {
+			delete(l.buckets, client)
+		}
+	}
+	if l.overflow != nil && l.full(l.overflow, now) {
+		l.overflow = nil
+	}
+}
+
+// StartJanitor forgets idle clients once a minute.
+func (l *RateLimiter) StartJanitor() {
+	go func() {
+		for range time.Tick(time.Minute) {
+			l.sweep()
+		}
+	}()
+}
+
+// ClientUsage is one client's requests on the current UTC day.
+type ClientUsage struct {
+	Client string `json:"client"`
+	// RequestsToday counts admitted requests.
+	RequestsToday int `json:"requests_today"`
+	// Limited counts requests refused today.
+	Limited    int    `json:"limited"`
+	DailyQuota int    `json:"daily_quota,omitempty"`
+	Remaining  *int   `json:"remaining,omitempty"`
+	ResetsAt   string `json:"resets_at"`
+}
+
+// usage reports client's counts today. l.mu must be held.
+func (l *RateLimiter) usage(client string, now time.Time) ClientUsage {
+	report := ClientUsage{Client: client, ResetsAt: nextUTCMidnight(now).Format(time.RFC3339)}
+	if count := l.counts[client]; count != nil && l.day == utcDay(now) {
+		report.RequestsToday, report.Limited = count.today, count.limited
+	}
+	if l.config != nil && l.config.DailyQuota > 0 {
+		remaining := max(0, l.config.DailyQuota-report.RequestsToday)
+		report.DailyQuota, report.Remaining = l.config.DailyQuota, &remaining
+	}
+	return report
+}
+
+func (l *RateLimiter) limits() gin.H {
+	if l.config == nil {
+		return gin.H{"enabled": false}
+	}
+	return gin.H{"enabled": true, "requests_per_second": l.config.RPS, "burst": l.config.Burst}
+}
+
+// handleUsage reports the calling client's usage today.
+func handleUsage(c *gin.Context) {
+	client := rateLimiter.clientKey(c)
+	now := time.Now()
+	rateLimiter.mu.Lock()
+	report := rateLimiter.usage(client, now)
+	rateLimiter.mu.Unlock()
+	c.JSON(http.StatusOK, gin.H{
+		"usage":      report,
+		"rate_limit": rateLimiter.limits(),
+	})
+}
+
+// handleAdminUsage reports every client seen today, busiest first.
+func handleAdminUsage(c *gin.Context) {
+	now := time.Now()
+	today := utcDay(now)
+	rateLimiter.mu.Lock()
+	clients := make([]ClientUsage, 0, len(rateLimiter.counts))
+	if rateLimiter.day == today {
+		for client := range rateLimiter.counts {
+			clients = append(clients, rateLimiter.usage(client, now))
+		}
+	}
+	rateLimiter.mu.Unlock()
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].RequestsToday != clients[j].RequestsToday {
+			return clients[i].RequestsToday > clients[j].RequestsToday
+		}
+		return clients[i].Client < clients[j].Client
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"clients":    clients,
+		"rate_limit": rateLimiter.limits(),
+		"day":        today,
+	})
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTrustedProxiesFromEnv(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"none", []string{}, false},
+		{"10.0.0.1", []string{"10.0.0.1"}, false},
+		{" 10.0.0.0/8, ::1 ", []string{"10.0.0.0/8", "::1"}, false},
+		{"10.0.0.1,proxy.local", nil, true},
+		{"10.0.0.0/33", nil, true},
+	}
+	for _, tt := range tests {
+		t.Setenv("TRUSTED_PROXIES", tt.value)
+		got, err := trustedProxiesFromEnv()
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("TRUSTED_PROXIES=%q: got %q, %v; want %q, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestClientIPTrustedProxies(t *testing.T) {
+	// httptest requests come from 192.0.2.1.
+	tests := []struct {
+		proxies string
+		want    string
+	}{
+		{"", "ip:192.0.2.1"},
+		{"none", "ip:192.0.2.1"},
+		{"198.51.100.0/24", "ip:192.0.2.1"},
+		{"192.0.2.1", "ip:203.0.113.9"},
+	}
+	for _, tt := range tests {
+		t.Setenv("TRUSTED_PROXIES", tt.proxies)
+		w := do(t, newRouter(), http.MethodGet, "/api/v1/usage", "", "X-Forwarded-For", "203.0.113.9")
+		var resp struct {
+			Usage ClientUsage `json:"usage"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Usage.Client != tt.want {
+			t.Errorf("TRUSTED_PROXIES=%q: client %q, want %q", tt.proxies, resp.Usage.Client, tt.want)
+		}
+	}
+}
+
+func TestRateLimitNeedsTrustedProxies(t *testing.T) {
+	tests := []struct {
+		rateLimit, proxies string
+		wantOK             bool
+	}{
+		{"off", "", true},
+		{"on", "", false},
+		{"on", "none", true},
+		{"on", "10.0.0.1", true},
+	}
+	for _, tt := range tests {
+		t.Setenv("RATE_LIMIT", tt.rateLimit)
+		t.Setenv("TRUSTED_PROXIES", tt.proxies)
+		for _, check := range validateConfig() {
+			if check.Name == "TRUSTED_PROXIES" && check.OK != tt.wantOK {
+				t.Errorf("RATE_LIMIT=%s TRUSTED_PROXIES=%q: ok %v, want %v (%s)", tt.rateLimit, tt.proxies, check.OK, tt.wantOK, check.Detail)
+			}
+		}
+	}
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		config  *RateLimitConfig
+		at      []time.Duration
+		allowed []bool
+	}{
+		{"off", nil, []time.Duration{0, 0, 0}, []bool{true, true, true}},
+		{"burst", &RateLimitConfig{RPS: 1, Burst: 2}, []time.Duration{0, 0, 0}, []bool{true, true, false}},
+		{"refill", &RateLimitConfig{RPS: 1, Burst: 1}, []time.Duration{0, 0, time.Second}, []bool{true, false, true}},
+		{"quota", &RateLimitConfig{RPS: 100, Burst: 100, DailyQuota: 2}, []time.Duration{0, time.Second, 2 * time.Second}, []bool{true, true, false}},
+		{"quota resets at midnight", &RateLimitConfig{RPS: 100, Burst: 100, DailyQuota: 1}, []time.Duration{0, time.Second, 12 * time.Hour}, []bool{true, false, true}},
+	}
+	for _, tt := range tests {
+		l := NewRateLimiter(tt.config)
+		for i, at := range tt.at {
+			allowed, wait, _ := l.allow("ip:192.0.2.1", now.Add(at))
+			if allowed != tt.allowed[i] {
+				t.Errorf("%s: request %d allowed %v, want %v", tt.name, i, allowed, tt.allowed[i])
+			}
+			if !allowed && wait <= 0 {
+				t.Errorf("%s: request %d refused without a wait", tt.name, i)
+			}
+		}
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	l := NewRateLimiter(&RateLimitConfig{RPS: 1000, Burst: 1})
+	l.allow("ip:192.0.2.1", time.Now().Add(-time.Second))
+	l.allow("ip:192.0.2.2", time.Now().Add(time.Hour))
+	l.sweep()
+	if _, ok := l.buckets["ip:192.0.2.1"]; ok {
+		t.Error("a refilled bucket seen today was kept")
+	}
+	if _, ok := l.buckets["ip:192.0.2.2"]; !ok {
+		t.Error("a bucket that has not refilled was dropped")
+	}
+	if got := l.usage("ip:192.0.2.1", time.Now()).RequestsToday; got != 1 {
+		t.Errorf("requests today = %d after the sweep, want 1", got)
+	}
+}
+
+func TestRateLimiterBounded(t *testing.T) {
+	now := time.Now()
+	l := NewRateLimiter(&RateLimitConfig{RPS: 0.001, Burst: 1})
+	for i := 0; i < maxRateLimitClients; i++ {
+		l.allow(fmt.Sprintf("ip:%d", i), now)
+	}
+	// Every bucket is empty, so newcomers share the overflow entry.
+	if allowed, _, _ := l.allow("ip:new-1", now); !allowed {
+		t.Error("the first client over the limit was refused")
+	}
+	if allowed, _, _ := l.allow("ip:new-2", now); allowed {
+		t.Error("a second client over the limit did not share the overflow bucket")
+	}
+	if len(l.buckets) > maxRateLimitClients {
+		t.Errorf("%d buckets, want at most %d", len(l.buckets), maxRateLimitClients)
+	}
+	// The counts table holds the overflow entry on top of its clients.
+	if len(l.counts) > maxRateLimitClients+1 {
+		t.Errorf("%d counts, want at most %d", len(l.counts), maxRateLimitClients+1)
+	}
+
+	// Once buckets have refilled, a newcomer takes the place of one.
+	for _, bucket := range l.buckets {
+		bucket.tokens = 1
+	}
+	l.counts = make(map[string]*dayCount)
+	if allowed, _, _ := l.allow("ip:new-3", now); !allowed {
+		t.Error("a newcomer was refused although a refilled bucket could be dropped")
+	}
+	if _, ok := l.buckets["ip:new-3"]; !ok || len(l.buckets) != maxRateLimitClients {
+		t.Errorf("newcomer has its own bucket %v with %d buckets, want true with %d", ok, len(l.buckets), maxRateLimitClients)
+	}
+}
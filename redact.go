@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Redaction modes for sentence bodies that end up in log lines, selected
+// with LOG_REDACTION.
+const (
+	redactOff      = "off"
+	redactHash     = "hash"
+	redactTruncate = "truncate"
+	redactDrop     = "drop"
+
+	defaultRedactTruncate = 32
+)
+
+// Redactor rewrites log output so request text never reaches stderr
+// verbatim. Sentence bodies are replaced according to the mode, and any
+// match of the optional pattern is masked regardless of mode.
+type Redactor struct {
+	mode     string
+	truncate int
+	pattern  *regexp.Regexp
+}
+
+func newRedactorFromEnv() (*Redactor, error) {
+	r := &Redactor{mode: redactHash, truncate: defaultRedactTruncate}
+	if mode := os.Getenv("LOG_REDACTION"); mode != "" {
+		r.mode = mode
+	}
+	switch r.mode {
+	case redactOff, redactHash, redactTruncate, redactDrop:
+	default:
+		return nil, fmt.Errorf("LOG_REDACTION %q must be one of off, hash, truncate, drop", r.mode)
+	}
+	if n := os.Getenv("LOG_REDACTION_TRUNCATE"); n != "" {
+		length, err := strconv.Atoi(n)
+		if err != nil || length < 0 {
+			return nil, fmt.Errorf("LOG_REDACTION_TRUNCATE %q must be a non-negative integer", n)
+		}
+		r.truncate = length
+	}
+	if expr := os.Getenv("LOG_REDACTION_REGEX"); expr != "" {
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("LOG_REDACTION_REGEX is invalid: %v", err)
+		}
+		r.pattern = pattern
+	}
+	return r, nil
+}
+
+// logRedactor falls back to hashing if the environment is invalid; startup
+// validation reports the error and refuses to start in that case.
+var logRedactor = func() *Redactor {
+	r, err := newRedactorFromEnv()
+	if err != nil {
+		return &Redactor{mode: redactHash, truncate: defaultRedactTruncate}
+	}
+	return r
+}()
+
+func (r *Redactor) sentence(text string) string {
+	switch r.mode {
+	case redactHash:
+		sum := sha256.Sum256([]byte(text))
+		return "[sha256:" + hex.EncodeToString(sum[:6]) + "]"
+	case redactTruncate:
+		runes := []rune(text)
+		if len(runes) <= r.truncate {
+			return text
+		}
+		return fmt.Sprintf("%s…[+%d chars]", string(runes[:r.truncate]), len(runes)-r.truncate)
+	case redactDrop:
+		return "[redacted]"
+	}
+	return text
+}
+
+// Redact rewrites every occurrence of the given sentence bodies in s and
+// masks pattern matches.
+func (r *Redactor) Redact(s string, sentences ...string) string {
+	if r.mode != redactOff && len(sentences) > 0 {
+		// Longest first, so a sentence that contains another is replaced whole.
+		ordered := append([]string(nil), sentences...)
+		sort.Slice(ordered, func(i, j int) bool { return len(ordered[i]) > len(ordered[j]) })
+		for _, sentence := range ordered {
+			if sentence != "" {
+				s = strings.ReplaceAll(s, sentence, r.sentence(sentence))
+			}
+		}
+	}
+	if r.pattern != nil {
+		s = r.pattern.ReplaceAllString(s, "[redacted]")
+	}
+	return s
+}
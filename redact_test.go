@@ -0,0 +1,77 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestRedactorRedact(t *testing.T) {
+	tests := []struct {
+		name      string
+		redactor  *Redactor
+		log       string
+		sentences []string
+		want      string
+	}{
+		{"off", &Redactor{mode: redactOff}, `scoring "hello"`, []string{"hello"}, `scoring "hello"`},
+		{"hash", &Redactor{mode: redactHash}, `scoring "hello"`, []string{"hello"}, `scoring "[sha256:2cf24dba5fb0]"`},
+		{"truncate", &Redactor{mode: redactTruncate, truncate: 3}, "a=héllo wörld", []string{"héllo wörld"}, "a=hél…[+8 chars]"},
+		{"truncate short", &Redactor{mode: redactTruncate, truncate: 32}, "a=hello", []string{"hello"}, "a=hello"},
+		{"truncate to nothing", &Redactor{mode: redactTruncate, truncate: 0}, "a=hi", []string{"hi"}, "a=…[+2 chars]"},
+		{"drop", &Redactor{mode: redactDrop}, "a=hello b=world", []string{"hello", "world"}, "a=[redacted] b=[redacted]"},
+		{"every occurrence", &Redactor{mode: redactDrop}, "hello, hello", []string{"hello"}, "[redacted], [redacted]"},
+		{"longest first", &Redactor{mode: redactDrop}, "a=hello world", []string{"hello", "hello world"}, "a=[redacted]"},
+		{"empty sentence ignored", &Redactor{mode: redactDrop}, "a=b", []string{""}, "a=b"},
+		{"no sentences", &Redactor{mode: redactDrop}, "a=b", nil, "a=b"},
+		{
+			"pattern with mode off",
+			&Redactor{mode: redactOff, pattern: regexp.MustCompile(`\d{3}-\d{4}`)},
+			"call 555-1234", []string{"call 555-1234"}, "call [redacted]",
+		},
+		{
+			"pattern after sentences",
+			&Redactor{mode: redactTruncate, truncate: 20, pattern: regexp.MustCompile(`[\w.]+@[\w.]+`)},
+			"mail bob@example.com", []string{"bob@example.com"}, "mail [redacted]",
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.redactor.Redact(tt.log, tt.sentences...); got != tt.want {
+			t.Errorf("%s: Redact(%q) = %q, want %q", tt.name, tt.log, got, tt.want)
+		}
+	}
+}
+
+func TestNewRedactorFromEnv(t *testing.T) {
+	tests := []struct {
+		mode, truncate, regex string
+		wantMode              string
+		wantTruncate          int
+		wantErr               bool
+	}{
+		{"", "", "", redactHash, defaultRedactTruncate, false},
+		{"drop", "", "", redactDrop, defaultRedactTruncate, false},
+		{"truncate", "8", "", redactTruncate, 8, false},
+		{"off", "0", `\d+`, redactOff, 0, false},
+		{"mask", "", "", "", 0, true},
+		{"truncate", "-1", "", "", 0, true},
+		{"truncate", "many", "", "", 0, true},
+		{"hash", "", "(", "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Setenv("LOG_REDACTION", tt.mode)
+		t.Setenv("LOG_REDACTION_TRUNCATE", tt.truncate)
+		t.Setenv("LOG_REDACTION_REGEX", tt.regex)
+		r, err := newRedactorFromEnv()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("LOG_REDACTION=%q LOG_REDACTION_TRUNCATE=%q LOG_REDACTION_REGEX=%q: error = %v, want error %v",
+				tt.mode, tt.truncate, tt.regex, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if r.mode != tt.wantMode || r.truncate != tt.wantTruncate || (r.pattern != nil) != (tt.regex != "") {
+			t.Errorf("LOG_REDACTION=%q: got mode %q truncate %d pattern %v", tt.mode, r.mode, r.truncate, r.pattern)
+		}
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"container/list"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"text-similarity-api/similarity"
+)
+
+const (
+	maxResultRetention         = 90 * 24 * time.Hour
+	maxStoredResults           = 10000
+	defaultMaxResultsPerClient = 100
+)
+
+// Who may read a stored result, selected with RESULT_ACCESS.
+const (
+	// resultAccessLink lets anyone holding a valid link read the result.
+	resultAccessLink = "link"
+	// resultAccessKey also requires one of the RATE_LIMIT_KEYS.
+	resultAccessKey = "key"
+	// resultAccessOwner requires the API key that stored the result.
+	resultAccessOwner = "owner"
+)
+
+// StoredResult is a comparison kept so it can be shared by link. It holds
+// the inputs, so whoever RESULT_ACCESS lets open the link can read them.
+type StoredResult struct {
+	ID         string                 `json:"id"`
+	Sentence1  string                 `json:"sentence1"`
+	Sentence2  string                 `json:"sentence2"`
+	Similarity float64                `json:"similarity"`
+	Method     string                 `json:"method,omitempty"`
+	Unit       string                 `json:"unit,omitempty"`
+	Removals   []similarity.Removal   `json:"removals,omitempty"`
+	Spans      []similarity.SpanMatch `json:"spans,omitempty"`
+	CreatedAt  time.Time              `json:"created_at"`
+	ExpiresAt  time.Time              `json:"expires_at"`
+	// owner is the client that stored the result, as identified by the
+	// rate limiter.
+	owner string
+}
+
+// PersistedResult is what a request with persist set gets back.
+type PersistedResult struct {
+	ID        string `json:"id"`
+	URL       string `json:"url"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// ResultConfig controls persistence. A zero Retention, the default,
+// turns it off.
+type ResultConfig struct {
+	Retention time.Duration
+	// MaxPerClient bounds the results one client keeps; storing another
+	// evicts its oldest.
+	MaxPerClient int
+	// Access is one of the resultAccess modes.
+	Access string
+	// SigningKey signs share links. It is required, so that links survive
+	// a restart and every replica accepts them.
+	SigningKey []byte
+	// BaseURL prefixes share links, e.g. https://similarity.example.com.
+	BaseURL string
+}
+
+// resultConfigFromEnv reads RESULT_RETENTION, RESULT_MAX_PER_CLIENT,
+// RESULT_ACCESS, RESULT_SIGNING_KEY and RESULT_BASE_URL. The others are
+// only checked when RESULT_RETENTION turns persistence on.
+func resultConfigFromEnv() (ResultConfig, error) {
+	config := ResultConfig{MaxPerClient: defaultMaxResultsPerClient, Access: resultAccessLink}
+	if raw := os.Getenv("RESULT_RETENTION"); raw != "" {
+		retention, err := time.ParseDuration(raw)
+		if err != nil || retention < 0 || retention > maxResultRetention {
+			return ResultConfig{}, fmt.Errorf("RESULT_RETENTION %q must be a duration between 0 and %s", raw, maxResultRetention)
+		}
+		config.Retention = retention
+	}
+	if config.Retention == 0 {
+		return ResultConfig{}, nil
+	}
+	if raw := os.Getenv("RESULT_MAX_PER_CLIENT"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxStoredResults {
+			return ResultConfig{}, fmt.Errorf("RESULT_MAX_PER_CLIENT %q must be an integer between 1 and %d", raw, maxStoredResults)
+		}
+		config.MaxPerClient = n
+	}
+	if access := os.Getenv("RESULT_ACCESS"); access != "" {
+		config.Access = access
+	}
+	switch config.Access {
+	case resultAccessLink:
+	case resultAccessKey, resultAccessOwner:
+		if limits, err := rateLimitFromEnv(); err != nil || limits == nil || limits.KeyHeader == "" {
+			return ResultConfig{}, fmt.Errorf("RESULT_ACCESS=%s needs API keys: set RATE_LIMIT=on, RATE_LIMIT_KEY_HEADER and RATE_LIMIT_KEYS", config.Access)
+		}
+	default:
+		return ResultConfig{}, fmt.Errorf("RESULT_ACCESS %q must be one of link, key, owner", config.Access)
+	}
+	key := os.Getenv("RESULT_SIGNING_KEY")
+	if len(key) < 32 {
+		return ResultConfig{}, fmt.Errorf("RESULT_SIGNING_KEY of at least 32 characters is required with RESULT_RETENTION")
+	}
+	config.SigningKey = []byte(key)
+	if raw := os.Getenv("RESULT_BASE_URL"); raw != "" {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return ResultConfig{}, fmt.Errorf("RESULT_BASE_URL %q must be an http or https URL", raw)
+		}
+		config.BaseURL = strings.TrimSuffix(raw, "/")
+	}
+	return config, nil
+}
+
+// ResultStore keeps results in memory, oldest first. Every result lives
+// for the same retention, so the oldest is also the first to expire.
+type ResultStore struct {
+	config  ResultConfig
+	mu      sync.Mutex
+	order   *list.List
+	results map[string]*list.Element
+	// owned lists each client's result IDs, oldest first.
+	owned map[string][]string
+}
+
+func NewResultStore(config ResultConfig) *ResultStore {
+	return &ResultStore{
+		config:  config,
+		order:   list.New(),
+		results: make(map[string]*list.Element),
+		owned:   make(map[string][]string),
+	}
+}
+
+// resultStore has persistence off when the environment is invalid;
+// startup validation refuses to start in that case.
+var resultStore = func() *ResultStore {
+	config, _ := resultConfigFromEnv()
+	return NewResultStore(config)
+}()
+
+func (s *ResultStore) Enabled() bool {
+	return s.config.Retention > 0
+}
+
+// Save stores result for owner with a new ID and returns its share link.
+// A full store, or an owner at its limit, makes room by evicting the
+// oldest result, so storing never fails for lack of space.
+func (s *ResultStore) Save(result StoredResult, owner string) (PersistedResult, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return PersistedResult{}, err
+	}
+	now := time.Now().Truncate(time.Second)
+	result.ID, result.CreatedAt, result.ExpiresAt = id, now.UTC(), now.Add(s.config.Retention).UTC()
+	result.owner = owner
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if owned := s.owned[owner]; len(owned) >= s.config.MaxPerClient {
+		s.remove(owned[0])
+	}
+	if len(s.results) >= maxStoredResults {
+		s.remove(s.order.Front().Value.(*StoredResult).ID)
+	}
+	s.results[id] = s.order.PushBack(&result)
+	s.owned[owner] = append(s.owned[owner], id)
+	return PersistedResult{
+		ID:        id,
+		URL:       s.link(id, result.ExpiresAt),
+		ExpiresAt: result.ExpiresAt.Format(time.RFC3339),
+	}, nil
+}
+
+// remove deletes a result. s.mu must be held.
+func (s *ResultStore) remove(id string) bool {
+	elem, ok := s.results[id]
+	if !ok {
+		return false
+	}
+	result := s.order.Remove(elem).(*StoredResult)
+	delete(s.results, id)
+	owned := s.owned[result.owner]
+	for i, other := range owned {
+		if other == id {
+			owned = append(owned[:i], owned[i+1:]...)
+			break
+		}
+	}
+	if len(owned) == 0 {
+		delete(s.owned, result.owner)
+	} else {
+		s.owned[result.owner] = owned
+	}
+	return true
+}
+
+func (s *ResultStore) link(id string, expires time.Time) string {
+	unix := strconv.FormatInt(expires.Unix(), 10)
+	return fmt.Sprintf("%s/api/v1/results/%s?expires=%s&signature=%s", s.config.BaseURL, id, unix, s.sign(id, unix))
+}
+
+func (s *ResultStore) sign(id, expires string) string {
+	mac := hmac.New(sha256.New, s.config.SigningKey)
+	mac.Write([]byte(id + "\x00" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Get returns the result if signature signs id and expires and neither
+// the link nor the result has expired. It reports whether the signature
+// was valid, so a forged link can be told apart from an expired one.
+func (s *ResultStore) Get(id, expires, signature string) (*StoredResult, bool) {
+	if !hmac.Equal([]byte(signature), []byte(s.sign(id, expires))) {
+		return nil, false
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return nil, true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elem, ok := s.results[id]
+	if !ok {
+		return nil, true
+	}
+	result := elem.Value.(*StoredResult)
+	if time.Now().After(result.ExpiresAt) {
+		return nil, true
+	}
+	return result, true
+}
+
+func (s *ResultStore) Delete(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.remove(id)
+}
+
+func (s *ResultStore) sweep() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for elem := s.order.Front(); elem != nil; elem = s.order.Front() {
+		result := elem.Value.(*StoredResult)
+		if !now.After(result.ExpiresAt) {
+			break
+		}
+		s.remove(result.ID)
+	}
+}
+
+// StartJanitor removes expired results once a minute.
+func (s *ResultStore) StartJanitor() {
+	go func() {
+		for range time.Tick(time.Minute) {
+			s.sweep()
+		}
+	}()
+}
+
+func resultPersistenceStatus() string {
+	if !resultStore.Enabled() {
+		return "disabled"
+	}
+	return resultStore.config.Retention.String()
+}
+
+// persistResult saves result for a request that asked for it. It writes
+// an error response and returns false if persistence is off, or if
+// results need an API key and the request has none.
+func persistResult(c *gin.Context, result StoredResult) (*PersistedResult, bool) {
+	if !resultStore.Enabled() {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "result persistence is disabled on this server",
+		})
+		return nil, false
+	}
+	if resultStore.config.Access != resultAccessLink {
+		if _, ok := rateLimiter.apiKey(c); !ok {
+			respondResultKeyRequired(c)
+			return nil, false
+		}
+	}
+	persisted, err := resultStore.Save(result, rateLimiter.clientKey(c))
+	if err != nil {
+		respond(c, http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to store the result",
+		})
+		return nil, false
+	}
+	return &persisted, true
+}
+
+func respondResultKeyRequired(c *gin.Context) {
+	respond(c, http.StatusUnauthorized, ErrorResponse{
+		Error:   "unauthorized",
+		Message: fmt.Sprintf("Stored results on this server need an API key in %s", rateLimiter.config.KeyHeader),
+	})
+}
+
+// mayRead applies RESULT_ACCESS to a request with a validly signed link.
+func (s *ResultStore) mayRead(c *gin.Context, result *StoredResult) bool {
+	switch s.config.Access {
+	case resultAccessKey:
+		_, ok := rateLimiter.apiKey(c)
+		return ok
+	case resultAccessOwner:
+		_, ok := rateLimiter.apiKey(c)
+		return ok && rateLimiter.clientKey(c) == result.owner
+	}
+	return true
+}
+
+var resultPage = template.Must(template.New("result").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Similarity {{printf "%.3f" .Similarity}}</title>
+<style>
+body { font-family: sans-serif; max-width: 48rem; margin: 2rem auto; padding: 0 1rem; color: #222; }
+blockquote { margin: 0 0 1rem; padding: .5rem 1rem; background: #f4f4f4; white-space: pre-wrap; }
+table { border-collapse: collapse; } td, th { padding: .25rem .75rem; text-align: left; border-bottom: 1px solid #ddd; }
+.meta { color: #666; font-size: .9rem; }
+</style>
+</head>
+<body>
+<h1>Similarity {{printf "%.3f" .Similarity}}</h1>
+<p class="meta">{{if .Method}}Scored with {{.Method}}. {{end}}Created {{.CreatedAt.Format "2006-01-02 15:04 UTC"}}, available until {{.ExpiresAt.Format "2006-01-02 15:04 UTC"}}.</p>
+<h2>Sentence 1</h2>
+<blockquote>{{.Sentence1}}</blockquote>
+<h2>Sentence 2</h2>
+<blockquote>{{.Sentence2}}</blockquote>
+{{if .Removals}}<h2>Explanation</h2>
+<p class="meta">Similarity after removing each {{.Unit}}. A positive contribution means the {{.Unit}} supports the match.</p>
+<table><tr><th>{{.Unit}}</th><th>Score without it</th><th>Contribution</th></tr>
+{{range .Removals}}<tr><td>{{.Text}}</td><td>{{printf "%.3f" .Score}}</td><td>{{printf "%+.3f" .Delta}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Spans}}<h2>Matching passages</h2>
+<table><tr><th>Sentence 1</th><th>Sentence 2</th><th>Similarity</th></tr>
+{{range .Spans}}<tr><td>{{.Span1.Text}}</td><td>{{.Span2.Text}}</td><td>{{printf "%.3f" .Similarity}}</td></tr>
+{{end}}</table>{{end}}
+</body>
+</html>
+`))
+
+// handleGetResult renders a shared result as HTML, or as JSON for clients
+// that ask for it.
+func handleGetResult(c *gin.Context) {
+	result, signed := resultStore.Get(c.Param("id"), c.Query("expires"), c.Query("signature"))
+	if !signed {
+		respond(c, http.StatusForbidden, ErrorResponse{
+			Error:   "invalid_signature",
+			Message: "The link is not valid",
+		})
+		return
+	}
+	if result == nil {
+		respond(c, http.StatusNotFound, ErrorResponse{
+			Error:   "not_found",
+			Message: "The result has expired or was deleted",
+		})
+		return
+	}
+	if !resultStore.mayRead(c, result) {
+		if _, ok := rateLimiter.apiKey(c); !ok {
+			respondResultKeyRequired(c)
+			return
+		}
+		respond(c, http.StatusForbidden, ErrorResponse{
+			Error:   "forbidden",
+			Message: "Only the API key that stored this result can read it",
+		})
+		return
+	}
+	c.Header("Cache-Control", "private, no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+	if c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		c.JSON(http.StatusOK, result)
+		return
+	}
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := resultPage.Execute(c.Writer, result); err != nil {
+		c.Error(err)
+	}
+}
+
+// handleDeleteResult revokes a result before it expires, which
+// invalidates every link to it.
+func handleDeleteResult(c *gin.Context) {
+	if !resultStore.Delete(c.Param("id")) {
+		respond(c, http.StatusNotFound, ErrorResponse{
+			Error:   "not_found",
+			Message: "No such result",
+		})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSigningKey = "0123456789abcdef0123456789abcdef"
+
+func newTestResultStore(maxPerClient int) *ResultStore {
+	return NewResultStore(ResultConfig{
+		Retention:    time.Hour,
+		MaxPerClient: maxPerClient,
+		Access:       resultAccessLink,
+		SigningKey:   []byte(testSigningKey),
+	})
+}
+
+// linkParams splits a share link into its ID, expiry and signature.
+func linkParams(t *testing.T, link string) (id, expires, signature string) {
+	t.Helper()
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimPrefix(u.Path, "/api/v1/results/"), u.Query().Get("expires"), u.Query().Get("signature")
+}
+
+func TestResultConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    ResultConfig
+		wantErr bool
+	}{
+		{"off by default", map[string]string{}, ResultConfig{}, false},
+		{"explicitly off", map[string]string{"RESULT_RETENTION": "0"}, ResultConfig{}, false},
+		{"on", map[string]string{"RESULT_RETENTION": "24h", "RESULT_SIGNING_KEY": testSigningKey},
+			ResultConfig{Retention: 24 * time.Hour, MaxPerClient: defaultMaxResultsPerClient, Access: resultAccessLink}, false},
+		{"per client", map[string]string{"RESULT_RETENTION": "1h", "RESULT_SIGNING_KEY": testSigningKey, "RESULT_MAX_PER_CLIENT": "5"},
+			ResultConfig{Retention: time.Hour, MaxPerClient: 5, Access: resultAccessLink}, false},
+		{"no signing key", map[string]string{"RESULT_RETENTION": "1h"}, ResultConfig{}, true},
+		{"short signing key", map[string]string{"RESULT_RETENTION": "1h", "RESULT_SIGNING_KEY": "short"}, ResultConfig{}, true},
+		{"retention too long", map[string]string{"RESULT_RETENTION": "2400h", "RESULT_SIGNING_KEY": testSigningKey}, ResultConfig{}, true},
+		{"bad per client", map[string]string{"RESULT_RETENTION": "1h", "RESULT_SIGNING_KEY": testSigningKey, "RESULT_MAX_PER_CLIENT": "0"}, ResultConfig{}, true},
+		{"bad access", map[string]string{"RESULT_RETENTION": "1h", "RESULT_SIGNING_KEY": testSigningKey, "RESULT_ACCESS": "public"}, ResultConfig{}, true},
+		{"owner access without keys", map[string]string{"RESULT_RETENTION": "1h", "RESULT_SIGNING_KEY": testSigningKey, "RESULT_ACCESS": "owner"}, ResultConfig{}, true},
+		{"owner access", map[string]string{"RESULT_RETENTION": "1h", "RESULT_SIGNING_KEY": testSigningKey, "RESULT_ACCESS": "owner",
+			"RATE_LIMIT": "on", "RATE_LIMIT_KEY_HEADER": "X-API-Key", "RATE_LIMIT_KEYS": "k1"},
+			ResultConfig{Retention: time.Hour, MaxPerClient: defaultMaxResultsPerClient, Access: resultAccessOwner}, false},
+	}
+	for _, tt := range tests {
+		for _, name := range []string{"RESULT_RETENTION", "RESULT_SIGNING_KEY", "RESULT_MAX_PER_CLIENT", "RESULT_ACCESS", "RESULT_BASE_URL", "RATE_LIMIT", "RATE_LIMIT_KEY_HEADER", "RATE_LIMIT_KEYS"} {
+			t.Setenv(name, tt.env[name])
+		}
+		got, err := resultConfigFromEnv()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got.Retention != tt.want.Retention || got.MaxPerClient != tt.want.MaxPerClient || got.Access != tt.want.Access {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestResultSigning(t *testing.T) {
+	s := newTestResultStore(10)
+	persisted, err := s.Save(StoredResult{Sentence1: "a", Sentence2: "b", Similarity: 0.5}, "ip:192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, expires, signature := linkParams(t, persisted.URL)
+	later := strconv.FormatInt(time.Now().Add(48*time.Hour).Unix(), 10)
+	other := NewResultStore(ResultConfig{Retention: time.Hour, SigningKey: []byte(strings.Repeat("x", 32))})
+
+	tests := []struct {
+		name                   string
+		id, expires, signature string
+		wantSigned, wantFound  bool
+	}{
+		{"valid link", id, expires, signature, true, true},
+		{"no signature", id, expires, "", false, false},
+		{"altered signature", id, expires, strings.Repeat("0", len(signature)), false, false},
+		{"extended expiry", id, later, signature, false, false},
+		{"other ID", strings.Repeat("f", len(id)), expires, signature, false, false},
+		{"signed for an unknown ID", "missing", expires, s.sign("missing", expires), true, false},
+		{"signed with another key", id, expires, other.sign(id, expires), false, false},
+	}
+	for _, tt := range tests {
+		result, signed := s.Get(tt.id, tt.expires, tt.signature)
+		if signed != tt.wantSigned || (result != nil) != tt.wantFound {
+			t.Errorf("%s: signed %v found %v, want %v and %v", tt.name, signed, result != nil, tt.wantSigned, tt.wantFound)
+		}
+	}
+}
+
+func TestResultExpiry(t *testing.T) {
+	s := newTestResultStore(10)
+	persisted, _ := s.Save(StoredResult{Sentence1: "a", Sentence2: "b"}, "ip:192.0.2.1")
+	id, _, _ := linkParams(t, persisted.URL)
+
+	past := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	if result, signed := s.Get(id, past, s.sign(id, past)); !signed || result != nil {
+		t.Errorf("expired link: signed %v found %v, want true and false", signed, result != nil)
+	}
+
+	// A result past its retention is gone even with a link that has
+	// not expired, and the sweep forgets it.
+	s.results[id].Value.(*StoredResult).ExpiresAt = time.Now().Add(-time.Second)
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	if result, _ := s.Get(id, future, s.sign(id, future)); result != nil {
+		t.Error("a result past its retention was returned")
+	}
+	s.sweep()
+	if len(s.results) != 0 || s.order.Len() != 0 || len(s.owned) != 0 {
+		t.Errorf("sweep left %d results, %d ordered and %d owners", len(s.results), s.order.Len(), len(s.owned))
+	}
+}
+
+func TestResultStoreEviction(t *testing.T) {
+	s := newTestResultStore(2)
+	var ids []string
+	for i := 0; i < 3; i++ {
+		persisted, err := s.Save(StoredResult{Sentence1: strconv.Itoa(i)}, "ip:192.0.2.1")
+		if err != nil {
+			t.Fatalf("save %d: %v", i, err)
+		}
+		ids = append(ids, persisted.ID)
+	}
+	if _, ok := s.results[ids[0]]; ok {
+		t.Error("the client's oldest result was kept past its limit")
+	}
+	if len(s.owned["ip:192.0.2.1"]) != 2 {
+		t.Errorf("client holds %d results, want 2", len(s.owned["ip:192.0.2.1"]))
+	}
+
+	// A full store evicts its oldest result instead of refusing.
+	s = newTestResultStore(maxStoredResults)
+	first, _ := s.Save(StoredResult{}, "ip:192.0.2.1")
+	for i := 1; i < maxStoredResults; i++ {
+		s.Save(StoredResult{}, "ip:192.0.2."+strconv.Itoa(2+i%200))
+	}
+	if _, err := s.Save(StoredResult{}, "ip:192.0.2.250"); err != nil {
+		t.Fatalf("save into a full store: %v", err)
+	}
+	if len(s.results) != maxStoredResults || s.order.Len() != maxStoredResults {
+		t.Errorf("store holds %d results, want %d", len(s.results), maxStoredResults)
+	}
+	if _, ok := s.results[first.ID]; ok {
+		t.Error("the oldest result survived a save into a full store")
+	}
+	if _, ok := s.owned["ip:192.0.2.1"]; ok {
+		t.Error("an owner without results is still listed")
+	}
+}
+
+func TestResultAccess(t *testing.T) {
+	t.Setenv("RATE_LIMIT", "on")
+	t.Setenv("TRUSTED_PROXIES", "none")
+	t.Setenv("RATE_LIMIT_KEY_HEADER", "X-API-Key")
+	t.Setenv("RATE_LIMIT_KEYS", "alice-key,bob-key")
+	limits, err := rateLimitFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedLimiter, savedStore := rateLimiter, resultStore
+	defer func() { rateLimiter, resultStore = savedLimiter, savedStore }()
+	rateLimiter = NewRateLimiter(limits)
+	r := newRouter()
+
+	tests := []struct {
+		access   string
+		storeKey string
+		readKey  string
+		wantSave int
+		wantRead int
+	}{
+		{resultAccessLink, "", "", http.StatusOK, http.StatusOK},
+		{resultAccessKey, "", "", http.StatusUnauthorized, 0},
+		{resultAccessKey, "alice-key", "", http.StatusOK, http.StatusUnauthorized},
+		{resultAccessKey, "alice-key", "bob-key", http.StatusOK, http.StatusOK},
+		{resultAccessKey, "alice-key", "made-up", http.StatusOK, http.StatusUnauthorized},
+		{resultAccessOwner, "alice-key", "bob-key", http.StatusOK, http.StatusForbidden},
+		{resultAccessOwner, "alice-key", "alice-key", http.StatusOK, http.StatusOK},
+	}
+	for _, tt := range tests {
+		resultStore = NewResultStore(ResultConfig{Retention: time.Hour, MaxPerClient: 10, Access: tt.access, SigningKey: []byte(testSigningKey)})
+		w := do(t, r, http.MethodPost, "/api/v1/similarity",
+			`{"sentence1": "a b c", "sentence2": "b c d", "method": "jaccard", "persist": true}`, "X-API-Key", tt.storeKey)
+		if w.Code != tt.wantSave {
+			t.Errorf("%s, stored with %q: status %d, want %d: %s", tt.access, tt.storeKey, w.Code, tt.wantSave, w.Body)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var resp SimilarityResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Result == nil {
+			t.Fatalf("%s: no stored result in %s", tt.access, w.Body)
+		}
+		w = do(t, r, http.MethodGet, resp.Result.URL, "", "X-API-Key", tt.readKey, "Accept", "application/json")
+		if w.Code != tt.wantRead {
+			t.Errorf("%s, stored with %q, read with %q: status %d, want %d: %s", tt.access, tt.storeKey, tt.readKey, w.Code, tt.wantRead, w.Body)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"text-similarity-api/similarity"
+)
+
+const (
+	// backendErrorKey holds the error logBackendError last saw for the
+	// request, so the error response can say whether a retry may help.
+	backendErrorKey = "backend_error"
+	// defaultRetryAfterSeconds is sent with retryable errors that have no
+	// better estimate of when the condition clears.
+	defaultRetryAfterSeconds = 5
+)
+
+// retryableStatus reports whether a status means the same request may
+// succeed later: the client was throttled, or the server or an upstream
+// was unavailable.
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryableBackendError reports whether a failed backend call may succeed
+// if repeated. Timeouts, crashes, an unreachable model server and failed
+// hooks can clear up; an out-of-memory kill or a panic would recur for the
+// same input.
+func retryableBackendError(err error) bool {
+	var process *similarity.ProcessFailure
+	if errors.As(err, &process) {
+		return process.Class != similarity.FailureOOM
+	}
+	var panicked *similarity.PanicError
+	if errors.As(err, &panicked) || errors.Is(err, errPanicked) {
+		return false
+	}
+	return !errors.Is(err, similarity.ErrEmptyInput)
+}
+
+// markRetryable fills in whether an error response is worth retrying and,
+// if it is, makes sure Retry-After is set. Handlers that know better, such
+// as the rate limiter, set Retry-After themselves first.
+func markRetryable(c *gin.Context, code int, errResp ErrorResponse) ErrorResponse {
+	if !errResp.Retryable {
+		errResp.Retryable = retryableStatus(code)
+	}
+	if value, ok := c.Get(backendErrorKey); ok && !errResp.Retryable && code >= 500 {
+		errResp.Retryable = retryableBackendError(value.(error))
+	}
+	if errResp.Retryable && c.Writer.Header().Get("Retry-After") == "" {
+		c.Header("Retry-After", strconv.Itoa(defaultRetryAfterSeconds))
+	}
+	return errResp
+}
+
+// handleNoRoute answers unknown paths with an ErrorResponse, like every
+// other error.
+func handleNoRoute(c *gin.Context) {
+	respond(c, http.StatusNotFound, ErrorResponse{
+		Error:   "not_found",
+		Message: "No such endpoint; see /docs",
+	})
+}
+
+// recoverPanic logs and counts a panic in a request's handler and answers
+// the request. gin.CustomRecovery calls it from its deferred recover, so
+// the stack still shows where the panic happened.
+func recoverPanic(c *gin.Context, recovered interface{}) {
+	panicCounts.Add(panicSiteRequest)
+	slog.Error("Recovered panic", "site", panicSiteRequest, "request_id", requestID(c),
+		"route", c.FullPath(), "panic", fmt.Sprint(recovered), "stack", string(debug.Stack()))
+	respond(c, http.StatusInternalServerError, ErrorResponse{
+		Error:   "internal_error",
+		Message: "The server hit an unexpected error",
+	})
+	c.Abort()
+}
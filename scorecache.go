@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"text-similarity-api/similarity"
+)
+
+const (
+	defaultScoreCacheSize = 10000
+	defaultScoreCacheTTL  = time.Hour
+)
+
+// ScoreCacheConfig sizes the cache of model pair scores. With a RedisURL
+// the scores are kept in Redis instead of in memory, and Size only turns
+// the cache on or off; Redis's own memory policy bounds it.
+type ScoreCacheConfig struct {
+	Size     int
+	TTL      time.Duration
+	RedisURL string
+}
+
+// scoreCacheFromEnv reads SCORE_CACHE_SIZE, where 0 turns the cache off,
+// SCORE_CACHE_TTL, where 0 keeps entries until they are evicted, and
+// SCORE_CACHE_REDIS_URL.
+func scoreCacheFromEnv() (ScoreCacheConfig, error) {
+	config := ScoreCacheConfig{Size: defaultScoreCacheSize, TTL: defaultScoreCacheTTL}
+	if raw := os.Getenv("SCORE_CACHE_SIZE"); raw != "" {
+		size, err := strconv.Atoi(raw)
+		if err != nil || size < 0 {
+			return config, fmt.Errorf("SCORE_CACHE_SIZE %q must be a non-negative integer", raw)
+		}
+		config.Size = size
+	}
+	if raw := os.Getenv("SCORE_CACHE_TTL"); raw != "" {
+		ttl, err := time.ParseDuration(raw)
+		if err != nil || ttl < 0 {
+			return config, fmt.Errorf("SCORE_CACHE_TTL %q must be a non-negative duration such as 30m", raw)
+		}
+		config.TTL = ttl
+	}
+	if raw := os.Getenv("SCORE_CACHE_REDIS_URL"); raw != "" {
+		if _, err := similarity.NewRedisCache(raw); err != nil {
+			return config, fmt.Errorf("SCORE_CACHE_REDIS_URL: %v", err)
+		}
+		config.RedisURL = raw
+	}
+	return config, nil
+}
+
+// At most one of scoreCache and redisScoreCache is set; both are nil when
+// caching is off. An invalid environment uses the in-memory defaults;
+// startup validation refuses to start in that case.
+var scoreCache, redisScoreCache = func() (*similarity.LRUCache, *similarity.RedisCache) {
+	config, err := scoreCacheFromEnv()
+	if err != nil {
+		config = ScoreCacheConfig{Size: defaultScoreCacheSize, TTL: defaultScoreCacheTTL}
+	}
+	if config.Size == 0 {
+		return nil, nil
+	}
+	if config.RedisURL != "" {
+		redis, _ := similarity.NewRedisCache(config.RedisURL)
+		redis.TTL = config.TTL
+		return nil, redis
+	}
+	return similarity.NewLRUCacheWithTTL(config.Size, config.TTL), nil
+}()
+
+// activeScoreCache is the cache the scorer uses, or nil.
+func activeScoreCache() similarity.Cache {
+	switch {
+	case redisScoreCache != nil:
+		return redisScoreCache
+	case scoreCache != nil:
+		return scoreCache
+	}
+	return nil
+}
+
+func scoreCacheStatus() string {
+	switch {
+	case redisScoreCache != nil:
+		return "redis"
+	case scoreCache != nil:
+		return "enabled"
+	}
+	return "disabled"
+}
+
+func handleGetScoreCache(c *gin.Context) {
+	config, _ := scoreCacheFromEnv()
+	if redisScoreCache != nil {
+		stats := redisScoreCache.Stats()
+		response := gin.H{
+			"enabled":     true,
+			"backend":     "redis",
+			"addr":        redisScoreCache.Addr(),
+			"stats":       stats,
+			"hit_rate":    hitRate(stats.Hits, stats.Misses),
+			"ttl_seconds": config.TTL.Seconds(),
+		}
+		if err := redisScoreCache.Ping(c.Request.Context()); err != nil {
+			response["error"] = err.Error()
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	if scoreCache == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+	stats := scoreCache.Stats()
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":     true,
+		"backend":     "memory",
+		"stats":       stats,
+		"hit_rate":    hitRate(stats.Hits, stats.Misses),
+		"ttl_seconds": config.TTL.Seconds(),
+	})
+}
+
+func hitRate(hits, misses int64) float64 {
+	if lookups := hits + misses; lookups > 0 {
+		return float64(hits) / float64(lookups)
+	}
+	return 0
+}
+
+// handleDeleteScoreCache empties the cache, for example after the model
+// behind the backend changed. A Redis cache is emptied for every replica.
+func handleDeleteScoreCache(c *gin.Context) {
+	if redisScoreCache != nil {
+		if _, err := redisScoreCache.Purge(c.Request.Context()); err != nil {
+			respond(c, http.StatusBadGateway, ErrorResponse{
+				Error:   "cache_error",
+				Message: err.Error(),
+			})
+			return
+		}
+	}
+	if scoreCache != nil {
+		scoreCache.Purge()
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"text-similarity-api/similarity"
+)
+
+type SelfTestResult struct {
+	Name       string `json:"name"`
+	Passed     bool   `json:"passed"`
+	DurationMs int64  `json:"duration_ms"`
+	Detail     string `json:"detail,omitempty"`
+}
+
+type SelfTestReport struct {
+	Passed      bool             `json:"passed"`
+	DurationMs  int64            `json:"duration_ms"`
+	Tests       []SelfTestResult `json:"tests"`
+	ProcessedAt string           `json:"processed_at"`
+}
+
+type selfTest struct {
+	name string
+	run  func() error
+}
+
+var selfTests = []selfTest{
+	{"config", selfTestConfig},
+	{"validation", selfTestValidation},
+	{"native_hashing", selfTestNativeHashing},
+	{"sessions_read_write", selfTestSessions},
+	{"backend_similarity", selfTestBackendSimilarity},
+	{"backend_matrix", selfTestBackendMatrix},
+	{"backend_embeddings", selfTestBackendEmbeddings},
+}
+
+func selfTestConfig() error {
+	for _, check := range validateConfig() {
+		if !check.OK {
+			return fmt.Errorf("%s: %s", check.Name, check.Detail)
+		}
+	}
+	return nil
+}
+
+// selfTestValidation sends a blank pair through the real handler and
+// expects it to be rejected before reaching the backend.
+func selfTestValidation() error {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/similarity", bytes.NewBufferString(`{"sentence1": "  ", "sentence2": "text"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	handleSimilarity(c)
+	if recorder.Code != http.StatusBadRequest {
+		return fmt.Errorf("blank sentence returned status %d, expected 400", recorder.Code)
+	}
+	return nil
+}
+
+func selfTestNativeHashing() error {
+	text := "The self test compares a sentence with itself."
+	if d := similarity.HammingDistance(similarity.SimHash(text), similarity.SimHash(text)); d != 0 {
+		return fmt.Errorf("simhash distance of identical texts is %d", d)
+	}
+	signature := similarity.MinHash(text, similarity.DefaultShingleSize, similarity.DefaultNumHashes)
+	jaccard, err := similarity.JaccardEstimate(signature, signature)
+	if err != nil {
+		return err
+	}
+	if jaccard != 1 {
+		return fmt.Errorf("minhash jaccard of identical texts is %f", jaccard)
+	}
+	return nil
+}
+
+func selfTestSessions() error {
+	store := NewSessionStore()
+	now := time.Now()
+	session := &Session{
+		ID:         "selftest",
+		Sentences:  []string{"self test"},
+		Embeddings: [][]float64{{1, 0}},
+		TTL:        time.Minute,
+		CreatedAt:  now,
+		LastUsedAt: now,
+	}
+	if !store.Add(session) {
+		return fmt.Errorf("session store rejected write")
+	}
+	got, ok := store.Get(session.ID)
+	if !ok || len(got.Sentences) != 1 {
+		return fmt.Errorf("session store did not return written session")
+	}
+	if !store.Delete(session.ID) {
+		return fmt.Errorf("session store did not delete session")
+	}
+	return nil
+}
+
+func selfTestBackendSimilarity() error {
+	sentence := "The self test compares a sentence with itself."
+	result, err := scorer.ScoreDetailed(context.Background(), sentence, sentence)
+	if err != nil {
+		return err
+	}
+	if result.FellBack {
+		return fmt.Errorf("backend failed and the %s fallback answered", fallbackMethod)
+	}
+	if result.Score < 0.99 {
+		return fmt.Errorf("identical sentences scored %.4f", result.Score)
+	}
+	return nil
+}
+
+func selfTestBackendMatrix() error {
+	sentences := []string{"The weather is sunny today.", "Quarterly revenue grew by ten percent."}
+	matrix, err := scorer.Matrix(context.Background(), sentences, sentences)
+	if err != nil {
+		return err
+	}
+	for i := range sentences {
+		if len(matrix[i]) != len(sentences) {
+			return fmt.Errorf("row %d has %d columns, expected %d", i, len(matrix[i]), len(sentences))
+		}
+		if matrix[i][i] < 0.99 {
+			return fmt.Errorf("diagonal entry %d scored %.4f", i, matrix[i][i])
+		}
+	}
+	return nil
+}
+
+func selfTestBackendEmbeddings() error {
+	embeddings, err := scorer.Embed(context.Background(), []string{"first sentence", "second sentence"})
+	if err != nil {
+		return err
+	}
+	if len(embeddings[0]) == 0 || len(embeddings[0]) != len(embeddings[1]) {
+		return fmt.Errorf("embedding dimensions are %d and %d", len(embeddings[0]), len(embeddings[1]))
+	}
+	for _, value := range embeddings[0] {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return fmt.Errorf("embedding contains non-finite values")
+		}
+	}
+	return nil
+}
+
+func runSelfTests() SelfTestReport {
+	start := time.Now()
+	report := SelfTestReport{Passed: true}
+	for _, test := range selfTests {
+		testStart := time.Now()
+		err := test.run()
+		result := SelfTestResult{
+			Name:       test.name,
+			Passed:     err == nil,
+			DurationMs: time.Since(testStart).Milliseconds(),
+		}
+		if err != nil {
+			result.Detail = err.Error()
+			report.Passed = false
+		}
+		report.Tests = append(report.Tests, result)
+	}
+	report.DurationMs = time.Since(start).Milliseconds()
+	report.ProcessedAt = time.Now().UTC().Format(time.RFC3339)
+	return report
+}
+
+func handleSelfTest(c *gin.Context) {
+	report := runSelfTests()
+	status := http.StatusOK
+	if !report.Passed {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"text-similarity-api/similarity"
+)
+
+const (
+	defaultSessionTTL  = 15 * time.Minute
+	maxSessionTTL      = 24 * time.Hour
+	maxSessions        = 1000
+	defaultSessionTopK = 5
+	maxSessionHistory  = 1000
+)
+
+// Session holds a context set of sentences together with their embeddings,
+// so repeated queries only pay for embedding the query itself.
+type Session struct {
+	ID         string
+	Sentences  []string
+	Embeddings [][]float64
+	TTL        time.Duration
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	// Pooling is applied to the context sentences and every query, so they
+	// share one embedding space.
+	Pooling string
+	// History holds the most recent distinct queries, oldest first.
+	History []PastQuery
+}
+
+// PastQuery is a query the session has answered, kept so later queries can
+// be matched against it.
+type PastQuery struct {
+	Sentence  string
+	Embedding []float64
+	BestMatch SessionMatch
+	AskedAt   time.Time
+}
+
+// context applies the session's pooling to ctx.
+func (s *Session) context(ctx context.Context) context.Context {
+	return similarity.WithCallOptions(ctx, similarity.CallOptions{Pooling: s.Pooling})
+}
+
+func (s *Session) expiresAt() time.Time {
+	return s.LastUsedAt.Add(s.TTL)
+}
+
+type SessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+var sessionStore = NewSessionStore()
+
+func NewSessionStore() *SessionStore {
+	return &SessionStore{sessions: make(map[string]*Session)}
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (s *SessionStore) Add(session *Session) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.sessions) >= maxSessions {
+		return false
+	}
+	s.sessions[session.ID] = session
+	return true
+}
+
+// Get refreshes the session's idle timer and returns a copy of it. The
+// sentences and embeddings are never modified after creation and history
+// is replaced rather than modified, so the copy can share them.
+func (s *SessionStore) Get(id string) (*Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok || time.Now().After(session.expiresAt()) {
+		return nil, false
+	}
+	session.LastUsedAt = time.Now()
+	snapshot := *session
+	return &snapshot, true
+}
+
+// RecordQuery appends a query to the session's history. An earlier query
+// with the same sentence is replaced, and the oldest entries are dropped
+// beyond maxSessionHistory.
+func (s *SessionStore) RecordQuery(id string, query PastQuery) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return
+	}
+	history := make([]PastQuery, 0, len(session.History)+1)
+	for _, past := range session.History {
+		if past.Sentence != query.Sentence {
+			history = append(history, past)
+		}
+	}
+	history = append(history, query)
+	if len(history) > maxSessionHistory {
+		history = history[len(history)-maxSessionHistory:]
+	}
+	session.History = history
+}
+
+func (s *SessionStore) Delete(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.sessions[id]
+	delete(s.sessions, id)
+	return ok
+}
+
+func (s *SessionStore) sweep() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for id, session := range s.sessions {
+		if now.After(session.expiresAt()) {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+// StartJanitor removes idle sessions once a minute.
+func (s *SessionStore) StartJanitor() {
+	go func() {
+		for range time.Tick(time.Minute) {
+			s.sweep()
+		}
+	}()
+}
+
+type CreateSessionInput struct {
+	Sentences  []string `json:"sentences" binding:"required,min=1"`
+	TTLSeconds int      `json:"ttl_seconds"`
+	Pooling    string   `json:"pooling"`
+}
+
+type SessionInfo struct {
+	SessionID string `json:"session_id"`
+	Size      int    `json:"size"`
+	Queries   int    `json:"queries"`
+	Pooling   string `json:"pooling,omitempty"`
+	CreatedAt string `json:"created_at"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+type SessionQueryInput struct {
+	Sentence string `json:"sentence" binding:"required"`
+	TopK     int    `json:"top_k"`
+}
+
+type SessionMatch struct {
+	Index      int     `json:"index"`
+	Sentence   string  `json:"sentence"`
+	Similarity float64 `json:"similarity"`
+}
+
+type SessionQueryResponse struct {
+	SessionID   string         `json:"session_id"`
+	Sentence    string         `json:"sentence"`
+	Matches     []SessionMatch `json:"matches"`
+	ProcessedAt string         `json:"processed_at"`
+}
+
+type QuerySuggestion struct {
+	Sentence   string  `json:"sentence"`
+	Similarity float64 `json:"similarity"`
+	// BestMatch is the session sentence that ranked first when this query
+	// was asked, with its score at the time.
+	BestMatch SessionMatch `json:"best_match"`
+	AskedAt   string       `json:"asked_at"`
+}
+
+type SuggestionsResponse struct {
+	SessionID   string            `json:"session_id"`
+	Sentence    string            `json:"sentence"`
+	Suggestions []QuerySuggestion `json:"suggestions"`
+	ProcessedAt string            `json:"processed_at"`
+}
+
+func sessionInfo(session *Session) SessionInfo {
+	return SessionInfo{
+		SessionID: session.ID,
+		Size:      len(session.Sentences),
+		Queries:   len(session.History),
+		Pooling:   session.Pooling,
+		CreatedAt: session.CreatedAt.UTC().Format(time.RFC3339),
+		ExpiresAt: session.expiresAt().UTC().Format(time.RFC3339),
+	}
+}
+
+func handleCreateSession(c *gin.Context) {
+	var input CreateSessionInput
+	if err := bindInput(c, &input); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "Invalid input format: " + err.Error(),
+		})
+		return
+	}
+	lim := limitsFor(c)
+	if len(input.Sentences) > lim.MaxSessionSentences {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: fmt.Sprintf("A session holds at most %d sentences", lim.MaxSessionSentences),
+		})
+		return
+	}
+	if !checkSentenceLengths(c, lim, input.Sentences...) {
+		return
+	}
+
+	ttl := defaultSessionTTL
+	if input.TTLSeconds != 0 {
+		ttl = time.Duration(input.TTLSeconds) * time.Second
+	}
+	if ttl <= 0 || ttl > maxSessionTTL {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "ttl_seconds must be between 1 and 86400",
+		})
+		return
+	}
+
+	callOptions := similarity.CallOptions{Pooling: input.Pooling}
+	if err := validateCallOptions(c.Request.Context(), callOptions); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	sentences := make([]string, len(input.Sentences))
+	for i, sentence := range input.Sentences {
+		sentences[i] = strings.TrimSpace(sentence)
+		if sentences[i] == "" {
+			respond(c, http.StatusBadRequest, ErrorResponse{
+				Error:   "empty_sentences",
+				Message: "All sentences must be non-empty",
+			})
+			return
+		}
+	}
+
+	embeddings, err := scorer.Embed(similarity.WithCallOptions(c.Request.Context(), callOptions), sentences)
+	if err != nil {
+		logBackendError(c, err, sentences...)
+		respond(c, http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to embed session sentences",
+		})
+		return
+	}
+
+	id, err := newSessionID()
+	if err != nil {
+		respond(c, http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to create session",
+		})
+		return
+	}
+	now := time.Now()
+	session := &Session{
+		ID:         id,
+		Sentences:  sentences,
+		Embeddings: embeddings,
+		TTL:        ttl,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		Pooling:    input.Pooling,
+	}
+	if !sessionStore.Add(session) {
+		respond(c, http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "too_many_sessions",
+			Message: "The session limit has been reached, try again later",
+		})
+		return
+	}
+
+	respond(c, http.StatusCreated, sessionInfo(session))
+}
+
+func handleGetSession(c *gin.Context) {
+	session, ok := sessionStore.Get(c.Param("id"))
+	if !ok {
+		respond(c, http.StatusNotFound, ErrorResponse{
+			Error:   "session_not_found",
+			Message: "Session does not exist or has expired",
+		})
+		return
+	}
+	respond(c, http.StatusOK, sessionInfo(session))
+}
+
+func handleDeleteSession(c *gin.Context) {
+	if !sessionStore.Delete(c.Param("id")) {
+		respond(c, http.StatusNotFound, ErrorResponse{
+			Error:   "session_not_found",
+			Message: "Session does not exist or has expired",
+		})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func handleQuerySession(c *gin.Context) {
+	var input SessionQueryInput
+	if err := bindInput(c, &input); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "Invalid input format: " + err.Error(),
+		})
+		return
+	}
+	input.Sentence = strings.TrimSpace(input.Sentence)
+	if input.Sentence == "" {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "empty_sentences",
+			Message: "sentence must be non-empty",
+		})
+		return
+	}
+	if !checkSentenceLengths(c, limitsFor(c), input.Sentence) {
+		return
+	}
+
+	session, ok := sessionStore.Get(c.Param("id"))
+	if !ok {
+		respond(c, http.StatusNotFound, ErrorResponse{
+			Error:   "session_not_found",
+			Message: "Session does not exist or has expired",
+		})
+		return
+	}
+
+	topK := input.TopK
+	if topK <= 0 {
+		topK = defaultSessionTopK
+	}
+	if topK > len(session.Sentences) {
+		topK = len(session.Sentences)
+	}
+
+	embeddings, err := scorer.Embed(session.context(c.Request.Context()), []string{input.Sentence})
+	if err != nil {
+		logBackendError(c, err, input.Sentence)
+		respond(c, http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to process similarity calculation",
+		})
+		return
+	}
+
+	matches := make([]SessionMatch, len(session.Sentences))
+	for i, embedding := range session.Embeddings {
+		matches[i] = SessionMatch{
+			Index:      i,
+			Sentence:   session.Sentences[i],
+			Similarity: similarity.Cosine(embeddings[0], embedding),
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Similarity > matches[j].Similarity
+	})
+	sessionStore.RecordQuery(session.ID, PastQuery{
+		Sentence:  input.Sentence,
+		Embedding: embeddings[0],
+		BestMatch: matches[0],
+		AskedAt:   time.Now(),
+	})
+
+	respond(c, http.StatusOK, SessionQueryResponse{
+		SessionID:   session.ID,
+		Sentence:    input.Sentence,
+		Matches:     matches[:topK],
+		ProcessedAt: time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// handleSessionSuggestions ranks the session's earlier queries against a
+// new sentence, for "already asked" and "did you mean" prompts. It does not
+// add the sentence to the history.
+func handleSessionSuggestions(c *gin.Context) {
+	var input SessionQueryInput
+	if err := bindInput(c, &input); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "Invalid input format: " + err.Error(),
+		})
+		return
+	}
+	input.Sentence = strings.TrimSpace(input.Sentence)
+	if input.Sentence == "" {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "empty_sentences",
+			Message: "sentence must be non-empty",
+		})
+		return
+	}
+	if !checkSentenceLengths(c, limitsFor(c), input.Sentence) {
+		return
+	}
+
+	session, ok := sessionStore.Get(c.Param("id"))
+	if !ok {
+		respond(c, http.StatusNotFound, ErrorResponse{
+			Error:   "session_not_found",
+			Message: "Session does not exist or has expired",
+		})
+		return
+	}
+
+	response := SuggestionsResponse{
+		SessionID:   session.ID,
+		Sentence:    input.Sentence,
+		Suggestions: []QuerySuggestion{},
+	}
+	if len(session.History) > 0 {
+		embeddings, err := scorer.Embed(session.context(c.Request.Context()), []string{input.Sentence})
+		if err != nil {
+			logBackendError(c, err, input.Sentence)
+			respond(c, http.StatusInternalServerError, ErrorResponse{
+				Error:   "internal_error",
+				Message: "Failed to process similarity calculation",
+			})
+			return
+		}
+
+		for _, past := range session.History {
+			response.Suggestions = append(response.Suggestions, QuerySuggestion{
+				Sentence:   past.Sentence,
+				Similarity: similarity.Cosine(embeddings[0], past.Embedding),
+				BestMatch:  past.BestMatch,
+				AskedAt:    past.AskedAt.UTC().Format(time.RFC3339),
+			})
+		}
+		sort.SliceStable(response.Suggestions, func(i, j int) bool {
+			return response.Suggestions[i].Similarity > response.Suggestions[j].Similarity
+		})
+		topK := input.TopK
+		if topK <= 0 {
+			topK = defaultSessionTopK
+		}
+		if topK < len(response.Suggestions) {
+			response.Suggestions = response.Suggestions[:topK]
+		}
+	}
+
+	response.ProcessedAt = time.Now().UTC().Format(time.RFC3339)
+	respond(c, http.StatusOK, response)
+}
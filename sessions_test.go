@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"text-similarity-api/similarity"
+)
+
+// letterBackend embeds a sentence as its counts of the letters a to z, so
+// sentences made of the same letters score 1.
+type letterBackend struct{}
+
+func (letterBackend) Similarity(ctx context.Context, a, b string) (float64, error) {
+	return 0, similarity.ErrUnsupported
+}
+
+func (letterBackend) Matrix(ctx context.Context, a, b []string) ([][]float64, error) {
+	return nil, similarity.ErrUnsupported
+}
+
+func (letterBackend) Embed(ctx context.Context, sentences []string) ([][]float64, error) {
+	embeddings := make([][]float64, len(sentences))
+	for i, sentence := range sentences {
+		embeddings[i] = make([]float64, 26)
+		for _, r := range strings.ToLower(sentence) {
+			if r >= 'a' && r <= 'z' {
+				embeddings[i][r-'a']++
+			}
+		}
+	}
+	return embeddings, nil
+}
+
+// withSessionScorer swaps in an empty session store and a scorer that
+// embeds with letterBackend until the returned function is called.
+func withSessionScorer() (restore func()) {
+	savedScorer, savedStore := scorer, sessionStore
+	scorer = similarity.New(similarity.WithBackend(letterBackend{}))
+	sessionStore = NewSessionStore()
+	return func() { scorer, sessionStore = savedScorer, savedStore }
+}
+
+func TestSessionStore(t *testing.T) {
+	s := NewSessionStore()
+	now := time.Now()
+	s.Add(&Session{ID: "live", TTL: time.Minute, CreatedAt: now, LastUsedAt: now})
+	s.Add(&Session{ID: "idle", TTL: time.Minute, CreatedAt: now, LastUsedAt: now.Add(-2 * time.Minute)})
+
+	if _, ok := s.Get("idle"); ok {
+		t.Error("Get returned a session past its TTL")
+	}
+	session, ok := s.Get("live")
+	if !ok || !session.LastUsedAt.After(now) {
+		t.Errorf("Get = %+v, %v; want the session with its idle timer refreshed", session, ok)
+	}
+
+	for _, sentence := range []string{"a", "b", "a"} {
+		s.RecordQuery("live", PastQuery{Sentence: sentence})
+	}
+	if session, _ := s.Get("live"); len(session.History) != 2 || session.History[0].Sentence != "b" || session.History[1].Sentence != "a" {
+		t.Errorf("history = %+v, want b then the repeated a", session.History)
+	}
+	// The copy Get returned before is not changed by later queries.
+	if len(session.History) != 0 {
+		t.Errorf("earlier copy's history = %+v, want it unchanged", session.History)
+	}
+
+	s.sweep()
+	if _, ok := s.sessions["idle"]; ok {
+		t.Error("sweep kept an idle session")
+	}
+	if !s.Delete("live") || s.Delete("live") {
+		t.Error("Delete should report the session only the first time")
+	}
+}
+
+func TestSessionHistoryCap(t *testing.T) {
+	s := NewSessionStore()
+	s.Add(&Session{ID: "s", TTL: time.Minute, LastUsedAt: time.Now()})
+	for i := 0; i < maxSessionHistory+10; i++ {
+		s.RecordQuery("s", PastQuery{Sentence: strings.Repeat("x", i+1)})
+	}
+	session, _ := s.Get("s")
+	if len(session.History) != maxSessionHistory || len(session.History[0].Sentence) != 11 {
+		t.Errorf("history holds %d queries starting at length %d, want the newest %d", len(session.History), len(session.History[0].Sentence), maxSessionHistory)
+	}
+}
+
+func TestSessionStoreFull(t *testing.T) {
+	s := NewSessionStore()
+	for i := 0; i < maxSessions; i++ {
+		if !s.Add(&Session{ID: strings.Repeat("s", i+1)}) {
+			t.Fatalf("Add refused session %d of %d", i+1, maxSessions)
+		}
+	}
+	if s.Add(&Session{ID: "one more"}) {
+		t.Error("Add accepted a session past maxSessions")
+	}
+}
+
+func TestCreateSessionValidation(t *testing.T) {
+	defer withSessionScorer()()
+	r := newRouter()
+	tests := []struct {
+		name      string
+		body      string
+		wantCode  int
+		wantError string
+	}{
+		{"ok", `{"sentences": ["aaa", "bbb"]}`, http.StatusCreated, ""},
+		{"no sentences", `{"sentences": []}`, http.StatusBadRequest, "validation_error"},
+		{"blank sentence", `{"sentences": ["aaa", "  "]}`, http.StatusBadRequest, "empty_sentences"},
+		{"negative TTL", `{"sentences": ["aaa"], "ttl_seconds": -1}`, http.StatusBadRequest, "validation_error"},
+		{"TTL too long", `{"sentences": ["aaa"], "ttl_seconds": 86401}`, http.StatusBadRequest, "validation_error"},
+		{"unknown pooling", `{"sentences": ["aaa"], "pooling": "median"}`, http.StatusBadRequest, "validation_error"},
+	}
+	for _, tt := range tests {
+		w := do(t, r, http.MethodPost, "/api/v1/sessions", tt.body)
+		if w.Code != tt.wantCode {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.wantCode, w.Body)
+			continue
+		}
+		var resp ErrorResponse
+		if tt.wantError != "" && (json.Unmarshal(w.Body.Bytes(), &resp) != nil || resp.Error != tt.wantError) {
+			t.Errorf("%s: body %s, want %s", tt.name, w.Body, tt.wantError)
+		}
+	}
+}
+
+func TestSessionEndpoints(t *testing.T) {
+	defer withSessionScorer()()
+	r := newRouter()
+
+	w := do(t, r, http.MethodPost, "/api/v1/sessions", `{"sentences": ["aaaa", " bbbb ", "cccc"], "ttl_seconds": 60}`)
+	var info SessionInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil || w.Code != http.StatusCreated || info.Size != 3 {
+		t.Fatalf("create: status %d: %s", w.Code, w.Body)
+	}
+	path := "/api/v1/sessions/" + info.SessionID
+
+	// Suggestions need earlier queries.
+	w = do(t, r, http.MethodPost, path+"/suggestions", `{"sentence": "bb"}`)
+	var suggestions SuggestionsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &suggestions); err != nil || len(suggestions.Suggestions) != 0 {
+		t.Errorf("suggestions before any query = %s, want none", w.Body)
+	}
+
+	w = do(t, r, http.MethodPost, path+"/query", `{"sentence": "bbb", "top_k": 2}`)
+	var query SessionQueryResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &query); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("query: status %d: %s", w.Code, w.Body)
+	}
+	if len(query.Matches) != 2 || query.Matches[0].Index != 1 || query.Matches[0].Sentence != "bbbb" || query.Matches[0].Similarity != 1 {
+		t.Errorf("matches = %+v, want the trimmed bbbb first of 2", query.Matches)
+	}
+	do(t, r, http.MethodPost, path+"/query", `{"sentence": "ccc"}`)
+
+	w = do(t, r, http.MethodPost, path+"/suggestions", `{"sentence": "b", "top_k": 1}`)
+	suggestions = SuggestionsResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &suggestions); err != nil || len(suggestions.Suggestions) != 1 {
+		t.Fatalf("suggestions: status %d: %s", w.Code, w.Body)
+	}
+	if got := suggestions.Suggestions[0]; got.Sentence != "bbb" || got.Similarity != 1 || got.BestMatch.Sentence != "bbbb" {
+		t.Errorf("suggestion = %+v, want the earlier bbb query and its best match", got)
+	}
+
+	w = do(t, r, http.MethodGet, path, "")
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil || info.Queries != 2 {
+		t.Errorf("get: %s, want 2 queries recorded", w.Body)
+	}
+
+	if w := do(t, r, http.MethodDelete, path, ""); w.Code != http.StatusNoContent {
+		t.Errorf("delete: status %d, want 204", w.Code)
+	}
+	for _, req := range []struct{ method, path, body string }{
+		{http.MethodGet, path, ""},
+		{http.MethodDelete, path, ""},
+		{http.MethodPost, path + "/query", `{"sentence": "a"}`},
+		{http.MethodPost, path + "/suggestions", `{"sentence": "a"}`},
+	} {
+		if w := do(t, r, req.method, req.path, req.body); w.Code != http.StatusNotFound {
+			t.Errorf("%s %s after delete: status %d, want 404", req.method, req.path, w.Code)
+		}
+	}
+}
+
+func TestSessionEmbedFailure(t *testing.T) {
+	defer withSessionScorer()()
+	scorer = similarity.New(similarity.WithBackend(&similarity.LexicalBackend{Method: similarity.MethodJaccard}))
+	r := newRouter()
+	w := do(t, r, http.MethodPost, "/api/v1/sessions", `{"sentences": ["aaa"]}`)
+	var resp ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusInternalServerError || resp.Error != "internal_error" {
+		t.Errorf("status %d: %s, want 500 internal_error", w.Code, w.Body)
+	}
+	if len(sessionStore.sessions) != 0 {
+		t.Error("a session was stored although its sentences could not be embedded")
+	}
+}
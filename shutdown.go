@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultShutdownGracePeriod = 30 * time.Second
+	// backendShutdownTimeout bounds the wait for persistent Python workers
+	// to exit once requests have drained.
+	backendShutdownTimeout = 10 * time.Second
+)
+
+// shutdownGracePeriodFromEnv reads SHUTDOWN_GRACE_PERIOD, how long
+// requests in flight may take to finish after SIGTERM.
+func shutdownGracePeriodFromEnv() (time.Duration, error) {
+	raw := os.Getenv("SHUTDOWN_GRACE_PERIOD")
+	if raw == "" {
+		return defaultShutdownGracePeriod, nil
+	}
+	grace, err := time.ParseDuration(raw)
+	if err != nil || grace < 0 {
+		return 0, fmt.Errorf("SHUTDOWN_GRACE_PERIOD %q must be a non-negative duration such as 30s", raw)
+	}
+	return grace, nil
+}
+
+// serve runs the server on addr until SIGINT or SIGTERM. It then stops
+// accepting connections and waits up to the grace period for requests in
+// flight and deferred requests being computed. Requests still running
+// after that are cancelled, which kills their Python processes. Last, it
+// stops the synthetic prober and the persistent Python workers and
+// flushes queued trace spans. A second signal exits at once.
+func serve(handler http.Handler, addr string) error {
+	grace, err := shutdownGracePeriodFromEnv()
+	if err != nil {
+		grace = defaultShutdownGracePeriod
+	}
+	requests, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	server := &http.Server{
+		Addr:        addr,
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return requests },
+	}
+
+	signals, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+	failed := make(chan error, 1)
+	go func() { failed <- server.ListenAndServe() }()
+	select {
+	case err := <-failed:
+		return err
+	case <-signals.Done():
+	}
+	stopSignals()
+
+	slog.Info("Shutting down, draining requests", "grace_period", grace.String())
+	drain, cancelDrain := context.WithTimeout(context.Background(), grace)
+	defer cancelDrain()
+	if err := server.Shutdown(drain); err != nil {
+		slog.Warn("Grace period ended with requests in flight, cancelling them", "error", err)
+		cancelRequests()
+		server.Close()
+	}
+	if err := deferrals.Drain(drain); err != nil {
+		slog.Warn("Grace period ended with deferred requests running, cancelling them", "error", err)
+	}
+
+	if prober != nil {
+		prober.Stop()
+	}
+	stop, cancelStop := context.WithTimeout(context.Background(), backendShutdownTimeout)
+	defer cancelStop()
+	if err := jobQueue.Stop(stop); err != nil {
+		slog.Warn("Stopping job workers", "error", err)
+	}
+	if err := pythonBackend.Shutdown(stop); err != nil {
+		slog.Warn("Stopping Python workers", "error", err)
+	}
+	if requestTracer != nil {
+		if err := requestTracer.Shutdown(stop); err != nil {
+			slog.Warn("Flushing trace spans", "error", err)
+		}
+	}
+	if err := corpusStore.Close(); err != nil {
+		slog.Warn("Closing the corpus database", "error", err)
+	}
+	slog.Info("Server stopped")
+	return nil
+}
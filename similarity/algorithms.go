@@ -0,0 +1,158 @@
+package similarity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Lexical methods implemented by LexicalBackend.
+const (
+	MethodTFIDF       = "tfidf"
+	MethodJaccard     = "jaccard"
+	MethodLevenshtein = "levenshtein"
+)
+
+// ErrUnsupported is returned by a backend for a call it cannot answer. A
+// fallback that returns it leaves the primary backend's error in place.
+var ErrUnsupported = errors.New("similarity: not supported by this backend")
+
+// TFIDFCosine is the cosine of the TF-IDF vectors of a and b's word tokens,
+// with the pair itself as the corpus and smoothed IDF, so words that only
+// one text uses weigh more than words both share.
+func TFIDFCosine(a, b string, entities ...string) float64 {
+	termsA := termCounts(tokenizeProtected(a, entities))
+	termsB := termCounts(tokenizeProtected(b, entities))
+	idf := func(term string) float64 {
+		df := 0
+		if termsA[term] > 0 {
+			df++
+		}
+		if termsB[term] > 0 {
+			df++
+		}
+		return math.Log(3/float64(1+df)) + 1
+	}
+
+	var dot, normA, normB float64
+	for term, count := range termsA {
+		weight := float64(count) * idf(term)
+		normA += weight * weight
+		if other, ok := termsB[term]; ok {
+			dot += weight * float64(other) * idf(term)
+		}
+	}
+	for term, count := range termsB {
+		weight := float64(count) * idf(term)
+		normB += weight * weight
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+func termCounts(tokens []string) map[string]int {
+	counts := make(map[string]int, len(tokens))
+	for _, token := range tokens {
+		counts[token]++
+	}
+	return counts
+}
+
+// Jaccard is the size of the intersection of a and b's word token sets
+// over the size of their union.
+func Jaccard(a, b string, entities ...string) float64 {
+	setA := termCounts(tokenizeProtected(a, entities))
+	setB := termCounts(tokenizeProtected(b, entities))
+	shared := 0
+	for token := range setA {
+		if _, ok := setB[token]; ok {
+			shared++
+		}
+	}
+	union := len(setA) + len(setB) - shared
+	if union == 0 {
+		return 0
+	}
+	return float64(shared) / float64(union)
+}
+
+// LevenshteinRatio is 1 minus the character edit distance between the
+// normalised texts over the length of the longer one.
+func LevenshteinRatio(a, b string) float64 {
+	runesA := []rune(strings.Join(tokenize(a), " "))
+	runesB := []rune(strings.Join(tokenize(b), " "))
+	longest := max(len(runesA), len(runesB))
+	if longest == 0 {
+		return 0
+	}
+
+	previous := make([]int, len(runesB)+1)
+	current := make([]int, len(runesB)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(runesA); i++ {
+		current[0] = i
+		for j := 1; j <= len(runesB); j++ {
+			cost := 1
+			if runesA[i-1] == runesB[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return 1 - float64(previous[len(runesB)])/float64(longest)
+}
+
+// LexicalBackend scores a pair with one of the lexical methods, in pure Go
+// and without a model. It is meant as a fallback that keeps pair scoring
+// available when the model is down. Its scores measure surface overlap and
+// are not comparable to model scores, so it does not produce matrices or
+// embeddings for callers that combine many scores; those calls return
+// ErrUnsupported. Entities in the call options are kept verbatim by the
+// token-based methods.
+type LexicalBackend struct {
+	Method string
+}
+
+func NewLexicalBackend(method string) (*LexicalBackend, error) {
+	switch method {
+	case MethodTFIDF, MethodJaccard, MethodLevenshtein:
+		return &LexicalBackend{Method: method}, nil
+	default:
+		return nil, fmt.Errorf("similarity: unknown lexical method %q", method)
+	}
+}
+
+func (l LexicalBackend) Similarity(ctx context.Context, a, b string) (float64, error) {
+	return LexicalScore(ctx, l.Method, a, b)
+}
+
+func (LexicalBackend) Matrix(ctx context.Context, a, b []string) ([][]float64, error) {
+	return nil, ErrUnsupported
+}
+
+func (LexicalBackend) Embed(ctx context.Context, sentences []string) ([][]float64, error) {
+	return nil, ErrUnsupported
+}
+
+// LexicalScore scores a pair with the named lexical method, taking entities
+// from ctx's call options.
+func LexicalScore(ctx context.Context, method, a, b string) (float64, error) {
+	entities := CallOptionsFrom(ctx).Entities
+	switch method {
+	case MethodTFIDF:
+		return TFIDFCosine(a, b, entities...), nil
+	case MethodJaccard:
+		return Jaccard(a, b, entities...), nil
+	case MethodLevenshtein:
+		return LevenshteinRatio(a, b), nil
+	default:
+		return 0, fmt.Errorf("similarity: unknown lexical method %q", method)
+	}
+}
@@ -0,0 +1,188 @@
+package similarity
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+)
+
+func approxEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestTFIDFCosine(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     string
+		entities []string
+		want     float64
+	}{
+		{"identical", "the cat sat", "the cat sat", nil, 1},
+		{"case and punctuation", "The cat sat.", "the CAT sat", nil, 1},
+		{"disjoint", "red apple", "blue sky", nil, 0},
+		{"empty", "", "anything", nil, 0},
+		{"punctuation only", "!!", "??", nil, 0},
+		// Both share "cat" (idf 1); "a" and "b" only appear once (idf
+		// ln(1.5)+1 each), so the cosine is 1 / (1 + (ln(1.5)+1)^2).
+		{"partial", "cat a", "cat b", nil, 1 / (1 + math.Pow(math.Log(1.5)+1, 2))},
+		{"entities kept whole", "SKU-12A", "sku 12a", []string{"SKU-12A"}, 0},
+	}
+	for _, tt := range tests {
+		if got := TFIDFCosine(tt.a, tt.b, tt.entities...); !approxEqual(got, tt.want) {
+			t.Errorf("%s: TFIDFCosine(%q, %q) = %v, want %v", tt.name, tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestJaccard(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     string
+		entities []string
+		want     float64
+	}{
+		{"identical", "a b c", "c b a", nil, 1},
+		{"half", "a b", "b c d", nil, 0.25},
+		{"repeats ignored", "a a a b", "a b", nil, 1},
+		{"disjoint", "a b", "c d", nil, 0},
+		{"both empty", "", "", nil, 0},
+		{"entities kept whole", "iOS 17", "ios 17", []string{"iOS"}, 1.0 / 3},
+	}
+	for _, tt := range tests {
+		if got := Jaccard(tt.a, tt.b, tt.entities...); !approxEqual(got, tt.want) {
+			t.Errorf("%s: Jaccard(%q, %q) = %v, want %v", tt.name, tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestLevenshteinRatio(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"kitten", "kitten", 1},
+		{"kitten", "sitting", 1 - 3.0/7},
+		{"Kitten!", "kitten", 1},
+		{"abc", "", 0},
+		{"", "", 0},
+		{"ab", "ba", 0},
+		{"héllo", "hallo", 0.8},
+	}
+	for _, tt := range tests {
+		if got := LevenshteinRatio(tt.a, tt.b); !approxEqual(got, tt.want) {
+			t.Errorf("LevenshteinRatio(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestLexicalScore(t *testing.T) {
+	ctx := context.Background()
+	for _, method := range []string{MethodTFIDF, MethodJaccard, MethodLevenshtein} {
+		got, err := LexicalScore(ctx, method, "same text", "same text")
+		if err != nil || !approxEqual(got, 1) {
+			t.Errorf("LexicalScore(%s) = %v, %v; want 1, nil", method, got, err)
+		}
+	}
+	if _, err := LexicalScore(ctx, "bm25", "a", "b"); err == nil {
+		t.Error("LexicalScore with an unknown method succeeded")
+	}
+
+	entities := WithCallOptions(ctx, CallOptions{Entities: []string{"SKU-12A"}})
+	if got, _ := LexicalScore(entities, MethodJaccard, "SKU-12A", "sku 12a"); got != 0 {
+		t.Errorf("LexicalScore ignored the call's entities: got %v, want 0", got)
+	}
+}
+
+func TestNewLexicalBackend(t *testing.T) {
+	if _, err := NewLexicalBackend("bm25"); err == nil {
+		t.Error("NewLexicalBackend accepted an unknown method")
+	}
+	backend, err := NewLexicalBackend(MethodJaccard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.Matrix(context.Background(), []string{"a"}, []string{"b"}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Matrix error = %v, want ErrUnsupported", err)
+	}
+	if _, err := backend.Embed(context.Background(), []string{"a"}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Embed error = %v, want ErrUnsupported", err)
+	}
+}
+
+// stubBackend answers every call with score and err.
+type stubBackend struct {
+	score float64
+	err   error
+	calls int
+}
+
+func (b *stubBackend) Similarity(ctx context.Context, x, y string) (float64, error) {
+	b.calls++
+	return b.score, b.err
+}
+
+func (b *stubBackend) Matrix(ctx context.Context, x, y []string) ([][]float64, error) {
+	b.calls++
+	if b.err != nil {
+		return nil, b.err
+	}
+	return [][]float64{{b.score}}, nil
+}
+
+func (b *stubBackend) Embed(ctx context.Context, sentences []string) ([][]float64, error) {
+	b.calls++
+	if b.err != nil {
+		return nil, b.err
+	}
+	return [][]float64{{1}}, nil
+}
+
+func TestScorerFallback(t *testing.T) {
+	errDown := errors.New("backend down")
+	tests := []struct {
+		name         string
+		primary      *stubBackend
+		fallback     Backend
+		wantScore    float64
+		wantFellBack bool
+		wantErr      error
+	}{
+		{"primary answers", &stubBackend{score: 0.7}, &LexicalBackend{Method: MethodJaccard}, 0.7, false, nil},
+		{"fallback answers", &stubBackend{err: errDown}, &LexicalBackend{Method: MethodJaccard}, 1.0 / 3, true, nil},
+		{"no fallback", &stubBackend{err: errDown}, nil, 0, false, errDown},
+	}
+	for _, tt := range tests {
+		opts := []Option{WithBackend(tt.primary)}
+		if tt.fallback != nil {
+			opts = append(opts, WithFallback(tt.fallback))
+		}
+		result, err := New(opts...).ScoreDetailed(context.Background(), "a b", "b c")
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !approxEqual(result.Score, tt.wantScore) || result.FellBack != tt.wantFellBack {
+			t.Errorf("%s: got score %v fell back %v, want %v and %v", tt.name, result.Score, result.FellBack, tt.wantScore, tt.wantFellBack)
+		}
+	}
+}
+
+func TestScorerFallbackUnsupported(t *testing.T) {
+	errDown := errors.New("backend down")
+	scorer := New(WithBackend(&stubBackend{err: errDown}), WithFallback(&LexicalBackend{Method: MethodTFIDF}))
+	if _, err := scorer.Matrix(context.Background(), []string{"a"}, []string{"b"}); !errors.Is(err, errDown) {
+		t.Errorf("Matrix error = %v, want the primary backend's error", err)
+	}
+}
+
+func TestScorerNoFallbackAfterCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	primary := &stubBackend{err: context.Canceled}
+	scorer := New(WithBackend(primary), WithFallback(&LexicalBackend{Method: MethodTFIDF}))
+	result, err := scorer.ScoreDetailed(ctx, "a", "b")
+	if err == nil || result.FellBack {
+		t.Errorf("got %+v, %v; want an error and no fallback once the context is cancelled", result, err)
+	}
+}
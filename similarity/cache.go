@@ -0,0 +1,150 @@
+package similarity
+
+import (
+	"container/list"
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache stores pair scores. Implementations must be safe for concurrent
+// use; a Scorer calls them from every goroutine that scores through it.
+type Cache interface {
+	Get(key string) (float64, bool)
+	Set(key string, score float64)
+}
+
+// cacheKey identifies a pair of preprocessed inputs. Cosine similarity is
+// symmetric, so a and b are ordered first. The model, pooling, sequence
+// length and entities are included so one cache can serve differently
+// configured calls. Normalize is not: it does not change cosine scores.
+func (s *Scorer) cacheKey(ctx context.Context, a, b string) string {
+	if b < a {
+		a, b = b, a
+	}
+	opts := s.callOptions(ctx)
+	return sha256Hex(s.model + "\x00" + opts.Pooling + seqLengthKey(opts.MaxSeqLength) + "\x00" + strings.Join(opts.Entities, "\x01") + "\x00" + a + "\x00" + b)
+}
+
+// seqLengthKey is empty for the model's own limit, so keys of calls that
+// do not set one are unchanged and shared caches stay warm.
+func seqLengthKey(maxSeqLength int) string {
+	if maxSeqLength == 0 {
+		return ""
+	}
+	return "\x00max_seq_length=" + strconv.Itoa(maxSeqLength)
+}
+
+type lruEntry struct {
+	key     string
+	score   float64
+	expires time.Time
+}
+
+// LRUCache is an in-memory Cache that evicts the least recently used
+// score once it holds capacity entries. With a TTL, entries also expire
+// that long after they were set.
+type LRUCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	order    *list.List
+	entries  map[string]*list.Element
+	stats    CacheStats
+}
+
+// CacheStats counts an LRUCache's lookups and removals since it was
+// created.
+type CacheStats struct {
+	Entries     int   `json:"entries"`
+	Capacity    int   `json:"capacity"`
+	Hits        int64 `json:"hits"`
+	Misses      int64 `json:"misses"`
+	Evictions   int64 `json:"evictions"`
+	Expirations int64 `json:"expirations"`
+}
+
+func NewLRUCache(capacity int) *LRUCache {
+	return NewLRUCacheWithTTL(capacity, 0)
+}
+
+// NewLRUCacheWithTTL is NewLRUCache with entries that expire ttl after
+// they are set. A ttl of zero or less never expires them.
+func NewLRUCacheWithTTL(capacity int, ttl time.Duration) *LRUCache {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &LRUCache{
+		capacity: capacity,
+		ttl:      ttl,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (c *LRUCache) Get(key string) (float64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		c.stats.Misses++
+		return 0, false
+	}
+	entry := element.Value.(*lruEntry)
+	if c.ttl > 0 && time.Now().After(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		c.stats.Expirations++
+		c.stats.Misses++
+		return 0, false
+	}
+	c.order.MoveToFront(element)
+	c.stats.Hits++
+	return entry.score, true
+}
+
+func (c *LRUCache) Set(key string, score float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := time.Now().Add(c.ttl)
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*lruEntry)
+		entry.score, entry.expires = score, expires
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, score: score, expires: expires})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+		c.stats.Evictions++
+	}
+}
+
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Stats returns the current size and the counters. Expired entries that
+// have not been looked up since still count as entries.
+func (c *LRUCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.order.Len()
+	stats.Capacity = c.capacity
+	return stats
+}
+
+// Purge removes every entry. The counters are kept.
+func (c *LRUCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+}
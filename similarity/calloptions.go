@@ -0,0 +1,105 @@
+package similarity
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Pooling strategies for turning token embeddings into a sentence
+// embedding. The empty strategy keeps the model's own pooling.
+const (
+	PoolingMean = "mean"
+	PoolingCLS  = "cls"
+	PoolingMax  = "max"
+)
+
+// CallOptions adjust how a single call is scored. They travel in the
+// context so the Backend interface stays unchanged; backends that do not
+// support an option ignore it.
+type CallOptions struct {
+	Pooling string
+	// Normalize scales embeddings to unit length. Cosine scores are the
+	// same either way; it changes the vectors Embed returns.
+	Normalize bool
+	// MaxSeqLength truncates inputs to fewer tokens than the model's own
+	// limit. Zero keeps the model's limit.
+	MaxSeqLength int
+	// Entities are product names, codes and other spans that lexical
+	// tokenization keeps verbatim: matched case-sensitively as whole words
+	// and kept as one token, instead of being lowercased and split on
+	// punctuation. Only the native backend tokenizes; the model backends
+	// receive the text unchanged either way.
+	Entities []string
+}
+
+func (o CallOptions) Validate() error {
+	for _, entity := range o.Entities {
+		if strings.TrimSpace(entity) == "" {
+			return fmt.Errorf("entities must not be empty")
+		}
+	}
+	if o.MaxSeqLength < 0 {
+		return fmt.Errorf("max_seq_length must not be negative")
+	}
+	switch o.Pooling {
+	case "", PoolingMean, PoolingCLS, PoolingMax:
+		return nil
+	}
+	return fmt.Errorf("pooling %q must be one of mean, cls, max", o.Pooling)
+}
+
+// Per-call model parameters a backend can declare in BackendInfo.Parameters.
+const (
+	ParameterPooling      = "pooling"
+	ParameterNormalize    = "normalize"
+	ParameterMaxSeqLength = "max_seq_length"
+)
+
+// Parameters returns the model parameters o sets.
+func (o CallOptions) Parameters() []string {
+	var params []string
+	if o.Pooling != "" {
+		params = append(params, ParameterPooling)
+	}
+	if o.Normalize {
+		params = append(params, ParameterNormalize)
+	}
+	if o.MaxSeqLength != 0 {
+		params = append(params, ParameterMaxSeqLength)
+	}
+	return params
+}
+
+// Supported reports whether the model described by info honours o: every
+// parameter o sets must be one the backend declares, and MaxSeqLength must
+// not exceed the model's limit. A backend that declares no parameters at
+// all predates the declaration and is not checked.
+func (o CallOptions) Supported(info *BackendInfo) error {
+	if info == nil || info.Parameters == nil {
+		return nil
+	}
+	for _, param := range o.Parameters() {
+		if !slices.Contains(info.Parameters, param) {
+			return fmt.Errorf("model %s does not support %s", info.Model, param)
+		}
+	}
+	if info.MaxSeqLength > 0 && o.MaxSeqLength > info.MaxSeqLength {
+		return fmt.Errorf("max_seq_length %d exceeds the %d tokens model %s accepts", o.MaxSeqLength, info.MaxSeqLength, info.Model)
+	}
+	return nil
+}
+
+type callOptionsKey struct{}
+
+// WithCallOptions returns a context that applies opts to every Scorer and
+// backend call made with it.
+func WithCallOptions(ctx context.Context, opts CallOptions) context.Context {
+	return context.WithValue(ctx, callOptionsKey{}, opts)
+}
+
+func CallOptionsFrom(ctx context.Context) CallOptions {
+	opts, _ := ctx.Value(callOptionsKey{}).(CallOptions)
+	return opts
+}
@@ -0,0 +1,43 @@
+package similarity
+
+import "sync"
+
+// flight is one backend call shared by every identical Score call that
+// arrives while it runs.
+type flight struct {
+	done     chan struct{}
+	score    float64
+	fellBack bool
+	err      error
+}
+
+// coalescer tracks the pair scores currently being computed, by cache key.
+type coalescer struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+func newCoalescer() *coalescer {
+	return &coalescer{flights: make(map[string]*flight)}
+}
+
+// join returns the flight computing key, starting one if there is none.
+// It reports whether the caller started it and so must run it and call
+// land.
+func (c *coalescer) join(key string) (*flight, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.flights[key]; ok {
+		return f, false
+	}
+	f := &flight{done: make(chan struct{})}
+	c.flights[key] = f
+	return f, true
+}
+
+func (c *coalescer) land(key string, f *flight) {
+	c.mu.Lock()
+	delete(c.flights, key)
+	c.mu.Unlock()
+	close(f.done)
+}
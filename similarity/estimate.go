@@ -0,0 +1,112 @@
+package similarity
+
+import (
+	"math"
+	"strings"
+	"sync"
+)
+
+// Bounds is a cheap estimate of where the model's score for a pair lies.
+type Bounds struct {
+	Lower float64 `json:"lower"`
+	Upper float64 `json:"upper"`
+	// Confidence is the estimated probability that the model's score falls
+	// within [Lower, Upper].
+	Confidence float64 `json:"confidence"`
+	// Samples is the number of model scores the bounds were derived from.
+	Samples int `json:"samples"`
+}
+
+const (
+	overlapBuckets     = 10
+	lengthRatioBuckets = 3
+)
+
+type calibrationBucket struct {
+	count    int
+	min, max float64
+}
+
+// Calibration derives score bounds from lexical features by remembering
+// the range of model scores seen for pairs with similar features. Pairs
+// are grouped by character-trigram overlap and by length ratio. It is safe
+// for concurrent use.
+type Calibration struct {
+	mu      sync.Mutex
+	buckets [overlapBuckets][lengthRatioBuckets]calibrationBucket
+}
+
+func NewCalibration() *Calibration {
+	return &Calibration{}
+}
+
+// lexicalFeatures returns the bucket a pair falls into, and whether the
+// texts are identical after normalisation.
+func lexicalFeatures(a, b string) (int, int, bool) {
+	normalizedA := strings.Join(tokenize(a), " ")
+	normalizedB := strings.Join(tokenize(b), " ")
+	if normalizedA == normalizedB && normalizedA != "" {
+		return 0, 0, true
+	}
+
+	overlap := int(CharOverlap(a, b) * overlapBuckets)
+	if overlap >= overlapBuckets {
+		overlap = overlapBuckets - 1
+	}
+	lengthA, lengthB := len([]rune(normalizedA)), len([]rune(normalizedB))
+	if lengthA > lengthB {
+		lengthA, lengthB = lengthB, lengthA
+	}
+	ratio := 0
+	if lengthB > 0 {
+		switch r := float64(lengthA) / float64(lengthB); {
+		case r >= 0.8:
+			ratio = 2
+		case r >= 0.5:
+			ratio = 1
+		}
+	}
+	return overlap, ratio, false
+}
+
+// Observe records the model's score for a pair.
+func (c *Calibration) Observe(a, b string, score float64) {
+	overlap, ratio, identical := lexicalFeatures(a, b)
+	if identical {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	bucket := &c.buckets[overlap][ratio]
+	if bucket.count == 0 {
+		bucket.min, bucket.max = score, score
+	}
+	bucket.min = math.Min(bucket.min, score)
+	bucket.max = math.Max(bucket.max, score)
+	bucket.count++
+}
+
+// Bounds estimates the pair's score without calling the model. Texts that
+// are identical after normalisation score 1. Otherwise the bounds are the
+// lowest and highest scores observed for pairs with the same features. For
+// n exchangeable observations, the next one falls between their minimum
+// and maximum with probability (n-1)/(n+1), which is the confidence. With
+// fewer than two observations the bounds are the whole cosine range.
+func (c *Calibration) Bounds(a, b string) Bounds {
+	overlap, ratio, identical := lexicalFeatures(a, b)
+	if identical {
+		return Bounds{Lower: 1, Upper: 1, Confidence: 1}
+	}
+	c.mu.Lock()
+	bucket := c.buckets[overlap][ratio]
+	c.mu.Unlock()
+	if bucket.count < 2 {
+		return Bounds{Lower: -1, Upper: 1, Confidence: 1, Samples: bucket.count}
+	}
+	return Bounds{
+		Lower:      bucket.min,
+		Upper:      bucket.max,
+		Confidence: float64(bucket.count-1) / float64(bucket.count+1),
+		Samples:    bucket.count,
+	}
+}
@@ -0,0 +1,210 @@
+package similarity
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Units that Explain can remove from a text.
+const (
+	UnitToken  = "token"
+	UnitPhrase = "phrase"
+)
+
+var phraseSplitter = regexp.MustCompile(`[^,;:\n.!?]+[,;:.!?]*`)
+
+// Removal is the effect of deleting one unit from the explained text.
+// Delta is the original score minus the score without the unit, so a
+// positive delta marks a unit that supports the match.
+type Removal struct {
+	Index int     `json:"index"`
+	Text  string  `json:"text"`
+	Score float64 `json:"score"`
+	Delta float64 `json:"delta"`
+}
+
+type Explanation struct {
+	Score    float64   `json:"score"`
+	Unit     string    `json:"unit"`
+	Removals []Removal `json:"removals"`
+}
+
+// ExplainUnits splits text into the units Explain removes one at a time:
+// whitespace-separated tokens, or clauses ending in punctuation.
+func ExplainUnits(text, unit string) ([]string, error) {
+	text = Preprocess(text)
+	switch unit {
+	case UnitToken:
+		return strings.Fields(text), nil
+	case UnitPhrase:
+		var phrases []string
+		for _, phrase := range phraseSplitter.FindAllString(text, -1) {
+			if phrase = strings.TrimSpace(phrase); phrase != "" {
+				phrases = append(phrases, phrase)
+			}
+		}
+		return phrases, nil
+	}
+	return nil, fmt.Errorf("similarity: unknown explain unit %q", unit)
+}
+
+// Explain ranks the units of text by how much deleting each one changes
+// its score against other, largest change first. It only needs scores, so
+// it works with any Backend; the original text and every variant are
+// scored in a single Matrix call. A variant with nothing left scores 0.
+func (s *Scorer) Explain(ctx context.Context, text, other, unit string) (*Explanation, error) {
+	text, other = Preprocess(text), Preprocess(other)
+	if text == "" || other == "" {
+		return nil, ErrEmptyInput
+	}
+	units, err := ExplainUnits(text, unit)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := []string{text}
+	rowOf := make([]int, len(units))
+	for i := range units {
+		remaining := make([]string, 0, len(units)-1)
+		remaining = append(remaining, units[:i]...)
+		remaining = append(remaining, units[i+1:]...)
+		if variant := strings.Join(remaining, " "); variant != "" {
+			rowOf[i] = len(rows)
+			rows = append(rows, variant)
+		}
+	}
+	matrix, err := s.matrix(ctx, rows, []string{other})
+	if err != nil {
+		return nil, err
+	}
+
+	explanation := &Explanation{Score: matrix[0][0], Unit: unit, Removals: make([]Removal, len(units))}
+	for i, removed := range units {
+		var score float64
+		if rowOf[i] != 0 {
+			score = matrix[rowOf[i]][0]
+		}
+		explanation.Removals[i] = Removal{Index: i, Text: removed, Score: score, Delta: explanation.Score - score}
+	}
+	sort.SliceStable(explanation.Removals, func(i, j int) bool {
+		return math.Abs(explanation.Removals[i].Delta) > math.Abs(explanation.Removals[j].Delta)
+	})
+	return explanation, nil
+}
+
+const (
+	spanWindowWords  = 4
+	spanWindowStride = 2
+)
+
+var wordPattern = regexp.MustCompile(`\S+`)
+
+// Span is a passage of a text. Start and End are offsets in Unicode code
+// points, End exclusive.
+type Span struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Text  string `json:"text"`
+}
+
+// SpanMatch pairs a span of the first text with the most similar span of
+// the second.
+type SpanMatch struct {
+	Span1      Span    `json:"span1"`
+	Span2      Span    `json:"span2"`
+	Similarity float64 `json:"similarity"`
+}
+
+func runeSpan(text string, start, end int) Span {
+	offset := utf8.RuneCountInString(text[:start])
+	return Span{Start: offset, End: offset + utf8.RuneCountInString(text[start:end]), Text: text[start:end]}
+}
+
+// textSpans splits text into phrases, or into overlapping windows of a few
+// words when it is a single phrase, so there is always something finer
+// than the whole text to highlight.
+func textSpans(text string) []Span {
+	var spans []Span
+	for _, bounds := range phraseSplitter.FindAllStringIndex(text, -1) {
+		start, end := bounds[0], bounds[1]
+		for start < end && unicode.IsSpace(rune(text[start])) {
+			start++
+		}
+		for end > start && unicode.IsSpace(rune(text[end-1])) {
+			end--
+		}
+		if start < end {
+			spans = append(spans, runeSpan(text, start, end))
+		}
+	}
+	if len(spans) > 1 {
+		return spans
+	}
+
+	words := wordPattern.FindAllStringIndex(text, -1)
+	if len(words) <= spanWindowWords {
+		if len(spans) == 0 {
+			spans = append(spans, runeSpan(text, 0, len(text)))
+		}
+		return spans
+	}
+	spans = nil
+	for i := 0; i < len(words); i += spanWindowStride {
+		last := i + spanWindowWords - 1
+		if last >= len(words) {
+			last = len(words) - 1
+		}
+		spans = append(spans, runeSpan(text, words[i][0], words[last][1]))
+		if last == len(words)-1 {
+			break
+		}
+	}
+	return spans
+}
+
+// MatchSpans pairs each span of a with its most similar span of b and
+// returns up to limit pairs, most similar first. Offsets refer to a and b
+// after Preprocess. All spans are scored in a single Matrix call.
+func (s *Scorer) MatchSpans(ctx context.Context, a, b string, limit int) ([]SpanMatch, error) {
+	a, b = Preprocess(a), Preprocess(b)
+	if a == "" || b == "" {
+		return nil, ErrEmptyInput
+	}
+	spansA, spansB := textSpans(a), textSpans(b)
+	textsA := make([]string, len(spansA))
+	for i, span := range spansA {
+		textsA[i] = span.Text
+	}
+	textsB := make([]string, len(spansB))
+	for i, span := range spansB {
+		textsB[i] = span.Text
+	}
+	matrix, err := s.matrix(ctx, textsA, textsB)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]SpanMatch, len(spansA))
+	for i, row := range matrix {
+		best := 0
+		for j, score := range row {
+			if score > row[best] {
+				best = j
+			}
+		}
+		matches[i] = SpanMatch{Span1: spansA[i], Span2: spansB[best], Similarity: row[best]}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Similarity > matches[j].Similarity
+	})
+	if limit > 0 && limit < len(matches) {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
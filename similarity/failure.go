@@ -0,0 +1,93 @@
+package similarity
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Classes of ProcessFailure.
+const (
+	FailureOOM     = "oom"
+	FailureCrash   = "crash"
+	FailureTimeout = "timeout"
+)
+
+const failureStderrLines = 20
+
+// oomMarkers are stderr fragments that show the service ran out of memory
+// even when it exited on its own rather than being killed by the kernel.
+var oomMarkers = []string{
+	"memoryerror",
+	"out of memory",
+	"cannot allocate memory",
+	"std::bad_alloc",
+}
+
+// ProcessFailure is returned when the Python service process died before
+// answering, or was killed for running past its deadline. It carries what
+// is needed to tell an out-of-memory kill from a crash or a timeout.
+type ProcessFailure struct {
+	Class    string
+	PID      int
+	ExitCode int
+	// Signal names the signal that killed the process, if one did.
+	Signal string
+	// MaxRSSBytes is the process's peak resident memory, or 0 where the
+	// platform does not report it.
+	MaxRSSBytes int64
+	// Stderr holds the last lines the process wrote to stderr.
+	Stderr string
+	Err    error
+}
+
+func (f *ProcessFailure) Error() string {
+	return fmt.Sprintf("python service %s: %v, stderr: %s", f.Class, f.Err, f.Stderr)
+}
+
+func (f *ProcessFailure) Unwrap() error {
+	return f.Err
+}
+
+// newProcessFailure describes a process that has been waited for. class
+// is set when the caller killed the process and so knows why; otherwise it
+// is inferred from how the process ended.
+func newProcessFailure(state *os.ProcessState, err error, stderr, class string) *ProcessFailure {
+	f := &ProcessFailure{
+		Class:    class,
+		ExitCode: -1,
+		Stderr:   lastLines(stderr, failureStderrLines),
+		Err:      err,
+	}
+	if state != nil {
+		f.PID = state.Pid()
+		f.ExitCode = state.ExitCode()
+		f.Signal, f.MaxRSSBytes = processStats(state)
+	}
+	if f.Class == "" {
+		f.Class = FailureCrash
+		// The kernel's OOM killer sends SIGKILL; a shell reports it as 137.
+		if f.Signal == "killed" || f.ExitCode == 137 || hasOOMMarker(stderr) {
+			f.Class = FailureOOM
+		}
+	}
+	return f
+}
+
+func hasOOMMarker(stderr string) bool {
+	lower := strings.ToLower(stderr)
+	for _, marker := range oomMarkers {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+func lastLines(text string, n int) string {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,20 @@
+package similarity
+
+import (
+	"os"
+	"syscall"
+)
+
+// processStats returns the signal that ended the process, if any, and its
+// peak resident memory. Linux reports ru_maxrss in kilobytes.
+func processStats(state *os.ProcessState) (string, int64) {
+	var signal string
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		signal = status.Signal().String()
+	}
+	var maxRSS int64
+	if usage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		maxRSS = usage.Maxrss * 1024
+	}
+	return signal, maxRSS
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package similarity
+
+import "os"
+
+// processStats reports nothing beyond the exit code outside Linux.
+func processStats(state *os.ProcessState) (string, int64) {
+	return "", 0
+}
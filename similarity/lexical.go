@@ -0,0 +1,269 @@
+package similarity
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	DefaultShingleSize = 3
+	DefaultNumHashes   = 128
+)
+
+// tokenize lowercases text and splits it on anything that is not a letter or digit.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// tokenizeProtected is tokenize, except that whole-word occurrences of the
+// entities are kept verbatim as single tokens.
+func tokenizeProtected(text string, entities []string) []string {
+	var tokens []string
+	for len(entities) > 0 {
+		start, entity := findEntity(text, entities)
+		if start < 0 {
+			break
+		}
+		tokens = append(tokens, tokenize(text[:start])...)
+		tokens = append(tokens, entity)
+		text = text[start+len(entity):]
+	}
+	return append(tokens, tokenize(text)...)
+}
+
+// findEntity returns the earliest whole-word occurrence of any entity in
+// text, preferring the longest entity when several start at the same
+// offset. It returns -1 if there is none.
+func findEntity(text string, entities []string) (int, string) {
+	best, found := -1, ""
+	for _, entity := range entities {
+		if entity == "" {
+			continue
+		}
+		for offset := 0; ; {
+			i := strings.Index(text[offset:], entity)
+			if i < 0 {
+				break
+			}
+			i += offset
+			if isWordBoundary(text, i) && isWordBoundary(text, i+len(entity)) {
+				if best < 0 || i < best || (i == best && len(entity) > len(found)) {
+					best, found = i, entity
+				}
+				break
+			}
+			_, size := utf8.DecodeRuneInString(text[i:])
+			offset = i + size
+		}
+	}
+	return best, found
+}
+
+// isWordBoundary reports whether byte offset i of text does not fall
+// between two letters or digits.
+func isWordBoundary(text string, i int) bool {
+	before, _ := utf8.DecodeLastRuneInString(text[:i])
+	after, _ := utf8.DecodeRuneInString(text[i:])
+	isWord := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsNumber(r) }
+	return i == 0 || i == len(text) || !isWord(before) || !isWord(after)
+}
+
+// shingles returns the set of word n-grams of the given size. Texts shorter
+// than the shingle size yield a single shingle made of all their tokens.
+func shingles(text string, size int) map[string]struct{} {
+	tokens := tokenize(text)
+	set := make(map[string]struct{})
+	if len(tokens) == 0 {
+		return set
+	}
+	if len(tokens) < size {
+		set[strings.Join(tokens, " ")] = struct{}{}
+		return set
+	}
+	for i := 0; i+size <= len(tokens); i++ {
+		set[strings.Join(tokens[i:i+size], " ")] = struct{}{}
+	}
+	return set
+}
+
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// mix64 is the splitmix64 finalizer, used to derive independent hash
+// functions from a single base hash.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// HasWords reports whether text has any words to fingerprint. Texts
+// without any, such as punctuation, all get the same SimHash and MinHash.
+func HasWords(text string) bool {
+	return len(tokenize(text)) > 0
+}
+
+// SimHash computes a 64-bit Charikar fingerprint over term-frequency weighted tokens.
+func SimHash(text string) uint64 {
+	var weights [64]int
+	counts := make(map[string]int)
+	for _, token := range tokenize(text) {
+		counts[token]++
+	}
+	for token, count := range counts {
+		h := hash64(token)
+		for bit := 0; bit < 64; bit++ {
+			if h&(1<<uint(bit)) != 0 {
+				weights[bit] += count
+			} else {
+				weights[bit] -= count
+			}
+		}
+	}
+
+	var fingerprint uint64
+	for bit := 0; bit < 64; bit++ {
+		if weights[bit] > 0 {
+			fingerprint |= 1 << uint(bit)
+		}
+	}
+	return fingerprint
+}
+
+// HammingDistance is the number of differing bits between two SimHashes.
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// MinHash computes a signature of numHashes minimum hash values over the
+// word shingles of text.
+func MinHash(text string, shingleSize, numHashes int) []uint32 {
+	signature := make([]uint32, numHashes)
+	for i := range signature {
+		signature[i] = ^uint32(0)
+	}
+	for shingle := range shingles(text, shingleSize) {
+		base := hash64(shingle)
+		for i := range signature {
+			if h := uint32(mix64(base^uint64(i+1)*0x9e3779b97f4a7c15) >> 32); h < signature[i] {
+				signature[i] = h
+			}
+		}
+	}
+	return signature
+}
+
+// JaccardEstimate returns the fraction of signature positions that agree.
+func JaccardEstimate(a, b []uint32) (float64, error) {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0, fmt.Errorf("signatures must be non-empty and of equal length, got %d and %d", len(a), len(b))
+	}
+	matches := 0
+	for i := range a {
+		if a[i] == b[i] {
+			matches++
+		}
+	}
+	return float64(matches) / float64(len(a)), nil
+}
+
+// DuplicatePair is a pair of input indexes whose estimated Jaccard
+// similarity met the threshold. A is always less than B.
+type DuplicatePair struct {
+	A       int
+	B       int
+	Jaccard float64
+}
+
+// NearDuplicates buckets MinHash signatures into LSH bands and verifies
+// each candidate pair against the Jaccard threshold. Texts that share no
+// bucket are never compared, but every pair within a bucket is, so the work
+// is quadratic in the size of the largest bucket: n copies of one text cost
+// n*(n-1)/2 comparisons. It returns the matching pairs, the connected
+// groups of duplicate indexes, and the number of candidate pairs that were
+// verified. numHashes must be a multiple of bands.
+func NearDuplicates(texts []string, threshold float64, shingleSize, numHashes, bands int) ([]DuplicatePair, [][]int, int) {
+	rows := numHashes / bands
+	signatures := make([][]uint32, len(texts))
+	for i, text := range texts {
+		signatures[i] = MinHash(text, shingleSize, numHashes)
+	}
+
+	type pairKey struct{ a, b int }
+	candidates := make(map[pairKey]struct{})
+	for band := 0; band < bands; band++ {
+		buckets := make(map[uint64][]int)
+		for i, sig := range signatures {
+			h := uint64(band)
+			for _, v := range sig[band*rows : (band+1)*rows] {
+				h = mix64(h ^ uint64(v))
+			}
+			buckets[h] = append(buckets[h], i)
+		}
+		for _, members := range buckets {
+			for x := 0; x < len(members); x++ {
+				for y := x + 1; y < len(members); y++ {
+					candidates[pairKey{members[x], members[y]}] = struct{}{}
+				}
+			}
+		}
+	}
+
+	parent := make([]int, len(texts))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	pairs := []DuplicatePair{}
+	for key := range candidates {
+		jaccard, _ := JaccardEstimate(signatures[key.a], signatures[key.b])
+		if jaccard < threshold {
+			continue
+		}
+		pairs = append(pairs, DuplicatePair{A: key.a, B: key.b, Jaccard: jaccard})
+		parent[find(key.a)] = find(key.b)
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Jaccard != pairs[j].Jaccard {
+			return pairs[i].Jaccard > pairs[j].Jaccard
+		}
+		if pairs[i].A != pairs[j].A {
+			return pairs[i].A < pairs[j].A
+		}
+		return pairs[i].B < pairs[j].B
+	})
+
+	members := make(map[int][]int)
+	for i := range texts {
+		root := find(i)
+		members[root] = append(members[root], i)
+	}
+	groups := [][]int{}
+	for i := range texts {
+		if group := members[i]; len(group) > 1 {
+			groups = append(groups, group)
+		}
+	}
+	return pairs, groups, len(candidates)
+}
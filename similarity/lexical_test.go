@@ -0,0 +1,207 @@
+package similarity
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"The Cat sat.", []string{"the", "cat", "sat"}},
+		{"SKU-12A, v2", []string{"sku", "12a", "v2"}},
+		{"  ", []string{}},
+		{"!?...", []string{}},
+		{"naïve café", []string{"naïve", "café"}},
+	}
+	for _, tt := range tests {
+		if got := tokenize(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestTokenizeProtected(t *testing.T) {
+	tests := []struct {
+		text     string
+		entities []string
+		want     []string
+	}{
+		{"Buy SKU-12A now", []string{"SKU-12A"}, []string{"buy", "SKU-12A", "now"}},
+		{"Buy XSKU-12A now", []string{"SKU-12A"}, []string{"buy", "xsku", "12a", "now"}},
+		{"iOS and iOS 17", []string{"iOS", "iOS 17"}, []string{"iOS", "and", "iOS 17"}},
+		{"no entities here", nil, []string{"no", "entities", "here"}},
+	}
+	for _, tt := range tests {
+		if got := tokenizeProtected(tt.text, tt.entities); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenizeProtected(%q, %q) = %q, want %q", tt.text, tt.entities, got, tt.want)
+		}
+	}
+}
+
+func TestHasWords(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"hello", true},
+		{"", false},
+		{" \t", false},
+		{"--!!--", false},
+		{"42", true},
+	}
+	for _, tt := range tests {
+		if got := HasWords(tt.text); got != tt.want {
+			t.Errorf("HasWords(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestShingles(t *testing.T) {
+	tests := []struct {
+		text string
+		size int
+		want []string
+	}{
+		{"a b c d", 3, []string{"a b c", "b c d"}},
+		{"a b", 3, []string{"a b"}},
+		{"a a a a", 2, []string{"a a"}},
+		{"", 3, nil},
+	}
+	for _, tt := range tests {
+		got := shingles(tt.text, tt.size)
+		if len(got) != len(tt.want) {
+			t.Errorf("shingles(%q, %d) has %d shingles, want %d", tt.text, tt.size, len(got), len(tt.want))
+			continue
+		}
+		for _, shingle := range tt.want {
+			if _, ok := got[shingle]; !ok {
+				t.Errorf("shingles(%q, %d) is missing %q", tt.text, tt.size, shingle)
+			}
+		}
+	}
+}
+
+func TestSimHash(t *testing.T) {
+	tests := []struct {
+		name        string
+		a, b        string
+		maxDistance int
+	}{
+		{"identical", "The quick brown fox", "The quick brown fox", 0},
+		{"case and punctuation", "The quick brown fox!", "the QUICK brown fox", 0},
+		{"word order", "brown fox quick the", "The quick brown fox", 0},
+		{"one word changed", "the quick brown fox jumps over the lazy dog", "the quick brown fox leaps over the lazy dog", 20},
+	}
+	for _, tt := range tests {
+		if got := HammingDistance(SimHash(tt.a), SimHash(tt.b)); got > tt.maxDistance {
+			t.Errorf("%s: distance %d, want at most %d", tt.name, got, tt.maxDistance)
+		}
+	}
+	if SimHash("") != 0 {
+		t.Errorf("SimHash of an empty text = %x, want 0", SimHash(""))
+	}
+}
+
+func TestHammingDistance(t *testing.T) {
+	tests := []struct {
+		a, b uint64
+		want int
+	}{
+		{0, 0, 0},
+		{0, 1, 1},
+		{0xff, 0x0f, 4},
+		{0, ^uint64(0), 64},
+	}
+	for _, tt := range tests {
+		if got := HammingDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("HammingDistance(%x, %x) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMinHash(t *testing.T) {
+	a := MinHash("the quick brown fox jumps over the lazy dog", DefaultShingleSize, DefaultNumHashes)
+	if len(a) != DefaultNumHashes {
+		t.Fatalf("MinHash returned %d values, want %d", len(a), DefaultNumHashes)
+	}
+	if b := MinHash("The quick brown fox jumps over the lazy dog.", DefaultShingleSize, DefaultNumHashes); !reflect.DeepEqual(a, b) {
+		t.Error("MinHash differs for texts that differ only in case and punctuation")
+	}
+
+	tests := []struct {
+		name     string
+		a, b     string
+		min, max float64
+	}{
+		{"identical", "one two three four five", "one two three four five", 1, 1},
+		{"disjoint", "one two three four five", "six seven eight nine ten", 0, 0.1},
+		// 3 of the 5 distinct 2-shingles are shared: Jaccard 0.6.
+		{"overlapping", "a b c d", "a b c e", 0.35, 0.85},
+	}
+	for _, tt := range tests {
+		got, err := JaccardEstimate(MinHash(tt.a, 2, 256), MinHash(tt.b, 2, 256))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got < tt.min || got > tt.max {
+			t.Errorf("%s: Jaccard estimate %v, want between %v and %v", tt.name, got, tt.min, tt.max)
+		}
+	}
+}
+
+func TestJaccardEstimateErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []uint32
+	}{
+		{"different lengths", []uint32{1, 2}, []uint32{1}},
+		{"empty", nil, nil},
+	}
+	for _, tt := range tests {
+		if _, err := JaccardEstimate(tt.a, tt.b); err == nil {
+			t.Errorf("%s: JaccardEstimate succeeded, want an error", tt.name)
+		}
+	}
+}
+
+func TestNearDuplicates(t *testing.T) {
+	texts := []string{
+		"The quick brown fox jumps over the lazy dog",
+		"completely unrelated words about something else entirely",
+		"The quick brown fox jumps over the lazy dog!",
+		"the quick brown fox jumps over the lazy dog",
+		"another sentence that shares nothing with the rest",
+	}
+	pairs, groups, candidates := NearDuplicates(texts, 0.8, DefaultShingleSize, DefaultNumHashes, 32)
+
+	wantPairs := []DuplicatePair{{A: 0, B: 2, Jaccard: 1}, {A: 0, B: 3, Jaccard: 1}, {A: 2, B: 3, Jaccard: 1}}
+	if !reflect.DeepEqual(pairs, wantPairs) {
+		t.Errorf("pairs = %+v, want %+v", pairs, wantPairs)
+	}
+	if wantGroups := [][]int{{0, 2, 3}}; !reflect.DeepEqual(groups, wantGroups) {
+		t.Errorf("groups = %v, want %v", groups, wantGroups)
+	}
+	if candidates < len(wantPairs) {
+		t.Errorf("candidates = %d, want at least %d", candidates, len(wantPairs))
+	}
+}
+
+func TestNearDuplicatesThreshold(t *testing.T) {
+	texts := []string{"a b c d e f g h", "a b c d e f g x"}
+	tests := []struct {
+		threshold float64
+		wantPairs int
+	}{
+		{0, 1},
+		{1, 0},
+	}
+	for _, tt := range tests {
+		pairs, _, _ := NearDuplicates(texts, tt.threshold, 2, 128, 64)
+		if len(pairs) != tt.wantPairs {
+			t.Errorf("threshold %v: %d pairs, want %d", tt.threshold, len(pairs), tt.wantPairs)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package similarity
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	htmlComment      = regexp.MustCompile(`(?s)<!--.*?-->`)
+	htmlRawElement   = regexp.MustCompile(`(?is)<(script|style|head|template)\b[^>]*>.*?</(script|style|head|template)\s*>`)
+	htmlTag          = regexp.MustCompile(`(?s)</?([a-zA-Z][a-zA-Z0-9-]*)\b(?:[^>"']|"[^"]*"|'[^']*')*/?>`)
+	htmlAlt          = regexp.MustCompile(`(?i)\balt\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	mdFence          = regexp.MustCompile("(?m)^[ \t]*(```|~~~).*$")
+	mdImage          = regexp.MustCompile(`!\[([^\]]*)\](?:\([^)]*\)|\[[^\]]*\])`)
+	mdLink           = regexp.MustCompile(`\[([^\]]+)\](?:\([^)]*\)|\[[^\]]*\])`)
+	mdReference      = regexp.MustCompile(`(?m)^[ \t]*\[[^\]]+\]:[ \t]*\S+.*$`)
+	mdAutolink       = regexp.MustCompile(`<(?:https?|ftp|mailto):[^>\s]*>`)
+	bareURL          = regexp.MustCompile(`\b(?:https?|ftp)://[^\s<>()\[\]]+|\bwww\.[^\s<>()\[\]]+`)
+	mdLinePrefix     = regexp.MustCompile(`(?m)^[ \t]*(?:#{1,6}[ \t]+|>[ \t>]*|[-*+][ \t]+|\d+[.)][ \t]+)+`)
+	mdHeadingClosing = regexp.MustCompile(`(?m)[ \t]+#+[ \t]*$`)
+	mdRule           = regexp.MustCompile(`(?m)^[ \t]*(?:[-*_][ \t]*){3,}$|^[ \t]*\|?[ \t]*:?-{3,}:?[ \t]*(?:\|[ \t]*:?-{3,}:?[ \t]*)*\|?[ \t]*$`)
+	mdSetextRule     = regexp.MustCompile(`(?m)^[ \t]*=+[ \t]*$`)
+	mdStrong         = regexp.MustCompile(`(\*\*|__|~~)(\S(?:.*?\S)?)(\*\*|__|~~)`)
+	mdEmphasis       = regexp.MustCompile(`(^|[^\w*])[*_](\S(?:[^*_\n]*\S)?)[*_]([^\w*]|$)`)
+	mdCode           = regexp.MustCompile("`+([^`]*)`+")
+	spaceRun         = regexp.MustCompile(`[ \t\f\v\p{Zs}]+`)
+	blankLines       = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlBlockTags end a line of text, so sentences in separate paragraphs,
+// list items or cells are not run together.
+var htmlBlockTags = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "br": true,
+	"dd": true, "div": true, "dl": true, "dt": true, "figcaption": true, "figure": true,
+	"footer": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"header": true, "hr": true, "li": true, "main": true, "nav": true, "ol": true, "p": true,
+	"pre": true, "section": true, "table": true, "td": true, "th": true, "tr": true, "ul": true,
+}
+
+// StripMarkup reduces Markdown or HTML to its readable text, so that two
+// rich-text documents are compared on content rather than formatting.
+// Link and image text is kept: an image becomes its alt text and a link
+// its anchor text. Tags, comments, scripts, styles and URLs are dropped,
+// HTML entities are decoded and whitespace is collapsed. Line breaks
+// between blocks are kept. Plain text passes through with only its
+// whitespace normalised.
+func StripMarkup(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = stripHTML(text)
+	text = stripMarkdown(text)
+	text = html.UnescapeString(text)
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(spaceRun.ReplaceAllString(line, " "))
+	}
+	text = blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(text)
+}
+
+func stripHTML(text string) string {
+	text = htmlComment.ReplaceAllString(text, "")
+	text = htmlRawElement.ReplaceAllString(text, "")
+	return htmlTag.ReplaceAllStringFunc(text, func(tag string) string {
+		name := strings.ToLower(htmlTag.FindStringSubmatch(tag)[1])
+		if name == "img" || name == "area" {
+			if alt := htmlAlt.FindStringSubmatch(tag); alt != nil {
+				return " " + alt[1] + alt[2] + alt[3] + " "
+			}
+			return " "
+		}
+		if htmlBlockTags[name] {
+			return "\n"
+		}
+		// Inline tags such as <b> or <a> may sit inside a word.
+		return ""
+	})
+}
+
+func stripMarkdown(text string) string {
+	text = mdFence.ReplaceAllString(text, "")
+	text = mdReference.ReplaceAllString(text, "")
+	text = mdImage.ReplaceAllString(text, "$1")
+	text = mdLink.ReplaceAllString(text, "$1")
+	text = mdAutolink.ReplaceAllString(text, "")
+	text = bareURL.ReplaceAllString(text, "")
+	text = mdRule.ReplaceAllString(text, "")
+	text = mdSetextRule.ReplaceAllString(text, "")
+	text = mdLinePrefix.ReplaceAllString(text, "")
+	text = mdHeadingClosing.ReplaceAllString(text, "")
+	text = mdCode.ReplaceAllString(text, "$1")
+	text = mdStrong.ReplaceAllString(text, "$2")
+	// A match consumes the character after it, so adjacent emphasis such
+	// as "*a* *b*" needs a second pass.
+	for i := 0; i < 2; i++ {
+		text = mdEmphasis.ReplaceAllString(text, "$1$2$3")
+	}
+	// Table cells are separated by pipes.
+	return strings.ReplaceAll(text, "|", " ")
+}
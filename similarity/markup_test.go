@@ -0,0 +1,74 @@
+package similarity
+
+import "testing"
+
+func TestStripMarkupHTML(t *testing.T) {
+	tests := []struct {
+		name, text, want string
+	}{
+		{"inline tags", "<p>The <b>quick</b> fox</p>", "The quick fox"},
+		{"inline tag inside a word", "un<i>believ</i>able", "unbelievable"},
+		{"blocks become lines", "<p>one</p><p>two</p>", "one\n\ntwo"},
+		{"list items", "<ul><li>a</li><li>b</li></ul>", "a\n\nb"},
+		{"line break", "first<br/>second", "first\nsecond"},
+		{"comment", "keep<!-- drop\nthis -->this", "keepthis"},
+		{"script and style", "<style>p{}</style>text<script>alert(1)</script>", "text"},
+		{"image alt", `see <img src="x.png" alt="a cat"> here`, "see a cat here"},
+		{"image alt single quotes", `<img alt='a dog' src=y.png>`, "a dog"},
+		{"image without alt", `a<img src="x.png">b`, "a b"},
+		{"attribute with angle bracket", `<a href="/x" title="a > b">link</a>`, "link"},
+		{"entities", "Fish &amp; chips &lt;3 caf&eacute;", "Fish & chips <3 café"},
+		{"table cells", "<table><tr><td>a</td><td>b</td></tr></table>", "a\n\nb"},
+	}
+	for _, tt := range tests {
+		if got := StripMarkup(tt.text); got != tt.want {
+			t.Errorf("%s: StripMarkup(%q) = %q, want %q", tt.name, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestStripMarkupMarkdown(t *testing.T) {
+	tests := []struct {
+		name, text, want string
+	}{
+		{"heading", "## Getting started ##", "Getting started"},
+		{"setext heading", "Title\n=====\nbody", "Title\n\nbody"},
+		{"emphasis", "a *very* _good_ **bold** ~~old~~ idea", "a very good bold old idea"},
+		{"adjacent emphasis", "*a* *b*", "a b"},
+		{"underscores inside words", "snake_case_name", "snake_case_name"},
+		{"inline code", "run `go test` now", "run go test now"},
+		{"fenced code", "```go\nfmt.Println()\n```", "fmt.Println()"},
+		{"link", "read [the docs](https://example.com/docs) first", "read the docs first"},
+		{"reference link", "see [the guide][1]\n\n[1]: https://example.com", "see the guide"},
+		{"image", "![a cat](cat.png) sleeping", "a cat sleeping"},
+		{"autolink", "mail <mailto:a@example.com> or visit <https://example.com>", "mail or visit"},
+		{"bare URL", "visit https://example.com/a?b=c or www.example.org today", "visit or today"},
+		{"lists", "- one\n* two\n3. three", "one\ntwo\nthree"},
+		{"blockquote", "> quoted\n> > nested", "quoted\nnested"},
+		{"rule", "above\n\n---\n\nbelow", "above\n\nbelow"},
+		{"table", "| a | b |\n|---|:---:|\n| 1 | 2 |", "a b\n\n1 2"},
+	}
+	for _, tt := range tests {
+		if got := StripMarkup(tt.text); got != tt.want {
+			t.Errorf("%s: StripMarkup(%q) = %q, want %q", tt.name, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestStripMarkupPlainText(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"plain text", "plain text"},
+		{"  spaced \t out  ", "spaced out"},
+		{"a\r\nb", "a\nb"},
+		{"a\n\n\n\nb", "a\n\nb"},
+		{"2 * 3 = 6", "2 * 3 = 6"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := StripMarkup(tt.text); got != tt.want {
+			t.Errorf("StripMarkup(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package similarity
+
+import (
+	"context"
+	"math"
+	"runtime"
+)
+
+const nativeDimensions = 1024
+
+// NativeBackend scores texts in pure Go with no model: each text is a
+// hashed vector of its word unigrams and bigrams, and similarity is their
+// cosine. It measures word overlap, not meaning, so paraphrases score low,
+// but it starts instantly and runs anywhere, including where a Python
+// subprocess is not an option. Entities in the call options are kept
+// verbatim as single tokens. It is safe for concurrent use.
+type NativeBackend struct{}
+
+func NewNativeBackend() *NativeBackend {
+	return &NativeBackend{}
+}
+
+func nativeVector(text string, entities []string) []float64 {
+	vector := make([]float64, nativeDimensions)
+	tokens := tokenizeProtected(text, entities)
+	for i, token := range tokens {
+		vector[hash64(token)%nativeDimensions]++
+		if i > 0 {
+			vector[hash64(tokens[i-1]+" "+token)%nativeDimensions]++
+		}
+	}
+	var norm float64
+	for _, value := range vector {
+		norm += value * value
+	}
+	if norm > 0 {
+		norm = math.Sqrt(norm)
+		for i := range vector {
+			vector[i] /= norm
+		}
+	}
+	return vector
+}
+
+func (NativeBackend) Similarity(ctx context.Context, a, b string) (float64, error) {
+	entities := CallOptionsFrom(ctx).Entities
+	return Cosine(nativeVector(a, entities), nativeVector(b, entities)), nil
+}
+
+func (n NativeBackend) Matrix(ctx context.Context, a, b []string) ([][]float64, error) {
+	vectorsB, _ := n.Embed(ctx, b)
+	entities := CallOptionsFrom(ctx).Entities
+	matrix := make([][]float64, len(a))
+	for i, text := range a {
+		vector := nativeVector(text, entities)
+		matrix[i] = make([]float64, len(b))
+		for j := range b {
+			matrix[i][j] = Cosine(vector, vectorsB[j])
+		}
+	}
+	return matrix, nil
+}
+
+func (NativeBackend) Embed(ctx context.Context, sentences []string) ([][]float64, error) {
+	entities := CallOptionsFrom(ctx).Entities
+	vectors := make([][]float64, len(sentences))
+	for i, sentence := range sentences {
+		vectors[i] = nativeVector(sentence, entities)
+	}
+	return vectors, nil
+}
+
+func (NativeBackend) Info(ctx context.Context) (*BackendInfo, error) {
+	return &BackendInfo{
+		Model:              "native:hashed-bigrams",
+		EmbeddingDimension: nativeDimensions,
+		Runtime:            runtime.Version(),
+		// Vectors are always unit length, so Normalize is honoured as is.
+		Parameters: []string{ParameterNormalize},
+	}, nil
+}
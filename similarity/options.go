@@ -0,0 +1,116 @@
+package similarity
+
+import (
+	"context"
+	"time"
+)
+
+// Option configures a Scorer in New.
+type Option func(*Scorer)
+
+// WithBackend replaces the default Python backend.
+func WithBackend(backend Backend) Option {
+	return func(s *Scorer) {
+		s.backend = backend
+	}
+}
+
+// WithModel selects the sentence-transformers model the default Python
+// backend loads. It has no effect together with WithBackend; set
+// PythonBackend.Model instead.
+func WithModel(model string) Option {
+	return func(s *Scorer) {
+		s.model = model
+	}
+}
+
+// WithPooling sets the pooling strategy for calls whose context carries
+// none; see CallOptions.
+func WithPooling(pooling string) Option {
+	return func(s *Scorer) {
+		s.pooling = pooling
+	}
+}
+
+// WithTimeout bounds each backend call, on top of any deadline on the
+// caller's context. A call that falls back gets a fresh timeout for the
+// fallback.
+func WithTimeout(timeout time.Duration) Option {
+	return func(s *Scorer) {
+		s.timeout = timeout
+	}
+}
+
+// WithCache stores pair scores from Score in cache. Matrix and Embed
+// results are not cached.
+func WithCache(cache Cache) Option {
+	return func(s *Scorer) {
+		s.cache = cache
+	}
+}
+
+// WithFallback answers calls with fallback when the backend fails, unless
+// the caller's context has already ended. Fallback scores are never cached.
+func WithFallback(fallback Backend) Option {
+	return func(s *Scorer) {
+		s.fallback = fallback
+	}
+}
+
+// WithConcurrency allows at most n backend calls at a time across all
+// goroutines using the Scorer. The default, or n <= 0, is no limit.
+func WithConcurrency(n int) Option {
+	return func(s *Scorer) {
+		s.slots = nil
+		if n > 0 {
+			s.slots = make(chan struct{}, n)
+		}
+	}
+}
+
+// Hooks run around each call to the primary backend, inside its
+// concurrency slot and timeout, so they bracket exactly the backend's
+// work. An error from Before fails the call without reaching the backend;
+// the fallback may still answer it. After gets the call's error, and its
+// own error fails a call that had succeeded. After runs even when the
+// call's context was cancelled. Implementations must be safe for
+// concurrent use.
+type Hooks interface {
+	Before(ctx context.Context) error
+	After(ctx context.Context, callErr error) error
+}
+
+// WithHooks runs hooks around every primary backend call.
+func WithHooks(hooks Hooks) Option {
+	return func(s *Scorer) {
+		s.hooks = hooks
+	}
+}
+
+// Tracer starts a span for each backend call, fallback calls included.
+// The span covers the wait for a concurrency slot, the hooks and the call
+// itself, and end receives the call's error. The returned context is the
+// one the backend sees. Implementations must be safe for concurrent use.
+type Tracer interface {
+	StartSpan(ctx context.Context, name string, attributes map[string]string) (spanCtx context.Context, end func(err error))
+}
+
+// WithTracer traces backend calls with tracer. Spans are named
+// "similarity.<operation>", such as similarity.embed, and carry the
+// backend's type and whether it was the primary or the fallback.
+func WithTracer(tracer Tracer) Option {
+	return func(s *Scorer) {
+		s.tracer = tracer
+	}
+}
+
+// WithCoalescing makes concurrent Score calls for the same pair, model and
+// call options share a single backend call, so a burst of identical
+// requests after a cache miss costs one computation. The shared call is
+// not cancelled when the caller that started it gives up; each caller
+// still stops waiting when its own context ends.
+func WithCoalescing() Option {
+	return func(s *Scorer) {
+		s.flights = newCoalescer()
+	}
+}
@@ -0,0 +1,35 @@
+package similarity
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is returned when a backend call, a hook or a cache panicked.
+// The Scorer recovers the panic so that one bad input fails only its own
+// call, even when the call runs on a goroutine of its own, as a coalesced
+// score does, where a panic would otherwise end the process.
+type PanicError struct {
+	Op    string
+	Value interface{}
+	// Stack is the panicking goroutine's stack.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("similarity: %s panicked: %v", e.Op, e.Value)
+}
+
+// recoverPanic is deferred by code that must not let a panic escape. It
+// replaces *err with a *PanicError and counts the panic.
+func (s *Scorer) recoverPanic(op string, err *error) {
+	if r := recover(); r != nil {
+		s.panics.Add(1)
+		*err = &PanicError{Op: op, Value: r, Stack: debug.Stack()}
+	}
+}
+
+// Panics returns the number of panics the Scorer has recovered.
+func (s *Scorer) Panics() int64 {
+	return s.panics.Load()
+}
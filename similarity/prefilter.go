@@ -0,0 +1,81 @@
+package similarity
+
+import "strings"
+
+// Prefilter decides cheaply whether a pair is obviously identical or
+// obviously dissimilar, so the model call can be skipped. A zero field
+// disables its check.
+type Prefilter struct {
+	// MinLengthRatio is the shorter/longer character length ratio below
+	// which a pair is dissimilar. The length difference is a lower bound on
+	// the edit distance, so this bounds Levenshtein similarity without
+	// computing it.
+	MinLengthRatio float64
+	// MinCharOverlap is the Jaccard overlap of character trigrams below
+	// which a pair is dissimilar.
+	MinCharOverlap float64
+	// MaxSimHashDistance is the SimHash Hamming distance above which a pair
+	// is dissimilar.
+	MaxSimHashDistance int
+}
+
+func charTrigrams(text string) map[string]struct{} {
+	runes := []rune(text)
+	grams := make(map[string]struct{})
+	if len(runes) < 3 {
+		grams[text] = struct{}{}
+		return grams
+	}
+	for i := 0; i+3 <= len(runes); i++ {
+		grams[string(runes[i:i+3])] = struct{}{}
+	}
+	return grams
+}
+
+// CharOverlap is the Jaccard similarity of the character trigrams of the
+// normalised texts.
+func CharOverlap(a, b string) float64 {
+	gramsA := charTrigrams(strings.Join(tokenize(a), " "))
+	gramsB := charTrigrams(strings.Join(tokenize(b), " "))
+	shared := 0
+	for gram := range gramsA {
+		if _, ok := gramsB[gram]; ok {
+			shared++
+		}
+	}
+	union := len(gramsA) + len(gramsB) - shared
+	if union == 0 {
+		return 0
+	}
+	return float64(shared) / float64(union)
+}
+
+// Estimate returns a score and true if the pair can be decided without the
+// model: 1 for texts that are identical after normalisation, or their
+// character overlap for texts that fail one of the checks. Otherwise it
+// returns false and the pair should be scored normally.
+func (p Prefilter) Estimate(a, b string) (float64, bool) {
+	normalizedA := strings.Join(tokenize(a), " ")
+	normalizedB := strings.Join(tokenize(b), " ")
+	if normalizedA == normalizedB && normalizedA != "" {
+		return 1, true
+	}
+
+	overlap := CharOverlap(a, b)
+	if p.MinLengthRatio > 0 {
+		lengthA, lengthB := len([]rune(normalizedA)), len([]rune(normalizedB))
+		if lengthA > lengthB {
+			lengthA, lengthB = lengthB, lengthA
+		}
+		if lengthB > 0 && float64(lengthA)/float64(lengthB) < p.MinLengthRatio {
+			return overlap, true
+		}
+	}
+	if p.MinCharOverlap > 0 && overlap < p.MinCharOverlap {
+		return overlap, true
+	}
+	if p.MaxSimHashDistance > 0 && HammingDistance(SimHash(a), SimHash(b)) > p.MaxSimHashDistance {
+		return overlap, true
+	}
+	return 0, false
+}
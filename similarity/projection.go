@@ -0,0 +1,213 @@
+package similarity
+
+import (
+	"errors"
+	"math"
+)
+
+const (
+	pcaIterations    = 200
+	kmeansIterations = 100
+)
+
+// ErrRaggedVectors is returned when vectors passed together differ in
+// length.
+var ErrRaggedVectors = errors.New("similarity: vectors must all have the same dimension")
+
+func checkVectors(vectors [][]float64) (int, error) {
+	if len(vectors) == 0 || len(vectors[0]) == 0 {
+		return 0, ErrEmptyInput
+	}
+	dim := len(vectors[0])
+	for _, vector := range vectors {
+		if len(vector) != dim {
+			return 0, ErrRaggedVectors
+		}
+	}
+	return dim, nil
+}
+
+// PCA projects vectors onto their first dims principal components. It
+// returns the coordinates and the share of the total variance each
+// component explains. Components are found by power iteration on the
+// centred data, which needs no covariance matrix, so it stays cheap for
+// embedding-sized dimensions. If the data has fewer than dims independent
+// directions, the remaining coordinates are zero.
+func PCA(vectors [][]float64, dims int) ([][]float64, []float64, error) {
+	dim, err := checkVectors(vectors)
+	if err != nil {
+		return nil, nil, err
+	}
+	if dims < 1 {
+		return nil, nil, errors.New("similarity: dims must be at least 1")
+	}
+
+	mean := make([]float64, dim)
+	for _, vector := range vectors {
+		for j, value := range vector {
+			mean[j] += value / float64(len(vectors))
+		}
+	}
+	centred := make([][]float64, len(vectors))
+	totalVariance := 0.0
+	for i, vector := range vectors {
+		centred[i] = make([]float64, dim)
+		for j, value := range vector {
+			centred[i][j] = value - mean[j]
+			totalVariance += centred[i][j] * centred[i][j]
+		}
+	}
+
+	components := make([][]float64, 0, dims)
+	explained := make([]float64, dims)
+	for k := 0; k < dims; k++ {
+		component := powerIteration(centred, components, k)
+		if component == nil {
+			break
+		}
+		components = append(components, component)
+		if totalVariance > 0 {
+			variance := 0.0
+			for _, row := range centred {
+				projection := dot(row, component)
+				variance += projection * projection
+			}
+			explained[k] = variance / totalVariance
+		}
+	}
+
+	coordinates := make([][]float64, len(vectors))
+	for i, row := range centred {
+		coordinates[i] = make([]float64, dims)
+		for k, component := range components {
+			coordinates[i][k] = dot(row, component)
+		}
+	}
+	return coordinates, explained, nil
+}
+
+// powerIteration finds the dominant direction of X^T X orthogonal to
+// previous, starting from a fixed vector so results are reproducible. It
+// returns nil when no variance is left.
+func powerIteration(x [][]float64, previous [][]float64, seed int) []float64 {
+	dim := len(x[0])
+	v := make([]float64, dim)
+	for j := range v {
+		v[j] = 1 / math.Sqrt(float64(j+seed+1))
+	}
+	for iteration := 0; iteration < pcaIterations; iteration++ {
+		next := make([]float64, dim)
+		for _, row := range x {
+			projection := dot(row, v)
+			for j, value := range row {
+				next[j] += projection * value
+			}
+		}
+		for _, component := range previous {
+			projection := dot(next, component)
+			for j := range next {
+				next[j] -= projection * component[j]
+			}
+		}
+		norm := math.Sqrt(dot(next, next))
+		if norm < 1e-12 {
+			return nil
+		}
+		for j := range next {
+			next[j] /= norm
+		}
+		converged := math.Abs(dot(next, v)) > 1-1e-12
+		v = next
+		if converged {
+			break
+		}
+	}
+	return v
+}
+
+func dot(a, b []float64) float64 {
+	sum := 0.0
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+func squaredDistance(a, b []float64) float64 {
+	sum := 0.0
+	for i := range a {
+		d := a[i] - b[i]
+		sum += d * d
+	}
+	return sum
+}
+
+// KMeans assigns each vector to one of k clusters by Lloyd's algorithm.
+// Centroids are seeded deterministically, farthest-first from the first
+// vector, so the same input always gets the same labels. k is clamped to
+// the number of vectors.
+func KMeans(vectors [][]float64, k int) ([]int, error) {
+	if _, err := checkVectors(vectors); err != nil {
+		return nil, err
+	}
+	k = max(1, min(k, len(vectors)))
+
+	centroids := [][]float64{append([]float64(nil), vectors[0]...)}
+	nearest := make([]float64, len(vectors))
+	for i, vector := range vectors {
+		nearest[i] = squaredDistance(vector, centroids[0])
+	}
+	for len(centroids) < k {
+		farthest := 0
+		for i := range vectors {
+			if nearest[i] > nearest[farthest] {
+				farthest = i
+			}
+		}
+		centroid := append([]float64(nil), vectors[farthest]...)
+		centroids = append(centroids, centroid)
+		for i, vector := range vectors {
+			nearest[i] = math.Min(nearest[i], squaredDistance(vector, centroid))
+		}
+	}
+
+	labels := make([]int, len(vectors))
+	for iteration := 0; iteration < kmeansIterations; iteration++ {
+		changed := iteration == 0
+		for i, vector := range vectors {
+			best := 0
+			for c := 1; c < k; c++ {
+				if squaredDistance(vector, centroids[c]) < squaredDistance(vector, centroids[best]) {
+					best = c
+				}
+			}
+			if labels[i] != best {
+				labels[i], changed = best, true
+			}
+		}
+		if !changed {
+			break
+		}
+		counts := make([]int, k)
+		sums := make([][]float64, k)
+		for c := range sums {
+			sums[c] = make([]float64, len(vectors[0]))
+		}
+		for i, vector := range vectors {
+			counts[labels[i]]++
+			for j, value := range vector {
+				sums[labels[i]][j] += value
+			}
+		}
+		// A cluster that lost every member keeps its previous centroid.
+		for c := range centroids {
+			if counts[c] == 0 {
+				continue
+			}
+			for j := range centroids[c] {
+				centroids[c][j] = sums[c][j] / float64(counts[c])
+			}
+		}
+	}
+	return labels, nil
+}
@@ -0,0 +1,84 @@
+package similarity
+
+import (
+	"context"
+	"fmt"
+)
+
+// jsonProtocol sends one request document of the Python service protocol
+// and returns the decoded response. The Python and remote backends differ
+// only in how the document travels.
+type jsonProtocol func(ctx context.Context, req pythonRequest) (*pythonResponse, error)
+
+// send fills in the per-call options carried by ctx.
+func (call jsonProtocol) send(ctx context.Context, req pythonRequest) (*pythonResponse, error) {
+	opts := CallOptionsFrom(ctx)
+	req.Pooling, req.Normalize, req.MaxSeqLength = opts.Pooling, opts.Normalize, opts.MaxSeqLength
+	return call(ctx, req)
+}
+
+func (call jsonProtocol) Similarity(ctx context.Context, a, b string) (float64, error) {
+	resp, err := call.send(ctx, pythonRequest{
+		Sentence1: a,
+		Sentence2: b,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return resp.Similarity, nil
+}
+
+func (call jsonProtocol) SimilarityWithAudit(ctx context.Context, a, b string) (float64, *AuditBundle, error) {
+	resp, err := call.send(ctx, pythonRequest{
+		Sentence1: a,
+		Sentence2: b,
+		Audit:     true,
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	if resp.Audit != nil {
+		resp.Audit.BundleVersion = auditBundleVersion
+	}
+	return resp.Similarity, resp.Audit, nil
+}
+
+// Info asks the service which model and library versions it loaded.
+func (call jsonProtocol) Info(ctx context.Context) (*BackendInfo, error) {
+	resp, err := call.send(ctx, pythonRequest{Info: true})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Info == nil {
+		return nil, fmt.Errorf("service returned no info")
+	}
+	return resp.Info, nil
+}
+
+// Matrix embeds each sentence once and returns the full cosine matrix.
+func (call jsonProtocol) Matrix(ctx context.Context, a, b []string) ([][]float64, error) {
+	resp, err := call.send(ctx, pythonRequest{
+		Sentences1: a,
+		Sentences2: b,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Matrix) != len(a) {
+		return nil, fmt.Errorf("service returned %d rows, expected %d", len(resp.Matrix), len(a))
+	}
+	return resp.Matrix, nil
+}
+
+func (call jsonProtocol) Embed(ctx context.Context, sentences []string) ([][]float64, error) {
+	resp, err := call.send(ctx, pythonRequest{
+		Sentences: sentences,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Embeddings) != len(sentences) {
+		return nil, fmt.Errorf("service returned %d embeddings, expected %d", len(resp.Embeddings), len(sentences))
+	}
+	return resp.Embeddings, nil
+}
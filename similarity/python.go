@@ -0,0 +1,195 @@
+package similarity
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultPythonExecutable = "python3"
+	DefaultPythonScript     = "app/similarity_service.py"
+	DefaultPythonTimeout    = 30 * time.Second
+)
+
+// PythonBackend runs the sentence-transformers service script once per
+// call, exchanging a single JSON document over stdin/stdout. With
+// Persistent set it instead keeps Workers processes running, each loading
+// the model once, and pipelines calls to them as length-prefixed frames.
+// It is safe for concurrent use either way. Model selects the
+// sentence-transformers model; empty means the script's default.
+type PythonBackend struct {
+	Executable string
+	Script     string
+	Model      string
+	Timeout    time.Duration
+	Persistent bool
+	// Workers is the number of persistent processes; values below 1 mean
+	// one. Each loads its own copy of the model.
+	Workers int
+	// LogStderr, if set, is called with each line a persistent process
+	// writes to stderr. The last lines are also kept for ProcessFailure.
+	LogStderr func(pid int, line string)
+
+	mu    sync.Mutex
+	procs []*pythonProcess
+}
+
+func NewPythonBackend() *PythonBackend {
+	return &PythonBackend{
+		Executable: DefaultPythonExecutable,
+		Script:     DefaultPythonScript,
+		Timeout:    DefaultPythonTimeout,
+	}
+}
+
+// AuditInput describes how one input sentence reached the model.
+type AuditInput struct {
+	TextSHA256      string `json:"text_sha256"`
+	TokenCount      int    `json:"token_count"`
+	Truncated       bool   `json:"truncated"`
+	EmbeddingSHA256 string `json:"embedding_sha256"`
+}
+
+// AuditBundle carries everything needed to recompute and defend a score
+// later: the exact model weights, library versions, preprocessing and
+// truncation applied, and checksums of the embeddings that were compared.
+type AuditBundle struct {
+	BundleVersion  int               `json:"bundle_version"`
+	ServiceVersion string            `json:"service_version,omitempty"`
+	Model          string            `json:"model"`
+	ModelSHA256    string            `json:"model_sha256"`
+	Tokenizer      string            `json:"tokenizer"`
+	MaxSeqLength   int               `json:"max_seq_length"`
+	Libraries      map[string]string `json:"libraries"`
+	Preprocessing  []string          `json:"preprocessing"`
+	Inputs         []AuditInput      `json:"inputs"`
+	RawCosine      float64           `json:"raw_cosine"`
+}
+
+const auditBundleVersion = 1
+
+// BackendInfo is what a backend reports about its model and runtime.
+type BackendInfo struct {
+	Model              string            `json:"model"`
+	MaxSeqLength       int               `json:"max_seq_length,omitempty"`
+	EmbeddingDimension int               `json:"embedding_dimension,omitempty"`
+	Libraries          map[string]string `json:"libraries,omitempty"`
+	Runtime            string            `json:"runtime,omitempty"`
+	// Parameters are the per-call model parameters the backend honours,
+	// such as ParameterPooling.
+	Parameters []string `json:"parameters,omitempty"`
+}
+
+func sha256Hex(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+type pythonRequest struct {
+	Model        string   `json:"model,omitempty"`
+	Pooling      string   `json:"pooling,omitempty"`
+	Normalize    bool     `json:"normalize,omitempty"`
+	MaxSeqLength int      `json:"max_seq_length,omitempty"`
+	Sentence1    string   `json:"sentence1,omitempty"`
+	Sentence2    string   `json:"sentence2,omitempty"`
+	Sentences1   []string `json:"sentences1,omitempty"`
+	Sentences2   []string `json:"sentences2,omitempty"`
+	Sentences    []string `json:"sentences,omitempty"`
+	Audit        bool     `json:"audit,omitempty"`
+	Info         bool     `json:"info,omitempty"`
+}
+
+type pythonResponse struct {
+	Similarity float64      `json:"similarity"`
+	Matrix     [][]float64  `json:"matrix,omitempty"`
+	Embeddings [][]float64  `json:"embeddings,omitempty"`
+	Audit      *AuditBundle `json:"audit,omitempty"`
+	Info       *BackendInfo `json:"info,omitempty"`
+	Error      string       `json:"error,omitempty"`
+}
+
+func (p *PythonBackend) Similarity(ctx context.Context, a, b string) (float64, error) {
+	return jsonProtocol(p.run).Similarity(ctx, a, b)
+}
+
+func (p *PythonBackend) SimilarityWithAudit(ctx context.Context, a, b string) (float64, *AuditBundle, error) {
+	return jsonProtocol(p.run).SimilarityWithAudit(ctx, a, b)
+}
+
+// Info asks the service which model and library versions it loaded.
+func (p *PythonBackend) Info(ctx context.Context) (*BackendInfo, error) {
+	return jsonProtocol(p.run).Info(ctx)
+}
+
+// Matrix embeds each sentence once and returns the full cosine matrix.
+func (p *PythonBackend) Matrix(ctx context.Context, a, b []string) ([][]float64, error) {
+	return jsonProtocol(p.run).Matrix(ctx, a, b)
+}
+
+func (p *PythonBackend) Embed(ctx context.Context, sentences []string) ([][]float64, error) {
+	return jsonProtocol(p.run).Embed(ctx, sentences)
+}
+
+func (p *PythonBackend) run(ctx context.Context, req pythonRequest) (*pythonResponse, error) {
+	req.Model = p.Model
+
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	if p.Persistent {
+		resp, err := p.runPersistent(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Error != "" {
+			return nil, fmt.Errorf("python service error: %s", resp.Error)
+		}
+		return resp, nil
+	}
+
+	reqData, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	cmd := exec.CommandContext(ctx, p.Executable, p.Script)
+	cmd.Stdin = bytes.NewReader(reqData)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		switch {
+		case cmd.ProcessState == nil:
+			return nil, fmt.Errorf("python script failed: %w, stderr: %s", err, stderr.String())
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			return nil, newProcessFailure(cmd.ProcessState, err, stderr.String(), FailureTimeout)
+		case ctx.Err() != nil:
+			return nil, fmt.Errorf("python script failed: %w", ctx.Err())
+		}
+		return nil, newProcessFailure(cmd.ProcessState, err, stderr.String(), "")
+	}
+
+	var resp pythonResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("failed to parse python response: %w", err)
+	}
+
+	if resp.Error != "" {
+		return nil, fmt.Errorf("python service error: %s", resp.Error)
+	}
+
+	return &resp, nil
+}
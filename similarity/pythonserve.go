@@ -0,0 +1,442 @@
+package similarity
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// maxFrameSize bounds a single response frame, so a corrupted length
+// prefix fails instead of allocating gigabytes.
+const maxFrameSize = 256 << 20
+
+// maxStderrLine bounds a line of stderr held back for LogStderr; a longer
+// line is passed on in pieces.
+const maxStderrLine = 4096
+
+// pythonFrameRequest and pythonFrameResponse are the JSON payloads of the
+// persistent protocol: the one-shot documents plus an ID that pairs each
+// response with its request, since the service answers out of order.
+type pythonFrameRequest struct {
+	ID uint64 `json:"id"`
+	pythonRequest
+}
+
+type pythonFrameResponse struct {
+	ID uint64 `json:"id"`
+	pythonResponse
+}
+
+type frameReply struct {
+	resp *pythonResponse
+	err  error
+}
+
+// pythonProcess is a service started with --serve. Each frame on its
+// stdin and stdout is a 4-byte big-endian length followed by that many
+// bytes of JSON.
+type pythonProcess struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stderr *tailBuffer
+	// stderrLines passes the process's stderr on to LogStderr, if set.
+	stderrLines *lineWriter
+	writeMu     sync.Mutex
+	// done is closed once the process has exited and been waited for.
+	done chan struct{}
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan frameReply
+	// answered is when the process last sent a response frame.
+	answered time.Time
+	err      error
+	// killedFor is the failure class the process was killed for, if the
+	// backend killed it.
+	killedFor string
+}
+
+func startPythonProcess(executable, script string, logStderr func(pid int, line string)) (*pythonProcess, error) {
+	cmd := exec.Command(executable, script, "--serve")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	proc := &pythonProcess{
+		cmd:     cmd,
+		stdin:   stdin,
+		stderr:  &tailBuffer{limit: 4096},
+		pending: make(map[uint64]chan frameReply),
+		done:    make(chan struct{}),
+	}
+	cmd.Stderr = proc.stderr
+	if logStderr != nil {
+		proc.stderrLines = &lineWriter{log: func(line string) { logStderr(cmd.Process.Pid, line) }}
+		cmd.Stderr = io.MultiWriter(proc.stderr, proc.stderrLines)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start python service: %w", err)
+	}
+	go proc.read(stdout)
+	return proc, nil
+}
+
+// exited reports whether the process has stopped and must be replaced.
+func (proc *pythonProcess) exited() bool {
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+	return proc.err != nil
+}
+
+// load is the number of requests the process has yet to answer.
+func (proc *pythonProcess) load() int {
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+	return len(proc.pending)
+}
+
+// answeredSince reports whether the process has sent any response since t.
+func (proc *pythonProcess) answeredSince(t time.Time) bool {
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+	return !proc.answered.Before(t)
+}
+
+// send registers a request and writes its frame. The reply arrives on the
+// returned channel; callers that stop waiting must call forget. A process
+// that stopped reading its stdin blocks the write, so send gives up when
+// ctx ends and leaves the write to finish, or fail, in the background.
+func (proc *pythonProcess) send(ctx context.Context, req pythonRequest) (uint64, <-chan frameReply, error) {
+	proc.mu.Lock()
+	if proc.err != nil {
+		proc.mu.Unlock()
+		return 0, nil, proc.err
+	}
+	proc.nextID++
+	id := proc.nextID
+	replies := make(chan frameReply, 1)
+	proc.pending[id] = replies
+	proc.mu.Unlock()
+
+	payload, err := json.Marshal(pythonFrameRequest{ID: id, pythonRequest: req})
+	if err != nil {
+		proc.forget(id)
+		return 0, nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	frame := make([]byte, 4, 4+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	frame = append(frame, payload...)
+
+	written := make(chan error, 1)
+	go func() {
+		proc.writeMu.Lock()
+		defer proc.writeMu.Unlock()
+		if ctx.Err() != nil {
+			// The caller gave up while an earlier write held the lock.
+			written <- ctx.Err()
+			return
+		}
+		_, err := proc.stdin.Write(frame)
+		written <- err
+	}()
+	select {
+	case err = <-written:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		proc.forget(id)
+		if ctx.Err() != nil {
+			return 0, nil, ctx.Err()
+		}
+		return 0, nil, fmt.Errorf("failed to write to python service: %w", err)
+	}
+	return id, replies, nil
+}
+
+func (proc *pythonProcess) forget(id uint64) {
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+	delete(proc.pending, id)
+}
+
+// kill stops a process that stopped answering and waits for it to exit.
+// Requests still waiting on it fail with class, and the next pick
+// replaces it.
+func (proc *pythonProcess) kill(class string) {
+	proc.mu.Lock()
+	if proc.killedFor == "" {
+		proc.killedFor = class
+	}
+	proc.mu.Unlock()
+	proc.cmd.Process.Kill()
+	<-proc.done
+}
+
+// read delivers response frames until the stream ends or breaks, then
+// stops the process and fails every request still waiting.
+func (proc *pythonProcess) read(stdout io.Reader) {
+	defer close(proc.done)
+	reader := bufio.NewReader(stdout)
+	var err error
+	for {
+		var payload []byte
+		if payload, err = readFrame(reader); err != nil {
+			break
+		}
+		var frame pythonFrameResponse
+		if err = json.Unmarshal(payload, &frame); err != nil {
+			err = fmt.Errorf("failed to parse python response: %w", err)
+			break
+		}
+		proc.mu.Lock()
+		replies, ok := proc.pending[frame.ID]
+		delete(proc.pending, frame.ID)
+		proc.answered = time.Now()
+		proc.mu.Unlock()
+		if ok {
+			replies <- frameReply{resp: &frame.pythonResponse}
+		}
+	}
+
+	proc.cmd.Process.Kill()
+	cause, class := proc.cmd.Wait(), ""
+	if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		// The stream broke while the process was alive and was killed above.
+		cause, class = err, FailureCrash
+	}
+	if cause == nil {
+		cause = errors.New("exited unexpectedly")
+	}
+	if proc.stderrLines != nil {
+		proc.stderrLines.Flush()
+	}
+
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+	if proc.killedFor != "" {
+		cause, class = errors.New("killed after it stopped answering"), proc.killedFor
+	}
+	proc.err = newProcessFailure(proc.cmd.ProcessState, cause, proc.stderr.String(), class)
+	for id, replies := range proc.pending {
+		replies <- frameReply{err: proc.err}
+		delete(proc.pending, id)
+	}
+}
+
+// pickProcess returns the worker with the fewest requests outstanding. A
+// worker that stopped is replaced when it is picked, and workers are
+// started lazily until the pool is full.
+func (p *PythonBackend) pickProcess() (*pythonProcess, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	workers := p.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	slot := -1
+	for i, proc := range p.procs {
+		if proc.exited() {
+			slot = i
+			break
+		}
+		// An idle worker is as good as a new one and already has the
+		// model loaded.
+		if proc.load() == 0 {
+			return proc, nil
+		}
+	}
+	if slot < 0 && len(p.procs) < workers {
+		p.procs = append(p.procs, nil)
+		slot = len(p.procs) - 1
+	}
+	if slot < 0 {
+		best := p.procs[0]
+		for _, proc := range p.procs[1:] {
+			if proc.load() < best.load() {
+				best = proc
+			}
+		}
+		return best, nil
+	}
+
+	proc, err := startPythonProcess(p.Executable, p.Script, p.LogStderr)
+	if err != nil {
+		p.procs = append(p.procs[:slot], p.procs[slot+1:]...)
+		return nil, err
+	}
+	p.procs[slot] = proc
+	return proc, nil
+}
+
+// runPersistent sends req to the least loaded of the backend's
+// long-running processes.
+func (p *PythonBackend) runPersistent(ctx context.Context, req pythonRequest) (*pythonResponse, error) {
+	proc, err := p.pickProcess()
+	if err != nil {
+		return nil, err
+	}
+
+	sent := time.Now()
+	id, replies, err := proc.send(ctx, req)
+	if err == nil {
+		select {
+		case reply := <-replies:
+			return reply.resp, reply.err
+		case <-ctx.Done():
+			proc.forget(id)
+			err = ctx.Err()
+		}
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		return nil, err
+	}
+	// A process that answered other requests while this one waited is
+	// only slow on this one, so it keeps running. One that answered
+	// nothing may be stuck and would look idle once the request is
+	// forgotten, so it is replaced as a one-shot call would be.
+	if proc.answeredSince(sent) {
+		return nil, newProcessFailure(nil, err, proc.stderr.String(), FailureTimeout)
+	}
+	proc.kill(FailureTimeout)
+	return nil, newProcessFailure(proc.cmd.ProcessState, err, proc.stderr.String(), FailureTimeout)
+}
+
+// Close stops the persistent processes, if any are running. Requests
+// still in flight fail; the next call starts new processes.
+func (p *PythonBackend) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var errs []error
+	for _, proc := range p.procs {
+		errs = append(errs, proc.stdin.Close())
+	}
+	p.procs = nil
+	return errors.Join(errs...)
+}
+
+// Shutdown is Close, but waits for the processes to exit. A process that
+// is still running when ctx ends is killed. Call it once requests have
+// drained: requests still in flight fail.
+func (p *PythonBackend) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	procs := p.procs
+	p.procs = nil
+	p.mu.Unlock()
+
+	var errs []error
+	for _, proc := range procs {
+		errs = append(errs, proc.stdin.Close())
+	}
+	for _, proc := range procs {
+		select {
+		case <-proc.done:
+		case <-ctx.Done():
+			proc.cmd.Process.Kill()
+			<-proc.done
+			errs = append(errs, fmt.Errorf("python service %d killed: %w", proc.cmd.Process.Pid, ctx.Err()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func readFrame(reader io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > maxFrameSize {
+		return nil, fmt.Errorf("python service sent a %d byte frame, the limit is %d", size, maxFrameSize)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return payload, nil
+}
+
+// tailBuffer keeps the last limit bytes written to it, for reporting what
+// a long-running process logged before it died.
+type tailBuffer struct {
+	mu    sync.Mutex
+	limit int
+	data  []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.data = append(b.data, p...)
+	if extra := len(b.data) - b.limit; extra > 0 {
+		b.data = append(b.data[:0], b.data[extra:]...)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.data)
+}
+
+// lineWriter passes each line written to it to log, without the newline.
+// Blank lines are dropped.
+type lineWriter struct {
+	mu   sync.Mutex
+	log  func(line string)
+	line []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := len(p)
+	for len(p) > 0 {
+		room := maxStderrLine - len(w.line)
+		switch end := bytes.IndexByte(p, '\n'); {
+		case end >= 0 && end <= room:
+			w.line = append(w.line, p[:end]...)
+			w.flush()
+			p = p[end+1:]
+		case len(p) < room:
+			w.line = append(w.line, p...)
+			p = nil
+		default:
+			w.line = append(w.line, p[:room]...)
+			w.flush()
+			p = p[room:]
+		}
+	}
+	return n, nil
+}
+
+// Flush passes on a final line that did not end in a newline.
+func (w *lineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.flush()
+}
+
+func (w *lineWriter) flush() {
+	if line := string(bytes.TrimRight(w.line, "\r")); line != "" {
+		w.log(line)
+	}
+	w.line = w.line[:0]
+}
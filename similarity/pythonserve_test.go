@@ -0,0 +1,218 @@
+package similarity
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func frame(size uint32, payload string) []byte {
+	out := make([]byte, 4, 4+len(payload))
+	binary.BigEndian.PutUint32(out, size)
+	return append(out, payload...)
+}
+
+func TestReadFrame(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		want    string
+		wantErr error
+	}{
+		{"valid", frame(5, "hello"), "hello", nil},
+		{"empty payload", frame(0, ""), "", nil},
+		{"no input", nil, "", io.EOF},
+		{"truncated header", []byte{0, 0}, "", io.ErrUnexpectedEOF},
+		{"truncated payload", frame(10, "hello"), "", io.ErrUnexpectedEOF},
+		{"header only", frame(5, ""), "", io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		got, err := readFrame(bytes.NewReader(tt.input))
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: payload = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReadFrameTooLarge(t *testing.T) {
+	_, err := readFrame(bytes.NewReader(frame(maxFrameSize+1, "")))
+	if err == nil || errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("error = %v, want the frame size limit to be enforced before reading", err)
+	}
+}
+
+func TestReadFrameSequence(t *testing.T) {
+	reader := bytes.NewReader(append(frame(3, "one"), frame(3, "two")...))
+	for _, want := range []string{"one", "two"} {
+		got, err := readFrame(reader)
+		if err != nil || string(got) != want {
+			t.Fatalf("readFrame = %q, %v; want %q", got, err, want)
+		}
+	}
+	if _, err := readFrame(reader); !errors.Is(err, io.EOF) {
+		t.Errorf("error after the last frame = %v, want io.EOF", err)
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	tests := []struct {
+		name   string
+		limit  int
+		writes []string
+		want   string
+	}{
+		{"under limit", 10, []string{"abc", "def"}, "abcdef"},
+		{"at limit", 6, []string{"abc", "def"}, "abcdef"},
+		{"over limit", 4, []string{"abc", "def"}, "cdef"},
+		{"single large write", 3, []string{"abcdefgh"}, "fgh"},
+		{"nothing written", 4, nil, ""},
+	}
+	for _, tt := range tests {
+		buf := &tailBuffer{limit: tt.limit}
+		for _, w := range tt.writes {
+			if n, err := buf.Write([]byte(w)); n != len(w) || err != nil {
+				t.Fatalf("%s: Write(%q) = %d, %v", tt.name, w, n, err)
+			}
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("%s: String() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   []string
+	}{
+		{"one line", []string{"hello\n"}, []string{"hello"}},
+		{"split across writes", []string{"hel", "lo\nwor", "ld\n"}, []string{"hello", "world"}},
+		{"unterminated line flushed", []string{"a\nb"}, []string{"a", "b"}},
+		{"blank lines dropped", []string{"\n\na\r\n\n"}, []string{"a"}},
+		{"long line split", []string{strings.Repeat("x", maxStderrLine+1) + "\n"}, []string{strings.Repeat("x", maxStderrLine), "x"}},
+		{"nothing written", nil, nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		w := &lineWriter{log: func(line string) { got = append(got, line) }}
+		for _, data := range tt.writes {
+			if n, err := w.Write([]byte(data)); n != len(data) || err != nil {
+				t.Fatalf("%s: Write(%q) = %d, %v", tt.name, data, n, err)
+			}
+		}
+		w.Flush()
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: lines = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// newFakeServeBackend runs testdata/fake_service.py as a single persistent
+// worker.
+func newFakeServeBackend(t *testing.T) *PythonBackend {
+	t.Helper()
+	executable, err := exec.LookPath(DefaultPythonExecutable)
+	if err != nil {
+		t.Skipf("%s not found: %v", DefaultPythonExecutable, err)
+	}
+	backend := &PythonBackend{Executable: executable, Script: "testdata/fake_service.py", Persistent: true, Workers: 1}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		backend.Shutdown(ctx)
+	})
+	return backend
+}
+
+func runFake(backend *PythonBackend, sentence string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_, err := backend.runPersistent(ctx, pythonRequest{Sentence1: sentence, Sentence2: "b"})
+	return err
+}
+
+func wantTimeout(t *testing.T, err error) {
+	t.Helper()
+	var failure *ProcessFailure
+	if !errors.As(err, &failure) || failure.Class != FailureTimeout || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want a timeout failure", err)
+	}
+}
+
+func TestPersistentTimeoutKeepsAnsweringProcess(t *testing.T) {
+	backend := newFakeServeBackend(t)
+	hung := make(chan error, 1)
+	go func() { hung <- runFake(backend, "hang", time.Second) }()
+	time.Sleep(100 * time.Millisecond)
+	if err := runFake(backend, "a", 5*time.Second); err != nil {
+		t.Fatalf("request alongside the hung one failed: %v", err)
+	}
+	wantTimeout(t, <-hung)
+
+	proc := backend.procs[0]
+	if proc.exited() {
+		t.Fatal("the process was killed although it kept answering")
+	}
+	if err := runFake(backend, "a", 5*time.Second); err != nil {
+		t.Fatalf("request after the timeout failed: %v", err)
+	}
+	if backend.procs[0] != proc {
+		t.Error("the process was replaced although it kept answering")
+	}
+}
+
+func TestPersistentTimeoutReplacesSilentProcess(t *testing.T) {
+	backend := newFakeServeBackend(t)
+	wantTimeout(t, runFake(backend, "hang", 500*time.Millisecond))
+	proc := backend.procs[0]
+	if !proc.exited() {
+		t.Fatal("the process that stopped answering is still running")
+	}
+	if err := runFake(backend, "a", 5*time.Second); err != nil {
+		t.Fatalf("request after the kill failed: %v", err)
+	}
+	if backend.procs[0] == proc {
+		t.Error("the killed process was not replaced")
+	}
+}
+
+func TestPersistentSendHonoursDeadline(t *testing.T) {
+	t.Setenv("FAKE_SERVICE_DEAF", "1")
+	backend := newFakeServeBackend(t)
+	// Far more than a pipe buffers, so the write blocks.
+	sentence := strings.Repeat("x", 4<<20)
+	start := time.Now()
+	wantTimeout(t, runFake(backend, sentence, 500*time.Millisecond))
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("request took %v to give up on a blocked write", elapsed)
+	}
+}
+
+func TestPersistentLogsStderr(t *testing.T) {
+	backend := newFakeServeBackend(t)
+	lines := make(chan string, 16)
+	backend.LogStderr = func(pid int, line string) { lines <- line }
+	if err := runFake(backend, "a", 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case line := <-lines:
+		if line != "fake service ready" {
+			t.Errorf("logged %q, want the service's stderr line", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("nothing the service wrote to stderr was logged")
+	}
+}
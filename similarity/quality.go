@@ -0,0 +1,73 @@
+package similarity
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Quality issues reported by AssessQuality. Inputs like these carry little
+// meaning of their own and tend to produce misleadingly high scores.
+const (
+	QualityOK          = ""
+	QualityURL         = "url"
+	QualityEmoji       = "emoji"
+	QualityPunctuation = "punctuation"
+	QualityNumeric     = "numeric"
+	QualityBoilerplate = "boilerplate"
+)
+
+var urlPattern = regexp.MustCompile(`^(?i)(https?://|www\.)\S+$`)
+
+var boilerplatePhrases = map[string]struct{}{
+	"n a": {}, "na": {}, "none": {}, "null": {}, "nil": {}, "test": {}, "testing": {},
+	"asdf": {}, "lorem ipsum": {}, "lorem ipsum dolor sit amet": {}, "todo": {}, "tbd": {},
+	"ok": {}, "okay": {}, "yes": {}, "no": {}, "thanks": {}, "thank you": {}, "hi": {},
+	"hello": {}, "see attached": {}, "no comment": {}, "no comments": {}, "placeholder": {},
+	"sent from my iphone": {}, "click here": {}, "unsubscribe": {},
+}
+
+// AssessQuality reports why text is a low-information input, or QualityOK.
+func AssessQuality(text string) string {
+	text = Preprocess(text)
+	if text == "" {
+		return QualityOK
+	}
+
+	fields := strings.Fields(text)
+	allURLs := true
+	for _, field := range fields {
+		if !urlPattern.MatchString(field) {
+			allURLs = false
+			break
+		}
+	}
+	if allURLs {
+		return QualityURL
+	}
+
+	var letters, digits, symbols int
+	for _, r := range text {
+		switch {
+		case unicode.IsLetter(r):
+			letters++
+		case unicode.IsDigit(r):
+			digits++
+		case unicode.Is(unicode.So, r) || unicode.Is(unicode.Sk, r):
+			symbols++
+		}
+	}
+	switch {
+	case letters == 0 && digits > 0:
+		return QualityNumeric
+	case letters == 0 && digits == 0 && symbols > 0:
+		return QualityEmoji
+	case letters == 0 && digits == 0:
+		return QualityPunctuation
+	}
+
+	if _, ok := boilerplatePhrases[strings.Join(tokenize(text), " ")]; ok {
+		return QualityBoilerplate
+	}
+	return QualityOK
+}
@@ -0,0 +1,316 @@
+package similarity
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRedisTimeout = 250 * time.Millisecond
+	defaultRedisPort    = "6379"
+	maxIdleRedisConns   = 16
+	redisScanCount      = "1000"
+)
+
+// RedisCache is a Cache kept in Redis, so replicas behind a load balancer
+// share their scores. It speaks the Redis protocol over TCP itself rather
+// than pulling in a client library, since it needs only a handful of
+// commands. Cache has no way to report an error, so a Redis failure is a
+// miss on Get and a dropped score on Set; Stats counts both. It is safe
+// for concurrent use.
+type RedisCache struct {
+	// Prefix is prepended to every key, so the cache can share a Redis
+	// with other data and Purge removes only its own keys.
+	Prefix string
+	// TTL is how long Redis keeps each score; zero keeps it until Redis
+	// evicts it.
+	TTL time.Duration
+	// Timeout bounds each command, including dialling.
+	Timeout time.Duration
+
+	addr     string
+	password string
+	db       int
+	idle     chan *redisConn
+
+	mu    sync.Mutex
+	stats RedisCacheStats
+}
+
+// RedisCacheStats counts a RedisCache's lookups since it was created.
+// Misses include lookups that failed; Errors counts failed commands of
+// any kind.
+type RedisCacheStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	Errors int64 `json:"errors"`
+}
+
+// NewRedisCache returns a cache for a URL of the form
+// redis://[:password@]host[:port][/db]. It does not connect until the
+// first command.
+func NewRedisCache(rawURL string) (*RedisCache, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "redis" || u.Hostname() == "" {
+		return nil, fmt.Errorf("similarity: Redis URL must look like redis://[:password@]host[:port][/db]")
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultRedisPort
+	}
+	cache := &RedisCache{
+		Prefix:  "similarity:score:",
+		Timeout: DefaultRedisTimeout,
+		addr:    net.JoinHostPort(u.Hostname(), port),
+		idle:    make(chan *redisConn, maxIdleRedisConns),
+	}
+	if u.User != nil {
+		cache.password, _ = u.User.Password()
+		if cache.password == "" {
+			cache.password = u.User.Username()
+		}
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if cache.db, err = strconv.Atoi(db); err != nil || cache.db < 0 {
+			return nil, fmt.Errorf("similarity: Redis database %q must be a non-negative integer", db)
+		}
+	}
+	return cache, nil
+}
+
+// Addr is the host:port the cache connects to.
+func (c *RedisCache) Addr() string {
+	return c.addr
+}
+
+func (c *RedisCache) Get(key string) (float64, bool) {
+	reply, err := c.do(context.Background(), "GET", c.Prefix+key)
+	var score float64
+	if err == nil && reply != nil {
+		text, _ := reply.(string)
+		score, err = strconv.ParseFloat(text, 64)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.stats.Errors++
+	}
+	if err != nil || reply == nil {
+		c.stats.Misses++
+		return 0, false
+	}
+	c.stats.Hits++
+	return score, true
+}
+
+func (c *RedisCache) Set(key string, score float64) {
+	args := []string{"SET", c.Prefix + key, strconv.FormatFloat(score, 'g', -1, 64)}
+	if c.TTL > 0 {
+		args = append(args, "PX", strconv.FormatInt(c.TTL.Milliseconds(), 10))
+	}
+	if _, err := c.do(context.Background(), args...); err != nil {
+		c.mu.Lock()
+		c.stats.Errors++
+		c.mu.Unlock()
+	}
+}
+
+func (c *RedisCache) Stats() RedisCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Ping checks that Redis answers.
+func (c *RedisCache) Ping(ctx context.Context) error {
+	_, err := c.do(ctx, "PING")
+	return err
+}
+
+// Purge deletes every key under Prefix and returns how many it deleted.
+// Other replicas see the purge at once, since they share the keys.
+func (c *RedisCache) Purge(ctx context.Context) (int64, error) {
+	pattern := redisGlobEscaper.Replace(c.Prefix) + "*"
+	var deleted int64
+	cursor := "0"
+	for {
+		reply, err := c.do(ctx, "SCAN", cursor, "MATCH", pattern, "COUNT", redisScanCount)
+		if err != nil {
+			return deleted, err
+		}
+		page, ok := reply.([]interface{})
+		if !ok || len(page) != 2 {
+			return deleted, errors.New("similarity: unexpected SCAN reply from Redis")
+		}
+		cursor, _ = page[0].(string)
+		keys, _ := page[1].([]interface{})
+		if len(keys) > 0 {
+			args := []string{"DEL"}
+			for _, key := range keys {
+				if name, ok := key.(string); ok {
+					args = append(args, name)
+				}
+			}
+			reply, err := c.do(ctx, args...)
+			if err != nil {
+				return deleted, err
+			}
+			n, _ := reply.(int64)
+			deleted += n
+		}
+		if cursor == "0" || cursor == "" {
+			return deleted, nil
+		}
+	}
+}
+
+var redisGlobEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// do runs one command on an idle connection, or a new one. A connection
+// that failed is closed rather than reused, since its stream may hold
+// half a reply.
+func (c *RedisCache) do(ctx context.Context, args ...string) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	var conn *redisConn
+	select {
+	case conn = <-c.idle:
+	default:
+		var err error
+		if conn, err = c.dial(ctx); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := conn.do(ctx, args...)
+	var serverErr redisError
+	if err != nil && !errors.As(err, &serverErr) {
+		conn.Close()
+		return nil, err
+	}
+	select {
+	case c.idle <- conn:
+	default:
+		conn.Close()
+	}
+	return reply, err
+}
+
+func (c *RedisCache) dial(ctx context.Context) (*redisConn, error) {
+	var dialer net.Dialer
+	netConn, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, fmt.Errorf("similarity: connecting to Redis: %w", err)
+	}
+	conn := &redisConn{Conn: netConn, reader: bufio.NewReader(netConn)}
+	if c.password != "" {
+		if _, err := conn.do(ctx, "AUTH", c.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err := conn.do(ctx, "SELECT", strconv.Itoa(c.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// redisError is an error reply from the server. The connection is still
+// usable after one.
+type redisError string
+
+func (e redisError) Error() string {
+	return "similarity: Redis: " + string(e)
+}
+
+type redisConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// do sends a command and reads its reply: a string for simple and bulk
+// strings, nil for a null bulk string, an int64 for integers and a slice
+// for arrays.
+func (conn *redisConn) do(ctx context.Context, args ...string) (interface{}, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	var command strings.Builder
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(conn, command.String()); err != nil {
+		return nil, fmt.Errorf("similarity: writing to Redis: %w", err)
+	}
+	reply, err := conn.read()
+	if err != nil {
+		var serverErr redisError
+		if !errors.As(err, &serverErr) {
+			err = fmt.Errorf("similarity: reading from Redis: %w", err)
+		}
+	}
+	return reply, err
+}
+
+func (conn *redisConn) read() (interface{}, error) {
+	line, err := conn.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, errors.New("malformed reply")
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(conn.reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			// An error here leaves the rest of the array unread, so it
+			// must not pass as a redisError that keeps the connection.
+			if items[i], err = conn.read(); err != nil {
+				return nil, fmt.Errorf("array element: %v", err)
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unknown reply type %q", kind)
+	}
+}
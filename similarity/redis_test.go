@@ -0,0 +1,126 @@
+package similarity
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRedisRead(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    interface{}
+		wantErr bool
+	}{
+		{"simple string", "+OK\r\n", "OK", false},
+		{"integer", ":42\r\n", int64(42), false},
+		{"negative integer", ":-1\r\n", int64(-1), false},
+		{"bulk string", "$5\r\nhello\r\n", "hello", false},
+		{"bulk string with CRLF", "$4\r\na\r\nb\r\n", "a\r\nb", false},
+		{"empty bulk string", "$0\r\n\r\n", "", false},
+		{"null bulk string", "$-1\r\n", nil, false},
+		{"array", "*2\r\n$1\r\na\r\n:7\r\n", []interface{}{"a", int64(7)}, false},
+		{"nested array", "*2\r\n*1\r\n+x\r\n$-1\r\n", []interface{}{[]interface{}{"x"}, nil}, false},
+		{"null array", "*-1\r\n", nil, false},
+		{"missing CR", "+OK\n", nil, true},
+		{"empty simple string", "+\r\n", "", false},
+		{"too short", "\r\n", nil, true},
+		{"unknown type", "!oops\r\n", nil, true},
+		{"bad integer", ":abc\r\n", nil, true},
+		{"bad bulk length", "$x\r\n", nil, true},
+		{"truncated bulk string", "$10\r\nhello\r\n", nil, true},
+		{"truncated array", "*2\r\n+a\r\n", nil, true},
+		{"no input", "", nil, true},
+	}
+	for _, tt := range tests {
+		conn := &redisConn{reader: bufio.NewReader(strings.NewReader(tt.input))}
+		got, err := conn.read()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: read() = %#v, want %#v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRedisReadServerError(t *testing.T) {
+	conn := &redisConn{reader: bufio.NewReader(strings.NewReader("-ERR unknown command\r\n"))}
+	_, err := conn.read()
+	var serverErr redisError
+	if !errors.As(err, &serverErr) || string(serverErr) != "ERR unknown command" {
+		t.Errorf("error = %v, want the server's error reply", err)
+	}
+
+	// An error inside an array leaves the connection mid-reply, so it must
+	// not be mistaken for a plain server error.
+	conn = &redisConn{reader: bufio.NewReader(strings.NewReader("*2\r\n-ERR first\r\n+second\r\n"))}
+	if _, err := conn.read(); err == nil || errors.As(err, &serverErr) {
+		t.Errorf("error = %v, want a non-redisError", err)
+	}
+}
+
+func TestRedisCommandEncoding(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := &redisConn{Conn: client, reader: bufio.NewReader(client)}
+
+	const want = "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$0\r\n\r\n"
+	received := make(chan string, 1)
+	go func() {
+		defer server.Close()
+		buf := make([]byte, len(want))
+		io.ReadFull(server, buf)
+		received <- string(buf)
+		io.WriteString(server, "+OK\r\n")
+	}()
+
+	reply, err := conn.do(context.Background(), "SET", "key", "")
+	if err != nil || reply != "OK" {
+		t.Fatalf("do = %#v, %v; want OK", reply, err)
+	}
+	if got := <-received; got != want {
+		t.Errorf("command = %q, want %q", got, want)
+	}
+}
+
+func TestNewRedisCache(t *testing.T) {
+	tests := []struct {
+		url      string
+		addr     string
+		password string
+		db       int
+		wantErr  bool
+	}{
+		{"redis://localhost", "localhost:6379", "", 0, false},
+		{"redis://cache:7000/2", "cache:7000", "", 2, false},
+		{"redis://:secret@cache", "cache:6379", "secret", 0, false},
+		{"redis://secret@cache", "cache:6379", "secret", 0, false},
+		{"redis://[::1]:6380", "[::1]:6380", "", 0, false},
+		{"http://localhost", "", "", 0, true},
+		{"redis://", "", "", 0, true},
+		{"redis://localhost/x", "", "", 0, true},
+		{"redis://localhost/-1", "", "", 0, true},
+	}
+	for _, tt := range tests {
+		cache, err := NewRedisCache(tt.url)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewRedisCache(%q) error = %v, want error %v", tt.url, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if cache.Addr() != tt.addr || cache.password != tt.password || cache.db != tt.db {
+			t.Errorf("NewRedisCache(%q) = %s, %q, db %d; want %s, %q, db %d",
+				tt.url, cache.Addr(), cache.password, cache.db, tt.addr, tt.password, tt.db)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package similarity
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const DefaultRemoteTimeout = 10 * time.Second
+
+// RemoteBackend sends each call to a model server over HTTP. The server
+// receives the same JSON request document the Python script reads on
+// stdin and answers with the document it would print, so it can wrap
+// process_request from app/similarity_service.py. It is safe for
+// concurrent use.
+type RemoteBackend struct {
+	URL    string
+	Model  string
+	Client *http.Client
+}
+
+func NewRemoteBackend(url string) *RemoteBackend {
+	return &RemoteBackend{
+		URL:    url,
+		Client: &http.Client{Timeout: DefaultRemoteTimeout},
+	}
+}
+
+func (r *RemoteBackend) Similarity(ctx context.Context, a, b string) (float64, error) {
+	return jsonProtocol(r.post).Similarity(ctx, a, b)
+}
+
+func (r *RemoteBackend) SimilarityWithAudit(ctx context.Context, a, b string) (float64, *AuditBundle, error) {
+	return jsonProtocol(r.post).SimilarityWithAudit(ctx, a, b)
+}
+
+func (r *RemoteBackend) Info(ctx context.Context) (*BackendInfo, error) {
+	return jsonProtocol(r.post).Info(ctx)
+}
+
+func (r *RemoteBackend) Matrix(ctx context.Context, a, b []string) ([][]float64, error) {
+	return jsonProtocol(r.post).Matrix(ctx, a, b)
+}
+
+func (r *RemoteBackend) Embed(ctx context.Context, sentences []string) ([][]float64, error) {
+	return jsonProtocol(r.post).Embed(ctx, sentences)
+}
+
+func (r *RemoteBackend) post(ctx context.Context, req pythonRequest) (*pythonResponse, error) {
+	req.Model = r.Model
+	reqData, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, r.URL, bytes.NewReader(reqData))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("remote model request failed: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(httpResp.Body, 64<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read remote model response: %w", err)
+	}
+	var resp pythonResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("remote model returned status %d and an unparseable body: %w", httpResp.StatusCode, err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("remote model error: %s", resp.Error)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("remote model returned status %d", httpResp.StatusCode)
+	}
+	return &resp, nil
+}
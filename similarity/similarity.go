@@ -0,0 +1,335 @@
+// Package similarity is the scoring core of the Text Similarity API. It
+// can be embedded in other Go services without running the HTTP server:
+//
+//	scorer := similarity.New()
+//	score, err := scorer.Score(ctx, "AI is transforming the world.", "Artificial intelligence is changing society.")
+//
+// By default scores come from the sentence-transformers model through the
+// Python backend; use WithBackend to supply another Backend. The lexical
+// SimHash/MinHash functions in this package need no backend at all.
+//
+// A Scorer is safe for concurrent use and needs no locking by callers. Its
+// configuration is fixed by New, and the bundled LRUCache is internally
+// locked. The Python backend either starts a process per call, sharing
+// nothing between calls, or with Persistent set (the server's default)
+// keeps a pool of Workers processes that answer concurrent calls over a
+// framed protocol and are replaced if they die or time out. WithConcurrency bounds how many backend
+// calls run at once; further calls wait for a free slot or for their
+// context to end.
+package similarity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrEmptyInput is returned when an input is empty after preprocessing.
+var ErrEmptyInput = errors.New("similarity: inputs must be non-empty")
+
+// Backend computes model-based scores and embeddings. Inputs are already
+// preprocessed and non-empty when a Scorer calls it.
+type Backend interface {
+	Similarity(ctx context.Context, a, b string) (float64, error)
+	Matrix(ctx context.Context, a, b []string) ([][]float64, error)
+	Embed(ctx context.Context, sentences []string) ([][]float64, error)
+}
+
+// Auditor is implemented by backends that can report a reproducibility
+// bundle alongside a score.
+type Auditor interface {
+	SimilarityWithAudit(ctx context.Context, a, b string) (float64, *AuditBundle, error)
+}
+
+// Describer is implemented by backends that can report their model and
+// library versions.
+type Describer interface {
+	Info(ctx context.Context) (*BackendInfo, error)
+}
+
+type Scorer struct {
+	backend  Backend
+	fallback Backend
+	model    string
+	pooling  string
+	timeout  time.Duration
+	cache    Cache
+	slots    chan struct{}
+	flights  *coalescer
+	hooks    Hooks
+	tracer   Tracer
+	panics   atomic.Int64
+}
+
+func New(opts ...Option) *Scorer {
+	s := &Scorer{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.backend == nil {
+		backend := NewPythonBackend()
+		backend.Model = s.model
+		s.backend = backend
+	}
+	return s
+}
+
+func (s *Scorer) Backend() Backend {
+	return s.backend
+}
+
+// Preprocess applies the normalisation every input goes through before
+// scoring. It currently trims surrounding whitespace.
+func Preprocess(text string) string {
+	return strings.TrimSpace(text)
+}
+
+func preprocessAll(texts []string) ([]string, error) {
+	if len(texts) == 0 {
+		return nil, ErrEmptyInput
+	}
+	out := make([]string, len(texts))
+	for i, text := range texts {
+		if out[i] = Preprocess(text); out[i] == "" {
+			return nil, ErrEmptyInput
+		}
+	}
+	return out, nil
+}
+
+// callOptions are the options carried by ctx, with the Scorer's defaults
+// filling in what the caller left unset.
+func (s *Scorer) callOptions(ctx context.Context) CallOptions {
+	opts := CallOptionsFrom(ctx)
+	if opts.Pooling == "" {
+		opts.Pooling = s.pooling
+	}
+	return opts
+}
+
+// attempt makes one backend call for op. Hooks run only around calls to
+// the primary backend. A panic in the call or its hooks is returned as a
+// *PanicError, which the fallback may still answer.
+func (s *Scorer) attempt(ctx context.Context, op string, backend Backend, primary bool, call func(context.Context, Backend) error) (err error) {
+	if s.tracer != nil {
+		role := "fallback"
+		if primary {
+			role = "primary"
+		}
+		var end func(error)
+		ctx, end = s.tracer.StartSpan(ctx, "similarity."+op, map[string]string{
+			"backend.role": role,
+			"backend.type": fmt.Sprintf("%T", backend),
+		})
+		defer func() { end(err) }()
+	}
+	defer s.recoverPanic(op, &err)
+	if s.pooling != "" {
+		ctx = WithCallOptions(ctx, s.callOptions(ctx))
+	}
+	timings := timingsFrom(ctx)
+	if s.slots != nil {
+		start := time.Now()
+		select {
+		case s.slots <- struct{}{}:
+			defer func() { <-s.slots }()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if timings != nil {
+			timings.addQueue(time.Since(start))
+		}
+	}
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	if timings != nil {
+		start := time.Now()
+		defer func() { timings.addBackend(time.Since(start)) }()
+	}
+	if !primary || s.hooks == nil {
+		return call(ctx, backend)
+	}
+	if err := s.hooks.Before(ctx); err != nil {
+		return err
+	}
+	err = call(ctx, backend)
+	if afterErr := s.hooks.After(context.WithoutCancel(ctx), err); err == nil {
+		err = afterErr
+	}
+	return err
+}
+
+// do runs call for op against the backend and, if that fails while ctx is still
+// live, against the fallback. It reports whether the fallback answered. If
+// the fallback does not support the call, the backend's error is returned.
+func (s *Scorer) do(ctx context.Context, op string, call func(context.Context, Backend) error) (bool, error) {
+	err := s.attempt(ctx, op, s.backend, true, call)
+	if err == nil || s.fallback == nil || ctx.Err() != nil {
+		return false, err
+	}
+	if fallbackErr := s.attempt(ctx, op, s.fallback, false, call); !errors.Is(fallbackErr, ErrUnsupported) {
+		return true, fallbackErr
+	}
+	return false, err
+}
+
+// Score returns the semantic similarity of a and b in the range [0, 1].
+func (s *Scorer) Score(ctx context.Context, a, b string) (float64, error) {
+	result, err := s.ScoreDetailed(ctx, a, b)
+	return result.Score, err
+}
+
+// ScoreResult is a pair score together with how it was obtained.
+type ScoreResult struct {
+	Score float64
+	// Cached is set when the score came from the cache.
+	Cached bool
+	// Coalesced is set when the score was computed for an identical call
+	// that was already in flight; see WithCoalescing.
+	Coalesced bool
+	// FellBack is set when the fallback backend answered.
+	FellBack bool
+}
+
+// ScoreDetailed is Score, reporting where the score came from.
+func (s *Scorer) ScoreDetailed(ctx context.Context, a, b string) (ScoreResult, error) {
+	a, b = Preprocess(a), Preprocess(b)
+	if a == "" || b == "" {
+		return ScoreResult{}, ErrEmptyInput
+	}
+	key := s.cacheKey(ctx, a, b)
+	if s.cache != nil {
+		if score, ok := s.cache.Get(key); ok {
+			return ScoreResult{Score: score, Cached: true}, nil
+		}
+	}
+	if s.flights == nil {
+		score, fellBack, err := s.score(ctx, key, a, b)
+		return ScoreResult{Score: score, FellBack: fellBack}, err
+	}
+
+	// The shared call must not fail because the caller that started it
+	// went away, so it runs detached from any one caller's cancellation.
+	// Backend timeouts still bound it.
+	f, leader := s.flights.join(key)
+	if leader {
+		go func() {
+			// Landing even after a panic, for example in a cache, keeps the
+			// callers waiting on this flight from hanging.
+			defer s.flights.land(key, f)
+			defer s.recoverPanic("similarity", &f.err)
+			f.score, f.fellBack, f.err = s.score(context.WithoutCancel(ctx), key, a, b)
+		}()
+	}
+	select {
+	case <-f.done:
+		return ScoreResult{Score: f.score, FellBack: f.fellBack, Coalesced: !leader}, f.err
+	case <-ctx.Done():
+		return ScoreResult{}, ctx.Err()
+	}
+}
+
+func (s *Scorer) score(ctx context.Context, key, a, b string) (float64, bool, error) {
+	var score float64
+	fellBack, err := s.do(ctx, "similarity", func(ctx context.Context, backend Backend) (err error) {
+		score, err = backend.Similarity(ctx, a, b)
+		return err
+	})
+	if err != nil {
+		return 0, fellBack, err
+	}
+	// Fallback scores are not cached, so the primary backend is used again
+	// as soon as it recovers.
+	if s.cache != nil && !fellBack {
+		s.cache.Set(key, score)
+	}
+	return score, fellBack, nil
+}
+
+// ScoreWithAudit is Score plus a reproducibility bundle. It returns a nil
+// bundle if the backend cannot produce one.
+func (s *Scorer) ScoreWithAudit(ctx context.Context, a, b string) (float64, *AuditBundle, error) {
+	a, b = Preprocess(a), Preprocess(b)
+	if a == "" || b == "" {
+		return 0, nil, ErrEmptyInput
+	}
+	var score float64
+	var bundle *AuditBundle
+	_, err := s.do(ctx, "audit", func(ctx context.Context, backend Backend) (err error) {
+		if auditor, ok := backend.(Auditor); ok {
+			score, bundle, err = auditor.SimilarityWithAudit(ctx, a, b)
+			return err
+		}
+		score, err = backend.Similarity(ctx, a, b)
+		return err
+	})
+	if err != nil || bundle == nil {
+		return score, bundle, err
+	}
+	bundle.Preprocessing = append([]string{"go:trim_whitespace"}, bundle.Preprocessing...)
+	for i, text := range []string{a, b} {
+		if i < len(bundle.Inputs) {
+			bundle.Inputs[i].TextSHA256 = sha256Hex(text)
+		}
+	}
+	return score, bundle, nil
+}
+
+// Matrix scores every sentence in a against every sentence in b.
+func (s *Scorer) Matrix(ctx context.Context, a, b []string) ([][]float64, error) {
+	a, err := preprocessAll(a)
+	if err != nil {
+		return nil, err
+	}
+	b, err = preprocessAll(b)
+	if err != nil {
+		return nil, err
+	}
+	return s.matrix(ctx, a, b)
+}
+
+func (s *Scorer) matrix(ctx context.Context, a, b []string) ([][]float64, error) {
+	var matrix [][]float64
+	_, err := s.do(ctx, "matrix", func(ctx context.Context, backend Backend) (err error) {
+		matrix, err = backend.Matrix(ctx, a, b)
+		return err
+	})
+	return matrix, err
+}
+
+// Info returns the backend's self-description, or nil if the backend
+// cannot describe itself.
+func (s *Scorer) Info(ctx context.Context) (*BackendInfo, error) {
+	describer, ok := s.backend.(Describer)
+	if !ok {
+		return nil, nil
+	}
+	var info *BackendInfo
+	err := s.attempt(ctx, "info", s.backend, true, func(ctx context.Context, _ Backend) (err error) {
+		info, err = describer.Info(ctx)
+		return err
+	})
+	return info, err
+}
+
+// Embed returns the embedding vector of each sentence. Embeddings from a
+// fallback backend generally live in a different vector space, so callers
+// that store embeddings should not use a fallback.
+func (s *Scorer) Embed(ctx context.Context, sentences []string) ([][]float64, error) {
+	sentences, err := preprocessAll(sentences)
+	if err != nil {
+		return nil, err
+	}
+	var embeddings [][]float64
+	_, err = s.do(ctx, "embed", func(ctx context.Context, backend Backend) (err error) {
+		embeddings, err = backend.Embed(ctx, sentences)
+		return err
+	})
+	return embeddings, err
+}
@@ -0,0 +1,135 @@
+package similarity
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+var (
+	segmentSplitter     = regexp.MustCompile(`[^\n.!?]+[.!?]*`)
+	templatePlaceholder = regexp.MustCompile(`\{\{[^}]*\}\}`)
+)
+
+// TemplateDiff is the outcome of StripTemplates.
+type TemplateDiff struct {
+	ResidualA       string `json:"residual1"`
+	ResidualB       string `json:"residual2"`
+	RemovedSegments int    `json:"removed_segments"`
+	RemovedPrefix   int    `json:"removed_prefix_tokens"`
+	RemovedSuffix   int    `json:"removed_suffix_tokens"`
+}
+
+func segments(text string) []string {
+	var out []string
+	for _, segment := range segmentSplitter.FindAllString(text, -1) {
+		if segment = strings.TrimSpace(segment); segment != "" {
+			out = append(out, segment)
+		}
+	}
+	return out
+}
+
+// SplitSentences splits text into trimmed sentence or line segments.
+func SplitSentences(text string) []string {
+	return segments(text)
+}
+
+func normalizeSegment(segment string) string {
+	return strings.Join(tokenize(segment), " ")
+}
+
+// compileTemplates turns each template segment into a pattern over
+// normalised text, where {{placeholder}} matches any run of words.
+func compileTemplates(templates []string) []*regexp.Regexp {
+	var patterns []*regexp.Regexp
+	for _, template := range templates {
+		for _, segment := range segments(template) {
+			parts := templatePlaceholder.Split(segment, -1)
+			literal := false
+			for i, part := range parts {
+				parts[i] = regexp.QuoteMeta(normalizeSegment(part))
+				literal = literal || parts[i] != ""
+			}
+			if !literal {
+				continue
+			}
+			patterns = append(patterns, regexp.MustCompile(`^\s*`+strings.Join(parts, `.*`)+`\s*$`))
+		}
+	}
+	return patterns
+}
+
+// StripTemplates removes the content two texts share so that only their
+// differences are scored. It drops sentence/line segments that appear in
+// both texts or match one of the templates ({{name}} marks a placeholder),
+// then trims the longest common word prefix and suffix of what remains.
+func StripTemplates(a, b string, templates []string) TemplateDiff {
+	var diff TemplateDiff
+	patterns := compileTemplates(templates)
+
+	isTemplate := func(normalized string) bool {
+		for _, pattern := range patterns {
+			if pattern.MatchString(normalized) {
+				return true
+			}
+		}
+		return false
+	}
+
+	segmentsA, segmentsB := segments(a), segments(b)
+	inA := make(map[string]struct{}, len(segmentsA))
+	for _, segment := range segmentsA {
+		inA[normalizeSegment(segment)] = struct{}{}
+	}
+	inB := make(map[string]struct{}, len(segmentsB))
+	for _, segment := range segmentsB {
+		inB[normalizeSegment(segment)] = struct{}{}
+	}
+
+	keep := func(list []string, other map[string]struct{}) []string {
+		var kept []string
+		for _, segment := range list {
+			normalized := normalizeSegment(segment)
+			if _, shared := other[normalized]; shared || normalized == "" || isTemplate(normalized) {
+				diff.RemovedSegments++
+				continue
+			}
+			kept = append(kept, segment)
+		}
+		return kept
+	}
+	wordsA := strings.Fields(strings.Join(keep(segmentsA, inB), " "))
+	wordsB := strings.Fields(strings.Join(keep(segmentsB, inA), " "))
+
+	same := func(x, y string) bool {
+		return normalizeSegment(x) == normalizeSegment(y)
+	}
+	for len(wordsA) > 0 && len(wordsB) > 0 && same(wordsA[0], wordsB[0]) {
+		wordsA, wordsB = wordsA[1:], wordsB[1:]
+		diff.RemovedPrefix++
+	}
+	for len(wordsA) > 0 && len(wordsB) > 0 && same(wordsA[len(wordsA)-1], wordsB[len(wordsB)-1]) {
+		wordsA, wordsB = wordsA[:len(wordsA)-1], wordsB[:len(wordsB)-1]
+		diff.RemovedSuffix++
+	}
+
+	diff.ResidualA = strings.Join(wordsA, " ")
+	diff.ResidualB = strings.Join(wordsB, " ")
+	return diff
+}
+
+// ScoreTemplateDiff scores only the content that differs between a and b
+// after StripTemplates. Texts with no differing content score 1; if only
+// one side has differing content the pair scores 0.
+func (s *Scorer) ScoreTemplateDiff(ctx context.Context, a, b string, templates []string) (float64, TemplateDiff, error) {
+	diff := StripTemplates(Preprocess(a), Preprocess(b), templates)
+	switch {
+	case diff.ResidualA == "" && diff.ResidualB == "":
+		return 1, diff, nil
+	case diff.ResidualA == "" || diff.ResidualB == "":
+		return 0, diff, nil
+	}
+	score, err := s.Score(ctx, diff.ResidualA, diff.ResidualB)
+	return score, diff, err
+}
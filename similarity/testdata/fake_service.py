@@ -0,0 +1,39 @@
+"""Stands in for app/similarity_service.py --serve in the backend's tests.
+
+Requests whose sentence1 is "hang" are never answered; every other request
+is answered at once with a similarity of 0.5. With FAKE_SERVICE_DEAF set the
+service stops reading its stdin, as a wedged process would.
+"""
+import json
+import os
+import struct
+import sys
+import threading
+import time
+
+FRAME_HEADER = struct.Struct('>I')
+
+
+def main():
+    stdin, stdout = sys.stdin.buffer, sys.stdout.buffer
+    print("fake service ready", file=sys.stderr, flush=True)
+    if os.environ.get("FAKE_SERVICE_DEAF"):
+        time.sleep(60)
+        return
+    write_lock = threading.Lock()
+    while True:
+        header = stdin.read(FRAME_HEADER.size)
+        if len(header) < FRAME_HEADER.size:
+            return
+        (size,) = FRAME_HEADER.unpack(header)
+        request = json.loads(stdin.read(size))
+        if request.get("sentence1") == "hang":
+            continue
+        payload = json.dumps({"id": request["id"], "similarity": 0.5}).encode('utf-8')
+        with write_lock:
+            stdout.write(FRAME_HEADER.pack(len(payload)) + payload)
+            stdout.flush()
+
+
+if __name__ == '__main__':
+    main()
@@ -0,0 +1,60 @@
+package similarity
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Timings accumulates where the Scorer calls made with one context spent
+// their time. It is safe for concurrent use, so calls fanned out from one
+// request can share it.
+type Timings struct {
+	mu      sync.Mutex
+	queue   time.Duration
+	backend time.Duration
+	calls   int
+}
+
+// TimingsSnapshot is the accumulated time waiting for a concurrency slot
+// (see WithConcurrency), the time inside backend calls, fallback included,
+// and the number of those calls.
+type TimingsSnapshot struct {
+	Queue        time.Duration
+	Backend      time.Duration
+	BackendCalls int
+}
+
+func (t *Timings) Snapshot() TimingsSnapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return TimingsSnapshot{Queue: t.queue, Backend: t.backend, BackendCalls: t.calls}
+}
+
+func (t *Timings) addQueue(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.queue += d
+}
+
+func (t *Timings) addBackend(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.backend += d
+	t.calls++
+}
+
+type timingsKey struct{}
+
+// WithTimings returns a context whose Scorer calls record their timings in
+// the returned Timings. A call that is coalesced with another waits for it
+// without recording anything.
+func WithTimings(ctx context.Context) (context.Context, *Timings) {
+	t := &Timings{}
+	return context.WithValue(ctx, timingsKey{}, t), t
+}
+
+func timingsFrom(ctx context.Context) *Timings {
+	t, _ := ctx.Value(timingsKey{}).(*Timings)
+	return t
+}
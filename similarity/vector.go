@@ -0,0 +1,23 @@
+package similarity
+
+import "math"
+
+// Cosine returns the cosine similarity of two vectors clamped to [0, 1],
+// matching the scale the model backend reports. Vectors of different
+// length or zero magnitude score 0.
+func Cosine(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	similarity := dot / (math.Sqrt(normA) * math.Sqrt(normB))
+	return math.Max(0, math.Min(1, similarity))
+}
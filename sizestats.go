@@ -0,0 +1,124 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Input size buckets, by the total characters a request scores.
+const (
+	sizeTiny     = "tiny"
+	sizeShort    = "short"
+	sizeLong     = "long"
+	sizeDocument = "document"
+)
+
+// sizeBucket returns the bucket for a request's total input characters:
+// tiny below 100, short below 1,000, long below 10,000 and document
+// beyond.
+func sizeBucket(chars int) string {
+	switch {
+	case chars < 100:
+		return sizeTiny
+	case chars < 1000:
+		return sizeShort
+	case chars < 10000:
+		return sizeLong
+	}
+	return sizeDocument
+}
+
+type sizeSeries struct {
+	requests     int64
+	serverErrors int64
+	latency      []int64
+}
+
+// SizeStats keeps a latency histogram per route and input size bucket
+// since startup, so a slow route can be told apart from a route that is
+// sent large inputs. Requests that score no text are not counted.
+type SizeStats struct {
+	mu     sync.Mutex
+	series map[string]map[string]*sizeSeries
+}
+
+type SizeBucketStats struct {
+	Requests     int64              `json:"requests"`
+	ServerErrors int64              `json:"server_errors"`
+	LatencyMs    LatencyPercentiles `json:"latency_ms"`
+}
+
+var sizeStats = &SizeStats{series: make(map[string]map[string]*sizeSeries)}
+
+func (s *SizeStats) Record(route string, chars, status int, latency time.Duration) {
+	ms := float64(latency) / float64(time.Millisecond)
+	bucket := sizeBucket(chars)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	buckets := s.series[route]
+	if buckets == nil {
+		buckets = make(map[string]*sizeSeries)
+		s.series[route] = buckets
+	}
+	series := buckets[bucket]
+	if series == nil {
+		series = &sizeSeries{latency: make([]int64, len(latencyBoundsMs)+1)}
+		buckets[bucket] = series
+	}
+	series.requests++
+	if status >= 500 {
+		series.serverErrors++
+	}
+	i := 0
+	for i < len(latencyBoundsMs) && ms > latencyBoundsMs[i] {
+		i++
+	}
+	series.latency[i]++
+}
+
+// Report maps each route to its size buckets.
+func (s *SizeStats) Report() map[string]map[string]SizeBucketStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	report := make(map[string]map[string]SizeBucketStats, len(s.series))
+	for route, buckets := range s.series {
+		report[route] = make(map[string]SizeBucketStats, len(buckets))
+		for bucket, series := range buckets {
+			report[route][bucket] = SizeBucketStats{
+				Requests:     series.requests,
+				ServerErrors: series.serverErrors,
+				LatencyMs: LatencyPercentiles{
+					P50: histogramPercentile(series.latency, series.requests, 0.50),
+					P90: histogramPercentile(series.latency, series.requests, 0.90),
+					P95: histogramPercentile(series.latency, series.requests, 0.95),
+					P99: histogramPercentile(series.latency, series.requests, 0.99),
+				},
+			}
+		}
+	}
+	return report
+}
+
+// Middleware records each request under its input size. It reads the
+// inputs handlers report with noteInputs, so it must run inside the slow
+// log middleware.
+func (s *SizeStats) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		value, ok := c.Get(slowRequestKey)
+		if !ok {
+			return
+		}
+		request := value.(*slowRequest)
+		request.mu.Lock()
+		count, chars := request.count, request.chars
+		request.mu.Unlock()
+		if count > 0 {
+			s.Record(c.FullPath(), chars, c.Writer.Status(), time.Since(start))
+		}
+	}
+}
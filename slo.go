@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxSLOWindowMinutes = 24 * 60
+	sloBurnRateMinutes  = 5
+)
+
+// SLODefinition is an objective for one route: Target of its requests
+// must finish within LatencyMs without a server error, measured over the
+// trailing WindowMinutes.
+type SLODefinition struct {
+	Name          string  `json:"name"`
+	Route         string  `json:"route"`
+	LatencyMs     float64 `json:"latency_ms"`
+	Target        float64 `json:"target"`
+	WindowMinutes int     `json:"window_minutes"`
+}
+
+var defaultSLOs = []SLODefinition{
+	{Name: "similarity_latency", Route: "/api/v1/similarity", LatencyMs: 300, Target: 0.99, WindowMinutes: 60},
+}
+
+func (d SLODefinition) validate() error {
+	switch {
+	case d.Name == "":
+		return fmt.Errorf("every SLO needs a name")
+	case d.Route == "":
+		return fmt.Errorf("SLO %s needs a route", d.Name)
+	case d.LatencyMs <= 0:
+		return fmt.Errorf("SLO %s latency_ms must be positive", d.Name)
+	case d.Target <= 0 || d.Target >= 1:
+		return fmt.Errorf("SLO %s target must be between 0 and 1, exclusive", d.Name)
+	case d.WindowMinutes < 1 || d.WindowMinutes > maxSLOWindowMinutes:
+		return fmt.Errorf("SLO %s window_minutes must be between 1 and %d", d.Name, maxSLOWindowMinutes)
+	}
+	return nil
+}
+
+// sloDefinitionsFromEnv reads SLO_DEFINITIONS, a JSON array of
+// definitions, falling back to defaultSLOs when it is unset.
+func sloDefinitionsFromEnv() ([]SLODefinition, error) {
+	raw := os.Getenv("SLO_DEFINITIONS")
+	if raw == "" {
+		return defaultSLOs, nil
+	}
+	var defs []SLODefinition
+	if err := json.Unmarshal([]byte(raw), &defs); err != nil {
+		return nil, fmt.Errorf("SLO_DEFINITIONS is not a JSON array of SLOs: %v", err)
+	}
+	names := make(map[string]bool, len(defs))
+	for _, def := range defs {
+		if err := def.validate(); err != nil {
+			return nil, err
+		}
+		if names[def.Name] {
+			return nil, fmt.Errorf("SLO name %s is used twice", def.Name)
+		}
+		names[def.Name] = true
+	}
+	return defs, nil
+}
+
+type sloBucket struct {
+	minute int64
+	total  int64
+	good   int64
+}
+
+// sloSeries keeps one bucket per minute of the SLO window.
+type sloSeries struct {
+	def     SLODefinition
+	buckets []sloBucket
+}
+
+func (s *sloSeries) counts(now, minutes int64) (total, good int64) {
+	for _, b := range s.buckets {
+		if b.minute > now-minutes && b.minute <= now {
+			total += b.total
+			good += b.good
+		}
+	}
+	return total, good
+}
+
+type SLOTracker struct {
+	mu      sync.Mutex
+	series  []*sloSeries
+	byRoute map[string][]*sloSeries
+}
+
+func NewSLOTracker(defs []SLODefinition) *SLOTracker {
+	t := &SLOTracker{byRoute: make(map[string][]*sloSeries)}
+	for _, def := range defs {
+		series := &sloSeries{def: def, buckets: make([]sloBucket, def.WindowMinutes)}
+		t.series = append(t.series, series)
+		t.byRoute[def.Route] = append(t.byRoute[def.Route], series)
+	}
+	return t
+}
+
+// sloTracker falls back to the default SLOs if SLO_DEFINITIONS is invalid;
+// startup validation reports the error and refuses to start in that case.
+var sloTracker = func() *SLOTracker {
+	defs, err := sloDefinitionsFromEnv()
+	if err != nil {
+		defs = defaultSLOs
+	}
+	return NewSLOTracker(defs)
+}()
+
+func (t *SLOTracker) Record(route string, status int, latency time.Duration) {
+	series := t.byRoute[route]
+	if len(series) == 0 {
+		return
+	}
+	minute := time.Now().Unix() / 60
+	ms := float64(latency) / float64(time.Millisecond)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range series {
+		b := &s.buckets[minute%int64(len(s.buckets))]
+		if b.minute != minute {
+			*b = sloBucket{minute: minute}
+		}
+		b.total++
+		if status < 500 && ms <= s.def.LatencyMs {
+			b.good++
+		}
+	}
+}
+
+type SLOStatus struct {
+	SLODefinition
+	Requests   int64   `json:"requests"`
+	Good       int64   `json:"good"`
+	Compliance float64 `json:"compliance"`
+	// ErrorBudget is the number of bad requests the target allows over
+	// the window at the current volume.
+	ErrorBudget float64 `json:"error_budget"`
+	// BudgetRemaining is the unspent fraction of the error budget; it goes
+	// negative once the SLO is breached.
+	BudgetRemaining float64 `json:"budget_remaining"`
+	// BurnRate is the bad-request rate of the last five minutes relative to
+	// the rate the target allows; above 1 the budget runs out before the
+	// window ends.
+	BurnRate float64 `json:"burn_rate"`
+	Status   string  `json:"status"`
+}
+
+func (t *SLOTracker) Report() []SLOStatus {
+	now := time.Now().Unix() / 60
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	report := make([]SLOStatus, 0, len(t.series))
+	for _, s := range t.series {
+		status := SLOStatus{SLODefinition: s.def, Compliance: 1, BudgetRemaining: 1, Status: "no_data"}
+		status.Requests, status.Good = s.counts(now, int64(s.def.WindowMinutes))
+		allowed := 1 - s.def.Target
+		if status.Requests > 0 {
+			bad := float64(status.Requests - status.Good)
+			status.Compliance = float64(status.Good) / float64(status.Requests)
+			status.ErrorBudget = allowed * float64(status.Requests)
+			status.BudgetRemaining = 1 - bad/status.ErrorBudget
+			if recent, good := s.counts(now, sloBurnRateMinutes); recent > 0 {
+				status.BurnRate = float64(recent-good) / float64(recent) / allowed
+			}
+			switch {
+			case status.Compliance < s.def.Target:
+				status.Status = "breached"
+			case status.BurnRate > 1:
+				status.Status = "at_risk"
+			default:
+				status.Status = "ok"
+			}
+		}
+		report = append(report, status)
+	}
+	return report
+}
+
+// sloMiddleware records every request against the SLOs of its route.
+func sloMiddleware(tracker *SLOTracker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isDeferredReplay(c) {
+			c.Next()
+			return
+		}
+		start := time.Now()
+		c.Next()
+		tracker.Record(c.FullPath(), c.Writer.Status(), time.Since(start))
+	}
+}
+
+func handleSLO(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"slos":         sloTracker.Report(),
+		"generated_at": time.Now().UTC().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+
+	"text-similarity-api/similarity"
+)
+
+const (
+	maxSlowRequests = 50
+	// maxSlowInputLengths caps the per-input lengths kept for one request;
+	// the count and total cover the rest.
+	maxSlowInputLengths = 32
+	slowRequestKey      = "slowlog.request"
+)
+
+// SlowTimings breaks a request's latency down. Other is everything
+// outside the backend, such as decoding, validation, deferral queueing
+// and waiting on a coalesced call.
+type SlowTimings struct {
+	TotalMs      float64 `json:"total_ms"`
+	QueueMs      float64 `json:"queue_ms"`
+	BackendMs    float64 `json:"backend_ms"`
+	OtherMs      float64 `json:"other_ms"`
+	BackendCalls int     `json:"backend_calls"`
+}
+
+// SlowRequest describes one of the slowest requests without its content:
+// inputs appear only as lengths and a fingerprint, so repeats of the same
+// payload can be recognised.
+type SlowRequest struct {
+	At           string      `json:"at"`
+	Method       string      `json:"method"`
+	Path         string      `json:"path"`
+	Status       int         `json:"status"`
+	LatencyMs    float64     `json:"latency_ms"`
+	Model        string      `json:"model"`
+	InputCount   int         `json:"input_count"`
+	InputChars   int         `json:"input_chars"`
+	InputLengths []int       `json:"input_lengths,omitempty"`
+	Fingerprint  string      `json:"fingerprint,omitempty"`
+	Timings      SlowTimings `json:"timings"`
+}
+
+// slowRequest collects what a request's handler reports about its inputs.
+type slowRequest struct {
+	mu      sync.Mutex
+	count   int
+	chars   int
+	lengths []int
+	digest  hash.Hash
+}
+
+// noteInputs records the texts a request scores for the slow log.
+func noteInputs(c *gin.Context, texts ...string) {
+	value, ok := c.Get(slowRequestKey)
+	if !ok {
+		return
+	}
+	request := value.(*slowRequest)
+	request.mu.Lock()
+	defer request.mu.Unlock()
+	for _, text := range texts {
+		length := utf8.RuneCountInString(text)
+		request.count++
+		request.chars += length
+		if len(request.lengths) < maxSlowInputLengths {
+			request.lengths = append(request.lengths, length)
+		}
+		request.digest.Write([]byte(text))
+		request.digest.Write([]byte{0})
+	}
+}
+
+// SlowLog keeps the slowest requests seen since startup, slowest first.
+type SlowLog struct {
+	mu      sync.Mutex
+	entries []SlowRequest
+}
+
+var slowLog = &SlowLog{}
+
+func (l *SlowLog) add(entry SlowRequest) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.entries) == maxSlowRequests && entry.LatencyMs <= l.entries[len(l.entries)-1].LatencyMs {
+		return
+	}
+	i := sort.Search(len(l.entries), func(i int) bool { return l.entries[i].LatencyMs < entry.LatencyMs })
+	l.entries = append(l.entries, SlowRequest{})
+	copy(l.entries[i+1:], l.entries[i:])
+	l.entries[i] = entry
+	if len(l.entries) > maxSlowRequests {
+		l.entries = l.entries[:maxSlowRequests]
+	}
+}
+
+// slowLogModel names what scored the request: the model the backend
+// reported in its handshake, or the backend kind before one succeeds.
+func slowLogModel() string {
+	backendHandshake.mu.Lock()
+	defer backendHandshake.mu.Unlock()
+	if info := backendHandshake.info; info != nil && info.Model != "" {
+		return info.Model
+	}
+	return backendKind()
+}
+
+// Middleware times each request and the Scorer calls it makes, and keeps
+// it if it is among the slowest.
+func (l *SlowLog) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		ctx, timings := similarity.WithTimings(c.Request.Context())
+		c.Request = c.Request.WithContext(ctx)
+		request := &slowRequest{digest: sha256.New()}
+		c.Set(slowRequestKey, request)
+		c.Set(backendTimingsKey, timings)
+
+		c.Next()
+
+		latency := time.Since(start)
+		scored := timings.Snapshot()
+		request.mu.Lock()
+		entry := SlowRequest{
+			At:           start.UTC().Format(time.RFC3339),
+			Method:       c.Request.Method,
+			Path:         c.FullPath(),
+			Status:       c.Writer.Status(),
+			LatencyMs:    milliseconds(latency),
+			Model:        slowLogModel(),
+			InputCount:   request.count,
+			InputChars:   request.chars,
+			InputLengths: request.lengths,
+			Timings: SlowTimings{
+				TotalMs:      milliseconds(latency),
+				QueueMs:      milliseconds(scored.Queue),
+				BackendMs:    milliseconds(scored.Backend),
+				OtherMs:      milliseconds(max(0, latency-scored.Queue-scored.Backend)),
+				BackendCalls: scored.BackendCalls,
+			},
+		}
+		if request.count > 0 {
+			entry.Fingerprint = hex.EncodeToString(request.digest.Sum(nil))[:16]
+		}
+		request.mu.Unlock()
+		l.add(entry)
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func handleSlowLog(c *gin.Context) {
+	slowLog.mu.Lock()
+	entries := append([]SlowRequest{}, slowLog.entries...)
+	slowLog.mu.Unlock()
+	c.JSON(http.StatusOK, gin.H{
+		"requests":     entries,
+		"generated_at": time.Now().UTC().Format(time.RFC3339),
+	})
+}
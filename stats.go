@@ -0,0 +1,237 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const statsSchemaVersion = 1
+
+// latencyBoundsMs are the upper bounds of the latency histogram buckets.
+// Percentiles are reported as the upper bound of the bucket they fall in.
+var latencyBoundsMs = []float64{1, 2, 5, 10, 25, 50, 75, 100, 150, 200, 300, 500, 750, 1000, 1500, 2000, 3000, 5000, 10000, 30000}
+
+type statsBucket struct {
+	second       int64
+	requests     int64
+	clientErrors int64
+	serverErrors int64
+	latency      []int64
+}
+
+// RollingStats keeps one bucket per second for the last hour, which is
+// enough to answer any window up to one hour without storing samples.
+type RollingStats struct {
+	mu      sync.Mutex
+	started time.Time
+	buckets []statsBucket
+	total   int64
+	errors  int64
+}
+
+func NewRollingStats() *RollingStats {
+	return &RollingStats{
+		started: time.Now(),
+		buckets: make([]statsBucket, 3600),
+	}
+}
+
+var requestStats = NewRollingStats()
+
+// coalescedRequests counts similarity requests answered by sharing an
+// identical request's in-flight computation.
+var coalescedRequests atomic.Int64
+
+func (s *RollingStats) Record(status int, latency time.Duration) {
+	now := time.Now().Unix()
+	ms := float64(latency) / float64(time.Millisecond)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := &s.buckets[now%int64(len(s.buckets))]
+	if b.second != now {
+		*b = statsBucket{second: now, latency: make([]int64, len(latencyBoundsMs)+1)}
+	}
+	b.requests++
+	s.total++
+	switch {
+	case status >= 500:
+		b.serverErrors++
+		s.errors++
+	case status >= 400:
+		b.clientErrors++
+	}
+
+	i := 0
+	for i < len(latencyBoundsMs) && ms > latencyBoundsMs[i] {
+		i++
+	}
+	b.latency[i]++
+}
+
+type LatencyPercentiles struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+}
+
+type WindowStats struct {
+	Requests      int64              `json:"requests"`
+	RequestRate   float64            `json:"request_rate"`
+	ServerErrors  int64              `json:"server_errors"`
+	ClientErrors  int64              `json:"client_errors"`
+	ErrorRate     float64            `json:"error_rate"`
+	LatencyMs     LatencyPercentiles `json:"latency_ms"`
+	WindowSeconds int64              `json:"window_seconds"`
+}
+
+type StatsSnapshot struct {
+	SchemaVersion int                    `json:"schema_version"`
+	GeneratedAt   string                 `json:"generated_at"`
+	UptimeSeconds int64                  `json:"uptime_seconds"`
+	TotalRequests int64                  `json:"total_requests"`
+	TotalErrors   int64                  `json:"total_errors"`
+	Windows       map[string]WindowStats `json:"windows"`
+	SLOs          []SLOStatus            `json:"slos"`
+	// BackendFailures counts requests failed by backend process deaths and
+	// timeouts since startup, by class.
+	BackendFailures map[string]int64 `json:"backend_failures"`
+	// CoalescedRequests counts requests that shared an identical request's
+	// computation since startup.
+	CoalescedRequests int64 `json:"coalesced_requests"`
+	// InputSizes breaks latency down by route and input size bucket since
+	// startup.
+	InputSizes map[string]map[string]SizeBucketStats `json:"input_sizes"`
+	// HookFailures counts failed backend hook runs since startup.
+	HookFailures int64 `json:"hook_failures"`
+	// SpansExported and SpansDropped count trace spans since startup; see
+	// tracing.go.
+	SpansExported int64 `json:"spans_exported"`
+	SpansDropped  int64 `json:"spans_dropped"`
+	// ProbeFailedRounds counts synthetic probe rounds with a failed case
+	// since startup; see /admin/probe.
+	ProbeFailedRounds int64 `json:"probe_failed_rounds"`
+	// Panics counts recovered panics since startup by where they
+	// happened: request handlers, batch and job items, deferred requests,
+	// probes and backend calls.
+	Panics map[string]int64 `json:"panics"`
+	// Jobs counts the async jobs held, by status.
+	Jobs map[string]int `json:"jobs"`
+}
+
+func (s *RollingStats) window(now int64, seconds int64) WindowStats {
+	w := WindowStats{WindowSeconds: seconds}
+	histogram := make([]int64, len(latencyBoundsMs)+1)
+	for i := range s.buckets {
+		b := &s.buckets[i]
+		if b.requests == 0 || b.second <= now-seconds || b.second > now {
+			continue
+		}
+		w.Requests += b.requests
+		w.ServerErrors += b.serverErrors
+		w.ClientErrors += b.clientErrors
+		for j, n := range b.latency {
+			histogram[j] += n
+		}
+	}
+
+	// Rates are computed over the time actually covered, so a freshly
+	// started instance does not report artificially low throughput.
+	covered := seconds
+	if uptime := int64(time.Since(s.started).Seconds()) + 1; uptime < covered {
+		covered = uptime
+	}
+	w.RequestRate = float64(w.Requests) / float64(covered)
+	if w.Requests > 0 {
+		w.ErrorRate = float64(w.ServerErrors) / float64(w.Requests)
+	}
+	w.LatencyMs = LatencyPercentiles{
+		P50: histogramPercentile(histogram, w.Requests, 0.50),
+		P90: histogramPercentile(histogram, w.Requests, 0.90),
+		P95: histogramPercentile(histogram, w.Requests, 0.95),
+		P99: histogramPercentile(histogram, w.Requests, 0.99),
+	}
+	return w
+}
+
+func histogramPercentile(histogram []int64, total int64, q float64) float64 {
+	if total == 0 {
+		return 0
+	}
+	rank := int64(q*float64(total) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, n := range histogram {
+		seen += n
+		if seen >= rank {
+			if i < len(latencyBoundsMs) {
+				return latencyBoundsMs[i]
+			}
+			break
+		}
+	}
+	return latencyBoundsMs[len(latencyBoundsMs)-1]
+}
+
+func (s *RollingStats) Snapshot() StatsSnapshot {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return StatsSnapshot{
+		SchemaVersion: statsSchemaVersion,
+		GeneratedAt:   now.UTC().Format(time.RFC3339),
+		UptimeSeconds: int64(now.Sub(s.started).Seconds()),
+		TotalRequests: s.total,
+		TotalErrors:   s.errors,
+		Windows: map[string]WindowStats{
+			"1m": s.window(now.Unix(), 60),
+			"5m": s.window(now.Unix(), 300),
+			"1h": s.window(now.Unix(), 3600),
+		},
+	}
+}
+
+// statsMiddleware records the status and latency of every request.
+func statsMiddleware(stats *RollingStats) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isDeferredReplay(c) {
+			c.Next()
+			return
+		}
+		start := time.Now()
+		c.Next()
+		stats.Record(c.Writer.Status(), time.Since(start))
+	}
+}
+
+// statsSnapshot is the document served as /admin/stats.json.
+func statsSnapshot() StatsSnapshot {
+	snapshot := requestStats.Snapshot()
+	snapshot.SLOs = sloTracker.Report()
+	snapshot.BackendFailures = backendFailures.Counts()
+	snapshot.CoalescedRequests = coalescedRequests.Load()
+	snapshot.InputSizes = sizeStats.Report()
+	snapshot.HookFailures = hookFailures()
+	snapshot.ProbeFailedRounds = probeFailedRounds()
+	snapshot.Panics = panicCounts.Counts()
+	snapshot.Jobs = jobQueue.Counts()
+	if requestTracer != nil {
+		snapshot.SpansExported = requestTracer.exported.Load()
+		snapshot.SpansDropped = requestTracer.dropped.Load()
+	}
+	return snapshot
+}
+
+func handleStatsJSON(c *gin.Context) {
+	c.JSON(http.StatusOK, statsSnapshot())
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"text-similarity-api/similarity"
+)
+
+const defaultSupportThreshold = 0.6
+
+type SummaryInput struct {
+	Summary   string   `json:"summary" binding:"required"`
+	Sources   []string `json:"sources" binding:"required,min=1"`
+	Threshold float64  `json:"threshold"`
+}
+
+type SourceRef struct {
+	Document int    `json:"document"`
+	Index    int    `json:"index"`
+	Sentence string `json:"sentence"`
+}
+
+type SummarySentence struct {
+	Index     int     `json:"index"`
+	Sentence  string  `json:"sentence"`
+	Supported bool    `json:"supported"`
+	Support   float64 `json:"support"`
+	// Source is the most similar source sentence, whether or not it clears
+	// the threshold.
+	Source SourceRef `json:"source"`
+}
+
+type SummaryResponse struct {
+	// Faithfulness is the fraction of summary sentences supported by a
+	// source sentence at or above the threshold.
+	Faithfulness float64 `json:"faithfulness"`
+	// MeanSupport averages each summary sentence's best source score.
+	MeanSupport float64 `json:"mean_support"`
+	// SourceCoverage is the fraction of source sentences that support at
+	// least one summary sentence.
+	SourceCoverage float64           `json:"source_coverage"`
+	Threshold      float64           `json:"threshold"`
+	Sentences      []SummarySentence `json:"sentences"`
+	ProcessedAt    string            `json:"processed_at"`
+}
+
+func handleSummarySimilarity(c *gin.Context) {
+	var input SummaryInput
+	if err := bindInput(c, &input); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "Invalid input format: " + err.Error(),
+		})
+		return
+	}
+
+	threshold := input.Threshold
+	if threshold == 0 {
+		threshold = defaultSupportThreshold
+	}
+	if threshold < 0 || threshold > 1 {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "threshold must be between 0.0 and 1.0",
+		})
+		return
+	}
+
+	summary := similarity.SplitSentences(input.Summary)
+	var sources []string
+	var refs []SourceRef
+	for document, text := range input.Sources {
+		for i, sentence := range similarity.SplitSentences(text) {
+			sources = append(sources, sentence)
+			refs = append(refs, SourceRef{Document: document, Index: i, Sentence: sentence})
+		}
+	}
+	if len(summary) == 0 || len(sources) == 0 {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "empty_sentences",
+			Message: "summary and sources must contain at least one sentence",
+		})
+		return
+	}
+	lim := limitsFor(c)
+	if len(summary) > lim.MaxSummarySentences || len(sources) > lim.MaxSourceSentences {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: fmt.Sprintf("summaries are limited to %d sentences and sources to %d sentences in total", lim.MaxSummarySentences, lim.MaxSourceSentences),
+		})
+		return
+	}
+	if len(summary)*len(sources) > lim.MaxMatrixCells {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: fmt.Sprintf("%d summary sentences against %d source sentences exceeds the limit of %d comparisons", len(summary), len(sources), lim.MaxMatrixCells),
+		})
+		return
+	}
+	if !checkSentenceLengths(c, lim, summary...) || !checkSentenceLengths(c, lim, sources...) {
+		return
+	}
+
+	matrix, err := scorer.Matrix(c.Request.Context(), summary, sources)
+	if err != nil {
+		logBackendError(c, err, append(summary, sources...)...)
+		respond(c, http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to process summary similarity",
+		})
+		return
+	}
+
+	response := SummaryResponse{
+		Threshold:   threshold,
+		Sentences:   make([]SummarySentence, len(summary)),
+		ProcessedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	used := make([]bool, len(sources))
+	supported, total := 0, 0.0
+	for i, row := range matrix {
+		best := 0
+		for j, score := range row {
+			if score >= threshold {
+				used[j] = true
+			}
+			if score > row[best] {
+				best = j
+			}
+		}
+		sentence := SummarySentence{
+			Index:     i,
+			Sentence:  summary[i],
+			Supported: row[best] >= threshold,
+			Support:   row[best],
+			Source:    refs[best],
+		}
+		if sentence.Supported {
+			supported++
+		}
+		total += row[best]
+		response.Sentences[i] = sentence
+	}
+
+	usedCount := 0
+	for _, u := range used {
+		if u {
+			usedCount++
+		}
+	}
+	response.Faithfulness = float64(supported) / float64(len(summary))
+	response.MeanSupport = total / float64(len(summary))
+	response.SourceCoverage = float64(usedCount) / float64(len(sources))
+
+	respond(c, http.StatusOK, response)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"text-similarity-api/config"
+)
+
+const maxRecentLogLines = 1000
+
+// LogTail keeps the last lines written to the server and request logs, so
+// a support bundle can include them. Those logs are already redacted.
+type LogTail struct {
+	mu      sync.Mutex
+	lines   []string
+	partial string
+}
+
+var recentLogs = &LogTail{}
+
+func (t *LogTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	text := t.partial + string(p)
+	lines := strings.Split(text, "\n")
+	t.partial = lines[len(lines)-1]
+	t.lines = append(t.lines, lines[:len(lines)-1]...)
+	if extra := len(t.lines) - maxRecentLogLines; extra > 0 {
+		t.lines = append(t.lines[:0], t.lines[extra:]...)
+	}
+	return len(p), nil
+}
+
+func (t *LogTail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.lines) == 0 {
+		return ""
+	}
+	return strings.Join(t.lines, "\n") + "\n"
+}
+
+// captureLogs sets up structured logging and copies the server and
+// request logs into recentLogs. It must run before the router is built, since gin binds its
+// logger's writer then.
+func captureLogs() {
+	setupLogging(io.MultiWriter(os.Stderr, recentLogs))
+	gin.DefaultWriter = io.MultiWriter(os.Stdout, recentLogs)
+	gin.DefaultErrorWriter = io.MultiWriter(os.Stderr, recentLogs)
+}
+
+type bundleConfigCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+}
+
+// bundleConfig is the startup validation report, redacted like the
+// settings in /admin/config. It describes the effective configuration
+// without copying the raw environment.
+func bundleConfig() []bundleConfigCheck {
+	checks := validateConfig()
+	sanitized := make([]bundleConfigCheck, len(checks))
+	for i, check := range checks {
+		sanitized[i] = bundleConfigCheck{
+			Name:   check.Name,
+			OK:     check.OK,
+			Detail: config.Redact(check.Name, check.Detail),
+		}
+	}
+	return sanitized
+}
+
+// handleAdminConfig reports the effective configuration: the settings
+// given in the file or the environment, secrets redacted, and the
+// validation report, which also covers the defaults in use.
+func handleAdminConfig(c *gin.Context) {
+	settings := config.Effective()
+	if settings == nil {
+		settings = []config.Value{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"file":     config.File(),
+		"settings": settings,
+		"checks":   bundleConfig(),
+	})
+}
+
+// bundleVersion is /version without a fresh backend handshake, so that
+// building a bundle never waits on a backend that may be the problem.
+func bundleVersion() BuildInfo {
+	info := buildInfo()
+	backendHandshake.mu.Lock()
+	info.Backend = backendHandshake.info
+	backendHandshake.mu.Unlock()
+	return info
+}
+
+func writeBundleFile(archive *tar.Writer, name string, data []byte, modTime time.Time) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}
+	if err := archive.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := archive.Write(data)
+	return err
+}
+
+// writeSupportBundle writes a gzipped tar of the diagnostics an operator
+// attaches to a bug report.
+func writeSupportBundle(w io.Writer, now time.Time) error {
+	faults, faultCounts := faultInjector.Config()
+	documents := []struct {
+		name  string
+		value interface{}
+	}{
+		{"version.json", bundleVersion()},
+		{"config.json", bundleConfig()},
+		{"limits.json", limits.Get()},
+		{"faults.json", gin.H{"config": faults, "counters": faultCounts}},
+		{"stats.json", statsSnapshot()},
+		{"backend_failures.json", backendFailures.Report()},
+	}
+
+	zipped := gzip.NewWriter(w)
+	archive := tar.NewWriter(zipped)
+	for _, document := range documents {
+		data, err := json.MarshalIndent(document.value, "", "  ")
+		if err != nil {
+			return fmt.Errorf("%s: %w", document.name, err)
+		}
+		if err := writeBundleFile(archive, document.name, append(data, '\n'), now); err != nil {
+			return err
+		}
+	}
+	if err := writeBundleFile(archive, "logs.txt", []byte(recentLogs.String()), now); err != nil {
+		return err
+	}
+	var goroutines bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&goroutines, 2); err != nil {
+		return fmt.Errorf("goroutines.txt: %w", err)
+	}
+	if err := writeBundleFile(archive, "goroutines.txt", goroutines.Bytes(), now); err != nil {
+		return err
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return zipped.Close()
+}
+
+func handleSupportBundle(c *gin.Context) {
+	now := time.Now().UTC()
+	var bundle bytes.Buffer
+	if err := writeSupportBundle(&bundle, now); err != nil {
+		respond(c, http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to build support bundle: " + err.Error(),
+		})
+		return
+	}
+	filename := fmt.Sprintf("support-bundle-%s.tar.gz", now.Format("20060102T150405Z"))
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Data(http.StatusOK, "application/gzip", bundle.Bytes())
+}
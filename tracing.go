@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	requestIDHeader     = "X-Request-ID"
+	requestIDKey        = "request_id"
+	maxRequestIDLength  = 128
+	defaultServiceName  = "text-similarity-api"
+	defaultOTLPEndpoint = "http://localhost:4318"
+	spanQueueSize       = 2048
+	maxSpanBatch        = 512
+	spanFlushInterval   = 5 * time.Second
+	spanExportTimeout   = 10 * time.Second
+)
+
+// TracingConfig exports spans over OTLP/HTTP with protobuf encoding.
+type TracingConfig struct {
+	// Endpoint is the full URL spans are posted to, usually ending in
+	// /v1/traces.
+	Endpoint    string
+	ServiceName string
+	// Headers are sent with every export, typically for authentication.
+	Headers map[string]string
+}
+
+// tracingFromEnv reads the standard OpenTelemetry variables
+// OTEL_TRACES_EXPORTER, OTEL_EXPORTER_OTLP_ENDPOINT,
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT, OTEL_EXPORTER_OTLP_HEADERS,
+// OTEL_EXPORTER_OTLP_PROTOCOL and OTEL_SERVICE_NAME. It returns nil when
+// tracing is off: OTEL_TRACES_EXPORTER is none, or unset with no
+// endpoint configured.
+func tracingFromEnv() (*TracingConfig, error) {
+	base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	switch exporter := os.Getenv("OTEL_TRACES_EXPORTER"); exporter {
+	case "none":
+		return nil, nil
+	case "":
+		if base == "" && endpoint == "" {
+			return nil, nil
+		}
+	case "otlp":
+	default:
+		return nil, fmt.Errorf("OTEL_TRACES_EXPORTER %q must be otlp or none", exporter)
+	}
+	if protocol := os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL"); protocol != "" && protocol != "http/protobuf" {
+		return nil, fmt.Errorf("OTEL_EXPORTER_OTLP_PROTOCOL %q is not supported; only http/protobuf is", protocol)
+	}
+
+	if endpoint == "" {
+		if base == "" {
+			base = defaultOTLPEndpoint
+		}
+		endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+	if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("OTLP endpoint %q must be an http or https URL", endpoint)
+	}
+	config := &TracingConfig{
+		Endpoint:    endpoint,
+		ServiceName: defaultServiceName,
+		Headers:     make(map[string]string),
+	}
+	if name := strings.TrimSpace(os.Getenv("OTEL_SERVICE_NAME")); name != "" {
+		config.ServiceName = name
+	}
+	if raw := os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"); raw != "" {
+		for _, pair := range strings.Split(raw, ",") {
+			key, value, ok := strings.Cut(pair, "=")
+			key = strings.TrimSpace(key)
+			if !ok || key == "" {
+				return nil, fmt.Errorf("OTEL_EXPORTER_OTLP_HEADERS entry %q must look like key=value", pair)
+			}
+			decoded, err := url.QueryUnescape(strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("OTEL_EXPORTER_OTLP_HEADERS value for %s: %v", key, err)
+			}
+			config.Headers[key] = decoded
+		}
+	}
+	return config, nil
+}
+
+// Tracer exports spans through the OpenTelemetry SDK, in batches every
+// few seconds or as soon as a batch fills up. A full queue drops spans
+// rather than slowing requests down.
+type Tracer struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+	exported atomic.Int64
+	dropped  atomic.Int64
+}
+
+func NewTracer(config *TracingConfig) (*Tracer, error) {
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(config.Endpoint),
+		otlptracehttp.WithHeaders(config.Headers),
+		otlptracehttp.WithTimeout(spanExportTimeout),
+	)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tracer{}
+	t.provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(&countingExporter{SpanExporter: exporter, tracer: t},
+			sdktrace.WithMaxQueueSize(spanQueueSize),
+			sdktrace.WithMaxExportBatchSize(maxSpanBatch),
+			sdktrace.WithBatchTimeout(spanFlushInterval),
+		),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", config.ServiceName),
+			attribute.String("service.version", serviceVersion),
+		)),
+	)
+	t.tracer = t.provider.Tracer(defaultServiceName, trace.WithInstrumentationVersion(serviceVersion))
+	return t, nil
+}
+
+// countingExporter counts the spans its exporter sent or failed to send,
+// for /admin/stats.json.
+type countingExporter struct {
+	sdktrace.SpanExporter
+	tracer *Tracer
+}
+
+func (e *countingExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	if err := e.SpanExporter.ExportSpans(ctx, spans); err != nil {
+		e.tracer.dropped.Add(int64(len(spans)))
+		slog.Warn("Exporting spans failed", "spans", len(spans), "error", err)
+		return err
+	}
+	e.tracer.exported.Add(int64(len(spans)))
+	return nil
+}
+
+// requestTracer is nil when tracing is off or misconfigured; startup
+// validation refuses to start in the latter case.
+var requestTracer = func() *Tracer {
+	config, err := tracingFromEnv()
+	if err != nil || config == nil {
+		return nil
+	}
+	tracer, err := NewTracer(config)
+	if err != nil {
+		return nil
+	}
+	return tracer
+}()
+
+// StartSpan implements similarity.Tracer, so backend calls become client
+// spans under the request's span. A call that fails inside a request has
+// its span held until the request ends, when the sentences to redact from
+// its error are known.
+func (t *Tracer) StartSpan(ctx context.Context, name string, attributes map[string]string) (context.Context, func(error)) {
+	ctx, s := t.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient))
+	for key, value := range attributes {
+		s.SetAttributes(attribute.String(key, value))
+	}
+	return ctx, func(err error) {
+		if err == nil {
+			s.End()
+			return
+		}
+		heldSpansFrom(ctx).hold(s, err)
+	}
+}
+
+// Shutdown exports every queued span and stops the exporter, for use at
+// shutdown. It gives up when ctx ends.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	return t.provider.Shutdown(ctx)
+}
+
+type heldSpan struct {
+	span trace.Span
+	err  error
+	end  time.Time
+}
+
+// endFailedSpan ends a failed span at the time it failed, with its error
+// redacted as logBackendError redacts it.
+func endFailedSpan(held heldSpan, sentences ...string) {
+	held.span.SetStatus(codes.Error, logRedactor.Redact(held.err.Error(), sentences...))
+	held.span.End(trace.WithTimestamp(held.end))
+}
+
+// heldSpans keeps the failed backend spans of a request or job pair until
+// it ends, along with the sentences logged for its failures.
+type heldSpans struct {
+	mu        sync.Mutex
+	ended     bool
+	spans     []heldSpan
+	sentences []string
+}
+
+type heldSpansKey struct{}
+
+func withHeldSpans(ctx context.Context) (context.Context, *heldSpans) {
+	held := &heldSpans{}
+	return context.WithValue(ctx, heldSpansKey{}, held), held
+}
+
+func heldSpansFrom(ctx context.Context) *heldSpans {
+	held, _ := ctx.Value(heldSpansKey{}).(*heldSpans)
+	return held
+}
+
+// hold keeps s until end is called. With nothing to hold it, or once
+// end has been called, s ends at once.
+func (h *heldSpans) hold(s trace.Span, err error) {
+	held := heldSpan{s, err, time.Now()}
+	if h == nil {
+		endFailedSpan(held)
+		return
+	}
+	h.mu.Lock()
+	if !h.ended {
+		h.spans = append(h.spans, held)
+		h.mu.Unlock()
+		return
+	}
+	sentences := h.sentences
+	h.mu.Unlock()
+	endFailedSpan(held, sentences...)
+}
+
+// redact adds sentences to redact from the held spans' errors.
+func (h *heldSpans) redact(sentences ...string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sentences = append(h.sentences, sentences...)
+}
+
+// end ends the held spans, redacting sentences and those passed to redact
+// from their errors.
+func (h *heldSpans) end(sentences ...string) {
+	h.mu.Lock()
+	h.ended = true
+	spans := h.spans
+	h.spans = nil
+	h.sentences = append(h.sentences, sentences...)
+	sentences = h.sentences
+	h.mu.Unlock()
+	for _, held := range spans {
+		endFailedSpan(held, sentences...)
+	}
+}
+
+// validRequestID accepts IDs a client or proxy may send: up to 128
+// letters, digits and . _ : - characters, so they are safe to log and
+// echo back.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("._:-", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// requestTracing gives every request an ID and, with tracing on, a
+// server span. The ID is the client's X-Request-ID if it sent a valid
+// one, and otherwise the trace ID, so logs and traces can be matched. It
+// continues the trace of an incoming traceparent header.
+func requestTracing() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := propagation.TraceContext{}.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		var s trace.Span
+		if requestTracer != nil {
+			ctx, s = requestTracer.tracer.Start(ctx, c.Request.Method, trace.WithSpanKind(trace.SpanKindServer))
+		}
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID(id) {
+			id = traceIDFor(ctx)
+		}
+		c.Set(requestIDKey, id)
+		c.Header(requestIDHeader, id)
+		if requestTracer == nil {
+			c.Next()
+			return
+		}
+
+		ctx, held := withHeldSpans(ctx)
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+		held.end()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := c.Writer.Status()
+		s.SetName(c.Request.Method + " " + route)
+		s.SetAttributes(
+			attribute.String("http.method", c.Request.Method),
+			attribute.String("http.route", route),
+			attribute.String(requestIDKey, id),
+			attribute.Int("http.status_code", status),
+		)
+		if status >= 500 {
+			s.SetStatus(codes.Error, http.StatusText(status))
+		}
+		s.End()
+	}
+}
+
+// traceIDFor is the ID of the trace ctx belongs to, or a new random one
+// when it belongs to none.
+func traceIDFor(ctx context.Context) string {
+	if id := trace.SpanContextFromContext(ctx).TraceID(); id.IsValid() {
+		return id.String()
+	}
+	var id [16]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// requestID is the ID requestTracing gave the request.
+func requestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+func tracingStatus() string {
+	if requestTracer == nil {
+		return "disabled"
+	}
+	return "otlp"
+}
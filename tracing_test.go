@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newTestTracer swaps in a tracer that exports to memory as each span
+// ends, until the test finishes.
+func newTestTracer(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	saved := requestTracer
+	requestTracer = &Tracer{provider: provider, tracer: provider.Tracer(defaultServiceName)}
+	t.Cleanup(func() { requestTracer = saved })
+	return exporter
+}
+
+func TestTracingFromEnv(t *testing.T) {
+	tests := []struct {
+		name         string
+		env          map[string]string
+		wantEndpoint string
+		wantErr      bool
+	}{
+		{"off", nil, "", false},
+		{"base endpoint", map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318/"}, "http://collector:4318/v1/traces", false},
+		{"traces endpoint", map[string]string{"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": "https://collector/traces"}, "https://collector/traces", false},
+		{"exporter alone", map[string]string{"OTEL_TRACES_EXPORTER": "otlp"}, defaultOTLPEndpoint + "/v1/traces", false},
+		{"exporter none", map[string]string{"OTEL_TRACES_EXPORTER": "none", "OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318"}, "", false},
+		{"protobuf", map[string]string{"OTEL_TRACES_EXPORTER": "otlp", "OTEL_EXPORTER_OTLP_PROTOCOL": "http/protobuf"}, defaultOTLPEndpoint + "/v1/traces", false},
+		{"json", map[string]string{"OTEL_TRACES_EXPORTER": "otlp", "OTEL_EXPORTER_OTLP_PROTOCOL": "http/json"}, "", true},
+		{"grpc", map[string]string{"OTEL_TRACES_EXPORTER": "otlp", "OTEL_EXPORTER_OTLP_PROTOCOL": "grpc"}, "", true},
+		{"unknown exporter", map[string]string{"OTEL_TRACES_EXPORTER": "zipkin"}, "", true},
+		{"bad endpoint", map[string]string{"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": "collector:4318"}, "", true},
+		{"bad header", map[string]string{"OTEL_TRACES_EXPORTER": "otlp", "OTEL_EXPORTER_OTLP_HEADERS": "token"}, "", true},
+	}
+	for _, tt := range tests {
+		for _, key := range []string{"OTEL_TRACES_EXPORTER", "OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_EXPORTER_OTLP_PROTOCOL", "OTEL_EXPORTER_OTLP_HEADERS"} {
+			t.Setenv(key, tt.env[key])
+		}
+		config, err := tracingFromEnv()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		endpoint := ""
+		if config != nil {
+			endpoint = config.Endpoint
+		}
+		if err == nil && endpoint != tt.wantEndpoint {
+			t.Errorf("%s: endpoint = %q, want %q", tt.name, endpoint, tt.wantEndpoint)
+		}
+	}
+}
+
+func TestTracingHeaders(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "Authorization=Bearer%20token, x-tenant = a")
+	config, err := tracingFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Headers["Authorization"] != "Bearer token" || config.Headers["x-tenant"] != "a" {
+		t.Errorf("headers = %v", config.Headers)
+	}
+}
+
+func TestRequestTracingSpans(t *testing.T) {
+	exporter := newTestTracer(t)
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	r := gin.New()
+	r.Use(requestTracing())
+	r.GET("/score", func(c *gin.Context) {
+		_, end := requestTracer.StartSpan(c.Request.Context(), "similarity.similarity", map[string]string{"backend.role": "primary"})
+		end(nil)
+		c.Status(http.StatusOK)
+	})
+
+	w := do(t, r, http.MethodGet, "/score", "", "traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	if got := w.Header().Get(requestIDHeader); got != traceID {
+		t.Errorf("request ID = %q, want the incoming trace ID", got)
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(spans))
+	}
+	client, server := spans[0], spans[1]
+	if server.Name != "GET /score" || server.SpanKind != trace.SpanKindServer || server.SpanContext.TraceID().String() != traceID {
+		t.Errorf("server span = %s, kind %v, trace %s", server.Name, server.SpanKind, server.SpanContext.TraceID())
+	}
+	if client.Name != "similarity.similarity" || client.SpanKind != trace.SpanKindClient || client.Parent.SpanID() != server.SpanContext.SpanID() {
+		t.Errorf("client span = %s, kind %v, parent %s; want a child of the server span", client.Name, client.SpanKind, client.Parent.SpanID())
+	}
+
+	exporter.Reset()
+	do(t, r, http.MethodGet, "/score", "", "traceparent", "00-"+traceID+"-00f067aa0ba902b7-00")
+	if spans := exporter.GetSpans(); len(spans) != 0 {
+		t.Errorf("exported %d spans of an unsampled trace, want none", len(spans))
+	}
+
+	exporter.Reset()
+	w = do(t, r, http.MethodGet, "/score", "", requestIDHeader, "client-id-1")
+	if got := w.Header().Get(requestIDHeader); got != "client-id-1" {
+		t.Errorf("request ID = %q, want the client's", got)
+	}
+}
+
+func TestFailedSpanRedaction(t *testing.T) {
+	exporter := newTestTracer(t)
+	const secret = "my card number is 4242"
+	failure := errors.New(`python service crash: scoring "` + secret + `"`)
+	r := gin.New()
+	r.Use(requestTracing())
+	r.POST("/score", func(c *gin.Context) {
+		_, end := requestTracer.StartSpan(c.Request.Context(), "similarity.similarity", nil)
+		end(failure)
+		logBackendError(c, failure, secret)
+		c.Status(http.StatusInternalServerError)
+	})
+
+	do(t, r, http.MethodPost, "/score", "")
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(spans))
+	}
+	for _, s := range spans {
+		if strings.Contains(s.Status.Description, secret) {
+			t.Errorf("%s: status %q holds the request's sentence", s.Name, s.Status.Description)
+		}
+	}
+	client := spans[0]
+	if want := logRedactor.Redact(failure.Error(), secret); client.Status.Description != want {
+		t.Errorf("client span status = %q, want %q", client.Status.Description, want)
+	}
+	if !client.EndTime.Before(spans[1].EndTime) {
+		t.Error("the held client span did not keep the time it failed")
+	}
+}
+
+func TestHeldSpansOutsideRequest(t *testing.T) {
+	exporter := newTestTracer(t)
+	failure := errors.New("python service timeout: context deadline exceeded")
+	_, end := requestTracer.StartSpan(context.Background(), "similarity.embed", nil)
+	end(failure)
+	if spans := exporter.GetSpans(); len(spans) != 1 || spans[0].Status.Description != logRedactor.Redact(failure.Error()) {
+		t.Errorf("spans = %+v, want the failed span ended at once", spans)
+	}
+
+	ctx, held := withHeldSpans(context.Background())
+	_, end = requestTracer.StartSpan(ctx, "similarity.embed", nil)
+	end(errors.New("failed on pair one"))
+	held.end("pair one")
+	_, end = requestTracer.StartSpan(ctx, "similarity.embed", nil)
+	end(errors.New("failed on pair one again"))
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("exported %d spans, want 3", len(spans))
+	}
+	for _, s := range spans[1:] {
+		if strings.Contains(s.Status.Description, "pair one") {
+			t.Errorf("status %q holds the pair's sentence", s.Status.Description)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultTranscriptThreshold = 0.75
+
+type TranscriptSegment struct {
+	Start   float64 `json:"start"`
+	End     float64 `json:"end"`
+	Speaker string  `json:"speaker,omitempty"`
+	Text    string  `json:"text" binding:"required"`
+}
+
+type Transcript struct {
+	Segments []TranscriptSegment `json:"segments" binding:"required,min=1,dive"`
+}
+
+type TranscriptInput struct {
+	Transcript1 Transcript `json:"transcript1" binding:"required"`
+	Transcript2 Transcript `json:"transcript2" binding:"required"`
+	Threshold   float64    `json:"threshold"`
+}
+
+type SegmentRef struct {
+	Index   int     `json:"index"`
+	Start   float64 `json:"start"`
+	End     float64 `json:"end"`
+	Speaker string  `json:"speaker,omitempty"`
+	Text    string  `json:"text"`
+}
+
+type SegmentMatch struct {
+	Segment1   SegmentRef `json:"segment1"`
+	Segment2   SegmentRef `json:"segment2"`
+	Similarity float64    `json:"similarity"`
+}
+
+type TranscriptResponse struct {
+	// Alignment is the order-preserving pairing of segments that maximises
+	// total similarity, like aligning two recordings of the same agenda.
+	Alignment []SegmentMatch `json:"alignment"`
+	// RepeatedPoints pairs each segment of transcript1 with its best match
+	// in transcript2 regardless of when it was said.
+	RepeatedPoints []SegmentMatch `json:"repeated_points"`
+	Coverage1      float64        `json:"coverage1"`
+	Coverage2      float64        `json:"coverage2"`
+	Threshold      float64        `json:"threshold"`
+	ProcessedAt    string         `json:"processed_at"`
+}
+
+func segmentRef(segments []TranscriptSegment, i int) SegmentRef {
+	return SegmentRef{
+		Index:   i,
+		Start:   segments[i].Start,
+		End:     segments[i].End,
+		Speaker: segments[i].Speaker,
+		Text:    segments[i].Text,
+	}
+}
+
+// alignSegments finds the monotonic alignment between the two segment lists
+// that maximises the summed similarity of matched pairs, only matching pairs
+// at or above the threshold. Unmatched segments cost nothing, so this is a
+// weighted longest-common-subsequence over the score matrix.
+func alignSegments(matrix [][]float64, threshold float64) [][2]int {
+	n, m := len(matrix), len(matrix[0])
+	best := make([][]float64, n+1)
+	for i := range best {
+		best[i] = make([]float64, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			best[i][j] = best[i+1][j]
+			if best[i][j+1] > best[i][j] {
+				best[i][j] = best[i][j+1]
+			}
+			if score := matrix[i][j]; score >= threshold && best[i+1][j+1]+score > best[i][j] {
+				best[i][j] = best[i+1][j+1] + score
+			}
+		}
+	}
+
+	var pairs [][2]int
+	for i, j := 0, 0; i < n && j < m; {
+		switch {
+		case matrix[i][j] >= threshold && best[i][j] == best[i+1][j+1]+matrix[i][j]:
+			pairs = append(pairs, [2]int{i, j})
+			i++
+			j++
+		case best[i][j] == best[i+1][j]:
+			i++
+		default:
+			j++
+		}
+	}
+	return pairs
+}
+
+func handleTranscriptSimilarity(c *gin.Context) {
+	var input TranscriptInput
+	if err := bindInput(c, &input); err != nil {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "Invalid input format: " + err.Error(),
+		})
+		return
+	}
+
+	segments1, segments2 := input.Transcript1.Segments, input.Transcript2.Segments
+	lim := limitsFor(c)
+	if len(segments1) > lim.MaxTranscriptSegments || len(segments2) > lim.MaxTranscriptSegments {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: fmt.Sprintf("Transcripts are limited to %d segments each", lim.MaxTranscriptSegments),
+		})
+		return
+	}
+	if len(segments1)*len(segments2) > lim.MaxMatrixCells {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: fmt.Sprintf("%d by %d segments exceeds the limit of %d comparisons", len(segments1), len(segments2), lim.MaxMatrixCells),
+		})
+		return
+	}
+	for _, segments := range [][]TranscriptSegment{segments1, segments2} {
+		for _, segment := range segments {
+			if !checkSentenceLengths(c, lim, segment.Text) {
+				return
+			}
+		}
+	}
+
+	threshold := input.Threshold
+	if threshold == 0 {
+		threshold = defaultTranscriptThreshold
+	}
+	if threshold < 0 || threshold > 1 {
+		respond(c, http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_error",
+			Message: "threshold must be between 0.0 and 1.0",
+		})
+		return
+	}
+
+	texts1 := make([]string, len(segments1))
+	for i, segment := range segments1 {
+		texts1[i] = strings.TrimSpace(segment.Text)
+	}
+	texts2 := make([]string, len(segments2))
+	for i, segment := range segments2 {
+		texts2[i] = strings.TrimSpace(segment.Text)
+	}
+	for _, text := range append(append([]string{}, texts1...), texts2...) {
+		if text == "" {
+			respond(c, http.StatusBadRequest, ErrorResponse{
+				Error:   "empty_sentences",
+				Message: "Every segment must have non-empty text",
+			})
+			return
+		}
+	}
+
+	matrix, err := scorer.Matrix(c.Request.Context(), texts1, texts2)
+	if err != nil {
+		logBackendError(c, err, append(texts1, texts2...)...)
+		respond(c, http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to process transcript similarity",
+		})
+		return
+	}
+
+	response := TranscriptResponse{
+		Alignment:      []SegmentMatch{},
+		RepeatedPoints: []SegmentMatch{},
+		Threshold:      threshold,
+		ProcessedAt:    time.Now().UTC().Format(time.RFC3339),
+	}
+
+	for _, pair := range alignSegments(matrix, threshold) {
+		response.Alignment = append(response.Alignment, SegmentMatch{
+			Segment1:   segmentRef(segments1, pair[0]),
+			Segment2:   segmentRef(segments2, pair[1]),
+			Similarity: matrix[pair[0]][pair[1]],
+		})
+	}
+
+	covered2 := make([]bool, len(segments2))
+	covered1 := 0
+	for i, row := range matrix {
+		bestJ := 0
+		for j, score := range row {
+			if score >= threshold {
+				covered2[j] = true
+			}
+			if score > row[bestJ] {
+				bestJ = j
+			}
+		}
+		if row[bestJ] < threshold {
+			continue
+		}
+		covered1++
+		response.RepeatedPoints = append(response.RepeatedPoints, SegmentMatch{
+			Segment1:   segmentRef(segments1, i),
+			Segment2:   segmentRef(segments2, bestJ),
+			Similarity: row[bestJ],
+		})
+	}
+
+	count2 := 0
+	for _, covered := range covered2 {
+		if covered {
+			count2++
+		}
+	}
+	response.Coverage1 = float64(covered1) / float64(len(segments1))
+	response.Coverage2 = float64(count2) / float64(len(segments2))
+
+	respond(c, http.StatusOK, response)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"text-similarity-api/config"
+	"text-similarity-api/similarity"
+)
+
+// Build information, stamped at link time:
+//
+//	go build -ldflags "-X main.buildCommit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Without ldflags the commit and date come from the VCS stamp Go embeds
+// when building inside a checkout.
+var (
+	buildVersion = serviceVersion
+	buildCommit  = ""
+	buildDate    = ""
+)
+
+type BuildInfo struct {
+	Version   string                  `json:"version"`
+	Commit    string                  `json:"commit"`
+	BuildDate string                  `json:"build_date"`
+	GoVersion string                  `json:"go_version"`
+	Features  map[string]string       `json:"features"`
+	Backend   *similarity.BackendInfo `json:"backend,omitempty"`
+	// BackendError is set instead of Backend when the handshake failed.
+	BackendError string `json:"backend_error,omitempty"`
+}
+
+func buildInfo() BuildInfo {
+	info := BuildInfo{
+		Version:   buildVersion,
+		Commit:    buildCommit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+	}
+	if stamp, ok := debug.ReadBuildInfo(); ok {
+		modified := false
+		for _, setting := range stamp.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.BuildDate == "" {
+					info.BuildDate = setting.Value
+				}
+			case "vcs.modified":
+				modified = setting.Value == "true"
+			}
+		}
+		if modified && buildCommit == "" && info.Commit != "" {
+			info.Commit += "-dirty"
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildDate == "" {
+		info.BuildDate = "unknown"
+	}
+
+	faults, _ := faultInjector.Config()
+	_, audit := scorer.Backend().(similarity.Auditor)
+	info.Features = map[string]string{
+		"input_quality_policy": inputQualityPolicy(),
+		"log_redaction":        logRedactor.mode,
+		"log_format":           logConfig.Format,
+		"fault_injection":      enabledString(faults.Enabled),
+		"audit":                enabledString(audit),
+		"backend":              backendKind(),
+		"python_protocol":      pythonProtocol(),
+		"python_workers":       strconv.Itoa(pythonBackend.Workers),
+		"fallback_method":      fallbackMethod,
+		"score_cache":          scoreCacheStatus(),
+		"model_cards":          strconv.Itoa(len(modelCards)),
+		"result_persistence":   resultPersistenceStatus(),
+		"rate_limit":           enabledString(rateLimiter.config != nil),
+		"backend_hooks":        hooksStatus(),
+		"tracing":              tracingStatus(),
+		"config_file":          enabledString(config.File() != ""),
+		"probe":                probeStatus(),
+		"corpus_database":      corpusDatabaseStatus(),
+		"job_workers":          strconv.Itoa(jobQueue.config.Workers),
+		"admin":                adminStatus(),
+	}
+	return info
+}
+
+func enabledString(enabled bool) string {
+	if enabled {
+		return "enabled"
+	}
+	return "disabled"
+}
+
+// backendHandshake caches the backend's self-description. Only a
+// successful handshake is cached, so a backend that was down at startup is
+// asked again on the next /version request.
+var backendHandshake struct {
+	mu   sync.Mutex
+	info *similarity.BackendInfo
+}
+
+func backendInfo(ctx context.Context) (*similarity.BackendInfo, error) {
+	backendHandshake.mu.Lock()
+	defer backendHandshake.mu.Unlock()
+	if backendHandshake.info != nil {
+		return backendHandshake.info, nil
+	}
+	info, err := scorer.Info(ctx)
+	if err != nil {
+		return nil, err
+	}
+	backendHandshake.info = info
+	return info, nil
+}
+
+// logStartupBanner logs the build, then performs the backend handshake in
+// the background and logs the model versions it reports.
+func logStartupBanner() {
+	info := buildInfo()
+	log.Printf("Text Similarity API version=%s commit=%s build_date=%s go=%s", info.Version, info.Commit, info.BuildDate, info.GoVersion)
+	go func() {
+		backend, err := backendInfo(context.Background())
+		switch {
+		case err != nil:
+			slog.Error("Backend handshake failed", "error", err)
+		case backend != nil:
+			log.Printf("Backend model=%s max_seq_length=%d runtime=%q libraries=%v", backend.Model, backend.MaxSeqLength, backend.Runtime, backend.Libraries)
+		}
+	}()
+}
+
+func handleVersion(c *gin.Context) {
+	info := buildInfo()
+	backend, err := backendInfo(c.Request.Context())
+	if err != nil {
+		info.BackendError = err.Error()
+	}
+	info.Backend = backend
+	c.JSON(http.StatusOK, info)
+}